@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
+	archiveLib "github.com/rohanchauhan02/clean/common/archive"
 	mysqlLib "github.com/rohanchauhan02/clean/common/database/mysql"
 	datadogLib "github.com/rohanchauhan02/clean/common/datadog"
+	storageLib "github.com/rohanchauhan02/clean/common/storage"
 	transporterLib "github.com/rohanchauhan02/clean/common/transporter"
+	utilLib "github.com/rohanchauhan02/clean/common/util"
 	"github.com/rohanchauhan02/clean/intenal/config"
 	redislib "github.com/rohanchauhan02/common/database/redis"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
@@ -85,4 +93,57 @@ func main() {
 	e.Use(middleware.CORS())
 	e.Use(middlewareLib.MiddlewareRequestID())
 	e.HTTPErrorHandler = errorLib.ErrorHandler
+
+	// consumers are built from SQS.QUEUES, each queue is bound to the handler registered with its key
+	// TO_CHANGE: Register the handlers of your service
+	transporterLib.RegisterQueues(cfg.GetSQS().Env, cfg.GetSQS().Queues)
+	queueBootstrap := transporterLib.NewQueueBootstrap(transporterClient)
+	if archiveCfg := cfg.GetArchive(); archiveCfg.Bucket != "" {
+		storageClient, err := storageLib.NewClient(&storageLib.ClientOptions{
+			Provider:        "aws",
+			AccessKeyID:     cfg.GetAWS().AccessKey,
+			AccessKeySecret: cfg.GetAWS().SecretKey,
+			Region:          cfg.GetAWS().Region,
+		})
+		if err != nil {
+			e.Logger.Errorf("Failed to create storage client: %s", err.Error())
+		} else {
+			archiver, err := transporterLib.NewArchiver(&transporterLib.ArchiveOption{
+				Store:         archiveLib.NewStorageStore(storageClient, archiveCfg.Bucket),
+				Prefix:        archiveCfg.Prefix,
+				MaxRecords:    archiveCfg.MaxRecords,
+				FlushInterval: time.Duration(archiveCfg.FlushIntervalSecond) * time.Second,
+			})
+			if err != nil {
+				e.Logger.Errorf("Failed to create message archiver: %s", err.Error())
+			} else {
+				queueBootstrap.SetArchiver(archiver)
+			}
+		}
+	}
+	financeRouter := transporterLib.NewRouter(utilLib.DefaultValidator(), datadog)
+	queueBootstrap.RegisterHandler("FINANCE_UPDATE", financeRouter.Handle)
+
+	// the server and the consumers run until SIGTERM or SIGINT, the consumers finish
+	// their in-flight messages before the server is shut down
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	go func() {
+		if err := e.Start(fmt.Sprintf(":%d", cfg.GetPort())); err != nil && err != http.ErrServerClosed {
+			e.Logger.Errorf("Failed to start server: %s", err.Error())
+			stop()
+		}
+	}()
+
+	if err := queueBootstrap.Run(ctx, cfg.GetSQS().Queues); err != nil {
+		e.Logger.Errorf("Failed to run queue consumers: %s", err.Error())
+		stop()
+	}
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), transporterLib.DefaultShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		e.Logger.Errorf("Failed to shut down server: %s", err.Error())
+	}
 }
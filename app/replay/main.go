@@ -0,0 +1,130 @@
+// Command replay re-publishes the messages archived by the consumers, see transporter.Archiver.
+//
+//	go run ./app/replay -queue local-finance-std-svc-update.fifo -from 2023-07-01T10:00:00Z \
+//		-to 2023-07-01T12:00:00Z -outcome dead_lettered -dry-run
+//
+// Messages archived without their group need -group-id to be replayed to a FIFO queue.
+// The archive bucket and prefix are read from ARCHIVE in the app config
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	archiveLib "github.com/rohanchauhan02/clean/common/archive"
+	storageLib "github.com/rohanchauhan02/clean/common/storage"
+	transporterLib "github.com/rohanchauhan02/clean/common/transporter"
+	"github.com/rohanchauhan02/clean/intenal/config"
+)
+
+func main() {
+	var (
+		queue        = flag.String("queue", "", "name of the archived queue, required")
+		from         = flag.String("from", "", "RFC3339 time the messages were received from, required")
+		to           = flag.String("to", "", "RFC3339 time the messages were received until, now when empty")
+		outcomes     = flag.String("outcome", "", "comma separated outcomes to replay: acked, retried, dead_lettered, failed")
+		messageIDs   = flag.String("message-id", "", "comma separated IDs of the messages to replay")
+		attributes   = flag.String("attribute", "", "comma separated key=value attributes the messages must carry")
+		bodyContains = flag.String("body-contains", "", "text the bodies of the messages must contain")
+		target       = flag.String("target", "", "queue the messages are published to, their source queue when empty")
+		groupID      = flag.String("group-id", "", "message group the messages are published with on FIFO queues, their archived group when empty")
+		limit        = flag.Int("limit", 0, "most messages replayed, all of them when 0")
+		dryRun       = flag.Bool("dry-run", false, "print the selected messages without publishing them")
+	)
+	flag.Parse()
+
+	if err := run(*queue, *from, *to, *outcomes, *messageIDs, *attributes, *bodyContains, *target, *groupID, *limit, *dryRun); err != nil {
+		fmt.Fprintf(os.Stderr, "replay failed: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(queue, from, to, outcomes, messageIDs, attributes, bodyContains, target, groupID string, limit int, dryRun bool) error {
+	filter := transporterLib.ReplayFilter{
+		Queue:        queue,
+		MessageIDs:   splitList(messageIDs),
+		BodyContains: bodyContains,
+	}
+	var err error
+	if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	if to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return fmt.Errorf("invalid -to: %w", err)
+		}
+	}
+	for _, outcome := range splitList(outcomes) {
+		filter.Outcomes = append(filter.Outcomes, transporterLib.MessageOutcome(outcome))
+	}
+	for _, attribute := range splitList(attributes) {
+		key, value, ok := strings.Cut(attribute, "=")
+		if !ok {
+			return fmt.Errorf("invalid -attribute: %s, expected key=value", attribute)
+		}
+		if filter.Attributes == nil {
+			filter.Attributes = map[string]string{}
+		}
+		filter.Attributes[key] = value
+	}
+
+	cfg := config.NewImmutableConfig()
+	if cfg.GetArchive().Bucket == "" {
+		return fmt.Errorf("ARCHIVE.BUCKET is not set in the config")
+	}
+	storageClient, err := storageLib.NewClient(&storageLib.ClientOptions{
+		Provider:        "aws",
+		AccessKeyID:     cfg.GetAWS().AccessKey,
+		AccessKeySecret: cfg.GetAWS().SecretKey,
+		Region:          cfg.GetAWS().Region,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create storage client: %w", err)
+	}
+
+	var transporterClient transporterLib.Client
+	if !dryRun {
+		transporterClient, err = transporterLib.NewClient(&transporterLib.ClientOptions{
+			Provider:        "aws",
+			AccessKeyID:     cfg.GetAWS().AccessKey,
+			AccessKeySecret: cfg.GetAWS().SecretKey,
+			Region:          cfg.GetAWS().Region,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create transporter client: %w", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	replayer := transporterLib.NewReplayer(archiveLib.NewStorageStore(storageClient, cfg.GetArchive().Bucket), cfg.GetArchive().Prefix, transporterClient)
+	result, err := replayer.Replay(ctx, filter, transporterLib.ReplayOption{
+		TargetQueue:    target,
+		DryRun:         dryRun,
+		Limit:          limit,
+		MessageGroupID: groupID,
+	})
+	if result != nil {
+		for _, record := range result.Records {
+			fmt.Printf("%s\t%s\t%s\t%s\n", record.ReceivedAt.Format(time.RFC3339), record.MessageID, record.Outcome, record.Body)
+		}
+		fmt.Printf("scanned: %d, selected: %d, published: %d, dry run: %t\n", result.Scanned, len(result.Records), result.Published, dryRun)
+	}
+	return err
+}
+
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
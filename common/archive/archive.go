@@ -0,0 +1,69 @@
+// Package archive stores the messages recorded by transporter.Archiver in the buckets
+// of the storage module
+package archive
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/rohanchauhan02/clean/common/storage"
+)
+
+// StorageStore is a transporter.ArchiveStore keeping the archive in Bucket.
+// The objects are never deleted by the transporter, a lifecycle rule on the archive
+// prefix of the bucket should expire them
+type StorageStore struct {
+	Client storage.Client
+	Bucket string
+}
+
+// NewStorageStore returns an archive store writing to bucket through client
+func NewStorageStore(client storage.Client, bucket string) *StorageStore {
+	return &StorageStore{
+		Client: client,
+		Bucket: bucket,
+	}
+}
+
+// Put uploads body under key
+func (s *StorageStore) Put(ctx context.Context, key string, body []byte) error {
+	size := int64(len(body))
+	encoded := base64.StdEncoding.EncodeToString(body)
+	_, err := s.Client.PutObjectBase64(&storage.CreateBase64UploadRequest{
+		Filename: &key,
+		Size:     &size,
+		Bucket:   &s.Bucket,
+		Base64:   &encoded,
+	})
+	return err
+}
+
+// Get downloads the object stored under key
+func (s *StorageStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.Client.GetObjectBuffer(&storage.GetObjectBufferRequest{
+		Bucket: &s.Bucket,
+		Key:    &key,
+	})
+}
+
+// List returns the keys of the bucket starting with prefix, reading every page of the listing
+func (s *StorageStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var (
+		keys []string
+		opts = &storage.ListObjectsOptions{Prefix: prefix}
+	)
+	for {
+		page, err := s.Client.ListObjects(ctx, s.Bucket, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error listing the objects of bucket %s: %w", s.Bucket, err)
+		}
+		for _, object := range page.Objects {
+			keys = append(keys, object.Key)
+		}
+		if !page.IsTruncated || page.NextToken == "" {
+			return keys, nil
+		}
+		opts.Token = page.NextToken
+	}
+}
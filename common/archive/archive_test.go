@@ -0,0 +1,112 @@
+package archive
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/rohanchauhan02/clean/common/storage"
+	"github.com/rohanchauhan02/clean/common/transporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ transporter.ArchiveStore = &StorageStore{}
+)
+
+type fakeStorage struct {
+	storage.Client
+	objects map[string][]byte
+}
+
+func (f *fakeStorage) PutObjectBase64(payload *storage.CreateBase64UploadRequest) (*storage.CreateBase64UploadResponse, error) {
+	data, err := base64.StdEncoding.DecodeString(*payload.Base64)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[*payload.Bucket+"/"+*payload.Filename] = data
+	return &storage.CreateBase64UploadResponse{Filename: payload.Filename, Bucket: payload.Bucket}, nil
+}
+
+func (f *fakeStorage) GetObjectBuffer(payload *storage.GetObjectBufferRequest) ([]byte, error) {
+	data, ok := f.objects[*payload.Bucket+"/"+*payload.Key]
+	if !ok {
+		return nil, errors.New("NoSuchKey")
+	}
+	return data, nil
+}
+
+func (f *fakeStorage) ListObjects(ctx context.Context, bucket string, opts *storage.ListObjectsOptions) (storage.ListObjectsResult, error) {
+	return storage.ListObjectsResult{}, errors.New("listing is not implemented")
+}
+
+func TestStorageStore(t *testing.T) {
+	client := &fakeStorage{objects: map[string][]byte{}}
+	store := NewStorageStore(client, "qoala-archive")
+	body := []byte(`{"queue":"finance","message_id":"1"}` + "\n")
+	key := "archive/finance/2023/07/01/10/20230701T101500Z-consumer-1-000001.ndjson"
+
+	t.Run("test ok object is read with its key", func(t *testing.T) {
+		require.NoError(t, store.Put(context.Background(), key, body))
+		data, err := store.Get(context.Background(), key)
+		assert.Nil(t, err)
+		assert.Equal(t, body, data)
+	})
+
+	t.Run("test wrong client cannot list", func(t *testing.T) {
+		_, err := store.List(context.Background(), "archive/finance/")
+		assert.EqualError(t, err, "error listing the objects of bucket qoala-archive: listing is not implemented")
+	})
+}
+
+func TestStorageStoreListMemory(t *testing.T) {
+	client, err := storage.NewMemory("", "")
+	require.NoError(t, err)
+	store := NewStorageStore(client, "qoala-archive")
+	for _, key := range []string{"archive/finance/a.ndjson", "archive/finance/b.ndjson", "archive/policy/c.ndjson"} {
+		require.NoError(t, store.Put(context.Background(), key, []byte("{}\n")))
+	}
+
+	keys, err := store.List(context.Background(), "archive/finance/")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"archive/finance/a.ndjson", "archive/finance/b.ndjson"}, keys)
+}
+
+func TestStorageStoreListS3(t *testing.T) {
+	var pages int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/qoala-archive", r.URL.Path)
+		assert.Equal(t, "archive/finance/2023/07/01/10/", r.URL.Query().Get("prefix"))
+		pages++
+		w.Header().Set("Content-Type", "application/xml")
+		if r.URL.Query().Get("continuation-token") == "" {
+			fmt.Fprint(w, `<ListBucketResult><IsTruncated>true</IsTruncated><NextContinuationToken>page-2</NextContinuationToken><Contents><Key>archive/finance/2023/07/01/10/a.ndjson</Key></Contents></ListBucketResult>`)
+			return
+		}
+		fmt.Fprint(w, `<ListBucketResult><IsTruncated>false</IsTruncated><Contents><Key>archive/finance/2023/07/01/10/b.ndjson</Key></Contents></ListBucketResult>`)
+	}))
+	defer server.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("ap-southeast-1"),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	require.NoError(t, err)
+	store := NewStorageStore(&storage.S3{Client: s3.New(sess)}, "qoala-archive")
+
+	keys, err := store.List(context.Background(), "archive/finance/2023/07/01/10/")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"archive/finance/2023/07/01/10/a.ndjson", "archive/finance/2023/07/01/10/b.ndjson"}, keys)
+	assert.Equal(t, 2, pages)
+}
@@ -0,0 +1,66 @@
+// Package claimcheck stores the message bodies offloaded by transporter.ClaimCheckOption
+// in the buckets of the storage module
+package claimcheck
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/rohanchauhan02/clean/common/storage"
+	"github.com/rohanchauhan02/clean/common/transporter"
+)
+
+// StorageStore is a transporter.ClaimCheckStore keeping the bodies in Bucket, it is a
+// transporter.ClaimCheckDeleter so the bodies are deleted after ack with DeleteAfterAck.
+// A lifecycle rule on the claim check prefix of the bucket should expire the others
+type StorageStore struct {
+	Client storage.Client
+	Bucket string
+}
+
+// NewStorageStore returns a claim check store writing to bucket through client
+func NewStorageStore(client storage.Client, bucket string) *StorageStore {
+	return &StorageStore{
+		Client: client,
+		Bucket: bucket,
+	}
+}
+
+// Put uploads body under key and returns the key the provider stored it under
+func (s *StorageStore) Put(ctx context.Context, key string, body []byte) (string, error) {
+	size := int64(len(body))
+	encoded := base64.StdEncoding.EncodeToString(body)
+	resp, err := s.Client.PutObjectBase64(&storage.CreateBase64UploadRequest{
+		Filename: &key,
+		Size:     &size,
+		Bucket:   &s.Bucket,
+		Base64:   &encoded,
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp == nil || resp.Filename == nil {
+		return "", errors.New("storage did not return the key of the claim check")
+	}
+	return *resp.Filename, nil
+}
+
+// Get downloads the body stored under key, a missing object is reported as
+// transporter.ErrClaimCheckNotFound so the message is not retried
+func (s *StorageStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := s.Client.GetObjectBuffer(&storage.GetObjectBufferRequest{
+		Bucket: &s.Bucket,
+		Key:    &key,
+	})
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return nil, fmt.Errorf("%w: %s", transporter.ErrClaimCheckNotFound, err)
+	}
+	return data, err
+}
+
+// Delete removes the body stored under key, deleting a missing body succeeds
+func (s *StorageStore) Delete(ctx context.Context, key string) error {
+	return s.Client.DeleteObject(ctx, s.Bucket, key)
+}
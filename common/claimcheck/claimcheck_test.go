@@ -0,0 +1,71 @@
+package claimcheck
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/rohanchauhan02/clean/common/storage"
+	"github.com/rohanchauhan02/clean/common/transporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStorage struct {
+	storage.Client
+	objects map[string][]byte
+}
+
+func (f *fakeStorage) PutObjectBase64(payload *storage.CreateBase64UploadRequest) (*storage.CreateBase64UploadResponse, error) {
+	data, err := base64.StdEncoding.DecodeString(*payload.Base64)
+	if err != nil {
+		return nil, err
+	}
+	key := "/" + *payload.Filename
+	f.objects[*payload.Bucket+key] = data
+	return &storage.CreateBase64UploadResponse{Filename: &key, Bucket: payload.Bucket}, nil
+}
+
+func (f *fakeStorage) GetObjectBuffer(payload *storage.GetObjectBufferRequest) ([]byte, error) {
+	data, ok := f.objects[*payload.Bucket+*payload.Key]
+	if !ok {
+		return nil, fmt.Errorf("%s%s: %w: NoSuchKey", *payload.Bucket, *payload.Key, storage.ErrObjectNotFound)
+	}
+	return data, nil
+}
+
+func (f *fakeStorage) DeleteObject(ctx context.Context, bucket, key string) error {
+	delete(f.objects, bucket+key)
+	return nil
+}
+
+func TestStorageStore(t *testing.T) {
+	client := &fakeStorage{objects: map[string][]byte{}}
+	store := NewStorageStore(client, "qoala-claim-check")
+	body := []byte(`{"policy_number":"QOALA-1"}`)
+
+	t.Run("test ok body is read with the returned key", func(t *testing.T) {
+		key, err := store.Put(context.Background(), "claim-check/policy/2023/07/01/1.bin", body)
+		require.NoError(t, err)
+		assert.Equal(t, "/claim-check/policy/2023/07/01/1.bin", key)
+
+		data, err := store.Get(context.Background(), key)
+		assert.Nil(t, err)
+		assert.Equal(t, body, data)
+	})
+
+	t.Run("test ok body is deleted with the returned key", func(t *testing.T) {
+		key, err := store.Put(context.Background(), "claim-check/policy/2023/07/01/3.bin", body)
+		require.NoError(t, err)
+		assert.Nil(t, store.Delete(context.Background(), key))
+		_, err = store.Get(context.Background(), key)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("test wrong key does not exist", func(t *testing.T) {
+		_, err := store.Get(context.Background(), "/claim-check/policy/2023/07/01/2.bin")
+		assert.True(t, errors.Is(err, transporter.ErrClaimCheckNotFound), err)
+	})
+}
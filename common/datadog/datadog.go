@@ -52,3 +52,9 @@ func (d Datadog) SendDurationMetric(name string, t1, t2 time.Time, tags ...strin
 	t = append(t, tags...)
 	d.client.Timing(name, t2.Sub(t1), t, 1)
 }
+
+func (d Datadog) SendGaugeMetric(name string, value float64, tags ...string) {
+	t := []string{}
+	t = append(t, tags...)
+	d.client.Gauge(name, value, t, 1)
+}
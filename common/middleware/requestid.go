@@ -13,6 +13,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
+	"github.com/rohanchauhan02/clean/common/requestid"
 )
 
 type (
@@ -31,6 +32,9 @@ func MiddlewareRequestID() echo.MiddlewareFunc {
 			}
 			c.Request().Header.Set(echo.HeaderXRequestID, requestID)
 			c.Response().Header().Set(echo.HeaderXRequestID, requestID)
+			// kept in the request context so producers can propagate it to the consumers
+			ctx := requestid.NewContext(c.Request().Context(), requestID)
+			c.SetRequest(c.Request().WithContext(ctx))
 			return next(c)
 		}
 	}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/labstack/echo"
+	"github.com/rohanchauhan02/clean/common/requestid"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,11 +14,18 @@ func TestMiddlewareRequestID(t *testing.T) {
 		e := echo.New()
 		e.Use(MiddlewareRequestID())
 
+		var contextRequestID string
+		e.GET("/", func(c echo.Context) error {
+			contextRequestID = requestid.FromContext(c.Request().Context())
+			return nil
+		})
+
 		req := httptest.NewRequest(echo.GET, "/", nil)
 		res := httptest.NewRecorder()
 		e.ServeHTTP(res, req)
 
 		assert.NotEmpty(t, req.Header.Get(echo.HeaderXRequestID))
 		assert.NotEmpty(t, res.Header().Get(echo.HeaderXRequestID))
+		assert.Equal(t, req.Header.Get(echo.HeaderXRequestID), contextRequestID)
 	})
 }
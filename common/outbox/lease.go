@@ -0,0 +1,61 @@
+package outbox
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// releaseLeaseScript deletes the lease only when it is still held by the caller
+const releaseLeaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`
+
+// RedisLease is a lock held by a single relay replica at a time. It expires after
+// TTL so a replica that died does not block the others
+type RedisLease struct {
+	Client redis.Cmdable
+	Key    string
+	TTL    time.Duration
+	token  string
+}
+
+// NewRedisLease returns a lease on key, identified by the hostname and process ID
+func NewRedisLease(client redis.Cmdable, key string, ttl time.Duration) *RedisLease {
+	hostname, _ := os.Hostname()
+	return &RedisLease{
+		Client: client,
+		Key:    key,
+		TTL:    ttl,
+		token:  fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano()),
+	}
+}
+
+// Acquire takes the lease or extends it when it is already held by this replica,
+// false is returned when another replica holds it
+func (l *RedisLease) Acquire() (bool, error) {
+	acquired, err := l.Client.SetNX(l.Key, l.token, l.TTL).Result()
+	if err != nil {
+		return false, err
+	}
+	if acquired {
+		return true, nil
+	}
+
+	holder, err := l.Client.Get(l.Key).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if holder != l.token {
+		return false, nil
+	}
+	return true, l.Client.PExpire(l.Key, l.TTL).Err()
+}
+
+// Release gives the lease up if it is still held by this replica
+func (l *RedisLease) Release() error {
+	return l.Client.Eval(releaseLeaseScript, []string{l.Key}, l.token).Err()
+}
@@ -0,0 +1,47 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisLease(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	t.Cleanup(func() { _ = redisClient.Close() })
+
+	lease := NewRedisLease(redisClient, "outbox-relay", time.Minute)
+	other := NewRedisLease(redisClient, "outbox-relay", time.Minute)
+
+	acquired, err := lease.Acquire()
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	t.Run("test ok lease is extended by its holder", func(t *testing.T) {
+		redisServer.FastForward(30 * time.Second)
+		acquired, err := lease.Acquire()
+		assert.Nil(t, err)
+		assert.True(t, acquired)
+		assert.Equal(t, time.Minute, redisServer.TTL("outbox-relay"))
+	})
+
+	t.Run("test release by another replica keeps the lease", func(t *testing.T) {
+		acquired, err := other.Acquire()
+		assert.Nil(t, err)
+		assert.False(t, acquired)
+		assert.Nil(t, other.Release())
+		assert.True(t, redisServer.Exists("outbox-relay"))
+	})
+
+	t.Run("test ok lease expires", func(t *testing.T) {
+		redisServer.FastForward(2 * time.Minute)
+		acquired, err := other.Acquire()
+		assert.Nil(t, err)
+		assert.True(t, acquired)
+	})
+}
@@ -0,0 +1,52 @@
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Status of an outbox message
+type Status string
+
+const (
+	StatusPending Status = "PENDING"
+	StatusSent    Status = "SENT"
+	// StatusFailed is set once a message failed MaxAttempts times, it is not
+	// published again until its status is set back to PENDING
+	StatusFailed Status = "FAILED"
+
+	// TableName is the table the outbox messages are stored in
+	TableName = "transporter_outbox"
+)
+
+// Message is a row of the outbox table. It is written in the same transaction as the
+// business rows and published later by the Relay
+type Message struct {
+	ID          int64      `gorm:"column:id;primaryKey;autoIncrement"`
+	QueueName   string     `gorm:"column:queue_name;type:varchar(255);not null"`
+	MessageBody string     `gorm:"column:message_body;type:mediumtext;not null"`
+	Attributes  string     `gorm:"column:attributes;type:text"`
+	Status      Status     `gorm:"column:status;type:varchar(16);not null;index:idx_transporter_outbox_status_available_at,priority:1"`
+	Attempts    int        `gorm:"column:attempts;not null;default:0"`
+	LastError   string     `gorm:"column:last_error;type:text"`
+	AvailableAt time.Time  `gorm:"column:available_at;not null;index:idx_transporter_outbox_status_available_at,priority:2"`
+	SentAt      *time.Time `gorm:"column:sent_at"`
+	CreatedAt   time.Time  `gorm:"column:created_at"`
+	UpdatedAt   time.Time  `gorm:"column:updated_at"`
+}
+
+func (Message) TableName() string {
+	return TableName
+}
+
+// MessageAttributes decodes the attributes the message is published with
+func (m *Message) MessageAttributes() (map[string]string, error) {
+	if m.Attributes == "" {
+		return nil, nil
+	}
+	var attributes map[string]string
+	if err := json.Unmarshal([]byte(m.Attributes), &attributes); err != nil {
+		return nil, err
+	}
+	return attributes, nil
+}
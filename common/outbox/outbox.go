@@ -0,0 +1,92 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rohanchauhan02/clean/common/dbcontext"
+	"github.com/rohanchauhan02/clean/common/transporter"
+	log "github.com/rohanchauhan02/common/logs"
+	"gorm.io/gorm"
+)
+
+var (
+	logger = log.NewCommonLog()
+)
+
+// Outbox stores the messages to publish in the outbox table, inside the transaction
+// of the business rows, so a message is published if and only if the transaction commits
+type Outbox struct {
+	DB *gorm.DB
+	// Codec encodes the bodies that are not a string or []byte, JSON when it is nil
+	Codec transporter.Codec
+	Now   func() time.Time
+}
+
+// New returns an Outbox writing to db when the context has no transaction
+func New(db *gorm.DB) *Outbox {
+	return &Outbox{
+		DB:  db,
+		Now: time.Now,
+	}
+}
+
+// Enqueue writes body for queueName through the transaction stored in ctx by
+// dbcontext.NewContext, or through DB when ctx has none. The Datadog span context and
+// the request ID of ctx are stored with the message and sent as its attributes
+func (o *Outbox) Enqueue(ctx context.Context, queueName string, body interface{}) error {
+	if queueName == "" {
+		return fmt.Errorf("queue name is empty")
+	}
+
+	messageBody, attributes, err := o.encode(body)
+	if err != nil {
+		return err
+	}
+	attributes = transporter.InjectContext(ctx, attributes)
+
+	message := &Message{
+		QueueName:   queueName,
+		MessageBody: messageBody,
+		Status:      StatusPending,
+		AvailableAt: o.Now(),
+	}
+	if len(attributes) > 0 {
+		encoded, err := json.Marshal(attributes)
+		if err != nil {
+			return err
+		}
+		message.Attributes = string(encoded)
+	}
+
+	db := dbcontext.SetTransactionContext(ctx, o.DB).WithContext(ctx)
+	if err := db.Create(message).Error; err != nil {
+		logger.Errorf("failed to enqueue message for queue: %s in the outbox, %s", queueName, err)
+		return err
+	}
+	return nil
+}
+
+func (o *Outbox) encode(body interface{}) (string, map[string]string, error) {
+	switch v := body.(type) {
+	case nil:
+		return "", nil, fmt.Errorf("message body is empty")
+	case string:
+		return v, nil, nil
+	case *string:
+		if v == nil {
+			return "", nil, fmt.Errorf("message body is empty")
+		}
+		return *v, nil, nil
+	case []byte:
+		return string(v), nil, nil
+	}
+
+	codec := o.Codec
+	if codec == nil {
+		codec = transporter.JSONCodec{}
+	}
+	return transporter.EncodeBody(codec, body)
+}
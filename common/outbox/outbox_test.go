@@ -0,0 +1,71 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rohanchauhan02/clean/common/dbcontext"
+	"github.com/rohanchauhan02/clean/common/transporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormMysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+)
+
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	db, err := gorm.Open(gormMysql.New(gormMysql.Config{
+		Conn:                      sqlDB,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{Logger: gormLogger.Default.LogMode(gormLogger.Silent)})
+	require.NoError(t, err)
+	return db, mock
+}
+
+func TestEnqueueUsesContextTransaction(t *testing.T) {
+	db, mock := newMockDB(t)
+	now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	outbox := New(db)
+	outbox.Now = func() time.Time { return now }
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `policies`").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO `transporter_outbox`").
+		WithArgs("add-product", `{"id":1}`, `{"Content-Type":"application/json","X-Request-ID":"req-1"}`,
+			StatusPending, 0, "", now, nil, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		ctx := dbcontext.NewContext(context.Background(), tx)
+		ctx = transporter.WithRequestID(ctx, "req-1")
+		if err := tx.Exec("UPDATE `policies` SET status = ?", "ACTIVE").Error; err != nil {
+			return err
+		}
+		return outbox.Enqueue(ctx, "add-product", map[string]int{"id": 1})
+	})
+
+	t.Run("test ok message is written in the transaction of the context", func(t *testing.T) {
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestEnqueueInvalidMessage(t *testing.T) {
+	db, _ := newMockDB(t)
+	outbox := New(db)
+
+	t.Run("test enqueue without queue name", func(t *testing.T) {
+		assert.Error(t, outbox.Enqueue(context.Background(), "", "h1"))
+	})
+
+	t.Run("test enqueue without body", func(t *testing.T) {
+		assert.Error(t, outbox.Enqueue(context.Background(), "add-product", nil))
+	})
+}
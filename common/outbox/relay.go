@@ -0,0 +1,187 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/rohanchauhan02/clean/common/transporter"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultRelayBatchSize     = 100
+	DefaultRelayPollInterval  = time.Second
+	DefaultRelayMaxAttempts   = 10
+	DefaultRelayRetryDelay    = 5 * time.Second
+	DefaultRelayMaxRetryDelay = 10 * time.Minute
+)
+
+// RelayOptions configures a Relay, zero values use the defaults above
+//   - Lease - when set, a batch is only relayed by the replica holding the lease and rows
+//     are read without locking. Otherwise rows are locked with SELECT ... FOR UPDATE SKIP
+//     LOCKED, which needs MySQL 8
+//   - RetryDelay - the wait before publishing a failed message again, it doubles after
+//     every attempt up to MaxRetryDelay
+type RelayOptions struct {
+	BatchSize     int
+	PollInterval  time.Duration
+	MaxAttempts   int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	Lease         *RedisLease
+}
+
+// Relay publishes the pending outbox messages through a transporter.Client. A message is
+// published at least once: it is published again when its row could not be marked sent,
+// so consumers should be idempotent
+type Relay struct {
+	DB      *gorm.DB
+	Client  transporter.Client
+	Options RelayOptions
+	Now     func() time.Time
+}
+
+// NewRelay returns a Relay reading the outbox table of db
+func NewRelay(db *gorm.DB, client transporter.Client, options RelayOptions) *Relay {
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultRelayBatchSize
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultRelayPollInterval
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultRelayMaxAttempts
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = DefaultRelayRetryDelay
+	}
+	if options.MaxRetryDelay < options.RetryDelay {
+		options.MaxRetryDelay = DefaultRelayMaxRetryDelay
+	}
+	return &Relay{
+		DB:      db,
+		Client:  client,
+		Options: options,
+		Now:     time.Now,
+	}
+}
+
+// Run relays batches of messages until ctx is cancelled, waiting PollInterval
+// whenever a batch was not full
+func (r *Relay) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		relayed, err := r.RelayOnce(ctx)
+		if err != nil {
+			logger.Errorf("failed to relay outbox messages, %s", err)
+		}
+		if err == nil && relayed >= r.Options.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(r.Options.PollInterval):
+		}
+	}
+	return nil
+}
+
+// RelayOnce publishes a single batch of pending messages and returns how many were
+// processed, sent or not
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	if lease := r.Options.Lease; lease != nil {
+		acquired, err := lease.Acquire()
+		if err != nil || !acquired {
+			return 0, err
+		}
+		defer func() {
+			if err := lease.Release(); err != nil {
+				logger.Errorf("failed to release outbox relay lease: %s, %s", lease.Key, err)
+			}
+		}()
+	}
+
+	var relayed int
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx
+		if r.Options.Lease == nil {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+
+		var messages []Message
+		err := query.
+			Where("status = ? AND available_at <= ?", StatusPending, r.Now()).
+			Order("id").
+			Limit(r.Options.BatchSize).
+			Find(&messages).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range messages {
+			if err := tx.Model(&messages[i]).Updates(r.publish(&messages[i])).Error; err != nil {
+				return err
+			}
+			relayed++
+		}
+		return nil
+	})
+	return relayed, err
+}
+
+// PurgeSent deletes the messages sent before the given time and returns how many were deleted
+func (r *Relay) PurgeSent(ctx context.Context, before time.Time) (int64, error) {
+	result := r.DB.WithContext(ctx).
+		Where("status = ? AND sent_at < ?", StatusSent, before).
+		Delete(&Message{})
+	return result.RowsAffected, result.Error
+}
+
+// publish sends the message and returns the columns to update
+func (r *Relay) publish(message *Message) map[string]interface{} {
+	now := r.Now()
+	attempts := message.Attempts + 1
+
+	attributes, err := message.MessageAttributes()
+	if err == nil {
+		err = r.Client.Publish(&transporter.MessagePublishOptions{
+			QueueName:   message.QueueName,
+			MessageBody: message.MessageBody,
+			Attributes:  attributes,
+		})
+	}
+	if err == nil {
+		return map[string]interface{}{
+			"status":     StatusSent,
+			"attempts":   attempts,
+			"last_error": "",
+			"sent_at":    now,
+		}
+	}
+
+	status := StatusPending
+	if attempts >= r.Options.MaxAttempts {
+		status = StatusFailed
+		logger.Errorf("outbox message with ID: %d for queue: %s failed after %d attempts, %s", message.ID, message.QueueName, attempts, err)
+	} else {
+		logger.Errorf("failed to relay outbox message with ID: %d to queue: %s, %s", message.ID, message.QueueName, err)
+	}
+	return map[string]interface{}{
+		"status":       status,
+		"attempts":     attempts,
+		"last_error":   err.Error(),
+		"available_at": now.Add(r.retryDelay(attempts)),
+	}
+}
+
+func (r *Relay) retryDelay(attempts int) time.Duration {
+	delay := r.Options.RetryDelay
+	for i := 1; i < attempts && delay < r.Options.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > r.Options.MaxRetryDelay {
+		delay = r.Options.MaxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,108 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/rohanchauhan02/clean/common/transporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingClient struct {
+	*transporter.Memory
+	failQueue string
+}
+
+func (f *failingClient) Publish(options *transporter.MessagePublishOptions) error {
+	if options.QueueName == f.failQueue {
+		return errors.New("connection refused")
+	}
+	return f.Memory.Publish(options)
+}
+
+var outboxColumns = []string{"id", "queue_name", "message_body", "attributes", "status", "attempts", "last_error", "available_at", "sent_at", "created_at", "updated_at"}
+
+func TestRelayOncePublishesPendingMessages(t *testing.T) {
+	db, mock := newMockDB(t)
+	client := &failingClient{Memory: transporter.NewMemory(), failQueue: "finance"}
+	now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	relay := NewRelay(db, client, RelayOptions{BatchSize: 10, MaxAttempts: 2, RetryDelay: time.Minute})
+	relay.Now = func() time.Time { return now }
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\* FROM `transporter_outbox` WHERE status = \\? AND available_at <= \\? ORDER BY id LIMIT 10 FOR UPDATE SKIP LOCKED").
+		WithArgs(StatusPending, now).
+		WillReturnRows(sqlmock.NewRows(outboxColumns).
+			AddRow(1, "add-product", "h1", `{"X-Request-ID":"req-1"}`, StatusPending, 0, "", now, nil, now, now).
+			AddRow(2, "finance", "h2", "", StatusPending, 0, "", now, nil, now, now).
+			AddRow(3, "finance", "h3", "", StatusPending, 1, "connection refused", now, nil, now, now))
+	mock.ExpectExec("UPDATE `transporter_outbox` SET `attempts`=\\?,`last_error`=\\?,`sent_at`=\\?,`status`=\\?,`updated_at`=\\? WHERE `id` = \\?").
+		WithArgs(1, "", now, StatusSent, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE `transporter_outbox` SET `attempts`=\\?,`available_at`=\\?,`last_error`=\\?,`status`=\\?,`updated_at`=\\? WHERE `id` = \\?").
+		WithArgs(1, now.Add(time.Minute), "connection refused", StatusPending, sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE `transporter_outbox` SET `attempts`=\\?,`available_at`=\\?,`last_error`=\\?,`status`=\\?,`updated_at`=\\? WHERE `id` = \\?").
+		WithArgs(2, now.Add(2*time.Minute), "connection refused", StatusFailed, sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	relayed, err := relay.RelayOnce(context.Background())
+
+	t.Run("test ok every message of the batch is processed", func(t *testing.T) {
+		assert.Nil(t, err)
+		assert.Equal(t, 3, relayed)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test ok message is published with its attributes", func(t *testing.T) {
+		msg, err := client.Consume(&transporter.MessageConsumeOptions{QueueName: "add-product"})
+		assert.Nil(t, err)
+		require.NotNil(t, msg)
+		assert.Equal(t, "h1", msg.MessageBody)
+		assert.Equal(t, "req-1", msg.Attributes[transporter.AttributeRequestID])
+	})
+}
+
+func TestRelayOnceWithLease(t *testing.T) {
+	db, mock := newMockDB(t)
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	t.Cleanup(func() { _ = redisClient.Close() })
+
+	lease := NewRedisLease(redisClient, "outbox-relay", time.Minute)
+	other := NewRedisLease(redisClient, "outbox-relay", time.Minute)
+	relay := NewRelay(db, transporter.NewMemory(), RelayOptions{Lease: lease})
+
+	t.Run("test batch is skipped while another replica holds the lease", func(t *testing.T) {
+		acquired, err := other.Acquire()
+		require.NoError(t, err)
+		require.True(t, acquired)
+
+		relayed, err := relay.RelayOnce(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 0, relayed)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test ok rows are read without locking while holding the lease", func(t *testing.T) {
+		require.NoError(t, other.Release())
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT \\* FROM `transporter_outbox` WHERE status = \\? AND available_at <= \\? ORDER BY id LIMIT 100$").
+			WillReturnRows(sqlmock.NewRows(outboxColumns))
+		mock.ExpectCommit()
+
+		relayed, err := relay.RelayOnce(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 0, relayed)
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.False(t, redisServer.Exists("outbox-relay"), "lease should be released after the batch")
+	})
+}
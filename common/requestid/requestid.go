@@ -0,0 +1,19 @@
+// Package requestid keeps the X-Request-ID of a request in its context, it has no
+// dependency so the middleware and the transporter can both import it
+package requestid
+
+import "context"
+
+// key is the context key of the request ID, it is only set through NewContext
+type key struct{}
+
+// NewContext returns a copy of ctx holding requestID
+func NewContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, key{}, requestID)
+}
+
+// FromContext returns the request ID stored by NewContext, empty when there is none
+func FromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(key{}).(string)
+	return requestID
+}
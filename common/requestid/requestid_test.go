@@ -0,0 +1,19 @@
+package requestid
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	t.Run("test ok request ID is read back", func(t *testing.T) {
+		ctx := NewContext(context.Background(), "req-1")
+		assert.Equal(t, "req-1", FromContext(ctx))
+	})
+
+	t.Run("test ok empty without request ID", func(t *testing.T) {
+		assert.Empty(t, FromContext(context.Background()))
+	})
+}
@@ -3,6 +3,7 @@ package schemas
 import (
 	"fmt"
 	"strings"
+	"sync"
 )
 
 type (
@@ -77,7 +78,41 @@ var (
 	}
 )
 
+var (
+	sqsActionsMu       sync.RWMutex
+	sqsActionsRegistry = map[string]map[string]SQSActionsData{}
+)
+
+// RegisterSQSActions makes the queue of keyActions in env known to GetSQSActions, it
+// takes precedence over SQSActionsValue. env is one of LOCAL, DEV, UAT or PROD and both
+// env and keyActions are case insensitive
+func RegisterSQSActions(env string, keyActions string, data SQSActionsData) {
+	sqsActionsMu.Lock()
+	defer sqsActionsMu.Unlock()
+	env = strings.ToUpper(env)
+	if sqsActionsRegistry[env] == nil {
+		sqsActionsRegistry[env] = map[string]SQSActionsData{}
+	}
+	sqsActionsRegistry[env][strings.ToUpper(keyActions)] = data
+}
+
+// ResetSQSActions removes the queues registered with RegisterSQSActions
+func ResetSQSActions() {
+	sqsActionsMu.Lock()
+	defer sqsActionsMu.Unlock()
+	sqsActionsRegistry = map[string]map[string]SQSActionsData{}
+}
+
+// GetSQSActions returns the queue of keyActions in env, the queues registered with
+// RegisterSQSActions are looked up first and SQSActionsValue after
 func GetSQSActions(env string, keyActions string) (result SQSActionsData, err error) {
+	sqsActionsMu.RLock()
+	registered, ok := sqsActionsRegistry[strings.ToUpper(env)][strings.ToUpper(keyActions)]
+	sqsActionsMu.RUnlock()
+	if ok {
+		return registered, nil
+	}
+
 	for k, v := range SQSActionsValue {
 		if strings.EqualFold(k, keyActions) {
 			switch strings.ToUpper(env) {
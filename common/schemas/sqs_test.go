@@ -0,0 +1,40 @@
+package schemas
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSQSActions(t *testing.T) {
+	t.Cleanup(ResetSQSActions)
+
+	t.Run("test ok hard-coded queue", func(t *testing.T) {
+		result, err := GetSQSActions("dev", "finance_update")
+		assert.Nil(t, err)
+		assert.Equal(t, SQSActionsData{Queue: "dev-finance-std-svc-update.fifo", Delay: 1}, result)
+	})
+
+	t.Run("test ok registered queue takes precedence", func(t *testing.T) {
+		RegisterSQSActions("dev", "finance_update", SQSActionsData{Queue: "dev-finance-update.fifo", Delay: 5})
+		RegisterSQSActions("local", "claim_update", SQSActionsData{Queue: "local-claim-update"})
+
+		result, err := GetSQSActions("DEV", "FINANCE_UPDATE")
+		assert.Nil(t, err)
+		assert.Equal(t, SQSActionsData{Queue: "dev-finance-update.fifo", Delay: 5}, result)
+
+		result, err = GetSQSActions("LOCAL", "CLAIM_UPDATE")
+		assert.Nil(t, err)
+		assert.Equal(t, "local-claim-update", result.Queue)
+	})
+
+	t.Run("test wrong registered queue of another env", func(t *testing.T) {
+		_, err := GetSQSActions("PROD", "CLAIM_UPDATE")
+		assert.EqualError(t, err, "cannot find keyActions: CLAIM_UPDATE")
+	})
+
+	t.Run("test wrong env", func(t *testing.T) {
+		_, err := GetSQSActions("STAGING", "QUOTATION_UPDATE")
+		assert.EqualError(t, err, "cannot find env: STAGING")
+	})
+}
@@ -2,11 +2,21 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"mime"
+	"net"
+	"net/http"
+	"net/url"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
@@ -62,6 +72,52 @@ func (c OSS) CreatePresignedUpload(payload *CreatePresignedUploadRequest) (*Crea
 
 }
 
+// CreatePresignedPost creates a PostObject policy upload signed with the access key of the
+// client, OSS rejects the files breaking the content type and size conditions
+func (c OSS) CreatePresignedPost(payload *CreatePresignedUploadRequest, conditions PostConditions) (*CreatePresignedUploadResponse, error) {
+	postURL, err := c.postURL(*payload.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	creds := c.Client.Config.GetCredentials()
+	post := newPresignedPost(payload, bucketKey(sanitizeFileNameForUpload(*payload.Filename)), conditions, time.Now())
+	if token := creds.GetSecurityToken(); token != "" {
+		post.fields["x-oss-security-token"] = token
+	}
+	policy, err := post.encodePolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha1.New, []byte(creds.GetAccessKeySecret()))
+	mac.Write([]byte(policy))
+	post.fields[POST_FIELD_POLICY] = policy
+	post.fields["OSSAccessKeyId"] = creds.GetAccessKeyID()
+	post.fields["Signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return post.response(postURL), nil
+}
+
+// postURL returns the url of the bucket like the SDK, path style for an IP endpoint and
+// virtual hosted style otherwise
+func (c OSS) postURL(bucket string) (string, error) {
+	endpoint := c.Client.Config.Endpoint
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "http://" + endpoint
+	}
+	postURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case c.Client.Config.IsCname:
+	case net.ParseIP(postURL.Hostname()) != nil:
+		postURL.Path = "/" + bucket
+	default:
+		postURL.Host = bucket + "." + postURL.Host
+	}
+	return postURL.String(), nil
+}
+
 // CreatePreSignedView creates a presigned url for viewing an object already present in bucket using OSS signUrl
 func (c OSS) CreatePresignedView(payload *CreatePresignedViewRequest) (*CreatePresignedViewResponse, error) {
 	if payload.Duration == 0 {
@@ -108,7 +164,7 @@ func (c OSS) GetObjectBuffer(payload *GetObjectBufferRequest) ([]byte, error) {
 	io, err := bucket.GetObject(*payload.Key)
 	if err != nil {
 		logger.Error("error during get object for OSS object: ", err)
-		return nil, err
+		return nil, ossNotFound(err, *payload.Bucket, *payload.Key)
 	}
 	data, err := ioutil.ReadAll(io)
 	if err != nil {
@@ -161,3 +217,369 @@ func (c OSS) PutObjectBase64(payload *CreateBase64UploadRequest) (*CreateBase64U
 	}
 	return resp, nil
 }
+
+// PutObject streams body to OSS, bodies larger than the multipart threshold are uploaded in parts.
+// OSS calls do not take a context, it is checked between the parts
+func (c OSS) PutObject(ctx context.Context, bucket, key string, body io.Reader, opts *PutObjectOptions) (ObjectInfo, error) {
+	return uploadObject(ctx, c, bucket, bucketKey(key), body, opts)
+}
+
+// GetObject streams an OSS object
+func (c OSS) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error) {
+	return c.getObject(ctx, bucket, key)
+}
+
+// GetObjectRange streams length bytes of an OSS object from offset
+func (c OSS) GetObjectRange(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, ObjectInfo, error) {
+	if offset < 0 {
+		return nil, ObjectInfo{}, fmt.Errorf("%w: offset %d", ErrInvalidRange, offset)
+	}
+	return c.getObject(ctx, bucket, key, oss.NormalizedRange(strings.TrimPrefix(rangeHeader(offset, length), "bytes=")))
+}
+
+// AbortUpload removes the parts of an OSS multipart upload
+func (c OSS) AbortUpload(ctx context.Context, bucket, key, uploadID string) error {
+	return c.abortUpload(ctx, bucket, bucketKey(key), uploadID)
+}
+
+func (c OSS) getObject(ctx context.Context, bucketName, key string, options ...oss.Option) (io.ReadCloser, ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		logger.Error("error while obtaining bucket info ", err)
+		return nil, ObjectInfo{}, err
+	}
+
+	key = bucketKey(key)
+	result, err := bucket.DoGetObject(&oss.GetObjectRequest{ObjectKey: key}, options)
+	if err != nil {
+		logger.Error("error during get object for OSS object: ", err)
+		return nil, ObjectInfo{}, err
+	}
+	headers := result.Response.Headers
+	size, _ := strconv.ParseInt(headers.Get(oss.HTTPHeaderContentLength), 10, 64)
+	lastModified, _ := http.ParseTime(headers.Get(oss.HTTPHeaderLastModified))
+	return result.Response, ObjectInfo{
+		Bucket:       bucketName,
+		Key:          key,
+		Size:         contentRangeSize(headers.Get("Content-Range"), size),
+		ContentType:  headers.Get(oss.HTTPHeaderContentType),
+		ETag:         headers.Get(oss.HTTPHeaderEtag),
+		LastModified: lastModified,
+	}, nil
+}
+
+func (c OSS) uploadSingle(ctx context.Context, bucketName, key, contentType string, data []byte) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return "", err
+	}
+	var headers http.Header
+	err = bucket.PutObject(key, bytes.NewReader(data),
+		oss.ObjectACL(oss.ACLPrivate),
+		oss.ContentType(contentType),
+		oss.ContentLength(int64(len(data))),
+		oss.GetResponseHeader(&headers),
+	)
+	if err != nil {
+		return "", err
+	}
+	return headers.Get(oss.HTTPHeaderEtag), nil
+}
+
+func (c OSS) createUpload(ctx context.Context, bucketName, key, contentType string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return "", err
+	}
+	imur, err := bucket.InitiateMultipartUpload(key, oss.ObjectACL(oss.ACLPrivate), oss.ContentType(contentType))
+	if err != nil {
+		return "", err
+	}
+	return imur.UploadID, nil
+}
+
+func (c OSS) uploadPart(ctx context.Context, bucketName, key, uploadID string, number int, data []byte) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return "", err
+	}
+	part, err := bucket.UploadPart(ossUpload(bucketName, key, uploadID), bytes.NewReader(data), int64(len(data)), number)
+	if err != nil {
+		return "", err
+	}
+	return part.ETag, nil
+}
+
+func (c OSS) listParts(ctx context.Context, bucketName, key, uploadID string) ([]UploadPart, error) {
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	var parts []UploadPart
+	marker := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result, err := bucket.ListUploadedParts(ossUpload(bucketName, key, uploadID), oss.PartNumberMarker(marker))
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range result.UploadedParts {
+			parts = append(parts, UploadPart{Number: part.PartNumber, ETag: part.ETag, Size: int64(part.Size)})
+		}
+		if !result.IsTruncated {
+			return parts, nil
+		}
+		if marker, err = strconv.Atoi(result.NextPartNumberMarker); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c OSS) completeUpload(ctx context.Context, bucketName, key, uploadID string, parts []UploadPart) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return "", err
+	}
+	completed := make([]oss.UploadPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, oss.UploadPart{PartNumber: part.Number, ETag: part.ETag})
+	}
+	result, err := bucket.CompleteMultipartUpload(ossUpload(bucketName, key, uploadID), completed)
+	if err != nil {
+		return "", err
+	}
+	return result.ETag, nil
+}
+
+func (c OSS) abortUpload(ctx context.Context, bucketName, key, uploadID string) error {
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	return bucket.AbortMultipartUpload(ossUpload(bucketName, key, uploadID))
+}
+
+func ossUpload(bucket, key, uploadID string) oss.InitiateMultipartUploadResult {
+	return oss.InitiateMultipartUploadResult{Bucket: bucket, Key: key, UploadID: uploadID}
+}
+
+// ListObjects returns a page of the objects of an OSS bucket
+func (c OSS) ListObjects(ctx context.Context, bucketName string, options *ListObjectsOptions) (ListObjectsResult, error) {
+	if err := ctx.Err(); err != nil {
+		return ListObjectsResult{}, err
+	}
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return ListObjectsResult{}, err
+	}
+	opts := options.withDefaults()
+	listOptions := []oss.Option{oss.Prefix(opts.Prefix), oss.MaxKeys(opts.MaxKeys)}
+	if opts.Delimiter != "" {
+		listOptions = append(listOptions, oss.Delimiter(opts.Delimiter))
+	}
+	if opts.Token != "" {
+		listOptions = append(listOptions, oss.ContinuationToken(opts.Token))
+	}
+	resp, err := bucket.ListObjectsV2(listOptions...)
+	if err != nil {
+		logger.Error("error listing OSS objects: ", err)
+		return ListObjectsResult{}, err
+	}
+
+	result := ListObjectsResult{
+		CommonPrefixes: resp.CommonPrefixes,
+		NextToken:      resp.NextContinuationToken,
+		IsTruncated:    resp.IsTruncated,
+	}
+	for _, object := range resp.Objects {
+		result.Objects = append(result.Objects, ObjectInfo{
+			Bucket:       bucketName,
+			Key:          object.Key,
+			Size:         object.Size,
+			ETag:         object.ETag,
+			LastModified: object.LastModified,
+		})
+	}
+	return result, nil
+}
+
+// HeadObject returns the properties and the metadata of an OSS object
+func (c OSS) HeadObject(ctx context.Context, bucketName, key string) (ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return ObjectInfo{}, err
+	}
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	key = bucketKey(key)
+	headers, err := bucket.GetObjectDetailedMeta(key)
+	if err != nil {
+		return ObjectInfo{}, ossNotFound(err, bucketName, key)
+	}
+
+	size, _ := strconv.ParseInt(headers.Get(oss.HTTPHeaderContentLength), 10, 64)
+	lastModified, _ := http.ParseTime(headers.Get(oss.HTTPHeaderLastModified))
+	metadata := map[string]string{}
+	for name := range headers {
+		if meta, ok := strings.CutPrefix(strings.ToLower(name), strings.ToLower(oss.HTTPHeaderOssMetaPrefix)); ok {
+			metadata[meta] = headers.Get(name)
+		}
+	}
+	return ObjectInfo{
+		Bucket:       bucketName,
+		Key:          key,
+		Size:         size,
+		ContentType:  headers.Get(oss.HTTPHeaderContentType),
+		ETag:         headers.Get(oss.HTTPHeaderEtag),
+		LastModified: lastModified,
+		Metadata:     metadata,
+	}, nil
+}
+
+// DeleteObject deletes an OSS object, deleting a missing object succeeds
+func (c OSS) DeleteObject(ctx context.Context, bucketName, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	return bucket.DeleteObject(bucketKey(key))
+}
+
+// DeleteObjects deletes OSS objects DELETE_OBJECTS_BATCH keys at a time
+func (c OSS) DeleteObjects(ctx context.Context, bucketName string, keys []string) error {
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(keys); start += DELETE_OBJECTS_BATCH {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := start + DELETE_OBJECTS_BATCH
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := make([]string, 0, end-start)
+		for _, key := range keys[start:end] {
+			batch = append(batch, bucketKey(key))
+		}
+		if _, err := bucket.DeleteObjects(batch, oss.DeleteObjectsQuiet(true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyObject copies an OSS object, its metadata and tags are copied with it
+func (c OSS) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	bucket, err := c.Client.Bucket(dstBucket)
+	if err != nil {
+		return err
+	}
+	_, err = bucket.CopyObjectFrom(srcBucket, bucketKey(srcKey), bucketKey(dstKey), oss.ObjectACL(oss.ACLPrivate))
+	if err != nil {
+		logger.Error("error copying OSS object: ", err)
+	}
+	return err
+}
+
+// MoveObject copies an OSS object then deletes the source
+func (c OSS) MoveObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	return moveObject(ctx, c, srcBucket, srcKey, dstBucket, dstKey)
+}
+
+// GetObjectTags returns the tags of an OSS object
+func (c OSS) GetObjectTags(ctx context.Context, bucketName, key string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	key = bucketKey(key)
+	result, err := bucket.GetObjectTagging(key)
+	if err != nil {
+		return nil, ossNotFound(err, bucketName, key)
+	}
+	tags := make(map[string]string, len(result.Tags))
+	for _, tag := range result.Tags {
+		tags[tag.Key] = tag.Value
+	}
+	return tags, nil
+}
+
+// SetObjectTags replaces the tags of an OSS object, OSS allows 10 tags per object
+func (c OSS) SetObjectTags(ctx context.Context, bucketName, key string, tags map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	tagging := oss.Tagging{}
+	for _, name := range sortedKeys(tags) {
+		tagging.Tags = append(tagging.Tags, oss.Tag{Key: name, Value: tags[name]})
+	}
+	return bucket.PutObjectTagging(bucketKey(key), tagging)
+}
+
+// GetObjectMetadata returns the user metadata of an OSS object
+func (c OSS) GetObjectMetadata(ctx context.Context, bucket, key string) (map[string]string, error) {
+	info, err := c.HeadObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return info.Metadata, nil
+}
+
+// SetObjectMetadata replaces the user metadata of an OSS object
+func (c OSS) SetObjectMetadata(ctx context.Context, bucketName, key string, metadata map[string]string) error {
+	info, err := c.HeadObject(ctx, bucketName, key)
+	if err != nil {
+		return err
+	}
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	options := []oss.Option{oss.ContentType(info.ContentType)}
+	for _, name := range sortedKeys(metadata) {
+		options = append(options, oss.Meta(name, metadata[name]))
+	}
+	return bucket.SetObjectMeta(info.Key, options...)
+}
+
+// ossNotFound wraps ErrObjectNotFound in the 404 errors of OSS
+func ossNotFound(err error, bucket, key string) error {
+	var svcErr oss.ServiceError
+	if errors.As(err, &svcErr) && svcErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s/%s: %w: %s", bucket, key, ErrObjectNotFound, svcErr.Code)
+	}
+	return err
+}
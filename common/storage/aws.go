@@ -2,14 +2,24 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"mime"
+	"net/http"
+	"net/url"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
@@ -49,6 +59,57 @@ func (c S3) CreatePresignedUpload(payload *CreatePresignedUploadRequest) (*Creat
 	return resp, nil
 }
 
+// CreatePresignedPost creates a POST policy upload signed with AWS Signature Version 4,
+// S3 rejects the files breaking the content type and size conditions
+func (c S3) CreatePresignedPost(payload *CreatePresignedUploadRequest, conditions PostConditions) (*CreatePresignedUploadResponse, error) {
+	creds, err := c.Client.Config.Credentials.Get()
+	if err != nil {
+		logger.Error("error while reading the AWS credentials ", err)
+		return nil, err
+	}
+	region := c.Client.SigningRegion
+	if region == "" {
+		region = aws.StringValue(c.Client.Config.Region)
+	}
+	postURL, err := url.Parse(c.Client.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if aws.BoolValue(c.Client.Config.S3ForcePathStyle) {
+		postURL.Path = "/" + *payload.Bucket
+	} else {
+		postURL.Host = *payload.Bucket + "." + postURL.Host
+	}
+
+	now := time.Now().UTC()
+	scope := now.Format("20060102") + "/" + region + "/s3/aws4_request"
+	post := newPresignedPost(payload, bucketKey(sanitizeFileNameForUpload(*payload.Filename)), conditions, now)
+	post.fields["x-amz-algorithm"] = "AWS4-HMAC-SHA256"
+	post.fields["x-amz-credential"] = creds.AccessKeyID + "/" + scope
+	post.fields["x-amz-date"] = now.Format("20060102T150405Z")
+	if creds.SessionToken != "" {
+		post.fields["x-amz-security-token"] = creds.SessionToken
+	}
+	policy, err := post.encodePolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range strings.Split(scope, "/") {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	post.fields[POST_FIELD_POLICY] = policy
+	post.fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(signingKey, policy))
+	return post.response(postURL.String()), nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
 // CreatePresignedView return s3 object presigned view url with given input
 func (c S3) CreatePresignedView(payload *CreatePresignedViewRequest) (*CreatePresignedViewResponse, error) {
 	if payload.Duration == 0 {
@@ -87,7 +148,7 @@ func (c S3) GetObjectBuffer(payload *GetObjectBufferRequest) ([]byte, error) {
 	})
 	if err != nil {
 		logger.Error("error during get object for S3 object: ", err)
-		return nil, err
+		return nil, s3NotFound(err, aws.StringValue(payload.Bucket), aws.StringValue(payload.Key))
 	}
 	io := resp.Body
 	data, err := ioutil.ReadAll(resp.Body)
@@ -151,3 +212,324 @@ func cleanKey(key *string) *string {
 
 	return key
 }
+
+// PutObject streams body to S3, bodies larger than the multipart threshold are uploaded in parts
+func (c S3) PutObject(ctx context.Context, bucket, key string, body io.Reader, opts *PutObjectOptions) (ObjectInfo, error) {
+	return uploadObject(ctx, c, bucket, aws.StringValue(cleanKey(&key)), body, opts)
+}
+
+// GetObject streams an S3 object
+func (c S3) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error) {
+	return c.getObject(ctx, bucket, key, nil)
+}
+
+// GetObjectRange streams length bytes of an S3 object from offset
+func (c S3) GetObjectRange(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, ObjectInfo, error) {
+	if offset < 0 {
+		return nil, ObjectInfo{}, fmt.Errorf("%w: offset %d", ErrInvalidRange, offset)
+	}
+	return c.getObject(ctx, bucket, key, aws.String(rangeHeader(offset, length)))
+}
+
+// AbortUpload removes the parts of an S3 multipart upload
+func (c S3) AbortUpload(ctx context.Context, bucket, key, uploadID string) error {
+	return c.abortUpload(ctx, bucket, aws.StringValue(cleanKey(&key)), uploadID)
+}
+
+func (c S3) getObject(ctx context.Context, bucket, key string, byteRange *string) (io.ReadCloser, ObjectInfo, error) {
+	key = aws.StringValue(cleanKey(&key))
+	resp, err := c.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  byteRange,
+	})
+	if err != nil {
+		logger.Error("error during get object for S3 object: ", err)
+		return nil, ObjectInfo{}, err
+	}
+	return resp.Body, ObjectInfo{
+		Bucket:       bucket,
+		Key:          key,
+		Size:         contentRangeSize(aws.StringValue(resp.ContentRange), aws.Int64Value(resp.ContentLength)),
+		ContentType:  aws.StringValue(resp.ContentType),
+		ETag:         aws.StringValue(resp.ETag),
+		LastModified: aws.TimeValue(resp.LastModified),
+	}, nil
+}
+
+func (c S3) uploadSingle(ctx context.Context, bucket, key, contentType string, data []byte) (string, error) {
+	resp, err := c.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		ACL:           aws.String("private"),
+		Body:          bytes.NewReader(data),
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.ETag), nil
+}
+
+func (c S3) createUpload(ctx context.Context, bucket, key, contentType string) (string, error) {
+	resp, err := c.Client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		ACL:         aws.String("private"),
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.UploadId), nil
+}
+
+func (c S3) uploadPart(ctx context.Context, bucket, key, uploadID string, number int, data []byte) (string, error) {
+	resp, err := c.Client.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Body:          bytes.NewReader(data),
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int64(int64(number)),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.ETag), nil
+}
+
+func (c S3) listParts(ctx context.Context, bucket, key, uploadID string) ([]UploadPart, error) {
+	var parts []UploadPart
+	err := c.Client.ListPartsPagesWithContext(ctx, &s3.ListPartsInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, part := range page.Parts {
+			parts = append(parts, UploadPart{
+				Number: int(aws.Int64Value(part.PartNumber)),
+				ETag:   aws.StringValue(part.ETag),
+				Size:   aws.Int64Value(part.Size),
+			})
+		}
+		return true
+	})
+	return parts, err
+}
+
+func (c S3) completeUpload(ctx context.Context, bucket, key, uploadID string, parts []UploadPart) (string, error) {
+	completed := make([]*s3.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(int64(part.Number)),
+		})
+	}
+	resp, err := c.Client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.ETag), nil
+}
+
+func (c S3) abortUpload(ctx context.Context, bucket, key, uploadID string) error {
+	_, err := c.Client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
+// ListObjects returns a page of the objects of an S3 bucket
+func (c S3) ListObjects(ctx context.Context, bucket string, options *ListObjectsOptions) (ListObjectsResult, error) {
+	opts := options.withDefaults()
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(opts.Prefix),
+		MaxKeys: aws.Int64(int64(opts.MaxKeys)),
+	}
+	if opts.Delimiter != "" {
+		input.Delimiter = aws.String(opts.Delimiter)
+	}
+	if opts.Token != "" {
+		input.ContinuationToken = aws.String(opts.Token)
+	}
+	resp, err := c.Client.ListObjectsV2WithContext(ctx, input)
+	if err != nil {
+		logger.Error("error listing S3 objects: ", err)
+		return ListObjectsResult{}, err
+	}
+
+	result := ListObjectsResult{
+		NextToken:   aws.StringValue(resp.NextContinuationToken),
+		IsTruncated: aws.BoolValue(resp.IsTruncated),
+	}
+	for _, object := range resp.Contents {
+		result.Objects = append(result.Objects, ObjectInfo{
+			Bucket:       bucket,
+			Key:          aws.StringValue(object.Key),
+			Size:         aws.Int64Value(object.Size),
+			ETag:         aws.StringValue(object.ETag),
+			LastModified: aws.TimeValue(object.LastModified),
+		})
+	}
+	for _, prefix := range resp.CommonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, aws.StringValue(prefix.Prefix))
+	}
+	return result, nil
+}
+
+// HeadObject returns the properties and the metadata of an S3 object
+func (c S3) HeadObject(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	key = bucketKey(key)
+	resp, err := c.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ObjectInfo{}, s3NotFound(err, bucket, key)
+	}
+	return ObjectInfo{
+		Bucket:       bucket,
+		Key:          key,
+		Size:         aws.Int64Value(resp.ContentLength),
+		ContentType:  aws.StringValue(resp.ContentType),
+		ETag:         aws.StringValue(resp.ETag),
+		LastModified: aws.TimeValue(resp.LastModified),
+		Metadata:     lowerKeys(aws.StringValueMap(resp.Metadata)),
+	}, nil
+}
+
+// DeleteObject deletes an S3 object, deleting a missing object succeeds
+func (c S3) DeleteObject(ctx context.Context, bucket, key string) error {
+	_, err := c.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(bucketKey(key)),
+	})
+	return err
+}
+
+// DeleteObjects deletes S3 objects DELETE_OBJECTS_BATCH keys at a time
+func (c S3) DeleteObjects(ctx context.Context, bucket string, keys []string) error {
+	var errs []error
+	for start := 0; start < len(keys); start += DELETE_OBJECTS_BATCH {
+		end := start + DELETE_OBJECTS_BATCH
+		if end > len(keys) {
+			end = len(keys)
+		}
+		objects := make([]*s3.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(bucketKey(key))})
+		}
+		resp, err := c.Client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		for _, deleteErr := range resp.Errors {
+			errs = append(errs, fmt.Errorf("%s: %s", aws.StringValue(deleteErr.Key), aws.StringValue(deleteErr.Message)))
+		}
+	}
+	return joinDeleteErrors(bucket, errs)
+}
+
+// CopyObject copies an S3 object, its metadata and tags are copied with it
+func (c S3) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	_, err := c.Client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		ACL:        aws.String("private"),
+		Bucket:     aws.String(dstBucket),
+		Key:        aws.String(bucketKey(dstKey)),
+		CopySource: aws.String(s3CopySource(srcBucket, srcKey)),
+	})
+	if err != nil {
+		logger.Error("error copying S3 object: ", err)
+	}
+	return err
+}
+
+// MoveObject copies an S3 object then deletes the source
+func (c S3) MoveObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	return moveObject(ctx, c, srcBucket, srcKey, dstBucket, dstKey)
+}
+
+// GetObjectTags returns the tags of an S3 object
+func (c S3) GetObjectTags(ctx context.Context, bucket, key string) (map[string]string, error) {
+	key = bucketKey(key)
+	resp, err := c.Client.GetObjectTaggingWithContext(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s3NotFound(err, bucket, key)
+	}
+	tags := make(map[string]string, len(resp.TagSet))
+	for _, tag := range resp.TagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return tags, nil
+}
+
+// SetObjectTags replaces the tags of an S3 object, S3 allows 10 tags per object
+func (c S3) SetObjectTags(ctx context.Context, bucket, key string, tags map[string]string) error {
+	tagSet := make([]*s3.Tag, 0, len(tags))
+	for _, name := range sortedKeys(tags) {
+		tagSet = append(tagSet, &s3.Tag{Key: aws.String(name), Value: aws.String(tags[name])})
+	}
+	_, err := c.Client.PutObjectTaggingWithContext(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(bucketKey(key)),
+		Tagging: &s3.Tagging{TagSet: tagSet},
+	})
+	return err
+}
+
+// GetObjectMetadata returns the user metadata of an S3 object
+func (c S3) GetObjectMetadata(ctx context.Context, bucket, key string) (map[string]string, error) {
+	info, err := c.HeadObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return info.Metadata, nil
+}
+
+// SetObjectMetadata replaces the user metadata of an S3 object by copying it onto itself
+func (c S3) SetObjectMetadata(ctx context.Context, bucket, key string, metadata map[string]string) error {
+	info, err := c.HeadObject(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	_, err = c.Client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		ACL:               aws.String("private"),
+		Bucket:            aws.String(bucket),
+		Key:               aws.String(info.Key),
+		CopySource:        aws.String(s3CopySource(bucket, info.Key)),
+		ContentType:       aws.String(info.ContentType),
+		Metadata:          aws.StringMap(metadata),
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+	})
+	return err
+}
+
+// s3CopySource returns the url encoded bucket/key the CopyObject API expects
+func s3CopySource(bucket, key string) string {
+	return (&url.URL{Path: bucket + "/" + bucketKey(key)}).EscapedPath()
+}
+
+// s3NotFound wraps ErrObjectNotFound in the 404 errors of S3
+func s3NotFound(err error, bucket, key string) error {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%s/%s: %w: %s", bucket, key, ErrObjectNotFound, reqErr.Code())
+	}
+	return err
+}
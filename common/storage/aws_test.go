@@ -1,16 +1,26 @@
 package storage
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
+	"errors"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/joho/godotenv"
 	"github.com/rohanchauhan02/clean/common/storage/mock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -256,3 +266,54 @@ func TestCreateS3ObjectBase64(t *testing.T) {
 	})
 
 }
+
+func TestS3PutObject(t *testing.T) {
+	fake := newFakeObjectServer()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String(mock.MockAWSRegion),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials(mock.MockAccessKey, mock.MockSecretKey, ""),
+	})
+	require.NoError(t, err)
+	client := S3{Client: s3.New(sess)}
+	body := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+
+	t.Run("test ok small body is uploaded at once", func(t *testing.T) {
+		info, err := client.PutObject(context.Background(), mock.MockBucket, mock.SanitizedS3Filename, bytes.NewReader(body[:10]), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "private/policy.pdf", info.Key)
+		assert.Equal(t, fakeETag(body[:10]), info.ETag)
+		assert.Equal(t, mock.DocumentMimeType, fake.types[mock.MockBucket+"/private/policy.pdf"])
+	})
+
+	t.Run("test ok large body is uploaded in parts", func(t *testing.T) {
+		info, err := client.PutObject(context.Background(), mock.MockBucket, "/claims/claim.mp4", bytes.NewReader(body), &PutObjectOptions{PartSize: 5, MultipartThreshold: 8, Concurrency: 2})
+		require.NoError(t, err)
+		assert.Equal(t, `"8-parts"`, info.ETag)
+		assert.Equal(t, int64(len(body)), info.Size)
+		assert.Equal(t, body, fake.objects[mock.MockBucket+"/claims/claim.mp4"])
+	})
+
+	t.Run("test ok range read", func(t *testing.T) {
+		data, info := readObject(t, client, mock.MockBucket, "/claims/claim.mp4", 30, 0)
+		assert.Equal(t, "uvwxyz", string(data))
+		assert.Equal(t, int64(len(body)), info.Size)
+	})
+
+	t.Run("test ok failed upload is aborted", func(t *testing.T) {
+		_, err := client.PutObject(context.Background(), mock.MockBucket, "/claims/failed.mp4", io.MultiReader(bytes.NewReader(body[:12]), iotest.ErrReader(errors.New("unexpected EOF from the client"))), &PutObjectOptions{PartSize: 5, MultipartThreshold: 8})
+		var uploadErr *UploadError
+		require.True(t, errors.As(err, &uploadErr))
+		assert.True(t, uploadErr.Aborted)
+		assert.Equal(t, []string{uploadErr.UploadID}, fake.aborted)
+		assert.NotContains(t, fake.objects, mock.MockBucket+"/claims/failed.mp4")
+	})
+
+	t.Run("test wrong object does not exist", func(t *testing.T) {
+		_, _, err := client.GetObject(context.Background(), mock.MockBucket, mock.MockAWSKeyFileNotExist)
+		assert.NotNil(t, err)
+	})
+}
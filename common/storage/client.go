@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/rohanchauhan02/clean/common/util"
@@ -14,13 +16,49 @@ type ClientOptions struct {
 	AccessKeyID     string `json:"accessKeyId"`
 	AccessKeySecret string `json:"accessKeySecret"`
 	Region          string `json:"region"`
+	// Root is the directory of the buckets of the local provider
+	Root string `json:"root"`
 }
 
 type Client interface {
 	CreatePresignedUpload(payload *CreatePresignedUploadRequest) (*CreatePresignedUploadResponse, error)
+	// CreatePresignedPost creates a form upload whose signed policy enforces conditions, the
+	// file has to be the last field of the form
+	CreatePresignedPost(payload *CreatePresignedUploadRequest, conditions PostConditions) (*CreatePresignedUploadResponse, error)
 	CreatePresignedView(payload *CreatePresignedViewRequest) (*CreatePresignedViewResponse, error)
 	GetObjectBuffer(payload *GetObjectBufferRequest) ([]byte, error)
 	PutObjectBase64(payload *CreateBase64UploadRequest) (*CreateBase64UploadResponse, error)
+	// PutObject streams body to key, large bodies are sent with a multipart upload
+	PutObject(ctx context.Context, bucket, key string, body io.Reader, opts *PutObjectOptions) (ObjectInfo, error)
+	// GetObject streams the object of key, the reader has to be closed
+	GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error)
+	// GetObjectRange streams length bytes of the object from offset, up to its end when length is 0
+	GetObjectRange(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, ObjectInfo, error)
+	// AbortUpload removes the parts of a multipart upload kept by PutObjectOptions.Resumable
+	AbortUpload(ctx context.Context, bucket, key, uploadID string) error
+
+	// The keys of the operations below may start with / like PREFIX_KEY_AWS or not like
+	// PREFIX_KEY_ALICLOUD, both name the same object and returned keys have no leading /
+
+	// ListObjects returns a page of the objects of bucket
+	ListObjects(ctx context.Context, bucket string, opts *ListObjectsOptions) (ListObjectsResult, error)
+	// HeadObject returns the size, content type, etag and metadata of an object, the error
+	// wraps ErrObjectNotFound when it does not exist
+	HeadObject(ctx context.Context, bucket, key string) (ObjectInfo, error)
+	DeleteObject(ctx context.Context, bucket, key string) error
+	// DeleteObjects deletes keys in batches, the error lists the keys that were not deleted
+	DeleteObjects(ctx context.Context, bucket string, keys []string) error
+	// CopyObject copies an object with its metadata and tags
+	CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error
+	// MoveObject copies an object then deletes the source
+	MoveObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error
+	GetObjectTags(ctx context.Context, bucket, key string) (map[string]string, error)
+	// SetObjectTags replaces the tags of an object
+	SetObjectTags(ctx context.Context, bucket, key string, tags map[string]string) error
+	// GetObjectMetadata returns the user metadata of an object with lower case names
+	GetObjectMetadata(ctx context.Context, bucket, key string) (map[string]string, error)
+	// SetObjectMetadata replaces the user metadata of an object, its content type is kept
+	SetObjectMetadata(ctx context.Context, bucket, key string, metadata map[string]string) error
 }
 
 type Options struct {
@@ -61,6 +99,34 @@ func NewClient(options *ClientOptions) (Client, error) {
 			}
 			return s3, nil
 		}
+	case "gcp":
+		{
+			// AccessKeySecret is the JSON key of the service account, or its PEM private
+			// key with AccessKeyID set to the service account email
+			gcs, err := NewGCS(options.AccessKeyID, options.AccessKeySecret, options.Endpoint)
+			if err != nil {
+				return nil, err
+			}
+			return gcs, nil
+		}
+	case "local":
+		{
+			// Endpoint is the url the Handler of the client is mounted on and AccessKeySecret
+			// signs its presigned urls
+			local, err := NewLocal(options.Root, options.Endpoint, options.AccessKeySecret)
+			if err != nil {
+				return nil, err
+			}
+			return local, nil
+		}
+	case "memory":
+		{
+			memory, err := NewMemory(options.Endpoint, options.AccessKeySecret)
+			if err != nil {
+				return nil, err
+			}
+			return memory, nil
+		}
 	default:
 		{
 			return nil, fmt.Errorf("provider:\"%s\" is not supported in storage module", options.Provider)
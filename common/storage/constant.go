@@ -8,4 +8,7 @@ const (
 	// alicloud PutObject doesn't allow for trailing /,
 	// folder names can be referenced with a / such as: private/file.pdf
 	PREFIX_KEY_ALICLOUD = "private"
+	// GCS object names do not start with a / either, /private/file.pdf keys
+	// are stored as private/file.pdf
+	PREFIX_KEY_GCP = "private"
 )
@@ -1 +1,626 @@
 package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_GCP_ENDPOINT = "https://storage.googleapis.com"
+	// GCP_MAX_DURATION is the longest validity of a V4 signed URL
+	GCP_MAX_DURATION = 7 * 24 * time.Hour
+	// gcpRequestDuration is the validity of the URLs GCS signs for its own requests
+	gcpRequestDuration  = 15 * time.Minute
+	gcpSigningAlgorithm = "GOOG4-RSA-SHA256"
+)
+
+// GCS implements Client on Google Cloud Storage. Every request, including the ones of
+// GetObjectBuffer and PutObjectBase64, goes through a V4 signed URL, so the service
+// account only needs its private key. Objects are private unless the bucket grants access
+//   - GoogleAccessID - the email of the service account
+//   - Endpoint - the scheme and host of the XML API, DEFAULT_GCP_ENDPOINT when empty
+type GCS struct {
+	GoogleAccessID string
+	PrivateKey     *rsa.PrivateKey
+	Endpoint       string
+	HTTPClient     *http.Client
+
+	now func() time.Time
+}
+
+// gcpServiceAccount holds the fields of a service account JSON key GCS needs
+type gcpServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+}
+
+type gcpError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// NewGCS returns a GCS client from the credentials of a service account. secret is either
+// its JSON key, or the PEM private key of the account named accessID
+func NewGCS(accessID, secret, endpoint string) (*GCS, error) {
+	keyPEM := secret
+	if strings.HasPrefix(strings.TrimSpace(secret), "{") {
+		var account gcpServiceAccount
+		if err := json.Unmarshal([]byte(secret), &account); err != nil {
+			return nil, fmt.Errorf("invalid GCP service account key: %w", err)
+		}
+		accessID = account.ClientEmail
+		keyPEM = account.PrivateKey
+	}
+	if accessID == "" {
+		return nil, errors.New("GCP service account email is empty")
+	}
+
+	privateKey, err := parseGCPPrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &GCS{
+		GoogleAccessID: accessID,
+		PrivateKey:     privateKey,
+		Endpoint:       endpoint,
+		HTTPClient:     http.DefaultClient,
+	}, nil
+}
+
+// CreatePresignedUpload creates a V4 signed url to PUT the file, the content type is signed
+// so the upload has to send the returned Mimetype
+func (c GCS) CreatePresignedUpload(payload *CreatePresignedUploadRequest) (*CreatePresignedUploadResponse, error) {
+	if payload.Duration == 0 {
+		payload.Duration = DEFAULT_DURATION
+	}
+
+	fileName := objectName(sanitizeFileNameForUpload(*payload.Filename))
+	mimeType := objectMimeType(*payload.Filename, payload.Mimetype)
+
+	preSignedURL, err := c.signURL(http.MethodPut, *payload.Bucket, fileName, payload.Duration, map[string]string{"content-type": mimeType}, nil)
+	if err != nil {
+		logger.Error("error while generating presigned url ", err)
+		return nil, err
+	}
+
+	resp := &CreatePresignedUploadResponse{
+		Filename: stringpointer(fileName),
+		Type:     payload.Type,
+		Mimetype: &mimeType,
+		Size:     payload.Size,
+		Bucket:   payload.Bucket,
+		Provider: payload.Provider,
+		URL:      &preSignedURL,
+		Key:      &fileName,
+	}
+	return resp, nil
+}
+
+// CreatePresignedPost creates a V4 POST policy upload signed with the private key, GCS
+// rejects the files breaking the content type and size conditions
+func (c GCS) CreatePresignedPost(payload *CreatePresignedUploadRequest, conditions PostConditions) (*CreatePresignedUploadResponse, error) {
+	if c.PrivateKey == nil {
+		return nil, errors.New("GCS client has no private key")
+	}
+	if payload.Duration == 0 {
+		payload.Duration = DEFAULT_DURATION
+	}
+	if payload.Duration < 0 || payload.Duration > GCP_MAX_DURATION {
+		return nil, fmt.Errorf("duration of a GCS signed url should be between 1s and %s, got %s", GCP_MAX_DURATION, payload.Duration)
+	}
+
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	timestamp := now().UTC()
+	post := newPresignedPost(payload, objectName(sanitizeFileNameForUpload(*payload.Filename)), conditions, timestamp)
+	post.fields["x-goog-algorithm"] = gcpSigningAlgorithm
+	post.fields["x-goog-credential"] = c.GoogleAccessID + "/" + timestamp.Format("20060102") + "/auto/storage/goog4_request"
+	post.fields["x-goog-date"] = timestamp.Format("20060102T150405Z")
+	policy, err := post.encodePolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(policy))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		logger.Error("error while generating presigned post ", err)
+		return nil, err
+	}
+	post.fields[POST_FIELD_POLICY] = policy
+	post.fields["x-goog-signature"] = hex.EncodeToString(signature)
+	return post.response(c.endpoint() + "/" + *payload.Bucket), nil
+}
+
+// CreatePresignedView creates a V4 signed url to GET an object already present in the bucket
+func (c GCS) CreatePresignedView(payload *CreatePresignedViewRequest) (*CreatePresignedViewResponse, error) {
+	if payload.Duration == 0 {
+		payload.Duration = DEFAULT_DURATION
+	}
+
+	fileName := objectName(*payload.Key)
+	preSignedURL, err := c.signURL(http.MethodGet, *payload.Bucket, fileName, payload.Duration, nil, nil)
+	if err != nil {
+		logger.Error("error while generating presigned url ", err)
+		return nil, err
+	}
+
+	resp := &CreatePresignedViewResponse{
+		Bucket:   payload.Bucket,
+		Provider: payload.Provider,
+		Key:      &fileName,
+		URL:      &preSignedURL,
+	}
+	return resp, nil
+}
+
+// GetObjectBuffer returns an object stored on the GCS bucket
+func (c GCS) GetObjectBuffer(payload *GetObjectBufferRequest) ([]byte, error) {
+	signedURL, err := c.signURL(http.MethodGet, *payload.Bucket, objectName(*payload.Key), gcpRequestDuration, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, signedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.do(req)
+	if err != nil {
+		logger.Error("error during get object for GCS object: ", err)
+		return nil, err
+	}
+	return data, nil
+}
+
+// PutObjectBase64 uploads an object to GCS with base64 string data
+func (c GCS) PutObjectBase64(payload *CreateBase64UploadRequest) (*CreateBase64UploadResponse, error) {
+	base64Data, err := base64.StdEncoding.DecodeString(*payload.Base64)
+	if err != nil {
+		logger.Error(fmt.Sprintf("error uploading %s err: %s", *payload.Filename, err.Error()))
+		return nil, err
+	}
+	length := int64(len(base64Data))
+	if payload.Size != nil {
+		length = *payload.Size
+	}
+
+	fileKey := objectName(sanitizeFileNameForUpload(*payload.Filename))
+	mimeType := objectMimeType(*payload.Filename, payload.Mimetype)
+
+	signedURL, err := c.signURL(http.MethodPut, *payload.Bucket, fileKey, gcpRequestDuration, map[string]string{"content-type": mimeType}, nil)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPut, signedURL, bytes.NewReader(base64Data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mimeType)
+	req.ContentLength = length
+
+	if _, err := c.do(req); err != nil {
+		logger.Error("error uploading object: ", err)
+		return nil, err
+	}
+
+	status := true
+	resp := &CreateBase64UploadResponse{
+		Filename: stringpointer(fileKey),
+		Type:     payload.Type,
+		Mimetype: stringpointer(mimeType),
+		Size:     &length,
+		Bucket:   payload.Bucket,
+		Provider: payload.Provider,
+		Status:   &status,
+	}
+	return resp, nil
+}
+
+// signURL returns a V4 signed url of the object valid for duration. headers are signed
+// with the host, the request has to send them with the same values. params are added to
+// the signed query, e.g. the uploadId of a multipart upload
+func (c GCS) signURL(method, bucket, object string, duration time.Duration, headers map[string]string, params url.Values) (string, error) {
+	if c.PrivateKey == nil {
+		return "", errors.New("GCS client has no private key")
+	}
+	if duration <= 0 || duration > GCP_MAX_DURATION {
+		return "", fmt.Errorf("duration of a GCS signed url should be between 1s and %s, got %s", GCP_MAX_DURATION, duration)
+	}
+	endpoint, err := url.Parse(c.endpoint())
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	timestamp := now().UTC()
+	datetime := timestamp.Format("20060102T150405Z")
+	scope := timestamp.Format("20060102") + "/auto/storage/goog4_request"
+
+	canonicalHeaders := map[string]string{"host": endpoint.Host}
+	for name, value := range headers {
+		if value != "" {
+			canonicalHeaders[strings.ToLower(name)] = strings.TrimSpace(value)
+		}
+	}
+	names := make([]string, 0, len(canonicalHeaders))
+	for name := range canonicalHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var headerLines strings.Builder
+	for _, name := range names {
+		headerLines.WriteString(name + ":" + canonicalHeaders[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := url.Values{}
+	for name, values := range params {
+		query[name] = values
+	}
+	query.Set("X-Goog-Algorithm", gcpSigningAlgorithm)
+	query.Set("X-Goog-Credential", c.GoogleAccessID+"/"+scope)
+	query.Set("X-Goog-Date", datetime)
+	query.Set("X-Goog-Expires", strconv.FormatInt(int64(duration/time.Second), 10))
+	query.Set("X-Goog-SignedHeaders", signedHeaders)
+	canonicalQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	resource := gcpEscapePath("/" + bucket + "/" + object)
+	canonicalRequest := strings.Join([]string{method, resource, canonicalQuery, headerLines.String(), signedHeaders, "UNSIGNED-PAYLOAD"}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{gcpSigningAlgorithm, datetime, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s://%s%s?%s&X-Goog-Signature=%s", endpoint.Scheme, endpoint.Host, resource, canonicalQuery, hex.EncodeToString(signature)), nil
+}
+
+// do sends req and returns the body of the response, GCS XML errors are returned with their code
+func (c GCS) do(req *http.Request) ([]byte, error) {
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("error while reading data from reader instance: ", err)
+		return nil, err
+	}
+	return data, nil
+}
+
+// send sends req and returns the response when it succeeds, its body has to be closed
+func (c GCS) send(req *http.Request) (*http.Response, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusMultipleChoices {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var gcsErr gcpError
+	if data, err := ioutil.ReadAll(resp.Body); err == nil && xml.Unmarshal(data, &gcsErr) == nil && gcsErr.Code != "" {
+		return nil, fmt.Errorf("GCS %s %s: %s: %s", req.Method, req.URL.Path, gcsErr.Code, gcsErr.Message)
+	}
+	return nil, fmt.Errorf("GCS %s %s: status %d", req.Method, req.URL.Path, resp.StatusCode)
+}
+
+func (c GCS) endpoint() string {
+	if c.Endpoint != "" {
+		return strings.TrimSuffix(c.Endpoint, "/")
+	}
+	return DEFAULT_GCP_ENDPOINT
+}
+
+// gcpEscapePath percent-encodes every segment of path like the V4 canonical request
+// expects, the / separating them are kept
+func gcpEscapePath(resource string) string {
+	segments := strings.Split(resource, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.QueryEscape(segment), "+", "%20")
+	}
+	return strings.Join(segments, "/")
+}
+
+func parseGCPPrivateKey(keyPEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("GCP private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GCP private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("GCP private key is not an RSA key")
+	}
+	return key, nil
+}
+
+type gcpInitiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type gcpPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	Size       int64  `xml:"Size,omitempty"`
+}
+
+type gcpListPartsResult struct {
+	IsTruncated          bool      `xml:"IsTruncated"`
+	NextPartNumberMarker int       `xml:"NextPartNumberMarker"`
+	Parts                []gcpPart `xml:"Part"`
+}
+
+type gcpCompleteMultipartUpload struct {
+	XMLName xml.Name  `xml:"CompleteMultipartUpload"`
+	Parts   []gcpPart `xml:"Part"`
+}
+
+type gcpCompleteMultipartUploadResult struct {
+	ETag string `xml:"ETag"`
+}
+
+// PutObject streams body to GCS, bodies larger than the multipart threshold are uploaded
+// with the multipart API of the XML API
+func (c GCS) PutObject(ctx context.Context, bucket, key string, body io.Reader, opts *PutObjectOptions) (ObjectInfo, error) {
+	return uploadObject(ctx, c, bucket, objectName(key), body, opts)
+}
+
+// GetObject streams a GCS object
+func (c GCS) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error) {
+	return c.getObject(ctx, bucket, key, "")
+}
+
+// GetObjectRange streams length bytes of a GCS object from offset
+func (c GCS) GetObjectRange(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, ObjectInfo, error) {
+	if offset < 0 {
+		return nil, ObjectInfo{}, fmt.Errorf("%w: offset %d", ErrInvalidRange, offset)
+	}
+	return c.getObject(ctx, bucket, key, rangeHeader(offset, length))
+}
+
+// AbortUpload removes the parts of a GCS multipart upload
+func (c GCS) AbortUpload(ctx context.Context, bucket, key, uploadID string) error {
+	return c.abortUpload(ctx, bucket, objectName(key), uploadID)
+}
+
+func (c GCS) getObject(ctx context.Context, bucket, key, byteRange string) (io.ReadCloser, ObjectInfo, error) {
+	key = objectName(key)
+	req, err := c.request(ctx, http.MethodGet, bucket, key, nil, nil, nil)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		logger.Error("error during get object for GCS object: ", err)
+		return nil, ObjectInfo{}, err
+	}
+	lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return resp.Body, ObjectInfo{
+		Bucket:       bucket,
+		Key:          key,
+		Size:         contentRangeSize(resp.Header.Get("Content-Range"), resp.ContentLength),
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: lastModified,
+	}, nil
+}
+
+func (c GCS) uploadSingle(ctx context.Context, bucket, key, contentType string, data []byte) (string, error) {
+	req, err := c.request(ctx, http.MethodPut, bucket, key, map[string]string{"content-type": contentType}, nil, data)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func (c GCS) createUpload(ctx context.Context, bucket, key, contentType string) (string, error) {
+	req, err := c.request(ctx, http.MethodPost, bucket, key, map[string]string{"content-type": contentType}, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	var result gcpInitiateMultipartUploadResult
+	if err := c.doXML(req, &result); err != nil {
+		return "", err
+	}
+	return result.UploadID, nil
+}
+
+func (c GCS) uploadPart(ctx context.Context, bucket, key, uploadID string, number int, data []byte) (string, error) {
+	params := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	req, err := c.request(ctx, http.MethodPut, bucket, key, nil, params, data)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func (c GCS) listParts(ctx context.Context, bucket, key, uploadID string) ([]UploadPart, error) {
+	var parts []UploadPart
+	marker := 0
+	for {
+		params := url.Values{"uploadId": {uploadID}}
+		if marker > 0 {
+			params.Set("part-number-marker", strconv.Itoa(marker))
+		}
+		req, err := c.request(ctx, http.MethodGet, bucket, key, nil, params, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result gcpListPartsResult
+		if err := c.doXML(req, &result); err != nil {
+			return nil, err
+		}
+		for _, part := range result.Parts {
+			parts = append(parts, UploadPart{Number: part.PartNumber, ETag: part.ETag, Size: part.Size})
+		}
+		if !result.IsTruncated {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+func (c GCS) completeUpload(ctx context.Context, bucket, key, uploadID string, parts []UploadPart) (string, error) {
+	complete := gcpCompleteMultipartUpload{}
+	for _, part := range parts {
+		complete.Parts = append(complete.Parts, gcpPart{PartNumber: part.Number, ETag: part.ETag})
+	}
+	data, err := xml.Marshal(complete)
+	if err != nil {
+		return "", err
+	}
+	req, err := c.request(ctx, http.MethodPost, bucket, key, nil, url.Values{"uploadId": {uploadID}}, data)
+	if err != nil {
+		return "", err
+	}
+	var result gcpCompleteMultipartUploadResult
+	if err := c.doXML(req, &result); err != nil {
+		return "", err
+	}
+	return result.ETag, nil
+}
+
+func (c GCS) abortUpload(ctx context.Context, bucket, key, uploadID string) error {
+	req, err := c.request(ctx, http.MethodDelete, bucket, key, nil, url.Values{"uploadId": {uploadID}}, nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
+
+// request returns a request to a signed url of the object, headers are signed and set on it
+func (c GCS) request(ctx context.Context, method, bucket, key string, headers map[string]string, params url.Values, body []byte) (*http.Request, error) {
+	signedURL, err := c.signURL(method, bucket, key, gcpRequestDuration, headers, params)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, signedURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
+
+func (c GCS) doXML(req *http.Request, result interface{}) error {
+	data, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(data, result)
+}
+
+// ListObjects is not supported by the GCS client yet
+func (c GCS) ListObjects(ctx context.Context, bucket string, opts *ListObjectsOptions) (ListObjectsResult, error) {
+	return ListObjectsResult{}, gcsNotSupported("ListObjects")
+}
+
+// HeadObject is not supported by the GCS client yet
+func (c GCS) HeadObject(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	return ObjectInfo{}, gcsNotSupported("HeadObject")
+}
+
+// DeleteObject is not supported by the GCS client yet
+func (c GCS) DeleteObject(ctx context.Context, bucket, key string) error {
+	return gcsNotSupported("DeleteObject")
+}
+
+// DeleteObjects is not supported by the GCS client yet
+func (c GCS) DeleteObjects(ctx context.Context, bucket string, keys []string) error {
+	return gcsNotSupported("DeleteObjects")
+}
+
+// CopyObject is not supported by the GCS client yet
+func (c GCS) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	return gcsNotSupported("CopyObject")
+}
+
+// MoveObject is not supported by the GCS client yet
+func (c GCS) MoveObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	return gcsNotSupported("MoveObject")
+}
+
+// GetObjectTags is not supported by the GCS client yet
+func (c GCS) GetObjectTags(ctx context.Context, bucket, key string) (map[string]string, error) {
+	return nil, gcsNotSupported("GetObjectTags")
+}
+
+// SetObjectTags is not supported by the GCS client yet
+func (c GCS) SetObjectTags(ctx context.Context, bucket, key string, tags map[string]string) error {
+	return gcsNotSupported("SetObjectTags")
+}
+
+// GetObjectMetadata is not supported by the GCS client yet
+func (c GCS) GetObjectMetadata(ctx context.Context, bucket, key string) (map[string]string, error) {
+	return nil, gcsNotSupported("GetObjectMetadata")
+}
+
+// SetObjectMetadata is not supported by the GCS client yet
+func (c GCS) SetObjectMetadata(ctx context.Context, bucket, key string, metadata map[string]string) error {
+	return gcsNotSupported("SetObjectMetadata")
+}
+
+func gcsNotSupported(op string) error {
+	return fmt.Errorf("GCS %s: %w", op, ErrNotSupported)
+}
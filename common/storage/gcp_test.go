@@ -1 +1,304 @@
 package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/rohanchauhan02/clean/common/storage/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const gcpTestAccount = "storage@qoala-mock.iam.gserviceaccount.com"
+
+// fakeGCS is a stand-in for the GCS XML API, it serves the objects of path style
+// signed urls after verifying their V4 signature with the public key of the account
+type fakeGCS struct {
+	*fakeObjectServer
+	t         *testing.T
+	publicKey *rsa.PublicKey
+	now       time.Time
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f.verify(r); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>SignatureDoesNotMatch</Code><Message>%s</Message></Error>`, err)
+		return
+	}
+	f.fakeObjectServer.ServeHTTP(w, r)
+}
+
+func (f *fakeGCS) verify(r *http.Request) error {
+	query := r.URL.Query()
+	if query.Get("X-Goog-Algorithm") != "GOOG4-RSA-SHA256" {
+		return fmt.Errorf("unsupported algorithm")
+	}
+	date, err := time.Parse("20060102T150405Z", query.Get("X-Goog-Date"))
+	if err != nil {
+		return err
+	}
+	expires, _ := strconv.Atoi(query.Get("X-Goog-Expires"))
+	if f.now.After(date.Add(time.Duration(expires) * time.Second)) {
+		return fmt.Errorf("request has expired")
+	}
+	if !strings.HasPrefix(query.Get("X-Goog-Credential"), gcpTestAccount+"/") {
+		return fmt.Errorf("unknown credential")
+	}
+	signature, err := hex.DecodeString(query.Get("X-Goog-Signature"))
+	if err != nil {
+		return err
+	}
+
+	var headers []string
+	for _, name := range strings.Split(query.Get("X-Goog-SignedHeaders"), ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers = append(headers, name+":"+value+"\n")
+	}
+	query.Del("X-Goog-Signature")
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, key := range keys {
+		params = append(params, url.QueryEscape(key)+"="+strings.ReplaceAll(url.QueryEscape(query.Get(key)), "+", "%20"))
+	}
+
+	canonicalRequest := strings.Join([]string{r.Method, r.URL.EscapedPath(), strings.Join(params, "&"), strings.Join(headers, ""), query.Get("X-Goog-SignedHeaders"), "UNSIGNED-PAYLOAD"}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := strings.TrimPrefix(query.Get("X-Goog-Credential"), gcpTestAccount+"/")
+	stringToSign := strings.Join([]string{"GOOG4-RSA-SHA256", query.Get("X-Goog-Date"), scope, hex.EncodeToString(requestHash[:])}, "\n")
+	digest := sha256.Sum256([]byte(stringToSign))
+	return rsa.VerifyPKCS1v15(f.publicKey, crypto.SHA256, digest[:], signature)
+}
+
+func newGCSTestClient(t *testing.T) (Client, *fakeGCS) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: mustMarshalPKCS8(t, privateKey)})
+	serviceAccount, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": gcpTestAccount,
+		"private_key":  string(keyPEM),
+	})
+	require.NoError(t, err)
+
+	fake := &fakeGCS{fakeObjectServer: newFakeObjectServer(), t: t, publicKey: &privateKey.PublicKey, now: time.Now()}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(&ClientOptions{
+		Provider:        "gcp",
+		Endpoint:        server.URL,
+		AccessKeySecret: string(serviceAccount),
+	})
+	require.NoError(t, err)
+	return client, fake
+}
+
+func mustMarshalPKCS8(t *testing.T, key *rsa.PrivateKey) []byte {
+	data, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return data
+}
+
+func TestGCSPutAndGetObject(t *testing.T) {
+	client, fake := newGCSTestClient(t)
+	data := []byte("%PDF-1.4 policy")
+
+	t.Run("test ok object is uploaded under the sanitized private key", func(t *testing.T) {
+		resp, err := client.PutObjectBase64(&CreateBase64UploadRequest{
+			Filename: stringpointer(mock.UnsanitizedS3FileName),
+			Bucket:   stringpointer(mock.MockBucket),
+			Provider: stringpointer(mock.ProviderGCP),
+			Base64:   stringpointer(base64.StdEncoding.EncodeToString(data)),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, mock.SanitizedOSSFileName, *resp.Filename)
+		assert.Equal(t, mock.DocumentMimeType, *resp.Mimetype)
+		assert.Equal(t, int64(len(data)), *resp.Size)
+		assert.True(t, *resp.Status)
+		assert.Equal(t, data, fake.objects[mock.MockBucket+"/"+mock.SanitizedOSSFileName])
+		assert.Equal(t, mock.DocumentMimeType, fake.types[mock.MockBucket+"/"+mock.SanitizedOSSFileName])
+	})
+
+	t.Run("test ok object is read with an S3 or an OSS style key", func(t *testing.T) {
+		for _, key := range []string{mock.SanitizedS3Filename, mock.SanitizedOSSFileName} {
+			got, err := client.GetObjectBuffer(&GetObjectBufferRequest{Bucket: stringpointer(mock.MockBucket), Key: stringpointer(key)})
+			assert.Nil(t, err)
+			assert.Equal(t, data, got)
+		}
+	})
+
+	t.Run("test wrong object does not exist", func(t *testing.T) {
+		_, err := client.GetObjectBuffer(&GetObjectBufferRequest{Bucket: stringpointer(mock.MockBucket), Key: stringpointer(mock.MockAWSKeyFileNotExist)})
+		assert.EqualError(t, err, "GCS GET /qoala-mock-testing/not_exist/dummy_pdf.pdf: NoSuchKey: The specified key does not exist.")
+	})
+
+	t.Run("test wrong invalid base64", func(t *testing.T) {
+		_, err := client.PutObjectBase64(&CreateBase64UploadRequest{
+			Filename: stringpointer(mock.DocumentFileName),
+			Bucket:   stringpointer(mock.MockBucket),
+			Base64:   stringpointer("not base64"),
+		})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGCSPresignedURLs(t *testing.T) {
+	client, fake := newGCSTestClient(t)
+
+	upload, err := client.CreatePresignedUpload(&CreatePresignedUploadRequest{
+		Bucket:   stringpointer(mock.MockBucket),
+		Filename: stringpointer("private/policy holder.pdf"),
+		Mimetype: stringpointer(mock.DocumentMimeType),
+		Provider: stringpointer(mock.ProviderGCP),
+		Size:     int64pointer(mock.DocumentSize),
+		Type:     stringpointer(mock.DocumentType),
+		Duration: 30 * time.Minute,
+	})
+	require.NoError(t, err)
+
+	t.Run("test ok upload url honors the duration and the content type", func(t *testing.T) {
+		assert.Equal(t, "private/policy-holder.pdf", *upload.Key)
+		assert.Equal(t, mock.DocumentMimeType, *upload.Mimetype)
+		parsed, err := url.Parse(*upload.URL)
+		require.NoError(t, err)
+		assert.Equal(t, "1800", parsed.Query().Get("X-Goog-Expires"))
+		assert.Equal(t, "content-type;host", parsed.Query().Get("X-Goog-SignedHeaders"))
+
+		req, _ := http.NewRequest(http.MethodPut, *upload.URL, strings.NewReader("%PDF-1.4"))
+		req.Header.Set("Content-Type", mock.DocumentMimeType)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("test wrong upload with another content type", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPut, *upload.URL, strings.NewReader("<html>"))
+		req.Header.Set("Content-Type", "text/html")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("test ok view url of the uploaded object", func(t *testing.T) {
+		view, err := client.CreatePresignedView(&CreatePresignedViewRequest{
+			Bucket: stringpointer(mock.MockBucket),
+			Key:    upload.Key,
+		})
+		require.NoError(t, err)
+		assert.Contains(t, *view.URL, "X-Goog-Expires=3600")
+
+		resp, err := http.Get(*view.URL)
+		require.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "%PDF-1.4", string(body))
+	})
+
+	t.Run("test wrong view url has expired", func(t *testing.T) {
+		view, err := client.CreatePresignedView(&CreatePresignedViewRequest{
+			Bucket:   stringpointer(mock.MockBucket),
+			Key:      upload.Key,
+			Duration: time.Minute,
+		})
+		require.NoError(t, err)
+
+		fake.now = time.Now().Add(2 * time.Minute)
+		defer func() { fake.now = time.Now() }()
+		resp, err := http.Get(*view.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("test wrong duration longer than seven days", func(t *testing.T) {
+		_, err := client.CreatePresignedView(&CreatePresignedViewRequest{
+			Bucket:   stringpointer(mock.MockBucket),
+			Key:      upload.Key,
+			Duration: 8 * 24 * time.Hour,
+		})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestNewGCSClient(t *testing.T) {
+	t.Run("test wrong service account without key", func(t *testing.T) {
+		_, err := NewClient(&ClientOptions{Provider: "gcp", AccessKeyID: gcpTestAccount, AccessKeySecret: "secret"})
+		assert.EqualError(t, err, "GCP private key is not PEM encoded")
+	})
+
+	t.Run("test wrong service account without email", func(t *testing.T) {
+		_, err := NewClient(&ClientOptions{Provider: "gcp", AccessKeySecret: `{"private_key":""}`})
+		assert.EqualError(t, err, "GCP service account email is empty")
+	})
+}
+
+func TestGCSPutObject(t *testing.T) {
+	client, fake := newGCSTestClient(t)
+	body := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+
+	t.Run("test ok large body is uploaded with the multipart api", func(t *testing.T) {
+		info, err := client.PutObject(context.Background(), mock.MockBucket, "/claims/claim.mp4", bytes.NewReader(body), &PutObjectOptions{PartSize: 5, MultipartThreshold: 8})
+		require.NoError(t, err)
+		assert.Equal(t, "claims/claim.mp4", info.Key)
+		assert.Equal(t, `"8-parts"`, info.ETag)
+		assert.Equal(t, body, fake.objects[mock.MockBucket+"/claims/claim.mp4"])
+		assert.Equal(t, "video/mp4", fake.types[mock.MockBucket+"/claims/claim.mp4"])
+	})
+
+	t.Run("test ok range read", func(t *testing.T) {
+		data, info := readObject(t, client, mock.MockBucket, "claims/claim.mp4", 10, 5)
+		assert.Equal(t, "abcde", string(data))
+		assert.Equal(t, int64(len(body)), info.Size)
+		assert.Equal(t, "video/mp4", info.ContentType)
+	})
+
+	t.Run("test ok resumable upload is aborted", func(t *testing.T) {
+		var uploadID string
+		_, err := client.PutObject(context.Background(), mock.MockBucket, "claims/other.mp4", io.MultiReader(bytes.NewReader(body[:12]), iotest.ErrReader(errors.New("unexpected EOF from the client"))), &PutObjectOptions{
+			PartSize:           5,
+			MultipartThreshold: 4,
+			Resumable:          true,
+			OnUploadCreated:    func(id string) { uploadID = id },
+		})
+		var uploadErr *UploadError
+		require.True(t, errors.As(err, &uploadErr))
+		assert.Equal(t, uploadID, uploadErr.UploadID)
+		assert.False(t, uploadErr.Aborted)
+		assert.Len(t, fake.uploads[uploadID], 2)
+
+		assert.Nil(t, client.AbortUpload(context.Background(), mock.MockBucket, "claims/other.mp4", uploadID))
+		assert.Equal(t, []string{uploadID}, fake.aborted)
+	})
+}
@@ -0,0 +1,532 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// Local keeps the buckets as directories of Root, it lets services run without cloud
+// credentials. Its presigned urls are served by Handler
+type Local struct {
+	Root   string
+	Signer URLSigner
+}
+
+// NewLocal returns a local client storing the objects under root. endpoint is the url
+// Handler is mounted on and secret signs its presigned urls
+func NewLocal(root, endpoint, secret string) (*Local, error) {
+	if root == "" {
+		return nil, errors.New("local storage root is empty")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	signer, err := NewURLSigner(endpoint, secret)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{
+		Root:   root,
+		Signer: signer,
+	}, nil
+}
+
+// CreatePresignedUpload creates a signed url to PUT the file through Handler, the content
+// type is signed so the upload has to send the returned Mimetype
+func (c Local) CreatePresignedUpload(payload *CreatePresignedUploadRequest) (*CreatePresignedUploadResponse, error) {
+	return presignUpload(c.Signer, payload)
+}
+
+// CreatePresignedPost creates a form upload to POST through Handler, the conditions of its
+// policy are checked by Handler
+func (c Local) CreatePresignedPost(payload *CreatePresignedUploadRequest, conditions PostConditions) (*CreatePresignedUploadResponse, error) {
+	return presignPost(c.Signer, payload, conditions)
+}
+
+// CreatePresignedView creates a signed url to GET an object through Handler
+func (c Local) CreatePresignedView(payload *CreatePresignedViewRequest) (*CreatePresignedViewResponse, error) {
+	return presignView(c.Signer, payload)
+}
+
+// GetObjectBuffer reads an object from the disk
+func (c Local) GetObjectBuffer(payload *GetObjectBufferRequest) ([]byte, error) {
+	return getObjectBuffer(c, payload)
+}
+
+// PutObjectBase64 writes an object to the disk with base64 string data
+func (c Local) PutObjectBase64(payload *CreateBase64UploadRequest) (*CreateBase64UploadResponse, error) {
+	return putObjectBase64(c, payload)
+}
+
+// Handler serves the presigned urls of the client, it should be mounted on the path of
+// the endpoint, e.g. e.Match([]string{echo.GET, echo.PUT, echo.POST}, "/storage/*", client.Handler())
+func (c Local) Handler() echo.HandlerFunc {
+	return presignedHandler(c.Signer, c)
+}
+
+func (c Local) putObject(bucket, name, mimeType string, data []byte) error {
+	filePath := c.objectPath(bucket, name)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return err
+	}
+
+	// the object is written next to its final path and renamed so readers never see a partial file
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return err
+	}
+	return c.removeMeta(bucket, name)
+}
+
+func (c Local) getObject(bucket, name string) ([]byte, string, error) {
+	data, err := ioutil.ReadFile(c.objectPath(bucket, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("%s/%s: %w", bucket, name, ErrObjectNotFound)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return data, objectMimeType(name, nil), nil
+}
+
+func (c Local) objectPath(bucket, name string) string {
+	return filepath.Join(c.Root, bucket, filepath.FromSlash(name))
+}
+
+type localUpload struct {
+	Bucket      string `json:"bucket"`
+	Key         string `json:"key"`
+	ContentType string `json:"content_type"`
+}
+
+// PutObject streams body to the disk, bodies larger than the multipart threshold are staged
+// in parts under the .uploads directory of Root like a cloud multipart upload
+func (c Local) PutObject(ctx context.Context, bucket, key string, body io.Reader, opts *PutObjectOptions) (ObjectInfo, error) {
+	if err := validateBucket(bucket); err != nil {
+		return ObjectInfo{}, err
+	}
+	return uploadObject(ctx, c, bucket, objectName(key), body, opts)
+}
+
+// GetObject opens an object of the disk
+func (c Local) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error) {
+	return c.GetObjectRange(ctx, bucket, key, 0, 0)
+}
+
+// GetObjectRange opens an object of the disk and reads length bytes from offset
+func (c Local) GetObjectRange(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, ObjectInfo, error) {
+	if err := validateBucket(bucket); err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	name := objectName(key)
+	file, err := os.Open(c.objectPath(bucket, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ObjectInfo{}, fmt.Errorf("%s/%s: %w", bucket, name, ErrObjectNotFound)
+	}
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, ObjectInfo{}, err
+	}
+	start, end, err := sliceRange(stat.Size(), offset, length)
+	if err == nil {
+		_, err = file.Seek(start, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, ObjectInfo{}, err
+	}
+	return readCloser{Reader: io.LimitReader(file, end-start), Closer: file}, ObjectInfo{
+		Bucket:       bucket,
+		Key:          name,
+		Size:         stat.Size(),
+		ContentType:  objectMimeType(name, nil),
+		LastModified: stat.ModTime(),
+	}, nil
+}
+
+// AbortUpload removes the staged parts of a multipart upload
+func (c Local) AbortUpload(ctx context.Context, bucket, key, uploadID string) error {
+	return c.abortUpload(ctx, bucket, objectName(key), uploadID)
+}
+
+func (c Local) uploadSingle(ctx context.Context, bucket, key, contentType string, data []byte) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := c.putObject(bucket, key, contentType, data); err != nil {
+		return "", err
+	}
+	return md5ETag(data), nil
+}
+
+func (c Local) createUpload(ctx context.Context, bucket, key, contentType string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	uploadID, err := newUploadID()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(c.uploadPath(uploadID), 0o755); err != nil {
+		return "", err
+	}
+	meta, err := json.Marshal(localUpload{Bucket: bucket, Key: key, ContentType: contentType})
+	if err != nil {
+		return "", err
+	}
+	return uploadID, ioutil.WriteFile(filepath.Join(c.uploadPath(uploadID), "upload.json"), meta, 0o644)
+}
+
+func (c Local) uploadPart(ctx context.Context, bucket, key, uploadID string, number int, data []byte) (string, error) {
+	if err := c.checkUpload(ctx, bucket, key, uploadID); err != nil {
+		return "", err
+	}
+	partPath := filepath.Join(c.uploadPath(uploadID), fmt.Sprintf("%05d.part", number))
+	if err := ioutil.WriteFile(partPath, data, 0o644); err != nil {
+		return "", err
+	}
+	return md5ETag(data), nil
+}
+
+func (c Local) listParts(ctx context.Context, bucket, key, uploadID string) ([]UploadPart, error) {
+	if err := c.checkUpload(ctx, bucket, key, uploadID); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(c.uploadPath(uploadID))
+	if err != nil {
+		return nil, err
+	}
+	var parts []UploadPart
+	for _, entry := range entries {
+		var number int
+		if _, err := fmt.Sscanf(entry.Name(), "%05d.part", &number); err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(c.uploadPath(uploadID), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, UploadPart{Number: number, ETag: md5ETag(data), Size: int64(len(data))})
+	}
+	return parts, nil
+}
+
+func (c Local) completeUpload(ctx context.Context, bucket, key, uploadID string, parts []UploadPart) (string, error) {
+	if err := c.checkUpload(ctx, bucket, key, uploadID); err != nil {
+		return "", err
+	}
+	filePath := c.objectPath(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	for _, part := range parts {
+		data, err := ioutil.ReadFile(filepath.Join(c.uploadPath(uploadID), fmt.Sprintf("%05d.part", part.Number)))
+		if err == nil && md5ETag(data) != part.ETag {
+			err = fmt.Errorf("part %d: etag does not match", part.Number)
+		}
+		if err == nil {
+			_, err = io.MultiWriter(tmp, hash).Write(data)
+		}
+		if err != nil {
+			tmp.Close()
+			return "", err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return "", err
+	}
+	if err := c.removeMeta(bucket, key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), os.RemoveAll(c.uploadPath(uploadID))
+}
+
+func (c Local) abortUpload(ctx context.Context, bucket, key, uploadID string) error {
+	if err := c.checkUpload(ctx, bucket, key, uploadID); err != nil {
+		return err
+	}
+	return os.RemoveAll(c.uploadPath(uploadID))
+}
+
+// checkUpload makes sure uploadID is a multipart upload of the object
+func (c Local) checkUpload(ctx context.Context, bucket, key, uploadID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return fmt.Errorf("invalid upload id: %q", uploadID)
+	}
+	meta, err := ioutil.ReadFile(filepath.Join(c.uploadPath(uploadID), "upload.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("upload %s: %w", uploadID, ErrUploadNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	var upload localUpload
+	if err := json.Unmarshal(meta, &upload); err != nil {
+		return err
+	}
+	if upload.Bucket != bucket || upload.Key != key {
+		return fmt.Errorf("upload %s is not an upload of %s/%s: %w", uploadID, bucket, key, ErrUploadNotFound)
+	}
+	return nil
+}
+
+func (c Local) uploadPath(uploadID string) string {
+	return filepath.Join(c.Root, ".uploads", uploadID)
+}
+
+// localMeta is kept in the hidden .<name>.meta.json file next to the object, it is removed
+// when the object is written again like the metadata of a cloud object
+type localMeta struct {
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// ListObjects returns a page of the objects of bucket sorted by key, the hidden files are skipped
+func (c Local) ListObjects(ctx context.Context, bucket string, options *ListObjectsOptions) (ListObjectsResult, error) {
+	if err := validateBucket(bucket); err != nil {
+		return ListObjectsResult{}, err
+	}
+	root := filepath.Join(c.Root, bucket)
+	var objects []ObjectInfo
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && filePath == root {
+			return nil
+		}
+		if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return err
+		}
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		stat, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		objects = append(objects, ObjectInfo{
+			Bucket:       bucket,
+			Key:          key,
+			Size:         stat.Size(),
+			ContentType:  objectMimeType(key, nil),
+			LastModified: stat.ModTime(),
+		})
+		return ctx.Err()
+	})
+	if err != nil {
+		return ListObjectsResult{}, err
+	}
+	// the directories are walked in lexical order of their names, not of the keys
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return listPage(objects, options.withDefaults()), nil
+}
+
+// HeadObject returns the properties and the metadata of an object, the ETag is the MD5 of the file
+func (c Local) HeadObject(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	reader, info, err := c.GetObject(ctx, bucket, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer reader.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return ObjectInfo{}, err
+	}
+	meta, err := c.readMeta(bucket, info.Key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info.ETag = hex.EncodeToString(hash.Sum(nil))
+	info.Metadata = copyMap(meta.Metadata)
+	return info, nil
+}
+
+// DeleteObject deletes an object, deleting a missing object succeeds
+func (c Local) DeleteObject(ctx context.Context, bucket, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := validateBucket(bucket); err != nil {
+		return err
+	}
+	name := objectName(key)
+	if err := os.Remove(c.objectPath(bucket, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return c.removeMeta(bucket, name)
+}
+
+// DeleteObjects deletes the objects of bucket, the keys that cannot be deleted are reported together
+func (c Local) DeleteObjects(ctx context.Context, bucket string, keys []string) error {
+	var errs []error
+	for _, key := range keys {
+		if err := c.DeleteObject(ctx, bucket, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinDeleteErrors(bucket, errs)
+}
+
+// CopyObject copies an object with its metadata and tags
+func (c Local) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := validateBucket(dstBucket); err != nil {
+		return err
+	}
+	if err := validateBucket(srcBucket); err != nil {
+		return err
+	}
+	srcName, dstName := objectName(srcKey), objectName(dstKey)
+	data, mimeType, err := c.getObject(srcBucket, srcName)
+	if err != nil {
+		return err
+	}
+	meta, err := c.readMeta(srcBucket, srcName)
+	if err != nil {
+		return err
+	}
+	if err := c.putObject(dstBucket, dstName, mimeType, data); err != nil {
+		return err
+	}
+	return c.writeMeta(dstBucket, dstName, meta)
+}
+
+// MoveObject copies an object then deletes the source
+func (c Local) MoveObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	return moveObject(ctx, c, srcBucket, srcKey, dstBucket, dstKey)
+}
+
+// GetObjectTags returns the tags of an object
+func (c Local) GetObjectTags(ctx context.Context, bucket, key string) (map[string]string, error) {
+	meta, err := c.objectMeta(ctx, bucket, objectName(key))
+	if err != nil {
+		return nil, err
+	}
+	return copyMap(meta.Tags), nil
+}
+
+// SetObjectTags replaces the tags of an object
+func (c Local) SetObjectTags(ctx context.Context, bucket, key string, tags map[string]string) error {
+	name := objectName(key)
+	meta, err := c.objectMeta(ctx, bucket, name)
+	if err != nil {
+		return err
+	}
+	meta.Tags = copyMap(tags)
+	return c.writeMeta(bucket, name, meta)
+}
+
+// GetObjectMetadata returns the user metadata of an object
+func (c Local) GetObjectMetadata(ctx context.Context, bucket, key string) (map[string]string, error) {
+	meta, err := c.objectMeta(ctx, bucket, objectName(key))
+	if err != nil {
+		return nil, err
+	}
+	return copyMap(meta.Metadata), nil
+}
+
+// SetObjectMetadata replaces the user metadata of an object
+func (c Local) SetObjectMetadata(ctx context.Context, bucket, key string, metadata map[string]string) error {
+	name := objectName(key)
+	meta, err := c.objectMeta(ctx, bucket, name)
+	if err != nil {
+		return err
+	}
+	meta.Metadata = lowerKeys(metadata)
+	return c.writeMeta(bucket, name, meta)
+}
+
+// objectMeta returns the metadata of an object, ErrObjectNotFound when the object is missing
+func (c Local) objectMeta(ctx context.Context, bucket, name string) (localMeta, error) {
+	if err := ctx.Err(); err != nil {
+		return localMeta{}, err
+	}
+	if err := validateBucket(bucket); err != nil {
+		return localMeta{}, err
+	}
+	if _, err := os.Stat(c.objectPath(bucket, name)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return localMeta{}, fmt.Errorf("%s/%s: %w", bucket, name, ErrObjectNotFound)
+		}
+		return localMeta{}, err
+	}
+	return c.readMeta(bucket, name)
+}
+
+func (c Local) readMeta(bucket, name string) (localMeta, error) {
+	var meta localMeta
+	data, err := ioutil.ReadFile(c.metaPath(bucket, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, err
+	}
+	return meta, json.Unmarshal(data, &meta)
+}
+
+func (c Local) writeMeta(bucket, name string, meta localMeta) error {
+	if len(meta.Metadata) == 0 && len(meta.Tags) == 0 {
+		return c.removeMeta(bucket, name)
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.metaPath(bucket, name), data, 0o644)
+}
+
+func (c Local) removeMeta(bucket, name string) error {
+	if err := os.Remove(c.metaPath(bucket, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (c Local) metaPath(bucket, name string) string {
+	filePath := c.objectPath(bucket, name)
+	return filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+".meta.json")
+}
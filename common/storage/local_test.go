@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rohanchauhan02/clean/common/storage/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalPutAndGetObject(t *testing.T) {
+	root := t.TempDir()
+	client, err := NewClient(&ClientOptions{Provider: "local", Root: root})
+	require.NoError(t, err)
+	data := []byte("%PDF-1.4 policy")
+
+	t.Run("test ok object is written under the root", func(t *testing.T) {
+		resp, err := client.PutObjectBase64(&CreateBase64UploadRequest{
+			Filename: stringpointer(mock.UnsanitizedS3FileName),
+			Bucket:   stringpointer(mock.MockBucket),
+			Base64:   stringpointer(base64.StdEncoding.EncodeToString(data)),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, mock.SanitizedOSSFileName, *resp.Filename)
+		assert.Equal(t, mock.DocumentMimeType, *resp.Mimetype)
+		assert.Equal(t, int64(len(data)), *resp.Size)
+		assert.True(t, *resp.Status)
+
+		onDisk, err := ioutil.ReadFile(filepath.Join(root, mock.MockBucket, "private", "policy.pdf"))
+		assert.Nil(t, err)
+		assert.Equal(t, data, onDisk)
+	})
+
+	t.Run("test ok object is read with an S3 or an OSS style key", func(t *testing.T) {
+		for _, key := range []string{mock.SanitizedS3Filename, mock.SanitizedOSSFileName} {
+			got, err := client.GetObjectBuffer(&GetObjectBufferRequest{Bucket: stringpointer(mock.MockBucket), Key: stringpointer(key)})
+			assert.Nil(t, err)
+			assert.Equal(t, data, got)
+		}
+	})
+
+	t.Run("test ok key cannot escape the root", func(t *testing.T) {
+		_, err := client.PutObjectBase64(&CreateBase64UploadRequest{
+			Filename: stringpointer("../../escaped.txt"),
+			Bucket:   stringpointer(mock.MockBucket),
+			Base64:   stringpointer(base64.StdEncoding.EncodeToString(data)),
+		})
+		require.NoError(t, err)
+		_, err = os.Stat(filepath.Join(root, mock.MockBucket, "escaped.txt"))
+		assert.Nil(t, err)
+	})
+
+	t.Run("test ok keys are listed by prefix", func(t *testing.T) {
+		assert.Equal(t, []string{mock.SanitizedOSSFileName}, listKeys(t, client, mock.MockBucket, "private/"))
+		assert.Empty(t, listKeys(t, client, "empty-bucket", ""))
+	})
+
+	t.Run("test wrong object does not exist", func(t *testing.T) {
+		_, err := client.GetObjectBuffer(&GetObjectBufferRequest{Bucket: stringpointer(mock.MockBucket), Key: stringpointer(mock.MockAWSKeyFileNotExist)})
+		assert.True(t, errors.Is(err, ErrObjectNotFound))
+	})
+
+	t.Run("test wrong bucket outside of the root", func(t *testing.T) {
+		_, err := client.GetObjectBuffer(&GetObjectBufferRequest{Bucket: stringpointer(".."), Key: stringpointer(mock.SanitizedOSSFileName)})
+		assert.EqualError(t, err, `invalid bucket name: ".."`)
+	})
+}
+
+func TestNewLocalClient(t *testing.T) {
+	t.Run("test wrong root is empty", func(t *testing.T) {
+		_, err := NewClient(&ClientOptions{Provider: "local"})
+		assert.EqualError(t, err, "local storage root is empty")
+	})
+}
+
+func TestLocalPutObject(t *testing.T) {
+	root := t.TempDir()
+	client, err := NewLocal(root, "", "")
+	require.NoError(t, err)
+	body := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+
+	t.Run("test ok large body is staged in parts", func(t *testing.T) {
+		info, err := client.PutObject(context.Background(), mock.MockBucket, "/claims/claim.mp4", bytes.NewReader(body), &PutObjectOptions{PartSize: 5, MultipartThreshold: 8})
+		require.NoError(t, err)
+		assert.Equal(t, "claims/claim.mp4", info.Key)
+		assert.Equal(t, md5ETag(body), info.ETag)
+
+		uploads, err := os.ReadDir(filepath.Join(root, ".uploads"))
+		assert.Nil(t, err)
+		assert.Empty(t, uploads)
+		assert.Equal(t, []string{"claims/claim.mp4"}, listKeys(t, client, mock.MockBucket, ""))
+	})
+
+	t.Run("test ok range read", func(t *testing.T) {
+		data, info := readObject(t, client, mock.MockBucket, "claims/claim.mp4", 10, 5)
+		assert.Equal(t, "abcde", string(data))
+		assert.Equal(t, int64(len(body)), info.Size)
+		assert.Equal(t, "video/mp4", info.ContentType)
+	})
+
+	t.Run("test wrong upload id outside of the uploads", func(t *testing.T) {
+		err := client.AbortUpload(context.Background(), mock.MockBucket, "claims/claim.mp4", "../claims")
+		assert.EqualError(t, err, `invalid upload id: "../claims"`)
+	})
+
+	t.Run("test wrong bucket of the uploads", func(t *testing.T) {
+		_, err := client.PutObject(context.Background(), ".uploads", "claim.mp4", bytes.NewReader(body), nil)
+		assert.EqualError(t, err, `invalid bucket name: ".uploads"`)
+	})
+}
@@ -25,6 +25,10 @@ type CreatePresignedUploadResponse struct {
 	Provider *string `json:"provider"`
 	URL      *string `json:"url"`
 	Key      *string `json:"key"`
+	// Method is POST for the form uploads of CreatePresignedPost, the file is sent with
+	// Fields in a multipart form. The other urls take a PUT
+	Method *string           `json:"method,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
 }
 
 type CreatePresignedViewRequest struct {
@@ -0,0 +1,373 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// Memory keeps the objects in a map, it is meant for the tests of the services using the
+// storage module. Copies of a Memory client share their objects
+type Memory struct {
+	Signer URLSigner
+
+	store *memoryStore
+}
+
+type memoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	uploads map[string]*memoryUpload
+}
+
+type memoryObject struct {
+	data     []byte
+	mimeType string
+	modified time.Time
+	metadata map[string]string
+	tags     map[string]string
+}
+
+type memoryUpload struct {
+	bucket      string
+	key         string
+	contentType string
+	parts       map[int][]byte
+}
+
+// NewMemory returns an empty memory client. endpoint is the url Handler is mounted on
+// and secret signs its presigned urls
+func NewMemory(endpoint, secret string) (*Memory, error) {
+	signer, err := NewURLSigner(endpoint, secret)
+	if err != nil {
+		return nil, err
+	}
+	return &Memory{
+		Signer: signer,
+		store:  &memoryStore{objects: map[string]memoryObject{}, uploads: map[string]*memoryUpload{}},
+	}, nil
+}
+
+// CreatePresignedUpload creates a signed url to PUT the file through Handler, the content
+// type is signed so the upload has to send the returned Mimetype
+func (c Memory) CreatePresignedUpload(payload *CreatePresignedUploadRequest) (*CreatePresignedUploadResponse, error) {
+	return presignUpload(c.Signer, payload)
+}
+
+// CreatePresignedPost creates a form upload to POST through Handler, the conditions of its
+// policy are checked by Handler
+func (c Memory) CreatePresignedPost(payload *CreatePresignedUploadRequest, conditions PostConditions) (*CreatePresignedUploadResponse, error) {
+	return presignPost(c.Signer, payload, conditions)
+}
+
+// CreatePresignedView creates a signed url to GET an object through Handler
+func (c Memory) CreatePresignedView(payload *CreatePresignedViewRequest) (*CreatePresignedViewResponse, error) {
+	return presignView(c.Signer, payload)
+}
+
+// GetObjectBuffer returns a copy of an object
+func (c Memory) GetObjectBuffer(payload *GetObjectBufferRequest) ([]byte, error) {
+	return getObjectBuffer(c, payload)
+}
+
+// PutObjectBase64 stores an object with base64 string data
+func (c Memory) PutObjectBase64(payload *CreateBase64UploadRequest) (*CreateBase64UploadResponse, error) {
+	return putObjectBase64(c, payload)
+}
+
+// Handler serves the presigned urls of the client, it should be mounted on the path of
+// the endpoint, e.g. e.Match([]string{echo.GET, echo.PUT, echo.POST}, "/storage/*", client.Handler())
+func (c Memory) Handler() echo.HandlerFunc {
+	return presignedHandler(c.Signer, c)
+}
+
+func (c Memory) putObject(bucket, name, mimeType string, data []byte) error {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	c.store.objects[bucket+"/"+name] = memoryObject{
+		data:     append([]byte(nil), data...),
+		mimeType: mimeType,
+		modified: time.Now(),
+	}
+	return nil
+}
+
+func (c Memory) getObject(bucket, name string) ([]byte, string, error) {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+	object, ok := c.store.objects[bucket+"/"+name]
+	if !ok {
+		return nil, "", fmt.Errorf("%s/%s: %w", bucket, name, ErrObjectNotFound)
+	}
+	return append([]byte(nil), object.data...), object.mimeType, nil
+}
+
+// PutObject stores body, bodies larger than the multipart threshold are kept in parts
+// until the upload completes like a cloud multipart upload
+func (c Memory) PutObject(ctx context.Context, bucket, key string, body io.Reader, opts *PutObjectOptions) (ObjectInfo, error) {
+	return uploadObject(ctx, c, bucket, objectName(key), body, opts)
+}
+
+// GetObject reads a copy of an object
+func (c Memory) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error) {
+	return c.GetObjectRange(ctx, bucket, key, 0, 0)
+}
+
+// GetObjectRange reads length bytes of an object from offset
+func (c Memory) GetObjectRange(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, ObjectInfo, error) {
+	name := objectName(key)
+	c.store.mu.RLock()
+	object, ok := c.store.objects[bucket+"/"+name]
+	c.store.mu.RUnlock()
+	if !ok {
+		return nil, ObjectInfo{}, fmt.Errorf("%s/%s: %w", bucket, name, ErrObjectNotFound)
+	}
+	start, end, err := sliceRange(int64(len(object.data)), offset, length)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	// objects are replaced and never modified, the slice can be read without the lock
+	return ioutil.NopCloser(bytes.NewReader(object.data[start:end])), object.info(bucket, name), nil
+}
+
+// AbortUpload drops the parts of a multipart upload
+func (c Memory) AbortUpload(ctx context.Context, bucket, key, uploadID string) error {
+	return c.abortUpload(ctx, bucket, objectName(key), uploadID)
+}
+
+func (c Memory) uploadSingle(ctx context.Context, bucket, key, contentType string, data []byte) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return md5ETag(data), c.putObject(bucket, key, contentType, data)
+}
+
+func (c Memory) createUpload(ctx context.Context, bucket, key, contentType string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	uploadID, err := newUploadID()
+	if err != nil {
+		return "", err
+	}
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	c.store.uploads[uploadID] = &memoryUpload{bucket: bucket, key: key, contentType: contentType, parts: map[int][]byte{}}
+	return uploadID, nil
+}
+
+func (c Memory) uploadPart(ctx context.Context, bucket, key, uploadID string, number int, data []byte) (string, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	upload, err := c.upload(ctx, bucket, key, uploadID)
+	if err != nil {
+		return "", err
+	}
+	upload.parts[number] = append([]byte(nil), data...)
+	return md5ETag(data), nil
+}
+
+func (c Memory) listParts(ctx context.Context, bucket, key, uploadID string) ([]UploadPart, error) {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+	upload, err := c.upload(ctx, bucket, key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]UploadPart, 0, len(upload.parts))
+	for number, data := range upload.parts {
+		parts = append(parts, UploadPart{Number: number, ETag: md5ETag(data), Size: int64(len(data))})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts, nil
+}
+
+func (c Memory) completeUpload(ctx context.Context, bucket, key, uploadID string, parts []UploadPart) (string, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	upload, err := c.upload(ctx, bucket, key, uploadID)
+	if err != nil {
+		return "", err
+	}
+	var data []byte
+	for _, part := range parts {
+		partData, ok := upload.parts[part.Number]
+		if !ok || md5ETag(partData) != part.ETag {
+			return "", fmt.Errorf("part %d: etag does not match", part.Number)
+		}
+		data = append(data, partData...)
+	}
+	delete(c.store.uploads, uploadID)
+	c.store.objects[bucket+"/"+key] = memoryObject{data: data, mimeType: upload.contentType, modified: time.Now()}
+	return md5ETag(data), nil
+}
+
+func (c Memory) abortUpload(ctx context.Context, bucket, key, uploadID string) error {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	if _, err := c.upload(ctx, bucket, key, uploadID); err != nil {
+		return err
+	}
+	delete(c.store.uploads, uploadID)
+	return nil
+}
+
+// upload returns the multipart upload uploadID of the object, store.mu has to be held
+func (c Memory) upload(ctx context.Context, bucket, key, uploadID string) (*memoryUpload, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	upload, ok := c.store.uploads[uploadID]
+	if !ok || upload.bucket != bucket || upload.key != key {
+		return nil, fmt.Errorf("upload %s of %s/%s: %w", uploadID, bucket, key, ErrUploadNotFound)
+	}
+	return upload, nil
+}
+
+// ListObjects returns a page of the objects of bucket sorted by key
+func (c Memory) ListObjects(ctx context.Context, bucket string, options *ListObjectsOptions) (ListObjectsResult, error) {
+	if err := ctx.Err(); err != nil {
+		return ListObjectsResult{}, err
+	}
+	c.store.mu.RLock()
+	var objects []ObjectInfo
+	for key, object := range c.store.objects {
+		if name, ok := strings.CutPrefix(key, bucket+"/"); ok {
+			objects = append(objects, object.info(bucket, name))
+		}
+	}
+	c.store.mu.RUnlock()
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return listPage(objects, options.withDefaults()), nil
+}
+
+// HeadObject returns the properties and the metadata of an object
+func (c Memory) HeadObject(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	name := objectName(key)
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+	object, err := c.object(ctx, bucket, name)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info := object.info(bucket, name)
+	info.Metadata = copyMap(object.metadata)
+	return info, nil
+}
+
+// DeleteObject deletes an object, deleting a missing object succeeds
+func (c Memory) DeleteObject(ctx context.Context, bucket, key string) error {
+	return c.DeleteObjects(ctx, bucket, []string{key})
+}
+
+// DeleteObjects deletes the objects of bucket
+func (c Memory) DeleteObjects(ctx context.Context, bucket string, keys []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	for _, key := range keys {
+		delete(c.store.objects, bucket+"/"+objectName(key))
+	}
+	return nil
+}
+
+// CopyObject copies an object with its metadata and tags
+func (c Memory) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	object, err := c.object(ctx, srcBucket, objectName(srcKey))
+	if err != nil {
+		return err
+	}
+	// the data is never modified, only the maps have to be copied
+	object.metadata = copyMap(object.metadata)
+	object.tags = copyMap(object.tags)
+	object.modified = time.Now()
+	c.store.objects[dstBucket+"/"+objectName(dstKey)] = object
+	return nil
+}
+
+// MoveObject copies an object then deletes the source
+func (c Memory) MoveObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	return moveObject(ctx, c, srcBucket, srcKey, dstBucket, dstKey)
+}
+
+// GetObjectTags returns the tags of an object
+func (c Memory) GetObjectTags(ctx context.Context, bucket, key string) (map[string]string, error) {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+	object, err := c.object(ctx, bucket, objectName(key))
+	if err != nil {
+		return nil, err
+	}
+	return copyMap(object.tags), nil
+}
+
+// SetObjectTags replaces the tags of an object
+func (c Memory) SetObjectTags(ctx context.Context, bucket, key string, tags map[string]string) error {
+	return c.updateObject(ctx, bucket, key, func(object *memoryObject) {
+		object.tags = copyMap(tags)
+	})
+}
+
+// GetObjectMetadata returns the user metadata of an object
+func (c Memory) GetObjectMetadata(ctx context.Context, bucket, key string) (map[string]string, error) {
+	info, err := c.HeadObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return info.Metadata, nil
+}
+
+// SetObjectMetadata replaces the user metadata of an object
+func (c Memory) SetObjectMetadata(ctx context.Context, bucket, key string, metadata map[string]string) error {
+	return c.updateObject(ctx, bucket, key, func(object *memoryObject) {
+		object.metadata = lowerKeys(metadata)
+	})
+}
+
+func (c Memory) updateObject(ctx context.Context, bucket, key string, update func(object *memoryObject)) error {
+	name := objectName(key)
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	object, err := c.object(ctx, bucket, name)
+	if err != nil {
+		return err
+	}
+	update(&object)
+	c.store.objects[bucket+"/"+name] = object
+	return nil
+}
+
+// object returns the object name of bucket, store.mu has to be held
+func (c Memory) object(ctx context.Context, bucket, name string) (memoryObject, error) {
+	if err := ctx.Err(); err != nil {
+		return memoryObject{}, err
+	}
+	object, ok := c.store.objects[bucket+"/"+name]
+	if !ok {
+		return memoryObject{}, fmt.Errorf("%s/%s: %w", bucket, name, ErrObjectNotFound)
+	}
+	return object, nil
+}
+
+func (o memoryObject) info(bucket, name string) ObjectInfo {
+	return ObjectInfo{
+		Bucket:       bucket,
+		Key:          name,
+		Size:         int64(len(o.data)),
+		ContentType:  o.mimeType,
+		ETag:         md5ETag(o.data),
+		LastModified: o.modified,
+	}
+}
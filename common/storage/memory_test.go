@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/rohanchauhan02/clean/common/storage/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMemoryTestServer(t *testing.T) (*Memory, *httptest.Server) {
+	e := echo.New()
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(&ClientOptions{Provider: "memory", Endpoint: server.URL + "/storage", AccessKeySecret: "local-secret"})
+	require.NoError(t, err)
+	memory := client.(*Memory)
+	e.Match([]string{echo.GET, echo.PUT, echo.POST}, "/storage/*", memory.Handler())
+	return memory, server
+}
+
+func TestMemoryPutAndGetObject(t *testing.T) {
+	client, err := NewClient(&ClientOptions{Provider: "memory"})
+	require.NoError(t, err)
+
+	t.Run("test ok copies of the client share their objects", func(t *testing.T) {
+		_, err := client.PutObjectBase64(&CreateBase64UploadRequest{
+			Filename: stringpointer(mock.DocumentFileName),
+			Bucket:   stringpointer(mock.MockBucket),
+			Base64:   stringpointer(base64.StdEncoding.EncodeToString([]byte("policy"))),
+		})
+		require.NoError(t, err)
+
+		copied := *client.(*Memory)
+		data, err := copied.GetObjectBuffer(&GetObjectBufferRequest{Bucket: stringpointer(mock.MockBucket), Key: stringpointer(mock.DocumentFileName)})
+		assert.Nil(t, err)
+		assert.Equal(t, "policy", string(data))
+	})
+
+	t.Run("test wrong object does not exist", func(t *testing.T) {
+		_, err := client.GetObjectBuffer(&GetObjectBufferRequest{Bucket: stringpointer(mock.MockBucket), Key: stringpointer(mock.MockAWSKeyFileNotExist)})
+		assert.True(t, errors.Is(err, ErrObjectNotFound))
+	})
+
+	t.Run("test wrong presigned url without endpoint", func(t *testing.T) {
+		_, err := client.CreatePresignedView(&CreatePresignedViewRequest{Bucket: stringpointer(mock.MockBucket), Key: stringpointer(mock.DocumentFileName)})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestMemoryPresignedURLs(t *testing.T) {
+	client, _ := newMemoryTestServer(t)
+
+	upload, err := client.CreatePresignedUpload(&CreatePresignedUploadRequest{
+		Bucket:   stringpointer(mock.MockBucket),
+		Filename: stringpointer(mock.UnsanitizedS3FileName),
+		Provider: stringpointer("MEMORY"),
+		Size:     int64pointer(mock.DocumentSize),
+		Type:     stringpointer(mock.DocumentType),
+	})
+	require.NoError(t, err)
+
+	put := func(url, contentType string) int {
+		req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader("%PDF-1.4"))
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("test wrong upload with another content type", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, put(*upload.URL, "text/html"))
+	})
+
+	t.Run("test ok upload then view through the handler", func(t *testing.T) {
+		assert.Equal(t, mock.SanitizedOSSFileName, *upload.Key)
+		assert.Equal(t, http.StatusOK, put(*upload.URL, *upload.Mimetype))
+
+		view, err := client.CreatePresignedView(&CreatePresignedViewRequest{Bucket: stringpointer(mock.MockBucket), Key: stringpointer(mock.SanitizedS3Filename)})
+		require.NoError(t, err)
+		resp, err := http.Get(*view.URL)
+		require.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, mock.DocumentMimeType, resp.Header.Get("Content-Type"))
+		assert.Equal(t, "%PDF-1.4", string(body))
+	})
+
+	t.Run("test wrong upload url used to view", func(t *testing.T) {
+		resp, err := http.Get(*upload.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("test wrong view url of another object", func(t *testing.T) {
+		view, err := client.CreatePresignedView(&CreatePresignedViewRequest{Bucket: stringpointer(mock.MockBucket), Key: stringpointer(mock.SanitizedOSSFileName)})
+		require.NoError(t, err)
+		resp, err := http.Get(strings.Replace(*view.URL, "policy.pdf", "other.pdf", 1))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("test wrong view url has expired", func(t *testing.T) {
+		view, err := client.CreatePresignedView(&CreatePresignedViewRequest{
+			Bucket:   stringpointer(mock.MockBucket),
+			Key:      stringpointer(mock.SanitizedOSSFileName),
+			Duration: time.Minute,
+		})
+		require.NoError(t, err)
+
+		expired := client.Signer
+		expired.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+		err = expired.Verify(http.MethodGet, mock.MockBucket, mock.SanitizedOSSFileName, "", queryOf(t, *view.URL))
+		assert.Equal(t, ErrPresignedURLExpired, err)
+	})
+
+	t.Run("test wrong view url signed with another secret", func(t *testing.T) {
+		other, err := NewURLSigner(client.Signer.BaseURL, "another-secret")
+		require.NoError(t, err)
+		signedURL, err := other.Sign(http.MethodGet, mock.MockBucket, mock.SanitizedOSSFileName, "", time.Minute)
+		require.NoError(t, err)
+		resp, err := http.Get(signedURL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("test wrong signed object does not exist", func(t *testing.T) {
+		view, err := client.CreatePresignedView(&CreatePresignedViewRequest{Bucket: stringpointer(mock.MockBucket), Key: stringpointer(mock.MockAWSKeyFileNotExist)})
+		require.NoError(t, err)
+		resp, err := http.Get(*view.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func queryOf(t *testing.T, rawURL string) map[string][]string {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	require.NoError(t, err)
+	return req.URL.Query()
+}
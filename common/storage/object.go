@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// DEFAULT_LIST_MAX_KEYS is the size of a page of ListObjects, S3 and OSS return 1000 keys at most
+	DEFAULT_LIST_MAX_KEYS = 1000
+	// DELETE_OBJECTS_BATCH is the number of keys S3 and OSS delete in one request
+	DELETE_OBJECTS_BATCH = 1000
+)
+
+// ErrNotSupported is returned for the operations a provider does not implement
+var ErrNotSupported = errors.New("operation is not supported by the provider")
+
+// ListObjectsOptions selects a page of ListObjects, every field is optional
+//   - Prefix - keys start with it, a leading / is ignored like in the keys
+//   - Delimiter - keys containing it after Prefix are grouped in CommonPrefixes, e.g. /
+//   - MaxKeys - objects and common prefixes of the page, DEFAULT_LIST_MAX_KEYS when 0
+//   - Token - NextToken of the previous page
+type ListObjectsOptions struct {
+	Prefix    string
+	Delimiter string
+	MaxKeys   int
+	Token     string
+}
+
+// ListObjectsResult is a page of ListObjects, the next one is read with NextToken while
+// IsTruncated is true
+type ListObjectsResult struct {
+	Objects        []ObjectInfo
+	CommonPrefixes []string
+	NextToken      string
+	IsTruncated    bool
+}
+
+func (o *ListObjectsOptions) withDefaults() ListObjectsOptions {
+	var opts ListObjectsOptions
+	if o != nil {
+		opts = *o
+	}
+	opts.Prefix = bucketKey(opts.Prefix)
+	if opts.MaxKeys <= 0 {
+		opts.MaxKeys = DEFAULT_LIST_MAX_KEYS
+	}
+	return opts
+}
+
+// bucketKey returns key as S3 and OSS store it. The PREFIX_KEY_AWS keys start with a / the S3
+// SDK removes from the request path while OSS rejects it, so /private/file.pdf and
+// private/file.pdf are the same object for both and keys are returned without it
+func bucketKey(key string) string {
+	return strings.TrimLeft(key, "/")
+}
+
+// moveObject copies the object then deletes the source, moving an object onto itself does nothing
+func moveObject(ctx context.Context, client Client, srcBucket, srcKey, dstBucket, dstKey string) error {
+	if srcBucket == dstBucket && objectName(srcKey) == objectName(dstKey) {
+		return nil
+	}
+	if err := client.CopyObject(ctx, srcBucket, srcKey, dstBucket, dstKey); err != nil {
+		return err
+	}
+	return client.DeleteObject(ctx, srcBucket, srcKey)
+}
+
+// lowerKeys returns the metadata with lower case names, S3 canonicalizes them like HTTP headers
+func lowerKeys(metadata map[string]string) map[string]string {
+	lowered := make(map[string]string, len(metadata))
+	for name, value := range metadata {
+		lowered[strings.ToLower(name)] = value
+	}
+	return lowered
+}
+
+func copyMap(values map[string]string) map[string]string {
+	copied := make(map[string]string, len(values))
+	for name, value := range values {
+		copied[name] = value
+	}
+	return copied
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// listPage returns the page of objects selected by opts like the S3 ListObjectsV2 API,
+// objects has to be sorted by key. The token is the last key or common prefix of the page
+func listPage(objects []ObjectInfo, opts ListObjectsOptions) ListObjectsResult {
+	var (
+		result ListObjectsResult
+		last   string
+	)
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Key, opts.Prefix) || object.Key <= opts.Token {
+			continue
+		}
+		if opts.Delimiter != "" && strings.HasSuffix(opts.Token, opts.Delimiter) && strings.HasPrefix(object.Key, opts.Token) {
+			continue
+		}
+
+		entry, commonPrefix := object.Key, false
+		if opts.Delimiter != "" {
+			if i := strings.Index(object.Key[len(opts.Prefix):], opts.Delimiter); i >= 0 {
+				entry, commonPrefix = object.Key[:len(opts.Prefix)+i+len(opts.Delimiter)], true
+			}
+		}
+		if commonPrefix && entry == last {
+			continue
+		}
+		if len(result.Objects)+len(result.CommonPrefixes) == opts.MaxKeys {
+			result.IsTruncated = true
+			result.NextToken = last
+			break
+		}
+
+		if commonPrefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, entry)
+		} else {
+			result.Objects = append(result.Objects, object)
+		}
+		last = entry
+	}
+	return result
+}
+
+func joinDeleteErrors(bucket string, errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("failed to delete %d objects of bucket %s: %w", len(errs), bucket, errors.Join(errs...))
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/rohanchauhan02/clean/common/storage/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listKeys returns every key of bucket starting with prefix, reading the pages of ListObjects
+func listKeys(t *testing.T, client Client, bucket, prefix string) []string {
+	var keys []string
+	opts := &ListObjectsOptions{Prefix: prefix}
+	for {
+		page, err := client.ListObjects(context.Background(), bucket, opts)
+		require.NoError(t, err)
+		for _, object := range page.Objects {
+			keys = append(keys, object.Key)
+		}
+		if !page.IsTruncated {
+			return keys
+		}
+		opts.Token = page.NextToken
+	}
+}
+
+// testObjectLifecycle runs the same scenario against every provider, the keys are written
+// with the S3 and the OSS style to check both name the same object
+func testObjectLifecycle(t *testing.T, client Client) {
+	ctx := context.Background()
+	bucket := mock.MockBucket
+	body := []byte("%PDF-1.4 policy")
+	for _, key := range []string{mock.SanitizedS3Filename, "private/claims/a.jpg", "/private/claims/b.jpg", "public/logo.png"} {
+		_, err := client.PutObject(ctx, bucket, key, bytes.NewReader(body), nil)
+		require.NoError(t, err)
+	}
+
+	t.Run("test ok objects are grouped by the delimiter", func(t *testing.T) {
+		page, err := client.ListObjects(ctx, bucket, &ListObjectsOptions{Prefix: "/private/", Delimiter: "/"})
+		require.NoError(t, err)
+		require.Len(t, page.Objects, 1)
+		assert.Equal(t, mock.SanitizedOSSFileName, page.Objects[0].Key)
+		assert.Equal(t, int64(len(body)), page.Objects[0].Size)
+		assert.Equal(t, []string{"private/claims/"}, page.CommonPrefixes)
+		assert.False(t, page.IsTruncated)
+	})
+
+	t.Run("test ok pages are read with the next token", func(t *testing.T) {
+		var (
+			keys  []string
+			pages int
+			opts  = &ListObjectsOptions{MaxKeys: 1}
+		)
+		for {
+			page, err := client.ListObjects(ctx, bucket, opts)
+			require.NoError(t, err)
+			pages++
+			for _, object := range page.Objects {
+				keys = append(keys, object.Key)
+			}
+			if !page.IsTruncated {
+				break
+			}
+			opts.Token = page.NextToken
+		}
+		assert.Equal(t, []string{"private/claims/a.jpg", "private/claims/b.jpg", mock.SanitizedOSSFileName, "public/logo.png"}, keys)
+		assert.Equal(t, 4, pages)
+	})
+
+	t.Run("test ok head returns the properties of the object", func(t *testing.T) {
+		info, err := client.HeadObject(ctx, bucket, mock.SanitizedS3Filename)
+		require.NoError(t, err)
+		assert.Equal(t, mock.SanitizedOSSFileName, info.Key)
+		assert.Equal(t, int64(len(body)), info.Size)
+		assert.Equal(t, mock.DocumentMimeType, info.ContentType)
+		assert.NotEmpty(t, info.ETag)
+		assert.Empty(t, info.Metadata)
+	})
+
+	t.Run("test ok metadata is replaced and the content type is kept", func(t *testing.T) {
+		require.NoError(t, client.SetObjectMetadata(ctx, bucket, mock.SanitizedOSSFileName, map[string]string{"Claim-ID": "C-1", "source": "upload"}))
+		metadata, err := client.GetObjectMetadata(ctx, bucket, mock.SanitizedS3Filename)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"claim-id": "C-1", "source": "upload"}, metadata)
+
+		info, err := client.HeadObject(ctx, bucket, mock.SanitizedOSSFileName)
+		require.NoError(t, err)
+		assert.Equal(t, mock.DocumentMimeType, info.ContentType)
+		assert.Equal(t, metadata, info.Metadata)
+	})
+
+	t.Run("test ok tags are replaced", func(t *testing.T) {
+		require.NoError(t, client.SetObjectTags(ctx, bucket, mock.SanitizedS3Filename, map[string]string{"status": "approved"}))
+		require.NoError(t, client.SetObjectTags(ctx, bucket, mock.SanitizedS3Filename, map[string]string{"status": "paid", "product": "health"}))
+		tags, err := client.GetObjectTags(ctx, bucket, mock.SanitizedOSSFileName)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"status": "paid", "product": "health"}, tags)
+	})
+
+	t.Run("test ok copy keeps the metadata and the tags", func(t *testing.T) {
+		require.NoError(t, client.CopyObject(ctx, bucket, mock.SanitizedS3Filename, bucket, "/archive/policy.pdf"))
+		data, _ := readObject(t, client, bucket, "archive/policy.pdf", 0, 0)
+		assert.Equal(t, body, data)
+
+		metadata, err := client.GetObjectMetadata(ctx, bucket, "archive/policy.pdf")
+		require.NoError(t, err)
+		assert.Equal(t, "C-1", metadata["claim-id"])
+		tags, err := client.GetObjectTags(ctx, bucket, "archive/policy.pdf")
+		require.NoError(t, err)
+		assert.Equal(t, "paid", tags["status"])
+	})
+
+	t.Run("test ok move removes the source", func(t *testing.T) {
+		require.NoError(t, client.MoveObject(ctx, bucket, "public/logo.png", bucket, "public/moved.png"))
+		require.NoError(t, client.MoveObject(ctx, bucket, "public/moved.png", bucket, "/public/moved.png"))
+		assert.Equal(t, []string{"public/moved.png"}, listKeys(t, client, bucket, "public/"))
+	})
+
+	t.Run("test ok objects are deleted", func(t *testing.T) {
+		require.NoError(t, client.DeleteObject(ctx, bucket, "/public/moved.png"))
+		require.NoError(t, client.DeleteObject(ctx, bucket, "public/moved.png"))
+		require.NoError(t, client.DeleteObjects(ctx, bucket, []string{mock.SanitizedS3Filename, "private/claims/a.jpg", "/private/claims/b.jpg"}))
+		assert.Equal(t, []string{"archive/policy.pdf"}, listKeys(t, client, bucket, ""))
+	})
+
+	t.Run("test wrong object does not exist", func(t *testing.T) {
+		_, err := client.HeadObject(ctx, bucket, mock.SanitizedS3Filename)
+		assert.True(t, errors.Is(err, ErrObjectNotFound), err)
+		_, err = client.GetObjectTags(ctx, bucket, mock.SanitizedS3Filename)
+		assert.True(t, errors.Is(err, ErrObjectNotFound), err)
+		err = client.SetObjectMetadata(ctx, bucket, mock.SanitizedS3Filename, map[string]string{"claim-id": "C-2"})
+		assert.True(t, errors.Is(err, ErrObjectNotFound), err)
+	})
+}
+
+func TestMemoryObjectLifecycle(t *testing.T) {
+	testObjectLifecycle(t, newStreamTestMemory(t))
+}
+
+func TestLocalObjectLifecycle(t *testing.T) {
+	client, err := NewLocal(t.TempDir(), "", "")
+	require.NoError(t, err)
+	testObjectLifecycle(t, client)
+
+	t.Run("test ok metadata is dropped when the object is written again", func(t *testing.T) {
+		ctx := context.Background()
+		require.NoError(t, client.SetObjectMetadata(ctx, mock.MockBucket, "archive/policy.pdf", map[string]string{"claim-id": "C-1"}))
+		_, err := client.PutObject(ctx, mock.MockBucket, "archive/policy.pdf", bytes.NewReader([]byte("%PDF-1.4")), nil)
+		require.NoError(t, err)
+		metadata, err := client.GetObjectMetadata(ctx, mock.MockBucket, "archive/policy.pdf")
+		require.NoError(t, err)
+		assert.Empty(t, metadata)
+		assert.Equal(t, []string{"archive/policy.pdf"}, listKeys(t, client, mock.MockBucket, ""))
+	})
+}
+
+func TestS3ObjectLifecycle(t *testing.T) {
+	server := httptest.NewServer(newFakeObjectServer())
+	defer server.Close()
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String(mock.MockAWSRegion),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials(mock.MockAccessKey, mock.MockSecretKey, ""),
+	})
+	require.NoError(t, err)
+	testObjectLifecycle(t, S3{Client: s3.New(sess)})
+}
+
+func TestOSSObjectLifecycle(t *testing.T) {
+	fake := newFakeObjectServer()
+	fake.headerPrefix = "x-oss-"
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client, err := oss.New(server.URL, mock.MockAccessKey, mock.MockSecretKey)
+	require.NoError(t, err)
+	testObjectLifecycle(t, OSS{Client: client})
+}
+
+func TestGCSObjectLifecycle(t *testing.T) {
+	client, _ := newGCSTestClient(t)
+
+	t.Run("test wrong operation is not supported", func(t *testing.T) {
+		_, err := client.ListObjects(context.Background(), mock.MockBucket, nil)
+		assert.True(t, errors.Is(err, ErrNotSupported))
+		assert.EqualError(t, client.DeleteObject(context.Background(), mock.MockBucket, mock.SanitizedOSSFileName), "GCS DeleteObject: operation is not supported by the provider")
+	})
+}
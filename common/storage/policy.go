@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	// DEFAULT_POLICY_TYPE is the key of the policy of the document types without their own
+	DEFAULT_POLICY_TYPE = "default"
+	// SNIFF_LENGTH is the number of bytes read to detect the type of a content
+	SNIFF_LENGTH = 512
+
+	SCAN_STATUS_TAG      = "scan-status"
+	SCAN_STATUS_CLEAN    = "clean"
+	SCAN_STATUS_INFECTED = "infected"
+)
+
+// ErrUploadRejected is wrapped by the errors of the uploads breaking their upload policy
+var ErrUploadRejected = errors.New("upload rejected")
+
+// UploadPolicy restricts the uploads of a document type, an empty field allows everything
+//   - MimeTypes - allowed types, e.g. application/pdf, or image/* for every image
+//   - Extensions - allowed extensions of the file name with their dot, e.g. .pdf
+//   - MaxSize - largest size in bytes
+type UploadPolicy struct {
+	MimeTypes  []string
+	Extensions []string
+	MaxSize    int64
+}
+
+// Check returns an error wrapping ErrUploadRejected when the file breaks the policy
+func (p UploadPolicy) Check(filename, mimeType string, size int64) error {
+	if ext := strings.ToLower(path.Ext(filename)); len(p.Extensions) > 0 && !containsFold(p.Extensions, ext) {
+		return fmt.Errorf("%w: extension %q of %s is not allowed", ErrUploadRejected, ext, filename)
+	}
+	if !p.allowsMimeType(mimeType) {
+		return fmt.Errorf("%w: type %s of %s is not allowed", ErrUploadRejected, mediaType(mimeType), filename)
+	}
+	if p.MaxSize > 0 && size > p.MaxSize {
+		return fmt.Errorf("%w: %s is larger than %d bytes", ErrUploadRejected, filename, p.MaxSize)
+	}
+	return nil
+}
+
+func (p UploadPolicy) allowsMimeType(mimeType string) bool {
+	if len(p.MimeTypes) == 0 {
+		return true
+	}
+	mimeType = mediaType(mimeType)
+	for _, allowed := range p.MimeTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mimeType || strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyClient enforces the upload policy of the document type of the uploads of Client.
+// Presigned uploads become POST uploads with the type and size conditions of the policy,
+// the content of the direct uploads is sniffed and scanned before it is stored
+//   - Policies - policies by document type, DEFAULT_POLICY_TYPE applies to the other types
+//     and the uploads of the other types are rejected without it
+//   - Scanner - scans the direct uploads and ScanObject, nothing is scanned when nil
+//   - QuarantineBucket - bucket keeping the infected objects, they are deleted when empty
+type PolicyClient struct {
+	Client
+	Policies         map[string]UploadPolicy
+	Scanner          Scanner
+	QuarantineBucket string
+}
+
+// NewPolicyClient returns client enforcing policies, scanner is optional
+func NewPolicyClient(client Client, policies map[string]UploadPolicy, scanner Scanner) *PolicyClient {
+	return &PolicyClient{
+		Client:   client,
+		Policies: policies,
+		Scanner:  scanner,
+	}
+}
+
+// Policy returns the upload policy of a document type
+func (c PolicyClient) Policy(docType string) (UploadPolicy, error) {
+	if policy, ok := c.Policies[docType]; ok {
+		return policy, nil
+	}
+	if policy, ok := c.Policies[DEFAULT_POLICY_TYPE]; ok {
+		return policy, nil
+	}
+	return UploadPolicy{}, fmt.Errorf("%w: no upload policy for type %q", ErrUploadRejected, docType)
+}
+
+// CreatePresignedUpload checks the file against the policy of payload.Type and creates a
+// POST upload, the provider rejects the files of another type or larger than the policy allows
+func (c PolicyClient) CreatePresignedUpload(payload *CreatePresignedUploadRequest) (*CreatePresignedUploadResponse, error) {
+	policy, err := c.Policy(stringValue(payload.Type))
+	if err != nil {
+		return nil, err
+	}
+	mimeType := objectMimeType(*payload.Filename, payload.Mimetype)
+	var size int64
+	if payload.Size != nil {
+		size = *payload.Size
+	}
+	if err := policy.Check(*payload.Filename, mimeType, size); err != nil {
+		return nil, err
+	}
+	return c.Client.CreatePresignedPost(payload, PostConditions{ContentType: mimeType, MaxSize: policy.MaxSize})
+}
+
+// PutObjectBase64 checks the decoded content against the policy of payload.Type and its
+// magic bytes against its type, then scans it before the upload
+func (c PolicyClient) PutObjectBase64(payload *CreateBase64UploadRequest) (*CreateBase64UploadResponse, error) {
+	data, err := base64.StdEncoding.DecodeString(*payload.Base64)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Size != nil && *payload.Size != int64(len(data)) {
+		return nil, fmt.Errorf("%w: size %d of %s is not the %d bytes of its content", ErrUploadRejected, *payload.Size, *payload.Filename, len(data))
+	}
+	policy, err := c.Policy(stringValue(payload.Type))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkContent(policy, *payload.Filename, objectMimeType(*payload.Filename, payload.Mimetype), data, int64(len(data))); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if c.Scanner != nil {
+		err := c.Scanner.Scan(ctx, *payload.Filename, bytes.NewReader(data))
+		var infected *InfectedError
+		if errors.As(err, &infected) && c.QuarantineBucket != "" {
+			quarantined := *payload
+			quarantined.Bucket = &c.QuarantineBucket
+			if resp, qErr := c.Client.PutObjectBase64(&quarantined); qErr != nil {
+				err = errors.Join(err, qErr)
+			} else if qErr := c.setScanStatus(ctx, c.QuarantineBucket, *resp.Filename, SCAN_STATUS_INFECTED); qErr != nil {
+				err = errors.Join(err, qErr)
+			}
+		}
+		if err != nil {
+			logger.Error("error scanning upload: ", err)
+			return nil, err
+		}
+	}
+
+	resp, err := c.Client.PutObjectBase64(payload)
+	if err != nil || c.Scanner == nil {
+		return resp, err
+	}
+	return resp, c.setScanStatus(ctx, *payload.Bucket, *resp.Filename, SCAN_STATUS_CLEAN)
+}
+
+// PutObject checks the first bytes of body against the policy of opts.Type and stops the
+// upload when body gets larger than it allows, the stored object is scanned with ScanObject
+func (c PolicyClient) PutObject(ctx context.Context, bucket, key string, body io.Reader, opts *PutObjectOptions) (ObjectInfo, error) {
+	var options PutObjectOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.ContentType == "" {
+		options.ContentType = objectMimeType(key, nil)
+	}
+	policy, err := c.Policy(options.Type)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	head, err := io.ReadAll(io.LimitReader(body, SNIFF_LENGTH))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := checkContent(policy, key, options.ContentType, head, int64(len(head))); err != nil {
+		return ObjectInfo{}, err
+	}
+	body = io.MultiReader(bytes.NewReader(head), body)
+	if policy.MaxSize > 0 {
+		body = &policyLimitReader{reader: body, name: key, remaining: policy.MaxSize}
+	}
+
+	info, err := c.Client.PutObject(ctx, bucket, key, body, &options)
+	if err != nil || c.Scanner == nil {
+		return info, err
+	}
+	return info, c.ScanObject(ctx, bucket, key)
+}
+
+// ScanObject scans a stored object, e.g. after a presigned upload. A clean object is tagged
+// SCAN_STATUS_CLEAN, an infected one is moved to QuarantineBucket or deleted and the error
+// is an *InfectedError
+func (c PolicyClient) ScanObject(ctx context.Context, bucket, key string) error {
+	if c.Scanner == nil {
+		return errors.New("policy client has no scanner")
+	}
+	reader, info, err := c.Client.GetObject(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	err = c.Scanner.Scan(ctx, info.Key, reader)
+	reader.Close()
+
+	var infected *InfectedError
+	if !errors.As(err, &infected) {
+		if err != nil {
+			return err
+		}
+		return c.setScanStatus(ctx, bucket, key, SCAN_STATUS_CLEAN)
+	}
+	logger.Error("infected object: ", err)
+	if c.QuarantineBucket == "" {
+		return errors.Join(err, c.Client.DeleteObject(ctx, bucket, key))
+	}
+	if moveErr := c.Client.MoveObject(ctx, bucket, key, c.QuarantineBucket, key); moveErr != nil {
+		return errors.Join(err, moveErr)
+	}
+	return errors.Join(err, c.setScanStatus(ctx, c.QuarantineBucket, key, SCAN_STATUS_INFECTED))
+}
+
+// checkContent checks the file against policy and its first bytes against mimeType
+func checkContent(policy UploadPolicy, filename, mimeType string, head []byte, size int64) error {
+	if err := policy.Check(filename, mimeType, size); err != nil {
+		return err
+	}
+	if sniffed := sniffMimeType(head); !contentMatches(mimeType, sniffed) {
+		return fmt.Errorf("%w: content of %s is %s, not %s", ErrUploadRejected, filename, sniffed, mediaType(mimeType))
+	}
+	return nil
+}
+
+// setScanStatus adds the SCAN_STATUS_TAG tag to the other tags of the object
+func (c PolicyClient) setScanStatus(ctx context.Context, bucket, key, status string) error {
+	tags, err := c.Client.GetObjectTags(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	tags[SCAN_STATUS_TAG] = status
+	return c.Client.SetObjectTags(ctx, bucket, key, tags)
+}
+
+// policyLimitReader fails the upload once more than remaining bytes are read
+type policyLimitReader struct {
+	reader    io.Reader
+	name      string
+	remaining int64
+}
+
+func (r *policyLimitReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, fmt.Errorf("%w: %s is larger than its upload policy allows", ErrUploadRejected, r.name)
+	}
+	return n, err
+}
+
+// zipContainers are the types http.DetectContentType detects as application/zip
+var zipContainers = map[string]bool{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+	"application/vnd.oasis.opendocument.text":                                   true,
+	"application/vnd.oasis.opendocument.spreadsheet":                            true,
+	"application/epub+zip":     true,
+	"application/java-archive": true,
+}
+
+// sniffMimeType returns the type of content detected from its magic bytes, without parameters
+func sniffMimeType(content []byte) string {
+	return mediaType(http.DetectContentType(content))
+}
+
+// contentMatches reports whether the sniffed type of a content can be the declared one. The
+// formats without magic bytes are sniffed as text/plain or application/octet-stream, the
+// text formats are accepted as text/plain and the others only as application/octet-stream
+func contentMatches(declared, sniffed string) bool {
+	declared = mediaType(declared)
+	switch {
+	case declared == sniffed:
+		return true
+	case sniffed == "application/zip":
+		return zipContainers[declared]
+	case sniffed == "text/plain", sniffed == "text/xml":
+		return strings.HasPrefix(declared, "text/") || declared == "application/json" || declared == "application/xml"
+	}
+	return false
+}
+
+// mediaType returns mimeType in lower case without its parameters, e.g. text/plain for
+// text/plain; charset=utf-8
+func mediaType(mimeType string) string {
+	if parsed, _, err := mime.ParseMediaType(mimeType); err == nil {
+		return parsed
+	}
+	return strings.ToLower(strings.TrimSpace(mimeType))
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
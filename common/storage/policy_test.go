@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/rohanchauhan02/clean/common/storage/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testQuarantineBucket = "qoala-quarantine"
+
+// fakeScanner finds the EICAR test string like an antivirus
+type fakeScanner struct {
+	scanned []string
+}
+
+func (f *fakeScanner) Scan(ctx context.Context, name string, body io.Reader) error {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	f.scanned = append(f.scanned, name)
+	if bytes.Contains(data, []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+		return &InfectedError{Name: name, Signature: "Eicar-Test-Signature"}
+	}
+	return nil
+}
+
+func newPolicyTestClient(t *testing.T) (*PolicyClient, *Memory, *fakeScanner) {
+	memory, err := NewMemory("http://localhost/storage", "local-secret")
+	require.NoError(t, err)
+	scanner := &fakeScanner{}
+	client := NewPolicyClient(memory, map[string]UploadPolicy{
+		mock.DocumentType: {MimeTypes: []string{mock.DocumentMimeType}, Extensions: []string{".pdf"}, MaxSize: 64},
+		"PHOTO":           {MimeTypes: []string{"image/*"}},
+	}, scanner)
+	client.QuarantineBucket = testQuarantineBucket
+	return client, memory, scanner
+}
+
+func TestUploadPolicyCheck(t *testing.T) {
+	policy := UploadPolicy{MimeTypes: []string{"application/pdf", "image/*"}, Extensions: []string{".pdf", ".JPG"}, MaxSize: 10}
+
+	t.Run("test ok file is allowed", func(t *testing.T) {
+		assert.Nil(t, policy.Check("private/policy.pdf", "application/pdf", 10))
+		assert.Nil(t, policy.Check("claim.jpg", "image/jpeg", 0))
+		assert.Nil(t, UploadPolicy{}.Check("claim.exe", "application/x-msdownload", 1<<30))
+	})
+
+	t.Run("test wrong file breaks the policy", func(t *testing.T) {
+		for _, err := range []error{
+			policy.Check("policy.html", "application/pdf", 10),
+			policy.Check("policy.pdf", "text/html; charset=utf-8", 10),
+			policy.Check("policy.pdf", "application/pdf", 11),
+		} {
+			assert.True(t, errors.Is(err, ErrUploadRejected), err)
+		}
+	})
+}
+
+func TestContentMatches(t *testing.T) {
+	t.Run("test ok magic bytes match the type", func(t *testing.T) {
+		assert.True(t, contentMatches("application/pdf", sniffMimeType([]byte("%PDF-1.4 policy"))))
+		assert.True(t, contentMatches("image/png", sniffMimeType([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))))
+		assert.True(t, contentMatches("application/vnd.openxmlformats-officedocument.wordprocessingml.document", sniffMimeType([]byte("PK\x03\x04\x14\x00"))))
+		assert.True(t, contentMatches("text/csv; charset=utf-8", sniffMimeType([]byte("policy,premium\nP-1,100\n"))))
+	})
+
+	t.Run("test wrong magic bytes of another type", func(t *testing.T) {
+		assert.False(t, contentMatches("application/pdf", sniffMimeType([]byte("<html><script>alert(1)</script></html>"))))
+		assert.False(t, contentMatches("image/jpeg", sniffMimeType([]byte("MZ\x90\x00\x03\x00\x00\x00"))))
+		assert.False(t, contentMatches("application/pdf", sniffMimeType([]byte("PK\x03\x04\x14\x00"))))
+	})
+}
+
+func newPolicyTestRequest() *CreatePresignedUploadRequest {
+	payload := newPostTestRequest()
+	payload.Size = int64pointer(64)
+	return payload
+}
+
+func TestPolicyClientPresignedUpload(t *testing.T) {
+	client, _, _ := newPolicyTestClient(t)
+
+	t.Run("test ok presigned upload is a post with the conditions of the policy", func(t *testing.T) {
+		post, err := client.CreatePresignedUpload(newPolicyTestRequest())
+		require.NoError(t, err)
+		assert.Equal(t, "POST", *post.Method)
+		assert.Equal(t, mock.DocumentMimeType, post.Fields[POST_FIELD_CONTENT_TYPE])
+		assert.Nil(t, checkPostPolicy(post.Fields[POST_FIELD_POLICY], mock.MockBucket, post.Fields, 64, time.Now()))
+		err = checkPostPolicy(post.Fields[POST_FIELD_POLICY], mock.MockBucket, post.Fields, 65, time.Now())
+		assert.True(t, errors.Is(err, ErrPostPolicyCondition))
+	})
+
+	t.Run("test wrong file breaks the policy", func(t *testing.T) {
+		payload := newPolicyTestRequest()
+		payload.Filename = stringpointer("/private/policy.html")
+		_, err := client.CreatePresignedUpload(payload)
+		assert.True(t, errors.Is(err, ErrUploadRejected))
+
+		payload = newPolicyTestRequest()
+		payload.Size = int64pointer(65)
+		_, err = client.CreatePresignedUpload(payload)
+		assert.True(t, errors.Is(err, ErrUploadRejected))
+	})
+
+	t.Run("test wrong document type without policy", func(t *testing.T) {
+		payload := newPolicyTestRequest()
+		payload.Type = stringpointer("KTP")
+		_, err := client.CreatePresignedUpload(payload)
+		assert.EqualError(t, err, `upload rejected: no upload policy for type "KTP"`)
+	})
+}
+
+func TestPolicyClientPutObjectBase64(t *testing.T) {
+	client, memory, scanner := newPolicyTestClient(t)
+	upload := func(filename, content string) (*CreateBase64UploadResponse, error) {
+		return client.PutObjectBase64(&CreateBase64UploadRequest{
+			Filename: stringpointer(filename),
+			Type:     stringpointer(mock.DocumentType),
+			Bucket:   stringpointer(mock.MockBucket),
+			Base64:   stringpointer(base64.StdEncoding.EncodeToString([]byte(content))),
+		})
+	}
+
+	t.Run("test ok clean file is stored and tagged", func(t *testing.T) {
+		resp, err := upload(mock.SanitizedS3Filename, "%PDF-1.4 policy")
+		require.NoError(t, err)
+		assert.Equal(t, []string{mock.SanitizedS3Filename}, scanner.scanned)
+		tags, err := memory.GetObjectTags(context.Background(), mock.MockBucket, *resp.Filename)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{SCAN_STATUS_TAG: SCAN_STATUS_CLEAN}, tags)
+	})
+
+	t.Run("test wrong content is not a pdf", func(t *testing.T) {
+		_, err := upload("private/invoice.pdf", "<html><script>alert(1)</script></html>")
+		assert.EqualError(t, err, "upload rejected: content of private/invoice.pdf is text/html, not application/pdf")
+		_, err = memory.HeadObject(context.Background(), mock.MockBucket, "private/invoice.pdf")
+		assert.True(t, errors.Is(err, ErrObjectNotFound))
+	})
+
+	t.Run("test wrong infected file is quarantined", func(t *testing.T) {
+		_, err := upload("private/claim.pdf", "%PDF-1.4 EICAR-STANDARD-ANTIVIRUS-TEST-FILE")
+		var infected *InfectedError
+		require.True(t, errors.As(err, &infected))
+		assert.True(t, errors.Is(err, ErrUploadRejected))
+		assert.Equal(t, "Eicar-Test-Signature", infected.Signature)
+
+		_, err = memory.HeadObject(context.Background(), mock.MockBucket, "private/claim.pdf")
+		assert.True(t, errors.Is(err, ErrObjectNotFound))
+		tags, err := memory.GetObjectTags(context.Background(), testQuarantineBucket, "private/claim.pdf")
+		require.NoError(t, err)
+		assert.Equal(t, SCAN_STATUS_INFECTED, tags[SCAN_STATUS_TAG])
+	})
+}
+
+func TestPolicyClientPutObject(t *testing.T) {
+	client, memory, _ := newPolicyTestClient(t)
+	ctx := context.Background()
+
+	t.Run("test ok object is stored and tagged", func(t *testing.T) {
+		_, err := client.PutObject(ctx, mock.MockBucket, "claims/photo.png", bytes.NewReader([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")), &PutObjectOptions{Type: "PHOTO"})
+		require.NoError(t, err)
+		tags, err := memory.GetObjectTags(ctx, mock.MockBucket, "claims/photo.png")
+		require.NoError(t, err)
+		assert.Equal(t, SCAN_STATUS_CLEAN, tags[SCAN_STATUS_TAG])
+	})
+
+	t.Run("test wrong body is larger than the policy allows", func(t *testing.T) {
+		body := append([]byte("%PDF-1.4 "), bytes.Repeat([]byte("x"), 64)...)
+		_, err := client.PutObject(ctx, mock.MockBucket, "private/large.pdf", bytes.NewReader(body), &PutObjectOptions{Type: mock.DocumentType})
+		assert.True(t, errors.Is(err, ErrUploadRejected), err)
+		_, err = memory.HeadObject(ctx, mock.MockBucket, "private/large.pdf")
+		assert.True(t, errors.Is(err, ErrObjectNotFound))
+	})
+
+	t.Run("test wrong infected object is moved to the quarantine", func(t *testing.T) {
+		_, err := memory.PutObject(ctx, mock.MockBucket, "private/posted.pdf", bytes.NewReader([]byte("%PDF-1.4 EICAR-STANDARD-ANTIVIRUS-TEST-FILE")), nil)
+		require.NoError(t, err)
+
+		err = client.ScanObject(ctx, mock.MockBucket, "private/posted.pdf")
+		var infected *InfectedError
+		require.True(t, errors.As(err, &infected))
+		_, err = memory.HeadObject(ctx, mock.MockBucket, "private/posted.pdf")
+		assert.True(t, errors.Is(err, ErrObjectNotFound))
+		tags, err := memory.GetObjectTags(ctx, testQuarantineBucket, "private/posted.pdf")
+		require.NoError(t, err)
+		assert.Equal(t, SCAN_STATUS_INFECTED, tags[SCAN_STATUS_TAG])
+	})
+}
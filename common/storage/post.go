@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	POST_FIELD_KEY          = "key"
+	POST_FIELD_CONTENT_TYPE = "Content-Type"
+	POST_FIELD_POLICY       = "policy"
+	// POST_FIELD_FILE is the form field of the file, the providers ignore the fields after it
+	POST_FIELD_FILE = "file"
+)
+
+// ErrPostPolicyCondition is returned when a POST upload breaks a condition of its policy
+var ErrPostPolicyCondition = errors.New("post policy condition is not met")
+
+// PostConditions are enforced by the provider on the uploads of a presigned POST
+//   - ContentType - type the upload has to send, the type of the file name when empty
+//   - MaxSize - largest size of the file in bytes, it is not limited when 0
+type PostConditions struct {
+	ContentType string
+	MaxSize     int64
+}
+
+// postPolicy is the policy document of the POST uploads of S3, OSS, GCS and the local providers
+type postPolicy struct {
+	Expiration string        `json:"expiration"`
+	Conditions []interface{} `json:"conditions"`
+}
+
+// presignedPost is the form of a POST upload, the providers add their credential fields
+// before encodePolicy and their signature after it
+type presignedPost struct {
+	payload    *CreatePresignedUploadRequest
+	bucket     string
+	key        string
+	expires    time.Time
+	conditions PostConditions
+	fields     map[string]string
+}
+
+func newPresignedPost(payload *CreatePresignedUploadRequest, key string, conditions PostConditions, now time.Time) presignedPost {
+	if payload.Duration == 0 {
+		payload.Duration = DEFAULT_DURATION
+	}
+	if conditions.ContentType == "" {
+		conditions.ContentType = objectMimeType(*payload.Filename, payload.Mimetype)
+	}
+	return presignedPost{
+		payload:    payload,
+		bucket:     *payload.Bucket,
+		key:        key,
+		expires:    now.Add(payload.Duration),
+		conditions: conditions,
+		fields: map[string]string{
+			POST_FIELD_KEY:          key,
+			POST_FIELD_CONTENT_TYPE: conditions.ContentType,
+		},
+	}
+}
+
+// encodePolicy returns the base64 policy document, every field set so far has to be sent as is
+func (p presignedPost) encodePolicy() (string, error) {
+	conditions := []interface{}{map[string]string{"bucket": p.bucket}}
+	for _, name := range sortedKeys(p.fields) {
+		conditions = append(conditions, []string{"eq", "$" + name, p.fields[name]})
+	}
+	if p.conditions.MaxSize > 0 {
+		conditions = append(conditions, []interface{}{"content-length-range", 0, p.conditions.MaxSize})
+	}
+	document, err := json.Marshal(postPolicy{
+		Expiration: p.expires.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Conditions: conditions,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(document), nil
+}
+
+func (p presignedPost) response(postURL string) *CreatePresignedUploadResponse {
+	return &CreatePresignedUploadResponse{
+		Filename: stringpointer(p.key),
+		Type:     p.payload.Type,
+		Mimetype: stringpointer(p.conditions.ContentType),
+		Size:     p.payload.Size,
+		Bucket:   p.payload.Bucket,
+		Provider: p.payload.Provider,
+		URL:      stringpointer(postURL),
+		Key:      stringpointer(p.key),
+		Method:   stringpointer(http.MethodPost),
+		Fields:   p.fields,
+	}
+}
+
+// checkPostPolicy checks the form of a POST upload of size bytes against the conditions of
+// the policy like S3, the names of the fields are not case sensitive
+func checkPostPolicy(encoded, bucket string, fields map[string]string, size int64, now time.Time) error {
+	document, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: invalid policy", ErrPostPolicyCondition)
+	}
+	var policy postPolicy
+	if err := json.Unmarshal(document, &policy); err != nil {
+		return fmt.Errorf("%w: invalid policy", ErrPostPolicyCondition)
+	}
+	expiration, err := time.Parse(time.RFC3339, policy.Expiration)
+	if err != nil || now.After(expiration) {
+		return ErrPresignedURLExpired
+	}
+
+	values := map[string]string{"bucket": bucket}
+	for name, value := range fields {
+		values[strings.ToLower(name)] = value
+	}
+	for _, condition := range policy.Conditions {
+		if err := checkPostCondition(condition, values, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkPostCondition(condition interface{}, values map[string]string, size int64) error {
+	switch condition := condition.(type) {
+	case map[string]interface{}:
+		for name, expected := range condition {
+			if values[strings.ToLower(name)] != fmt.Sprint(expected) {
+				return fmt.Errorf("%w: %s should be %v", ErrPostPolicyCondition, name, expected)
+			}
+		}
+		return nil
+	case []interface{}:
+		if len(condition) != 3 {
+			break
+		}
+		if op, _ := condition[0].(string); op == "content-length-range" {
+			min, minOK := condition[1].(float64)
+			max, maxOK := condition[2].(float64)
+			if !minOK || !maxOK {
+				break
+			}
+			if size < int64(min) || size > int64(max) {
+				return fmt.Errorf("%w: size %d is not between %d and %d bytes", ErrPostPolicyCondition, size, int64(min), int64(max))
+			}
+			return nil
+		}
+		op, _ := condition[0].(string)
+		field, _ := condition[1].(string)
+		expected, _ := condition[2].(string)
+		value := values[strings.ToLower(strings.TrimPrefix(field, "$"))]
+		switch strings.ToLower(op) {
+		case "eq":
+			if value != expected {
+				return fmt.Errorf("%w: %s should be %s", ErrPostPolicyCondition, strings.TrimPrefix(field, "$"), expected)
+			}
+			return nil
+		case "starts-with":
+			if !strings.HasPrefix(value, expected) {
+				return fmt.Errorf("%w: %s should start with %s", ErrPostPolicyCondition, strings.TrimPrefix(field, "$"), expected)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown condition %v", ErrPostPolicyCondition, condition)
+}
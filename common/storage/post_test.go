@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/rohanchauhan02/clean/common/storage/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPostTestRequest() *CreatePresignedUploadRequest {
+	return &CreatePresignedUploadRequest{
+		Bucket:   stringpointer(mock.MockBucket),
+		Filename: stringpointer(mock.UnsanitizedS3FileName),
+		Size:     int64pointer(mock.DocumentSize),
+		Type:     stringpointer(mock.DocumentType),
+	}
+}
+
+var postTestConditions = PostConditions{ContentType: mock.DocumentMimeType, MaxSize: 1024}
+
+// checkPostConditions checks the policy of a presigned POST accepts its fields and rejects
+// another content type or a larger file
+func checkPostConditions(t *testing.T, post *CreatePresignedUploadResponse) {
+	fields := post.Fields
+	now := time.Now()
+	assert.Equal(t, http.MethodPost, *post.Method)
+	assert.Equal(t, mock.DocumentMimeType, fields[POST_FIELD_CONTENT_TYPE])
+	assert.Nil(t, checkPostPolicy(fields[POST_FIELD_POLICY], mock.MockBucket, fields, 1024, now))
+
+	err := checkPostPolicy(fields[POST_FIELD_POLICY], mock.MockBucket, fields, 1025, now)
+	assert.True(t, errors.Is(err, ErrPostPolicyCondition), err)
+
+	changed := copyMap(fields)
+	changed[POST_FIELD_CONTENT_TYPE] = "text/html"
+	err = checkPostPolicy(fields[POST_FIELD_POLICY], mock.MockBucket, changed, 100, now)
+	assert.True(t, errors.Is(err, ErrPostPolicyCondition), err)
+
+	err = checkPostPolicy(fields[POST_FIELD_POLICY], mock.MockBucket, fields, 100, now.Add(2*time.Hour))
+	assert.Equal(t, ErrPresignedURLExpired, err)
+}
+
+func TestS3CreatePresignedPost(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String(mock.MockAWSRegion),
+		Endpoint:         aws.String("https://s3.ap-southeast-1.amazonaws.com"),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials(mock.MockAccessKey, mock.MockSecretKey, ""),
+	})
+	require.NoError(t, err)
+
+	post, err := S3{Client: s3.New(sess)}.CreatePresignedPost(newPostTestRequest(), postTestConditions)
+	require.NoError(t, err)
+	assert.Equal(t, "https://s3.ap-southeast-1.amazonaws.com/"+mock.MockBucket, *post.URL)
+	assert.Equal(t, mock.SanitizedOSSFileName, post.Fields[POST_FIELD_KEY])
+	checkPostConditions(t, post)
+
+	t.Run("test ok policy is signed with the signature version 4 key", func(t *testing.T) {
+		scope := strings.SplitN(post.Fields["x-amz-credential"], "/", 2)
+		require.Len(t, scope, 2)
+		assert.Equal(t, mock.MockAccessKey, scope[0])
+		key := []byte("AWS4" + mock.MockSecretKey)
+		for _, part := range strings.Split(scope[1], "/") {
+			key = hmacSHA256(key, part)
+		}
+		assert.Equal(t, hex.EncodeToString(hmacSHA256(key, post.Fields[POST_FIELD_POLICY])), post.Fields["x-amz-signature"])
+	})
+}
+
+func TestOSSCreatePresignedPost(t *testing.T) {
+	client, err := oss.New("oss-ap-southeast-5.aliyuncs.com", mock.MockAccessKey, mock.MockSecretKey)
+	require.NoError(t, err)
+
+	post, err := OSS{Client: client}.CreatePresignedPost(newPostTestRequest(), postTestConditions)
+	require.NoError(t, err)
+	assert.Equal(t, "http://"+mock.MockBucket+".oss-ap-southeast-5.aliyuncs.com", *post.URL)
+	assert.Equal(t, mock.SanitizedOSSFileName, post.Fields[POST_FIELD_KEY])
+	assert.Equal(t, mock.MockAccessKey, post.Fields["OSSAccessKeyId"])
+	checkPostConditions(t, post)
+
+	t.Run("test ok policy is signed with the access key secret", func(t *testing.T) {
+		mac := hmac.New(sha1.New, []byte(mock.MockSecretKey))
+		mac.Write([]byte(post.Fields[POST_FIELD_POLICY]))
+		assert.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), post.Fields["Signature"])
+	})
+}
+
+func TestGCSCreatePresignedPost(t *testing.T) {
+	client, fake := newGCSTestClient(t)
+
+	post, err := client.CreatePresignedPost(newPostTestRequest(), postTestConditions)
+	require.NoError(t, err)
+	assert.Equal(t, client.(*GCS).Endpoint+"/"+mock.MockBucket, *post.URL)
+	assert.Equal(t, mock.SanitizedOSSFileName, post.Fields[POST_FIELD_KEY])
+	assert.True(t, strings.HasPrefix(post.Fields["x-goog-credential"], gcpTestAccount+"/"))
+	checkPostConditions(t, post)
+
+	t.Run("test ok policy is signed with the private key", func(t *testing.T) {
+		signature, err := hex.DecodeString(post.Fields["x-goog-signature"])
+		require.NoError(t, err)
+		digest := sha256.Sum256([]byte(post.Fields[POST_FIELD_POLICY]))
+		assert.Nil(t, rsa.VerifyPKCS1v15(fake.publicKey, crypto.SHA256, digest[:], signature))
+	})
+}
+
+func TestMemoryPresignedPost(t *testing.T) {
+	client, _ := newMemoryTestServer(t)
+	data := []byte("%PDF-1.4 policy")
+
+	postForm := func(post *CreatePresignedUploadResponse, fields map[string]string, file []byte) int {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		for _, name := range sortedKeys(fields) {
+			require.NoError(t, form.WriteField(name, fields[name]))
+		}
+		part, err := form.CreateFormFile(POST_FIELD_FILE, mock.DocumentFileName)
+		require.NoError(t, err)
+		part.Write(file)
+		require.NoError(t, form.Close())
+
+		resp, err := http.Post(*post.URL, form.FormDataContentType(), &body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	post, err := client.CreatePresignedPost(newPostTestRequest(), PostConditions{MaxSize: int64(len(data))})
+	require.NoError(t, err)
+	rejected := func(t *testing.T, fields map[string]string, file []byte) {
+		assert.Equal(t, http.StatusForbidden, postForm(post, fields, file))
+		_, _, err := client.GetObject(context.Background(), mock.MockBucket, mock.SanitizedOSSFileName)
+		assert.True(t, errors.Is(err, ErrObjectNotFound))
+	}
+
+	t.Run("test wrong file is larger than the policy allows", func(t *testing.T) {
+		rejected(t, post.Fields, []byte("%PDF-1.4 policy\n"))
+	})
+
+	t.Run("test wrong content type is not the one of the policy", func(t *testing.T) {
+		fields := copyMap(post.Fields)
+		fields[POST_FIELD_CONTENT_TYPE] = "text/html"
+		rejected(t, fields, data)
+	})
+
+	t.Run("test wrong policy is not signed by the client", func(t *testing.T) {
+		fields := copyMap(post.Fields)
+		fields[POST_FIELD_POLICY] = base64.StdEncoding.EncodeToString([]byte(`{"expiration":"2099-01-01T00:00:00.000Z","conditions":[]}`))
+		rejected(t, fields, data)
+	})
+
+	t.Run("test ok file is stored", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, postForm(post, post.Fields, data))
+		stored, info := readObject(t, client, mock.MockBucket, mock.SanitizedOSSFileName, 0, 0)
+		assert.Equal(t, data, stored)
+		assert.Equal(t, mock.DocumentMimeType, info.ContentType)
+	})
+}
@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	PRESIGN_QUERY_EXPIRES   = "X-Storage-Expires"
+	PRESIGN_QUERY_SIGNATURE = "X-Storage-Signature"
+)
+
+var (
+	// ErrObjectNotFound is wrapped by the errors of a key missing in the bucket, by the local and
+	// memory providers and by the HeadObject and tag operations of S3 and OSS
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrPresignedURLExpired is returned when a presigned url is used after its expiry
+	ErrPresignedURLExpired = errors.New("presigned url has expired")
+	// ErrPresignedURLSignature is returned when the signature of a presigned url does not match
+	ErrPresignedURLSignature = errors.New("presigned url signature does not match")
+
+	errStorageEndpointEmpty = errors.New("storage endpoint is empty, presigned urls are served by the handler of the provider")
+)
+
+// URLSigner signs the presigned urls of the local and memory providers with HMAC-SHA256.
+// The urls point to BaseURL, where the Handler of the provider should be mounted
+//   - BaseURL - url of the handler, e.g. http://localhost:8080/storage
+//   - Secret - key of the HMAC, urls signed with another secret are rejected
+type URLSigner struct {
+	BaseURL string
+	Secret  []byte
+
+	now func() time.Time
+}
+
+// NewURLSigner returns a signer for the urls of baseURL, a random secret is used when secret is
+// empty so the urls do not survive a restart
+func NewURLSigner(baseURL, secret string) (URLSigner, error) {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return URLSigner{}, err
+		}
+	}
+	return URLSigner{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Secret:  key,
+	}, nil
+}
+
+// Sign returns the url to call method on the object name of bucket until duration elapses.
+// contentType is part of the signature of the PUT urls, the upload has to send it
+func (s URLSigner) Sign(method, bucket, name, contentType string, duration time.Duration) (string, error) {
+	if s.BaseURL == "" {
+		return "", errStorageEndpointEmpty
+	}
+	if duration <= 0 {
+		return "", fmt.Errorf("duration of a presigned url should be positive, got %s", duration)
+	}
+	signedURL, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(s.clock().Add(duration).Unix(), 10)
+	signedURL.Path = path.Join("/", signedURL.Path, bucket, name)
+	signedURL.RawQuery = url.Values{
+		PRESIGN_QUERY_EXPIRES:   {expires},
+		PRESIGN_QUERY_SIGNATURE: {s.signature(method, bucket, name, contentType, expires)},
+	}.Encode()
+	return signedURL.String(), nil
+}
+
+// Verify checks the query of a presigned url was signed for method on the object and has not expired
+func (s URLSigner) Verify(method, bucket, name, contentType string, query url.Values) error {
+	expires := query.Get(PRESIGN_QUERY_EXPIRES)
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrPresignedURLSignature
+	}
+	expected := s.signature(method, bucket, name, contentType, expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get(PRESIGN_QUERY_SIGNATURE))) {
+		return ErrPresignedURLSignature
+	}
+	if s.clock().Unix() > expiresAt {
+		return ErrPresignedURLExpired
+	}
+	return nil
+}
+
+// SignPolicy returns the signature of the base64 policy of a POST upload
+func (s URLSigner) SignPolicy(policy string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte("POLICY\n" + policy))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s URLSigner) signature(method, bucket, name, contentType, expires string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(strings.Join([]string{strings.ToUpper(method), bucket + "/" + name, expires, contentType}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s URLSigner) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// objectBackend keeps the objects of the local and memory providers, name is already
+// cleaned by objectName
+type objectBackend interface {
+	putObject(bucket, name, mimeType string, data []byte) error
+	getObject(bucket, name string) ([]byte, string, error)
+}
+
+func presignUpload(signer URLSigner, payload *CreatePresignedUploadRequest) (*CreatePresignedUploadResponse, error) {
+	if payload.Duration == 0 {
+		payload.Duration = DEFAULT_DURATION
+	}
+	if err := validateBucket(*payload.Bucket); err != nil {
+		return nil, err
+	}
+
+	fileName := objectName(sanitizeFileNameForUpload(*payload.Filename))
+	mimeType := objectMimeType(*payload.Filename, payload.Mimetype)
+
+	preSignedURL, err := signer.Sign(http.MethodPut, *payload.Bucket, fileName, mimeType, payload.Duration)
+	if err != nil {
+		logger.Error("error while generating presigned url ", err)
+		return nil, err
+	}
+
+	resp := &CreatePresignedUploadResponse{
+		Filename: stringpointer(fileName),
+		Type:     payload.Type,
+		Mimetype: &mimeType,
+		Size:     payload.Size,
+		Bucket:   payload.Bucket,
+		Provider: payload.Provider,
+		URL:      &preSignedURL,
+		Key:      &fileName,
+	}
+	return resp, nil
+}
+
+// presignPost creates a form upload to POST to the bucket through the handler, its policy
+// is checked by the handler
+func presignPost(signer URLSigner, payload *CreatePresignedUploadRequest, conditions PostConditions) (*CreatePresignedUploadResponse, error) {
+	if err := validateBucket(*payload.Bucket); err != nil {
+		return nil, err
+	}
+	if signer.BaseURL == "" {
+		return nil, errStorageEndpointEmpty
+	}
+
+	post := newPresignedPost(payload, objectName(sanitizeFileNameForUpload(*payload.Filename)), conditions, signer.clock())
+	policy, err := post.encodePolicy()
+	if err != nil {
+		return nil, err
+	}
+	post.fields[POST_FIELD_POLICY] = policy
+	post.fields[PRESIGN_QUERY_SIGNATURE] = signer.SignPolicy(policy)
+	return post.response(signer.BaseURL + "/" + post.bucket), nil
+}
+
+func presignView(signer URLSigner, payload *CreatePresignedViewRequest) (*CreatePresignedViewResponse, error) {
+	if payload.Duration == 0 {
+		payload.Duration = DEFAULT_DURATION
+	}
+	if err := validateBucket(*payload.Bucket); err != nil {
+		return nil, err
+	}
+
+	fileName := objectName(*payload.Key)
+	preSignedURL, err := signer.Sign(http.MethodGet, *payload.Bucket, fileName, "", payload.Duration)
+	if err != nil {
+		logger.Error("error while generating presigned url ", err)
+		return nil, err
+	}
+
+	resp := &CreatePresignedViewResponse{
+		Bucket:   payload.Bucket,
+		Provider: payload.Provider,
+		Key:      &fileName,
+		URL:      &preSignedURL,
+	}
+	return resp, nil
+}
+
+func getObjectBuffer(backend objectBackend, payload *GetObjectBufferRequest) ([]byte, error) {
+	if err := validateBucket(*payload.Bucket); err != nil {
+		return nil, err
+	}
+	data, _, err := backend.getObject(*payload.Bucket, objectName(*payload.Key))
+	if err != nil {
+		logger.Error("error during get object: ", err)
+		return nil, err
+	}
+	return data, nil
+}
+
+func putObjectBase64(backend objectBackend, payload *CreateBase64UploadRequest) (*CreateBase64UploadResponse, error) {
+	if err := validateBucket(*payload.Bucket); err != nil {
+		return nil, err
+	}
+	base64Data, err := base64.StdEncoding.DecodeString(*payload.Base64)
+	if err != nil {
+		logger.Error(fmt.Sprintf("error uploading %s err: %s", *payload.Filename, err.Error()))
+		return nil, err
+	}
+	length := int64(len(base64Data))
+
+	fileKey := objectName(sanitizeFileNameForUpload(*payload.Filename))
+	mimeType := objectMimeType(*payload.Filename, payload.Mimetype)
+	if err := backend.putObject(*payload.Bucket, fileKey, mimeType, base64Data); err != nil {
+		logger.Error("error uploading object: ", err)
+		return nil, err
+	}
+
+	status := true
+	resp := &CreateBase64UploadResponse{
+		Filename: stringpointer(fileKey),
+		Type:     payload.Type,
+		Mimetype: stringpointer(mimeType),
+		Size:     &length,
+		Bucket:   payload.Bucket,
+		Provider: payload.Provider,
+		Status:   &status,
+	}
+	return resp, nil
+}
+
+// presignedHandler serves the GET and PUT presigned urls of signer from backend, the
+// request path is the path of signer.BaseURL followed by /bucket/name. The form uploads
+// of presignPost are POST to /bucket
+func presignedHandler(signer URLSigner, backend objectBackend) echo.HandlerFunc {
+	basePath := ""
+	if base, err := url.Parse(signer.BaseURL); err == nil {
+		basePath = strings.TrimSuffix(base.Path, "/")
+	}
+
+	return func(c echo.Context) error {
+		req := c.Request()
+		if req.Method == http.MethodPost {
+			bucket := strings.Trim(strings.TrimPrefix(req.URL.Path, basePath), "/")
+			if validateBucket(bucket) != nil {
+				return echo.NewHTTPError(http.StatusNotFound, ErrObjectNotFound.Error())
+			}
+			return postUpload(c, signer, backend, bucket)
+		}
+		bucket, name, ok := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, basePath), "/"), "/")
+		if !ok || validateBucket(bucket) != nil || objectName(name) == "" {
+			return echo.NewHTTPError(http.StatusNotFound, ErrObjectNotFound.Error())
+		}
+		name = objectName(name)
+
+		switch req.Method {
+		case http.MethodGet:
+			if err := signer.Verify(req.Method, bucket, name, "", c.QueryParams()); err != nil {
+				return echo.NewHTTPError(http.StatusForbidden, err.Error())
+			}
+			data, mimeType, err := backend.getObject(bucket, name)
+			if errors.Is(err, ErrObjectNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, err.Error())
+			}
+			if err != nil {
+				return err
+			}
+			return c.Blob(http.StatusOK, mimeType, data)
+		case http.MethodPut:
+			contentType := req.Header.Get(echo.HeaderContentType)
+			if err := signer.Verify(req.Method, bucket, name, contentType, c.QueryParams()); err != nil {
+				return echo.NewHTTPError(http.StatusForbidden, err.Error())
+			}
+			data, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				return err
+			}
+			if err := backend.putObject(bucket, name, contentType, data); err != nil {
+				return err
+			}
+			return c.NoContent(http.StatusOK)
+		}
+		return echo.ErrMethodNotAllowed
+	}
+}
+
+// postUpload stores the file of a form upload once its signature and the conditions of its
+// policy are verified
+func postUpload(c echo.Context, signer URLSigner, backend objectBackend, bucket string) error {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	defer form.RemoveAll()
+	fields := map[string]string{}
+	for name, values := range form.Value {
+		if len(values) > 0 {
+			fields[name] = values[0]
+		}
+	}
+	files := form.File[POST_FIELD_FILE]
+	name := objectName(fields[POST_FIELD_KEY])
+	if len(files) == 0 || name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "key and file fields are required")
+	}
+
+	policy := fields[POST_FIELD_POLICY]
+	if !hmac.Equal([]byte(signer.SignPolicy(policy)), []byte(fields[PRESIGN_QUERY_SIGNATURE])) {
+		return echo.NewHTTPError(http.StatusForbidden, ErrPresignedURLSignature.Error())
+	}
+	if err := checkPostPolicy(policy, bucket, fields, files[0].Size, signer.clock()); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	file, err := files[0].Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if err := backend.putObject(bucket, name, fields[POST_FIELD_CONTENT_TYPE], data); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// validateBucket rejects the bucket names that would escape the root of the local provider
+// or clash with its .uploads directory
+func validateBucket(bucket string) error {
+	if bucket == "" || strings.HasPrefix(bucket, ".") || strings.ContainsAny(bucket, `/\`) {
+		return fmt.Errorf("invalid bucket name: %q", bucket)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// DEFAULT_CLAMAV_TIMEOUT is the deadline of a ClamAV scan when its context has none
+	DEFAULT_CLAMAV_TIMEOUT = 1 * time.Minute
+	// clamavChunkSize is the size of the chunks of the INSTREAM command
+	clamavChunkSize = 64 << 10
+)
+
+// Scanner checks the content of an upload before it is available, e.g. with an antivirus.
+// Scan returns an *InfectedError when the content has to be rejected
+type Scanner interface {
+	Scan(ctx context.Context, name string, body io.Reader) error
+}
+
+// InfectedError is returned by a Scanner for an infected content, it wraps ErrUploadRejected
+type InfectedError struct {
+	Name      string
+	Signature string
+}
+
+func (e *InfectedError) Error() string {
+	return fmt.Sprintf("%s is infected with %s", e.Name, e.Signature)
+}
+
+func (e *InfectedError) Unwrap() error {
+	return ErrUploadRejected
+}
+
+// ClamAV scans with a clamd daemon through its INSTREAM command, the StreamMaxLength of
+// clamd has to allow the largest upload
+//   - Network - unix or tcp
+//   - Address - path of the socket or host:port, e.g. /var/run/clamav/clamd.ctl
+//   - Timeout - deadline of a scan when its context has none, DEFAULT_CLAMAV_TIMEOUT when 0
+type ClamAV struct {
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamAV returns a scanner calling the clamd daemon listening on address
+func NewClamAV(network, address string) *ClamAV {
+	return &ClamAV{
+		Network: network,
+		Address: address,
+		Timeout: DEFAULT_CLAMAV_TIMEOUT,
+	}
+}
+
+// Scan streams body to clamd, name only identifies the content in the errors
+func (c ClamAV) Scan(ctx context.Context, name string, body io.Reader) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return fmt.Errorf("error connecting to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_CLAMAV_TIMEOUT
+	}
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	// a cancelled scan unblocks the reads and writes of the connection
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	readErr, writeErr := clamavStream(conn, body)
+	if readErr != nil {
+		return fmt.Errorf("error reading %s for clamd: %w", name, readErr)
+	}
+	// clamd replies before closing the connection when the stream is too long
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		if writeErr != nil {
+			err = writeErr
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return fmt.Errorf("error scanning %s with clamd: %w", name, err)
+	}
+	return clamavReply(name, strings.TrimSuffix(reply, "\x00"))
+}
+
+// clamavStream sends body in chunks prefixed by their size, a chunk of size 0 ends it
+func clamavStream(conn net.Conn, body io.Reader) (readErr, writeErr error) {
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return nil, err
+	}
+	chunk := make([]byte, 4+clamavChunkSize)
+	for {
+		n, err := body.Read(chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			if _, err := conn.Write(chunk[:4+n]); err != nil {
+				return nil, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err, nil
+		}
+	}
+	_, err := conn.Write([]byte{0, 0, 0, 0})
+	return nil, err
+}
+
+// clamavReply parses the reply of clamd, e.g. stream: OK or stream: Eicar-Signature FOUND
+func clamavReply(name, reply string) error {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &InfectedError{Name: name, Signature: strings.TrimSuffix(result, " FOUND")}
+	}
+	return fmt.Errorf("clamd could not scan %s: %s", name, reply)
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveFakeClamd answers the INSTREAM commands like clamd, streams longer than maxLength
+// are refused
+func serveFakeClamd(t *testing.T, maxLength int) string {
+	address := filepath.Join(t.TempDir(), "clamd.ctl")
+	listener, err := net.Listen("unix", address)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				if command, err := reader.ReadString(0); err != nil || command != "zINSTREAM\x00" {
+					io.WriteString(conn, "UNKNOWN COMMAND\x00")
+					return
+				}
+				var stream bytes.Buffer
+				for {
+					var size uint32
+					if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if stream.Len()+int(size) > maxLength {
+						io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+						return
+					}
+					if _, err := io.CopyN(&stream, reader, int64(size)); err != nil {
+						return
+					}
+				}
+				if strings.Contains(stream.String(), "EICAR-STANDARD-ANTIVIRUS-TEST-FILE") {
+					io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+					return
+				}
+				io.WriteString(conn, "stream: OK\x00")
+			}(conn)
+		}
+	}()
+	return address
+}
+
+func TestClamAVScan(t *testing.T) {
+	scanner := NewClamAV("unix", serveFakeClamd(t, 1<<20))
+
+	t.Run("test ok clean content", func(t *testing.T) {
+		assert.Nil(t, scanner.Scan(context.Background(), "policy.pdf", bytes.NewReader(bytes.Repeat([]byte("%PDF-1.4 "), 20000))))
+	})
+
+	t.Run("test wrong infected content", func(t *testing.T) {
+		err := scanner.Scan(context.Background(), "claim.pdf", strings.NewReader("%PDF-1.4 EICAR-STANDARD-ANTIVIRUS-TEST-FILE"))
+		var infected *InfectedError
+		require.True(t, errors.As(err, &infected))
+		assert.Equal(t, "Eicar-Test-Signature", infected.Signature)
+		assert.True(t, errors.Is(err, ErrUploadRejected))
+		assert.EqualError(t, err, "claim.pdf is infected with Eicar-Test-Signature")
+	})
+
+	t.Run("test wrong content is longer than clamd accepts", func(t *testing.T) {
+		limited := NewClamAV("unix", serveFakeClamd(t, 1024))
+		err := limited.Scan(context.Background(), "video.mp4", bytes.NewReader(make([]byte, 1<<20)))
+		assert.EqualError(t, err, "clamd could not scan video.mp4: INSTREAM size limit exceeded. ERROR")
+	})
+
+	t.Run("test wrong clamd is not running", func(t *testing.T) {
+		missing := NewClamAV("unix", filepath.Join(t.TempDir(), "missing.ctl"))
+		err := missing.Scan(context.Background(), "policy.pdf", strings.NewReader("%PDF-1.4"))
+		assert.Contains(t, err.Error(), "error connecting to clamd")
+	})
+}
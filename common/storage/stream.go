@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DEFAULT_PART_SIZE is the size of the parts of a multipart upload, S3, OSS and GCS
+	// reject parts smaller than 5MiB except the last one
+	DEFAULT_PART_SIZE = 8 << 20
+	// DEFAULT_MULTIPART_THRESHOLD is the size above which PutObject switches to a multipart upload
+	DEFAULT_MULTIPART_THRESHOLD = 16 << 20
+	// DEFAULT_UPLOAD_CONCURRENCY is the number of parts uploaded in parallel
+	DEFAULT_UPLOAD_CONCURRENCY = 4
+)
+
+var (
+	// ErrInvalidRange is returned by GetObjectRange when the range starts after the end of the object
+	ErrInvalidRange = errors.New("invalid range")
+	// ErrUploadNotFound is returned by the local and memory providers for an unknown multipart upload
+	ErrUploadNotFound = errors.New("multipart upload not found")
+)
+
+// ObjectInfo describes an object read or written by the streaming API
+//   - Size - size of the whole object, also for a range read
+//   - ETag - entity tag given by the provider, it is not the MD5 of multipart uploads
+//   - Metadata - user metadata with lower case names, only set by HeadObject
+type ObjectInfo struct {
+	Bucket       string
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// PutObjectOptions configures PutObject, every field is optional
+//   - ContentType - type of the object, guessed from the extension of the key when empty
+//   - PartSize - size of the parts of a multipart upload, DEFAULT_PART_SIZE when 0
+//   - MultipartThreshold - size above which a multipart upload is used, DEFAULT_MULTIPART_THRESHOLD when 0
+//   - Concurrency - parts uploaded in parallel, DEFAULT_UPLOAD_CONCURRENCY when 0
+//   - UploadID - multipart upload to resume, its uploaded parts are skipped. The body has to
+//     be read from the start again with the same PartSize
+//   - Resumable - a failed multipart upload is kept so it can be resumed, it is aborted otherwise
+//   - OnUploadCreated - called with the ID of a new multipart upload so it can be saved to resume it
+//   - Type - document type selecting the upload policy of PolicyClient
+type PutObjectOptions struct {
+	ContentType        string
+	PartSize           int64
+	MultipartThreshold int64
+	Concurrency        int
+	UploadID           string
+	Resumable          bool
+	OnUploadCreated    func(uploadID string)
+	Type               string
+}
+
+// UploadError is returned when a multipart upload fails, UploadID can be passed to
+// PutObjectOptions to resume a Resumable upload or to AbortUpload to give up on it
+type UploadError struct {
+	UploadID string
+	Aborted  bool
+	Err      error
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("multipart upload %s failed: %s", e.UploadID, e.Err)
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// UploadPart is a part of a multipart upload
+type UploadPart struct {
+	Number int
+	ETag   string
+	Size   int64
+}
+
+// partUploader is implemented by the providers with the calls of their multipart API,
+// uploadObject drives them for PutObject
+type partUploader interface {
+	uploadSingle(ctx context.Context, bucket, key, contentType string, data []byte) (string, error)
+	createUpload(ctx context.Context, bucket, key, contentType string) (string, error)
+	uploadPart(ctx context.Context, bucket, key, uploadID string, number int, data []byte) (string, error)
+	listParts(ctx context.Context, bucket, key, uploadID string) ([]UploadPart, error)
+	completeUpload(ctx context.Context, bucket, key, uploadID string, parts []UploadPart) (string, error)
+	abortUpload(ctx context.Context, bucket, key, uploadID string) error
+}
+
+func (o *PutObjectOptions) withDefaults(key string) PutObjectOptions {
+	var opts PutObjectOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.ContentType == "" {
+		opts.ContentType = objectMimeType(key, nil)
+	}
+	if opts.PartSize <= 0 {
+		opts.PartSize = DEFAULT_PART_SIZE
+	}
+	if opts.MultipartThreshold <= 0 {
+		opts.MultipartThreshold = DEFAULT_MULTIPART_THRESHOLD
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DEFAULT_UPLOAD_CONCURRENCY
+	}
+	return opts
+}
+
+// uploadObject reads body and uploads it with a single request when it is not larger than
+// the threshold, in parts uploaded in parallel otherwise
+func uploadObject(ctx context.Context, uploader partUploader, bucket, key string, body io.Reader, options *PutObjectOptions) (ObjectInfo, error) {
+	opts := options.withDefaults(key)
+	info := ObjectInfo{
+		Bucket:      bucket,
+		Key:         key,
+		ContentType: opts.ContentType,
+	}
+
+	if opts.UploadID == "" {
+		head, err := io.ReadAll(io.LimitReader(body, opts.MultipartThreshold+1))
+		if err != nil {
+			return info, err
+		}
+		if int64(len(head)) <= opts.MultipartThreshold {
+			etag, err := uploader.uploadSingle(ctx, bucket, key, opts.ContentType, head)
+			if err != nil {
+				logger.Error("error uploading object: ", err)
+				return info, err
+			}
+			info.Size = int64(len(head))
+			info.ETag = etag
+			info.LastModified = time.Now()
+			return info, nil
+		}
+		body = io.MultiReader(bytes.NewReader(head), body)
+	}
+
+	return uploadParts(ctx, uploader, info, body, opts)
+}
+
+func uploadParts(ctx context.Context, uploader partUploader, info ObjectInfo, body io.Reader, opts PutObjectOptions) (ObjectInfo, error) {
+	uploadID := opts.UploadID
+	uploaded := map[int]UploadPart{}
+	if uploadID == "" {
+		var err error
+		if uploadID, err = uploader.createUpload(ctx, info.Bucket, info.Key, opts.ContentType); err != nil {
+			logger.Error("error creating multipart upload: ", err)
+			return info, err
+		}
+		if opts.OnUploadCreated != nil {
+			opts.OnUploadCreated(uploadID)
+		}
+	} else {
+		parts, err := uploader.listParts(ctx, info.Bucket, info.Key, uploadID)
+		if err != nil {
+			return info, &UploadError{UploadID: uploadID, Err: err}
+		}
+		for _, part := range parts {
+			uploaded[part.Number] = part
+		}
+	}
+
+	partCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		parts   []UploadPart
+		partErr error
+		readErr error
+		sem     = make(chan struct{}, opts.Concurrency)
+	)
+
+read:
+	for number := 1; ; number++ {
+		data := make([]byte, opts.PartSize)
+		n, err := io.ReadFull(body, data)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			readErr = err
+			break
+		}
+		data = data[:n]
+		info.Size += int64(n)
+
+		if part, ok := uploaded[number]; ok && part.Size == int64(n) {
+			mu.Lock()
+			parts = append(parts, part)
+			mu.Unlock()
+		} else {
+			select {
+			case sem <- struct{}{}:
+			case <-partCtx.Done():
+				break read
+			}
+			wg.Add(1)
+			go func(number int, data []byte) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				etag, err := uploader.uploadPart(partCtx, info.Bucket, info.Key, uploadID, number, data)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					if partErr == nil {
+						partErr = fmt.Errorf("part %d: %w", number, err)
+					}
+					cancel()
+					return
+				}
+				parts = append(parts, UploadPart{Number: number, ETag: etag, Size: int64(len(data))})
+			}(number, data)
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	wg.Wait()
+
+	err := partErr
+	if err == nil {
+		err = readErr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+		info.ETag, err = uploader.completeUpload(ctx, info.Bucket, info.Key, uploadID, parts)
+	}
+	if err != nil {
+		logger.Error("error during multipart upload: ", err)
+		uploadErr := &UploadError{UploadID: uploadID, Err: err}
+		if !opts.Resumable {
+			// ctx may be the reason of the failure, the parts are removed anyway
+			if abortErr := uploader.abortUpload(context.Background(), info.Bucket, info.Key, uploadID); abortErr != nil {
+				logger.Error("error aborting multipart upload: ", abortErr)
+			} else {
+				uploadErr.Aborted = true
+			}
+		}
+		return info, uploadErr
+	}
+	info.LastModified = time.Now()
+	return info, nil
+}
+
+// rangeHeader returns the value of the HTTP Range header reading length bytes from offset,
+// up to the end of the object when length is not positive
+func rangeHeader(offset, length int64) string {
+	if length <= 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+// contentRangeSize returns the size of the whole object from a Content-Range header such as
+// bytes 0-99/1000, size when the header is missing
+func contentRangeSize(contentRange string, size int64) int64 {
+	if _, total, ok := strings.Cut(contentRange, "/"); ok {
+		if parsed, err := strconv.ParseInt(total, 10, 64); err == nil {
+			return parsed
+		}
+	}
+	return size
+}
+
+// sliceRange returns the bounds of the range read from an object of size bytes
+func sliceRange(size, offset, length int64) (int64, int64, error) {
+	if offset < 0 || offset >= size && !(offset == 0 && size == 0) {
+		return 0, 0, fmt.Errorf("%w: offset %d of an object of %d bytes", ErrInvalidRange, offset, size)
+	}
+	end := size
+	if length > 0 && offset+length < size {
+		end = offset + length
+	}
+	return offset, end, nil
+}
+
+// md5ETag returns the ETag of the local and memory objects
+func md5ETag(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func newUploadID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
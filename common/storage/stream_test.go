@@ -0,0 +1,430 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeObjectServer serves the objects and the multipart uploads of the S3 style XML API
+// shared by S3, OSS and GCS, GET honors the Range header. The path style requests of
+// the listing, the batch delete, the copy and the tagging of S3 and OSS are served too,
+// headerPrefix is x-amz- or x-oss- for their headers
+type fakeObjectServer struct {
+	mu           sync.Mutex
+	headerPrefix string
+	objects      map[string][]byte
+	types        map[string]string
+	metadata     map[string]map[string]string
+	tags         map[string]map[string]string
+	uploads      map[string]map[int][]byte
+	aborted      []string
+	nextID       int
+}
+
+// fakeLastModified is the modification time of the objects of fakeObjectServer
+var fakeLastModified = time.Date(2023, 7, 1, 10, 15, 0, 0, time.UTC)
+
+func newFakeObjectServer() *fakeObjectServer {
+	return &fakeObjectServer{
+		headerPrefix: "x-amz-",
+		objects:      map[string][]byte{},
+		types:        map[string]string{},
+		metadata:     map[string]map[string]string{},
+		tags:         map[string]map[string]string{},
+		uploads:      map[string]map[int][]byte{},
+	}
+}
+
+func (f *fakeObjectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	object := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(object, "/")
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	parts, uploading := f.uploads[uploadID]
+	if uploadID != "" && !uploading {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchUpload</Code><Message>The specified upload does not exist.</Message></Error>`)
+		return
+	}
+	if _, ok := f.objects[object]; !ok && (query.Has("tagging") || r.Method == http.MethodHead) {
+		f.notFound(w, r)
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		f.list(w, bucket, query)
+	case key == "" && r.Method == http.MethodPost && query.Has("delete"):
+		var batch struct {
+			Objects []struct{ Key string } `xml:"Object"`
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := xml.Unmarshal(body, &batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, deleted := range batch.Objects {
+			f.remove(bucket + "/" + deleted.Key)
+		}
+		fmt.Fprint(w, `<DeleteResult></DeleteResult>`)
+	case r.Method == http.MethodGet && query.Has("tagging"):
+		tagging := fakeTagging{}
+		for _, name := range sortedKeys(f.tags[object]) {
+			tagging.Tags = append(tagging.Tags, fakeTag{Key: name, Value: f.tags[object][name]})
+		}
+		data, _ := xml.Marshal(tagging)
+		w.Write(data)
+	case r.Method == http.MethodPut && query.Has("tagging"):
+		var tagging fakeTagging
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := xml.Unmarshal(body, &tagging); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.tags[object] = map[string]string{}
+		for _, tag := range tagging.Tags {
+			f.tags[object][tag.Key] = tag.Value
+		}
+	case r.Method == http.MethodPut && r.Header.Get(f.headerPrefix+"copy-source") != "":
+		f.copy(w, r, object)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		id := fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[id] = map[int][]byte{}
+		f.types[object] = r.Header.Get("Content-Type")
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, id)
+	case r.Method == http.MethodPut && uploading:
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		data, _ := ioutil.ReadAll(r.Body)
+		parts[number] = data
+		w.Header().Set("ETag", fakeETag(data))
+	case r.Method == http.MethodGet && uploading:
+		var numbers []int
+		for number := range parts {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		fmt.Fprint(w, `<ListPartsResult><IsTruncated>false</IsTruncated>`)
+		for _, number := range numbers {
+			fmt.Fprintf(w, `<Part><PartNumber>%d</PartNumber><ETag>%s</ETag><Size>%d</Size></Part>`, number, fakeETag(parts[number]), len(parts[number]))
+		}
+		fmt.Fprint(w, `</ListPartsResult>`)
+	case r.Method == http.MethodPost && uploading:
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var data []byte
+		for _, part := range complete.Parts {
+			if fakeETag(parts[part.PartNumber]) != part.ETag {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>InvalidPart</Code><Message>One or more of the specified parts could not be found.</Message></Error>`)
+				return
+			}
+			data = append(data, parts[part.PartNumber]...)
+		}
+		f.objects[object] = data
+		delete(f.uploads, uploadID)
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><ETag>"%d-parts"</ETag></CompleteMultipartUploadResult>`, len(complete.Parts))
+	case r.Method == http.MethodDelete && uploading:
+		delete(f.uploads, uploadID)
+		f.aborted = append(f.aborted, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		f.remove(object)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		f.remove(object)
+		f.objects[object] = data
+		f.types[object] = r.Header.Get("Content-Type")
+		f.metadata[object] = f.requestMetadata(r)
+		w.Header().Set("ETag", fakeETag(data))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[object]
+		if !ok {
+			f.notFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", f.types[object])
+		w.Header().Set("ETag", fakeETag(data))
+		for name, value := range f.metadata[object] {
+			w.Header().Set(f.headerPrefix+"meta-"+name, value)
+		}
+		http.ServeContent(w, r, "", fakeLastModified, bytes.NewReader(data))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type fakeTagging struct {
+	XMLName xml.Name  `xml:"Tagging"`
+	Tags    []fakeTag `xml:"TagSet>Tag"`
+}
+
+type fakeTag struct {
+	Key   string
+	Value string
+}
+
+// list serves a page of ListObjectsV2 with the paging of listPage
+func (f *fakeObjectServer) list(w http.ResponseWriter, bucket string, query url.Values) {
+	var objects []ObjectInfo
+	for object, data := range f.objects {
+		if key, ok := strings.CutPrefix(object, bucket+"/"); ok {
+			objects = append(objects, ObjectInfo{Key: key, Size: int64(len(data)), ETag: fakeETag(data)})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	maxKeys, _ := strconv.Atoi(query.Get("max-keys"))
+	page := listPage(objects, ListObjectsOptions{
+		Prefix:    query.Get("prefix"),
+		Delimiter: query.Get("delimiter"),
+		MaxKeys:   maxKeys,
+		Token:     query.Get("continuation-token"),
+	})
+
+	type content struct {
+		Key          string
+		Size         int64
+		ETag         string
+		LastModified string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string    `xml:",omitempty"`
+		Contents              []content `xml:"Contents"`
+		CommonPrefixes        []string  `xml:"CommonPrefixes>Prefix"`
+	}{IsTruncated: page.IsTruncated, NextContinuationToken: page.NextToken, CommonPrefixes: page.CommonPrefixes}
+	for _, object := range page.Objects {
+		result.Contents = append(result.Contents, content{Key: object.Key, Size: object.Size, ETag: object.ETag, LastModified: fakeLastModified.Format(time.RFC3339)})
+	}
+	data, _ := xml.Marshal(result)
+	w.Write(data)
+}
+
+// copy copies the object of the copy-source header, the metadata is replaced by the one of
+// the request when the metadata-directive header is REPLACE
+func (f *fakeObjectServer) copy(w http.ResponseWriter, r *http.Request, object string) {
+	source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get(f.headerPrefix+"copy-source"), "/"))
+	data, ok := f.objects[source]
+	if err != nil || !ok {
+		f.notFound(w, r)
+		return
+	}
+	contentType, metadata, tags := f.types[source], copyMap(f.metadata[source]), copyMap(f.tags[source])
+	if strings.EqualFold(r.Header.Get(f.headerPrefix+"metadata-directive"), "REPLACE") {
+		contentType, metadata = r.Header.Get("Content-Type"), f.requestMetadata(r)
+	}
+	f.objects[object], f.types[object], f.metadata[object], f.tags[object] = data, contentType, metadata, tags
+	fmt.Fprintf(w, `<CopyObjectResult><ETag>%s</ETag><LastModified>%s</LastModified></CopyObjectResult>`, fakeETag(data), fakeLastModified.Format(time.RFC3339))
+}
+
+func (f *fakeObjectServer) remove(object string) {
+	delete(f.objects, object)
+	delete(f.types, object)
+	delete(f.metadata, object)
+	delete(f.tags, object)
+}
+
+func (f *fakeObjectServer) requestMetadata(r *http.Request) map[string]string {
+	metadata := map[string]string{}
+	for name := range r.Header {
+		if meta, ok := strings.CutPrefix(strings.ToLower(name), f.headerPrefix+"meta-"); ok {
+			metadata[meta] = r.Header.Get(name)
+		}
+	}
+	return metadata
+}
+
+func (f *fakeObjectServer) notFound(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotFound)
+	if r.Method != http.MethodHead {
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+	}
+}
+
+func fakeETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// flakyUploader fails the upload of a part, or cancels the upload before it
+type flakyUploader struct {
+	Memory
+	failPart int
+	cancel   context.CancelFunc
+	calls    int32
+}
+
+func (f *flakyUploader) uploadPart(ctx context.Context, bucket, key, uploadID string, number int, data []byte) (string, error) {
+	atomic.AddInt32(&f.calls, 1)
+	if number == f.failPart {
+		if f.cancel != nil {
+			f.cancel()
+			return "", ctx.Err()
+		}
+		return "", errors.New("connection reset by peer")
+	}
+	return f.Memory.uploadPart(ctx, bucket, key, uploadID, number, data)
+}
+
+func newStreamTestMemory(t *testing.T) *Memory {
+	client, err := NewMemory("", "")
+	require.NoError(t, err)
+	return client
+}
+
+func readObject(t *testing.T, client Client, bucket, key string, offset, length int64) ([]byte, ObjectInfo) {
+	reader, info, err := client.GetObjectRange(context.Background(), bucket, key, offset, length)
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	return data, info
+}
+
+func TestMemoryPutObject(t *testing.T) {
+	client := newStreamTestMemory(t)
+	body := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+
+	t.Run("test ok small body is uploaded at once", func(t *testing.T) {
+		info, err := client.PutObject(context.Background(), "claims", "/videos/small.txt", bytes.NewReader(body[:8]), &PutObjectOptions{MultipartThreshold: 8})
+		require.NoError(t, err)
+		assert.Equal(t, "videos/small.txt", info.Key)
+		assert.Equal(t, int64(8), info.Size)
+		assert.Equal(t, "text/plain; charset=utf-8", info.ContentType)
+		assert.Empty(t, client.store.uploads)
+	})
+
+	t.Run("test ok large body is uploaded in parallel parts", func(t *testing.T) {
+		var uploadID string
+		info, err := client.PutObject(context.Background(), "claims", "videos/claim.mp4", bytes.NewReader(body), &PutObjectOptions{
+			PartSize:           5,
+			MultipartThreshold: 8,
+			Concurrency:        3,
+			OnUploadCreated:    func(id string) { uploadID = id },
+		})
+		require.NoError(t, err)
+		assert.NotEmpty(t, uploadID)
+		assert.Equal(t, int64(len(body)), info.Size)
+		assert.Equal(t, "video/mp4", info.ContentType)
+		assert.Empty(t, client.store.uploads)
+
+		data, info := readObject(t, client, "claims", "videos/claim.mp4", 0, 0)
+		assert.Equal(t, body, data)
+		assert.Equal(t, md5ETag(body), info.ETag)
+	})
+
+	t.Run("test ok range reads", func(t *testing.T) {
+		data, info := readObject(t, client, "claims", "videos/claim.mp4", 10, 5)
+		assert.Equal(t, "abcde", string(data))
+		assert.Equal(t, int64(len(body)), info.Size)
+
+		data, _ = readObject(t, client, "claims", "videos/claim.mp4", 30, 100)
+		assert.Equal(t, "uvwxyz", string(data))
+	})
+
+	t.Run("test wrong range after the end of the object", func(t *testing.T) {
+		_, _, err := client.GetObjectRange(context.Background(), "claims", "videos/claim.mp4", int64(len(body)), 0)
+		assert.True(t, errors.Is(err, ErrInvalidRange))
+	})
+}
+
+func TestPutObjectFailures(t *testing.T) {
+	body := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	opts := func() *PutObjectOptions {
+		return &PutObjectOptions{PartSize: 4, MultipartThreshold: 4, Concurrency: 1}
+	}
+
+	t.Run("test wrong failed upload is aborted", func(t *testing.T) {
+		uploader := &flakyUploader{Memory: *newStreamTestMemory(t), failPart: 3}
+		_, err := uploadObject(context.Background(), uploader, "claims", "claim.mp4", bytes.NewReader(body), opts())
+
+		var uploadErr *UploadError
+		require.True(t, errors.As(err, &uploadErr))
+		assert.True(t, uploadErr.Aborted)
+		assert.Contains(t, err.Error(), "part 3: connection reset by peer")
+		assert.Empty(t, uploader.store.uploads)
+		_, _, err = uploader.GetObject(context.Background(), "claims", "claim.mp4")
+		assert.True(t, errors.Is(err, ErrObjectNotFound))
+	})
+
+	t.Run("test wrong cancelled upload is aborted", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		uploader := &flakyUploader{Memory: *newStreamTestMemory(t), failPart: 2, cancel: cancel}
+		_, err := uploadObject(ctx, uploader, "claims", "claim.mp4", bytes.NewReader(body), opts())
+
+		var uploadErr *UploadError
+		require.True(t, errors.As(err, &uploadErr))
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.True(t, uploadErr.Aborted)
+		assert.Empty(t, uploader.store.uploads)
+	})
+
+	t.Run("test ok resumable upload skips the uploaded parts", func(t *testing.T) {
+		memory := newStreamTestMemory(t)
+		uploader := &flakyUploader{Memory: *memory, failPart: 3}
+		resumable := opts()
+		resumable.Resumable = true
+		_, err := uploadObject(context.Background(), uploader, "claims", "claim.mp4", bytes.NewReader(body), resumable)
+
+		var uploadErr *UploadError
+		require.True(t, errors.As(err, &uploadErr))
+		assert.False(t, uploadErr.Aborted)
+		require.Len(t, memory.store.uploads, 1)
+
+		resumed := &flakyUploader{Memory: *memory}
+		resumable.UploadID = uploadErr.UploadID
+		info, err := uploadObject(context.Background(), resumed, "claims", "claim.mp4", bytes.NewReader(body), resumable)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(body)), info.Size)
+		assert.Equal(t, int32(len(body)/4-2), resumed.calls)
+
+		data, _ := readObject(t, memory, "claims", "claim.mp4", 0, 0)
+		assert.Equal(t, body, data)
+	})
+
+	t.Run("test ok kept upload is aborted", func(t *testing.T) {
+		memory := newStreamTestMemory(t)
+		uploader := &flakyUploader{Memory: *memory, failPart: 2}
+		resumable := opts()
+		resumable.Resumable = true
+		_, err := uploadObject(context.Background(), uploader, "claims", "claim.mp4", bytes.NewReader(body), resumable)
+		var uploadErr *UploadError
+		require.True(t, errors.As(err, &uploadErr))
+
+		assert.True(t, errors.Is(memory.AbortUpload(context.Background(), "claims", "other.mp4", uploadErr.UploadID), ErrUploadNotFound))
+		assert.Nil(t, memory.AbortUpload(context.Background(), "claims", "claim.mp4", uploadErr.UploadID))
+		assert.Empty(t, memory.store.uploads)
+	})
+}
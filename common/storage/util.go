@@ -2,8 +2,11 @@ package storage
 
 import (
 	"fmt"
+	"mime"
+	"path"
 	"github.com/rohanchauhan02/clean/common/util"
 	"path/filepath"
+	"strings"
 )
 
 func sanitizeFileNameForUpload(filename string) string{
@@ -20,3 +23,22 @@ func stringpointer(s string) *string {
 func int64pointer(i int64) *int64 {
 	return &i
 }
+
+// objectName removes the leading / of the S3 style keys and the ./ sanitizeFileNameForUpload
+// gives to file names without directory, the GCS and local object names do not start with
+// them so /private/file.pdf and private/file.pdf are the same object
+func objectName(key string) string {
+	return strings.TrimPrefix(path.Clean("/"+key), "/")
+}
+
+// objectMimeType returns the type of the extension of filename, the requested one when the
+// extension is unknown
+func objectMimeType(filename string, requested *string) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(filename)); mimeType != "" {
+		return mimeType
+	}
+	if requested != nil && *requested != "" {
+		return *requested
+	}
+	return "application/octet-stream"
+}
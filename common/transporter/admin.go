@@ -0,0 +1,56 @@
+package transporter
+
+import "fmt"
+
+// QueueAttributes are the options a queue is created with, zero values keep the
+// provider defaults
+//   - FIFO - creates a FIFO queue, SQS requires the queue name to end with .fifo
+//   - VisibilityTimeout, MessageRetentionPeriod and DelayInSeconds are in seconds
+//   - RedrivePolicy - moves a message to a dead-letter queue after MaxReceiveCount receives
+type QueueAttributes struct {
+	FIFO                      bool
+	ContentBasedDeduplication bool
+	VisibilityTimeout         int64
+	MessageRetentionPeriod    int64
+	DelayInSeconds            int64
+	MaxMessageSize            int64
+	RedrivePolicy             *RedrivePolicy
+}
+
+// RedrivePolicy of a queue, DeadLetterQueueName must already exist
+type RedrivePolicy struct {
+	DeadLetterQueueName string
+	MaxReceiveCount     int64
+}
+
+// QueueCounts are the approximate number of messages in a queue
+//   - Visible - messages available to consumers
+//   - InFlight - messages received but not deleted yet
+//   - Delayed - messages waiting for their delay before being visible
+type QueueCounts struct {
+	Visible  int64 `json:"visible"`
+	InFlight int64 `json:"inFlight"`
+	Delayed  int64 `json:"delayed"`
+}
+
+// QueueAdmin is implemented by the providers that can manage their queues,
+// it is kept apart from Client so services that only publish and consume
+// do not need admin permissions
+type QueueAdmin interface {
+	CreateQueue(queueName string, attributes *QueueAttributes) error
+	DeleteQueue(queueName string) error
+	GetQueueCounts(queueName string) (*QueueCounts, error)
+	PurgeQueue(queueName string) error
+	// ListQueues returns the names of the queues starting with prefix
+	ListQueues(prefix string) ([]string, error)
+}
+
+// NewQueueAdmin returns the QueueAdmin of client, an error is returned when the
+// provider of client cannot manage queues
+func NewQueueAdmin(client Client) (QueueAdmin, error) {
+	admin, ok := Unwrap(client).(QueueAdmin)
+	if !ok {
+		return nil, fmt.Errorf("queue administration is not supported by %T", client)
+	}
+	return admin, nil
+}
@@ -1,13 +1,27 @@
 package transporter
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
 	"github.com/aliyun/aliyun-mns-go-sdk"
 )
 
 // MNS will implement the Client interface through pointer receivers
 type MNS struct {
 	Client ali_mns.MNSClient
+	// PropagateAttributes sends the message attributes inside an envelope around the body
+	// since the MNS SDK has no message properties. Consumers unwrap the envelope whether it
+	// is set or not, enable it once every consumer of the queue runs this version
+	PropagateAttributes bool
+	// QueueManager is used by the QueueAdmin methods, it defaults to the queue
+	// manager of Client
+	QueueManager ali_mns.AliQueueManager
+
+	queueManagerOnce sync.Once
 }
 
 func (mns *MNS) HealthCheck(options *HealthCheckOptions) (bool, error) {
@@ -18,16 +32,16 @@ func (mns *MNS) HealthCheck(options *HealthCheckOptions) (bool, error) {
 //Publish will push a message to the MNS queue with specified options and returns nil if no error
 // TODO: should return a proper message response that abides to the Client interface
 func (mns *MNS) Publish(options *MessagePublishOptions) error {
-	msgBody, ok := options.MessageBody.(*string)
+	msgBody, ok := mnsMessageBody(options.MessageBody)
 	if !ok {
-		err := fmt.Errorf("message body is not of type string to publish to MNS queue: %s", options.QueueName)
+		err := fmt.Errorf("message body is not of type string, *string or []byte to publish to MNS queue: %s", options.QueueName)
 		return err
 	}
 
 	queue := ali_mns.NewMNSQueue(options.QueueName, mns.Client)
 
 	msg := ali_mns.MessageSendRequest{
-		MessageBody:  *msgBody,
+		MessageBody:  mns.wrapAttributes(msgBody, options.Attributes),
 		Priority:     options.Priority,
 		DelaySeconds: options.DelayInSeconds,
 	}
@@ -60,11 +74,12 @@ func (mns *MNS) Consume(options *MessageConsumeOptions) (*MessageReceiveResponse
 		case resp := <-responseChannel:
 			{
 				rs := &MessageReceiveResponse{
-					MessageBody:              resp.MessageBody,
 					MessageID:                resp.MessageId,
 					MessageReceiptHandle:     resp.ReceiptHandle,
 					MessageVisibilityTimeout: options.VisibilityTimeout,
+					ReceiveCount:             resp.DequeueCount,
 				}
+				rs.MessageBody, rs.Attributes = unwrapMNSAttributes(resp.MessageBody)
 				if options.DeleteMessageAfterAck {
 					_ = mns.DeleteMessage(options.QueueName, rs)
 				}
@@ -80,30 +95,67 @@ func (mns *MNS) Consume(options *MessageConsumeOptions) (*MessageReceiveResponse
 }
 
 //BatchPublish will push a max of 16 messages to a queue at a time with max of 64kb
-//for all the messages, priority and delaySeconds will be the same across all messages
-// TODO: should return a proper message response that abides to the Client interface
-func (mns *MNS) BatchPublish(options *MessagePublishOptions) error {
-	msgBodyArr, ok := options.MessageBody.([]string)
-	if !ok {
-		return fmt.Errorf("message body is not of type []string to batch publish to MNS queue: %s", options.QueueName)
+//for all the messages. Messages are taken from options.Entries, or from MessageBody as a []string
+//in which case priority and delaySeconds will be the same across all messages.
+//Entries rejected by MNS are reported in BatchResult.Failed
+func (mns *MNS) BatchPublish(options *MessagePublishOptions) (*BatchResult, error) {
+	entries := options.Entries
+	if len(entries) == 0 {
+		msgBodyArr, ok := options.MessageBody.([]string)
+		if !ok {
+			return nil, fmt.Errorf("message body is not of type []string to batch publish to MNS queue: %s", options.QueueName)
+		}
+		for _, msgBody := range msgBodyArr {
+			entries = append(entries, MessagePublishEntry{MessageBody: msgBody})
+		}
 	}
 
 	queue := ali_mns.NewMNSQueue(options.QueueName, mns.Client)
 
-	msgsRequest := make([]ali_mns.MessageSendRequest, len(msgBodyArr))
-	for i, msgBody := range msgBodyArr {
+	ids := make([]string, len(entries))
+	msgsRequest := make([]ali_mns.MessageSendRequest, len(entries))
+	for i, entry := range entries {
+		msgBody, ok := mnsMessageBody(entry.MessageBody)
+		if !ok {
+			return nil, fmt.Errorf("message body of entry %d is not of type string to batch publish to MNS queue: %s", i, options.QueueName)
+		}
+		delay := options.DelayInSeconds
+		if entry.DelayInSeconds > 0 {
+			delay = entry.DelayInSeconds
+		}
+		ids[i] = firstNonEmpty(entry.ID, strconv.Itoa(i))
 		msgsRequest[i] = ali_mns.MessageSendRequest{
-			MessageBody:  msgBody,
+			MessageBody:  mns.wrapAttributes(msgBody, mergeAttributes(options.Attributes, entry.Attributes)),
 			Priority:     options.Priority,
-			DelaySeconds: options.DelayInSeconds,
+			DelaySeconds: delay,
 		}
 	}
-	_, err := queue.BatchSendMessage(msgsRequest...)
-	if err != nil {
+	resp, err := queue.BatchSendMessage(msgsRequest...)
+	if err != nil && len(resp.Messages) == 0 {
 		logger.Errorf("error in sending message to MNS queue: %s for: %s", options.QueueName, err)
-		return err
+		return nil, err
 	}
-	return nil
+
+	result := &BatchResult{}
+	for i, msg := range resp.Messages {
+		if i >= len(ids) {
+			break
+		}
+		if msg.ErrorCode != "" {
+			logger.Errorf("message failed to be sent to MNS queue: %s with ID: %s, for error %s", options.QueueName, ids[i], msg.ErrorMessage)
+			result.Failed = append(result.Failed, BatchResultEntry{
+				ID:      ids[i],
+				Code:    msg.ErrorCode,
+				Message: msg.ErrorMessage,
+			})
+			continue
+		}
+		result.Successful = append(result.Successful, BatchResultEntry{
+			ID:        ids[i],
+			MessageID: msg.MessageId,
+		})
+	}
+	return result, nil
 }
 
 //BatchConsume consumes a max of 16 messages to a queue at a time, after the messages
@@ -130,14 +182,15 @@ func (mns *MNS) BatchConsume(options *MessageConsumeOptions) ([]MessageReceiveRe
 				responses := make([]MessageReceiveResponse, len(msgs))
 				for i, msg := range msgs {
 					responses[i] = MessageReceiveResponse{
-						MessageBody:              msg.MessageBody,
 						MessageID:                msg.MessageId,
 						MessageReceiptHandle:     msg.ReceiptHandle,
 						MessageVisibilityTimeout: options.VisibilityTimeout,
+						ReceiveCount:             msg.DequeueCount,
 					}
+					responses[i].MessageBody, responses[i].Attributes = unwrapMNSAttributes(msg.MessageBody)
 				}
 				if options.DeleteMessageAfterAck {
-					_ = mns.BatchDeleteMessage(options.QueueName, responses)
+					_, _ = mns.BatchDeleteMessage(options.QueueName, responses)
 				}
 				return responses, nil
 			}
@@ -172,11 +225,30 @@ func (mns *MNS) DeleteMessage(queueName string, message *MessageReceiveResponse)
 
 }
 
+// ChangeVisibility hides the message for visibilityTimeout seconds from now with
+// ChangeMessageVisibility. MNS issues a new receipt handle, it replaces the one of
+// message so the message can still be deleted. MNS does not accept zero, the message
+// is made visible after one second instead
+func (mns *MNS) ChangeVisibility(queueName string, message *MessageReceiveResponse, visibilityTimeout int64) error {
+	if visibilityTimeout < 1 {
+		visibilityTimeout = 1
+	}
+	queue := ali_mns.NewMNSQueue(queueName, mns.Client)
+
+	ret, err := queue.ChangeMessageVisibility(message.MessageReceiptHandle, visibilityTimeout)
+	if err != nil {
+		logger.Errorf("error in changing visibility of MNS message with ID: %s on queue: %s, %s", message.MessageID, queueName, err)
+		return err
+	}
+	message.MessageReceiptHandle = ret.ReceiptHandle
+	return nil
+}
+
 //BatchDeleteMessage will delete a batch message by taking an array of message's receiptHandle as the parameter
 //If there are some messages that cannot be deleted in the batch, the MNS API returns an array of FailedMessages
 //by its receiptHandle.
 //TODO: Add alarm/metric for failing in deleting messages
-func (mns *MNS) BatchDeleteMessage(queueName string, messages []MessageReceiveResponse) error {
+func (mns *MNS) BatchDeleteMessage(queueName string, messages []MessageReceiveResponse) (*BatchResult, error) {
 
 	queue := ali_mns.NewMNSQueue(queueName, mns.Client)
 
@@ -186,13 +258,212 @@ func (mns *MNS) BatchDeleteMessage(queueName string, messages []MessageReceiveRe
 	}
 
 	resp, err := queue.BatchDeleteMessage(receiptHandlers...)
-	if err != nil {
+	if err != nil && len(resp.FailedMessages) == 0 {
 		logger.Errorf("error in batch deleting message, %s", err)
-		return err
+		return nil, err
 	}
+
+	failedHandles := make(map[string]ali_mns.MessageDeleteFailEntry, len(resp.FailedMessages))
 	for _, fm := range resp.FailedMessages {
 		logger.Errorf("messages failed to be deleted with receiptHandle: %s, for error %s", fm.ReceiptHandle, fm.ErrorMessage)
+		failedHandles[fm.ReceiptHandle] = fm
+	}
+
+	result := &BatchResult{}
+	for _, message := range messages {
+		if fm, ok := failedHandles[message.MessageReceiptHandle]; ok {
+			result.Failed = append(result.Failed, BatchResultEntry{
+				ID:      message.MessageID,
+				Code:    fm.ErrorCode,
+				Message: fm.ErrorMessage,
+			})
+			continue
+		}
+		result.Successful = append(result.Successful, BatchResultEntry{
+			ID:        message.MessageID,
+			MessageID: message.MessageID,
+		})
+	}
+	return result, nil
+
+}
+
+func mnsMessageBody(body interface{}) (string, bool) {
+	switch v := body.(type) {
+	case string:
+		return v, true
+	case *string:
+		if v == nil {
+			return "", false
+		}
+		return *v, true
+	case []byte:
+		return string(v), true
+	}
+	return "", false
+}
+
+type mnsAttributesEnvelope struct {
+	Envelope   string            `json:"transporter_envelope"`
+	Attributes map[string]string `json:"attributes"`
+	Body       string            `json:"body"`
+}
+
+const (
+	mnsAttributesEnvelopeMarker = "attributes"
+	mnsAttributesEnvelopePrefix = `{"transporter_envelope":"attributes"`
+)
+
+func (mns *MNS) wrapAttributes(body string, attributes map[string]string) string {
+	if !mns.PropagateAttributes {
+		return body
+	}
+	return wrapMNSAttributes(body, attributes)
+}
+
+func wrapMNSAttributes(body string, attributes map[string]string) string {
+	if len(attributes) == 0 {
+		return body
+	}
+	envelope, err := json.Marshal(mnsAttributesEnvelope{
+		Envelope:   mnsAttributesEnvelopeMarker,
+		Attributes: attributes,
+		Body:       body,
+	})
+	if err != nil {
+		return body
+	}
+	return string(envelope)
+}
+
+func unwrapMNSAttributes(body string) (string, map[string]string) {
+	if !strings.HasPrefix(body, mnsAttributesEnvelopePrefix) {
+		return body, nil
+	}
+	var envelope mnsAttributesEnvelope
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil || envelope.Envelope != mnsAttributesEnvelopeMarker {
+		return body, nil
+	}
+	return envelope.Body, envelope.Attributes
+}
+
+// Defaults of CreateQueue, they are the ones of ali_mns.CreateSimpleQueue
+const (
+	mnsDefaultMaxMessageSize         = 65536
+	mnsDefaultMessageRetentionPeriod = 345600
+	mnsDefaultVisibilityTimeout      = 30
+	mnsDefaultSlices                 = 2
+	mnsPurgeBatchSize                = 16
+)
+
+// queueManager returns QueueManager, defaulting it once so that concurrent QueueAdmin
+// calls do not race on the field
+func (mns *MNS) queueManager() ali_mns.AliQueueManager {
+	mns.queueManagerOnce.Do(func() {
+		if mns.QueueManager == nil {
+			mns.QueueManager = ali_mns.NewMNSQueueManager(mns.Client)
+		}
+	})
+	return mns.QueueManager
+}
+
+// CreateQueue creates a standard MNS queue, FIFO queues and redrive policies are not
+// supported by MNS, use ConsumerOption.RetryPolicy to route failed messages to a dead-letter queue
+func (mns *MNS) CreateQueue(queueName string, attributes *QueueAttributes) error {
+	if attributes == nil {
+		attributes = &QueueAttributes{}
+	}
+	if attributes.FIFO || attributes.ContentBasedDeduplication {
+		return fmt.Errorf("FIFO queues are not supported by MNS, queue: %s", queueName)
+	}
+	if attributes.RedrivePolicy != nil {
+		return fmt.Errorf("redrive policy is not supported by MNS, queue: %s", queueName)
+	}
+
+	orDefault := func(value int64, defaultValue int32) int32 {
+		if value > 0 {
+			return int32(value)
+		}
+		return defaultValue
+	}
+	err := mns.queueManager().CreateQueue(queueName,
+		int32(attributes.DelayInSeconds),
+		orDefault(attributes.MaxMessageSize, mnsDefaultMaxMessageSize),
+		orDefault(attributes.MessageRetentionPeriod, mnsDefaultMessageRetentionPeriod),
+		orDefault(attributes.VisibilityTimeout, mnsDefaultVisibilityTimeout),
+		0,
+		mnsDefaultSlices)
+	if err != nil {
+		logger.Errorf("error in creating MNS queue: %s, %s", queueName, err)
+		return err
 	}
 	return nil
+}
 
+func (mns *MNS) DeleteQueue(queueName string) error {
+	if err := mns.queueManager().DeleteQueue(queueName); err != nil {
+		logger.Errorf("error in deleting MNS queue: %s, %s", queueName, err)
+		return err
+	}
+	return nil
+}
+
+// GetQueueCounts returns the active, inactive and delayed messages of the queue
+func (mns *MNS) GetQueueCounts(queueName string) (*QueueCounts, error) {
+	attributes, err := mns.queueManager().GetQueueAttributes(queueName)
+	if err != nil {
+		logger.Errorf("error in getting attributes of MNS queue: %s, %s", queueName, err)
+		return nil, err
+	}
+	return &QueueCounts{
+		Visible:  attributes.ActiveMessages,
+		InFlight: attributes.InactiveMessages,
+		Delayed:  attributes.DelayMessages,
+	}, nil
+}
+
+// PurgeQueue receives and deletes the visible messages until the queue is empty since
+// MNS has no purge API. In-flight and delayed messages are not deleted
+func (mns *MNS) PurgeQueue(queueName string) error {
+	purged := 0
+	for {
+		msgs, err := mns.BatchConsume(&MessageConsumeOptions{
+			QueueName:        queueName,
+			NumberOfMessages: mnsPurgeBatchSize,
+			WaitTimeSeconds:  1,
+		})
+		if err != nil {
+			if ali_mns.ERR_MNS_MESSAGE_NOT_EXIST.IsEqual(err) {
+				logger.Infof("purged %d messages from MNS queue: %s", purged, queueName)
+				return nil
+			}
+			return err
+		}
+		if len(msgs) == 0 {
+			return nil
+		}
+		if _, err := mns.BatchDeleteMessage(queueName, msgs); err != nil {
+			return err
+		}
+		purged += len(msgs)
+	}
+}
+
+func (mns *MNS) ListQueues(prefix string) ([]string, error) {
+	var queueNames []string
+	marker := ""
+	for {
+		queues, err := mns.queueManager().ListQueue(marker, 1000, prefix)
+		if err != nil {
+			logger.Errorf("error in listing MNS queues with prefix: %s, %s", prefix, err)
+			return nil, err
+		}
+		for _, queue := range queues.Queues {
+			queueNames = append(queueNames, queue.QueueURL[strings.LastIndex(queue.QueueURL, "/")+1:])
+		}
+		if queues.NextMarker == "" {
+			return queueNames, nil
+		}
+		marker = queues.NextMarker
+	}
 }
@@ -2,11 +2,14 @@ package transporter
 
 import (
 	"os"
+	"sync"
 
 	"testing"
 
+	ali_mns "github.com/aliyun/aliyun-mns-go-sdk"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -208,7 +211,7 @@ func TestAlicloudBatchPublishWithInvalidMessageBody(t *testing.T) {
 	}
 
 	t.Run("test Alicloud batch publish with invalid MessageBody", func(t *testing.T) {
-		err := client.BatchPublish(options)
+		_, err := client.BatchPublish(options)
 		assert.Error(t, err)
 	})
 }
@@ -229,7 +232,7 @@ func TestAlicloudBatchPublishWithNoPriority(t *testing.T) {
 	}
 
 	t.Run("test Alicloud batch publish with no priority", func(t *testing.T) {
-		err := client.BatchPublish(options)
+		_, err := client.BatchPublish(options)
 		assert.Error(t, err)
 	})
 }
@@ -254,7 +257,7 @@ func TestAlicloudBatchPublishWithGreaterThan16Messages(t *testing.T) {
 	}
 
 	t.Run("test Alicloud request publish greather than 16 messages", func(t *testing.T) {
-		err := client.BatchPublish(options)
+		_, err := client.BatchPublish(options)
 		assert.Error(t, err)
 	})
 }
@@ -276,7 +279,7 @@ func TestAlicloudBatchPublishWithValidMessageOptions(t *testing.T) {
 	}
 
 	t.Run("test Alicloud batch publish with valid MessageOptions", func(t *testing.T) {
-		err := client.BatchPublish(options)
+		_, err := client.BatchPublish(options)
 		assert.Nil(t, err)
 	})
 }
@@ -341,7 +344,7 @@ func TestAliCloudDeleteMessageAfterBatchConsume(t *testing.T) {
 		QueueName:      queueName,
 	}
 
-	err := client.BatchPublish(publishOptions)
+	_, err := client.BatchPublish(publishOptions)
 	if err != nil {
 		assert.Fail(t, "batch publish message failed on testing delete message after consume")
 	}
@@ -419,3 +422,91 @@ func TestAlicloudDeleteMessageRoutine(t *testing.T) {
 		assert.Nil(t, err)
 	})
 }
+
+type fakeMNSQueueManager struct {
+	ali_mns.AliQueueManager
+	created   []ali_mns.QueueAttribute
+	listCalls []string
+}
+
+func (f *fakeMNSQueueManager) CreateQueue(queueName string, delaySeconds int32, maxMessageSize int32, messageRetentionPeriod int32, visibilityTimeout int32, pollingWaitSeconds int32, slices int32) error {
+	f.created = append(f.created, ali_mns.QueueAttribute{
+		QueueName:              queueName,
+		DelaySeconds:           delaySeconds,
+		MaxMessageSize:         maxMessageSize,
+		MessageRetentionPeriod: messageRetentionPeriod,
+		VisibilityTimeout:      visibilityTimeout,
+	})
+	return nil
+}
+
+func (f *fakeMNSQueueManager) GetQueueAttributes(queueName string) (ali_mns.QueueAttribute, error) {
+	return ali_mns.QueueAttribute{QueueName: queueName, ActiveMessages: 7, InactiveMessages: 2, DelayMessages: 4}, nil
+}
+
+func (f *fakeMNSQueueManager) ListQueue(nextMarker string, retNumber int32, prefix string) (ali_mns.Queues, error) {
+	f.listCalls = append(f.listCalls, nextMarker)
+	if nextMarker == "" {
+		return ali_mns.Queues{
+			Queues:     []ali_mns.Queue{{QueueURL: "http://1.mns.local/queues/policy-expiry"}},
+			NextMarker: "page-2",
+		}, nil
+	}
+	return ali_mns.Queues{Queues: []ali_mns.Queue{{QueueURL: "http://1.mns.local/queues/policy-renewal"}}}, nil
+}
+
+func TestAlicloudQueueAdmin(t *testing.T) {
+	queueManager := &fakeMNSQueueManager{}
+	admin, err := NewQueueAdmin(&MNS{QueueManager: queueManager})
+	require.NoError(t, err)
+
+	t.Run("test wrong FIFO queue and redrive policy", func(t *testing.T) {
+		assert.Error(t, admin.CreateQueue("policy-expiry", &QueueAttributes{FIFO: true}))
+		assert.Error(t, admin.CreateQueue("policy-expiry", &QueueAttributes{
+			RedrivePolicy: &RedrivePolicy{DeadLetterQueueName: "policy-dlq", MaxReceiveCount: 3},
+		}))
+	})
+
+	t.Run("test ok create queue with default attributes", func(t *testing.T) {
+		require.NoError(t, admin.CreateQueue("policy-expiry", &QueueAttributes{VisibilityTimeout: 60}))
+		assert.Equal(t, []ali_mns.QueueAttribute{{
+			QueueName:              "policy-expiry",
+			MaxMessageSize:         65536,
+			MessageRetentionPeriod: 345600,
+			VisibilityTimeout:      60,
+		}}, queueManager.created)
+	})
+
+	t.Run("test ok counts and list", func(t *testing.T) {
+		counts, err := admin.GetQueueCounts("policy-expiry")
+		require.NoError(t, err)
+		assert.Equal(t, &QueueCounts{Visible: 7, InFlight: 2, Delayed: 4}, counts)
+
+		queueNames, err := admin.ListQueues("policy")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"policy-expiry", "policy-renewal"}, queueNames)
+		assert.Equal(t, []string{"", "page-2"}, queueManager.listCalls)
+	})
+}
+
+func TestAlicloudQueueManager(t *testing.T) {
+	t.Run("test ok default queue manager is created once", func(t *testing.T) {
+		mns := &MNS{Client: ali_mns.NewAliMNSClient("http://1.mns.cn-hangzhou.aliyuncs.com", "key", "secret")}
+
+		managers := make([]ali_mns.AliQueueManager, 8)
+		var wg sync.WaitGroup
+		for i := range managers {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				managers[i] = mns.queueManager()
+			}(i)
+		}
+		wg.Wait()
+
+		require.NotNil(t, mns.QueueManager)
+		for _, manager := range managers {
+			assert.Same(t, mns.QueueManager, manager)
+		}
+	})
+}
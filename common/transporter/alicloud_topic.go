@@ -0,0 +1,146 @@
+package transporter
+
+import (
+	"fmt"
+	"strings"
+
+	ali_mns "github.com/aliyun/aliyun-mns-go-sdk"
+)
+
+// MNSTopic implements TopicClient with MNS topics delivering to MNS queues.
+// MNS can only filter on the message tag, which is the TagAttribute attribute of the
+// message, so filter policies can only list one value of TagAttribute
+type MNSTopic struct {
+	Client ali_mns.MNSClient
+	// TagAttribute is the attribute sent as the MNS message tag, it defaults to AttributeEventType
+	TagAttribute string
+	// PropagateAttributes sends the message attributes inside the envelope the MNS
+	// consumers unwrap, see MNS.PropagateAttributes
+	PropagateAttributes bool
+	// NewTopic returns the topic to use for a name, it defaults to ali_mns.NewMNSTopic
+	NewTopic func(topicName string) ali_mns.AliMNSTopic
+}
+
+// NewMNSTopic returns an MNS topic client
+func NewMNSTopic(client ali_mns.MNSClient) *MNSTopic {
+	return &MNSTopic{
+		Client:       client,
+		TagAttribute: AttributeEventType,
+	}
+}
+
+func (t *MNSTopic) topic(topicName string) ali_mns.AliMNSTopic {
+	if t.NewTopic != nil {
+		return t.NewTopic(topicName)
+	}
+	return ali_mns.NewMNSTopic(topicName, t.Client)
+}
+
+func (t *MNSTopic) tagAttribute() string {
+	return firstNonEmpty(t.TagAttribute, AttributeEventType)
+}
+
+func (t *MNSTopic) PublishToTopic(options *MessagePublishOptions) error {
+	if options.TopicName == "" {
+		return fmt.Errorf("topic name is empty")
+	}
+	msgBody, ok := mnsMessageBody(options.MessageBody)
+	if !ok {
+		return fmt.Errorf("message body is not of type string, *string or []byte to publish to MNS topic: %s", options.TopicName)
+	}
+	if t.PropagateAttributes {
+		msgBody = wrapMNSAttributes(msgBody, options.Attributes)
+	}
+
+	_, err := t.topic(options.TopicName).PublishMessage(ali_mns.MessagePublishRequest{
+		MessageBody: msgBody,
+		MessageTag:  options.Attributes[t.tagAttribute()],
+	})
+	if err != nil {
+		logger.Errorf("error in publishing message to MNS topic: %s, for: %s", options.TopicName, err)
+		return err
+	}
+	return nil
+}
+
+// Subscribe subscribes the queue to the topic under the queue name, messages are
+// delivered in the SIMPLIFIED format so consumers read the published body
+func (t *MNSTopic) Subscribe(topicName string, queueName string, filterPolicy FilterPolicy) (*TopicSubscription, error) {
+	filterTag, err := t.filterTag(filterPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	topic := t.topic(topicName)
+	err = topic.Subscribe(queueName, ali_mns.MessageSubsribeRequest{
+		Endpoint:            topic.GenerateQueueEndpoint(queueName),
+		FilterTag:           filterTag,
+		NotifyStrategy:      ali_mns.BACKOFF_RETRY,
+		NotifyContentFormat: ali_mns.SIMPLIFIED,
+	})
+	if err != nil {
+		logger.Errorf("error in subscribing MNS queue: %s to MNS topic: %s, %s", queueName, topicName, err)
+		return nil, err
+	}
+
+	return &TopicSubscription{
+		ID:           queueName,
+		TopicName:    topicName,
+		QueueName:    queueName,
+		FilterPolicy: filterPolicy,
+	}, nil
+}
+
+func (t *MNSTopic) Unsubscribe(subscription *TopicSubscription) error {
+	if err := t.topic(subscription.TopicName).Unsubscribe(subscription.ID); err != nil {
+		logger.Errorf("error in unsubscribing: %s from MNS topic: %s, %s", subscription.ID, subscription.TopicName, err)
+		return err
+	}
+	return nil
+}
+
+// ListSubscriptions returns the queue subscriptions of the topic, subscriptions of other
+// endpoints such as mail or HTTP are skipped
+func (t *MNSTopic) ListSubscriptions(topicName string) ([]TopicSubscription, error) {
+	topic := t.topic(topicName)
+
+	var subscriptions []TopicSubscription
+	marker := ""
+	for {
+		details, err := topic.ListSubscriptionDetailByTopic(marker, 1000, "")
+		if err != nil {
+			logger.Errorf("error in listing subscriptions of MNS topic: %s, %s", topicName, err)
+			return nil, err
+		}
+		for _, detail := range details.Attrs {
+			index := strings.LastIndex(detail.Endpoint, ":queues/")
+			if index < 0 {
+				continue
+			}
+			subscription := TopicSubscription{
+				ID:        detail.SubscriptionName,
+				TopicName: topicName,
+				QueueName: detail.Endpoint[index+len(":queues/"):],
+			}
+			if detail.FilterTag != "" {
+				subscription.FilterPolicy = FilterPolicy{t.tagAttribute(): {detail.FilterTag}}
+			}
+			subscriptions = append(subscriptions, subscription)
+		}
+		if details.NextMarker == "" {
+			return subscriptions, nil
+		}
+		marker = details.NextMarker
+	}
+}
+
+func (t *MNSTopic) filterTag(filterPolicy FilterPolicy) (string, error) {
+	if len(filterPolicy) == 0 {
+		return "", nil
+	}
+	values, ok := filterPolicy[t.tagAttribute()]
+	if len(filterPolicy) > 1 || !ok || len(values) != 1 {
+		return "", fmt.Errorf("MNS topics can only filter on one value of the %s attribute", t.tagAttribute())
+	}
+	return values[0], nil
+}
@@ -0,0 +1,245 @@
+package transporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultArchivePrefix        = "archive"
+	DefaultArchiveMaxRecords    = 500
+	DefaultArchiveFlushInterval = time.Minute
+)
+
+// MessageOutcome is what the consumer did with a message once its handler returned
+type MessageOutcome string
+
+const (
+	OutcomeAcked        MessageOutcome = "acked"
+	OutcomeRetried      MessageOutcome = "retried"
+	OutcomeDeadLettered MessageOutcome = "dead_lettered"
+	// OutcomeFailed messages are left in the queue to be redelivered by the provider
+	OutcomeFailed MessageOutcome = "failed"
+)
+
+// ArchiveStore keeps the archived messages, the storage module implements it in common/archive
+type ArchiveStore interface {
+	Put(ctx context.Context, key string, body []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	// List returns the keys starting with prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ArchiveRecord is one consumed message of the archive, a line of its NDJSON objects
+type ArchiveRecord struct {
+	Queue          string            `json:"queue"`
+	MessageID      string            `json:"message_id"`
+	MessageGroupID string            `json:"message_group_id,omitempty"`
+	Body           string            `json:"body"`
+	Attributes     map[string]string `json:"attributes,omitempty"`
+	ReceiveCount   int64             `json:"receive_count,omitempty"`
+	ReceivedAt     time.Time         `json:"received_at"`
+	ProcessedAt    time.Time         `json:"processed_at"`
+	Outcome        MessageOutcome    `json:"outcome"`
+	Error          string            `json:"error,omitempty"`
+}
+
+// ArchiveOption configures an Archiver
+//   - Prefix - the objects are Prefix/<queue>/<yyyy/mm/dd/hh>/<time>-<host>-<seq>.ndjson,
+//     partitioned by the UTC hour the messages were received at
+//   - MaxRecords - buffered records of a partition written at once, a full partition is
+//     written by the consumer that filled it
+//   - FlushInterval - how often Run writes the partitions that are not full
+type ArchiveOption struct {
+	Store         ArchiveStore
+	Prefix        string
+	MaxRecords    int
+	FlushInterval time.Duration
+}
+
+// Archiver buffers the messages received by the consumers and writes them to the store
+// in date-partitioned newline-delimited JSON, so they can be inspected and replayed.
+// Records that could not be written are kept and written with the next flush
+type Archiver struct {
+	store         ArchiveStore
+	prefix        string
+	maxRecords    int
+	flushInterval time.Duration
+	host          string
+	now           func() time.Time
+
+	mu       sync.Mutex
+	sequence int64
+	buffers  map[string][]ArchiveRecord
+}
+
+// NewArchiver returns an archiver writing to option.Store
+func NewArchiver(option *ArchiveOption) (*Archiver, error) {
+	if option == nil || option.Store == nil {
+		return nil, errors.New("archive store is empty")
+	}
+
+	hostname, _ := os.Hostname()
+	a := &Archiver{
+		store:         option.Store,
+		prefix:        firstNonEmpty(option.Prefix, DefaultArchivePrefix),
+		maxRecords:    option.MaxRecords,
+		flushInterval: option.FlushInterval,
+		host:          fmt.Sprintf("%s-%d", firstNonEmpty(hostname, "consumer"), os.Getpid()),
+		now:           time.Now,
+		buffers:       map[string][]ArchiveRecord{},
+	}
+	if a.maxRecords <= 0 {
+		a.maxRecords = DefaultArchiveMaxRecords
+	}
+	if a.flushInterval <= 0 {
+		a.flushInterval = DefaultArchiveFlushInterval
+	}
+	return a, nil
+}
+
+// Record buffers record in the partition of its queue and receive time, the partition
+// is written when it holds MaxRecords records
+func (a *Archiver) Record(ctx context.Context, record ArchiveRecord) {
+	partition := archivePartition(a.prefix, record.Queue, record.ReceivedAt)
+
+	a.mu.Lock()
+	a.buffers[partition] = append(a.buffers[partition], record)
+	full := len(a.buffers[partition]) >= a.maxRecords
+	a.mu.Unlock()
+
+	if full {
+		if err := a.flushPartition(ctx, partition); err != nil {
+			logger.Errorf("failed to archive messages of partition: %s, %s", partition, err)
+		}
+	}
+}
+
+// Flush writes the buffered records of every partition
+func (a *Archiver) Flush(ctx context.Context) error {
+	a.mu.Lock()
+	partitions := make([]string, 0, len(a.buffers))
+	for partition := range a.buffers {
+		partitions = append(partitions, partition)
+	}
+	a.mu.Unlock()
+	sort.Strings(partitions)
+
+	var errs []error
+	for _, partition := range partitions {
+		if err := a.flushPartition(ctx, partition); err != nil {
+			errs = append(errs, fmt.Errorf("partition: %s, %w", partition, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run flushes the archiver every FlushInterval until ctx is done, it flushes a last
+// time before returning
+func (a *Archiver) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := a.Flush(detachedContext{parent: ctx}); err != nil {
+				logger.Errorf("failed to archive messages: %s", err)
+			}
+			return
+		case <-ticker.C:
+			if err := a.Flush(ctx); err != nil {
+				logger.Errorf("failed to archive messages: %s", err)
+			}
+		}
+	}
+}
+
+func (a *Archiver) flushPartition(ctx context.Context, partition string) error {
+	a.mu.Lock()
+	records := a.buffers[partition]
+	delete(a.buffers, partition)
+	a.sequence++
+	key := fmt.Sprintf("%s/%s-%s-%06d.ndjson", partition, a.now().UTC().Format("20060102T150405Z"), a.host, a.sequence)
+	a.mu.Unlock()
+	if len(records) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	encoder.SetEscapeHTML(false)
+	for i := range records {
+		if err := encoder.Encode(records[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := a.store.Put(ctx, key, body.Bytes()); err != nil {
+		a.mu.Lock()
+		a.buffers[partition] = append(records, a.buffers[partition]...)
+		a.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// archive records the outcome of a message handled by the consumer
+func (c *consumer) archive(ctx context.Context, message *MessageReceiveResponse, receivedAt time.Time, outcome MessageOutcome, handlerErr error) {
+	if c.archiver == nil {
+		return
+	}
+	body, err := messageBodyBytes(message.MessageBody)
+	if err != nil {
+		logger.Errorf("failed to archive message with ID: %s on queue: %s, %s", message.MessageID, c.queueName, err)
+		return
+	}
+
+	record := ArchiveRecord{
+		Queue:          c.queueName,
+		MessageID:      message.MessageID,
+		MessageGroupID: message.MessageGroupID,
+		Body:           string(body),
+		Attributes:     message.Attributes,
+		ReceiveCount:   message.ReceiveCount,
+		ReceivedAt:     receivedAt.UTC(),
+		ProcessedAt:    time.Now().UTC(),
+		Outcome:        outcome,
+	}
+	if handlerErr != nil {
+		record.Error = handlerErr.Error()
+	}
+	c.archiver.Record(ctx, record)
+}
+
+// archivePartition is the key prefix of the records of queue received during the hour of t
+func archivePartition(prefix, queue string, t time.Time) string {
+	return path.Join(prefix, queue, t.UTC().Format("2006/01/02/15"))
+}
+
+// readArchiveRecords decodes the NDJSON records of an archive object
+func readArchiveRecords(data []byte) ([]ArchiveRecord, error) {
+	var records []ArchiveRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record ArchiveRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,233 @@
+package transporter
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeArchiveStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	err     error
+}
+
+func (f *fakeArchiveStore) Put(ctx context.Context, key string, body []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.objects[key] = append([]byte{}, body...)
+	return nil
+}
+
+func (f *fakeArchiveStore) Get(ctx context.Context, key string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[key]
+	if !ok {
+		return nil, errors.New("NoSuchKey")
+	}
+	return data, nil
+}
+
+func (f *fakeArchiveStore) List(ctx context.Context, prefix string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func TestArchiveAndReplay(t *testing.T) {
+	client, now := newMemoryTestClient(t)
+	store := &fakeArchiveStore{objects: map[string][]byte{}}
+	archiver, err := NewArchiver(&ArchiveOption{Store: store, MaxRecords: 3})
+	require.NoError(t, err)
+
+	serviceConsumer, err := NewServiceConsumer(&ConsumerOption{
+		TransporterClient: client,
+		QueueName:         "finance",
+		VisibilityTimeout: 30,
+		RetryPolicy:       &RetryPolicy{MaxAttempts: 2, Backoff: BackoffFixed, InitialDelay: 10 * time.Second, DeadLetterQueue: "finance-dlq"},
+		Archiver:          archiver,
+	})
+	require.NoError(t, err)
+	serviceConsumer.Handle(func(ctx context.Context, message *MessageReceiveResponse) error {
+		if strings.Contains(message.MessageBody.(string), "INVALID") {
+			return errors.New("stoploss not found")
+		}
+		return nil
+	})
+	c := serviceConsumer.(*consumer)
+
+	start := time.Now().Add(-time.Second)
+	for _, body := range []string{"UPDATE_STOPLOSS_1", "UPDATE_STOPLOSS_INVALID", "UPDATE_STOPLOSS_2"} {
+		require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: body, Attributes: map[string]string{
+			AttributeRequestID:      "req-" + body,
+			AttributeIdempotencyKey: body,
+			"x-datadog-trace-id":    "1",
+		}}))
+	}
+	for _, delay := range []time.Duration{0, 0, 0, 10 * time.Second} {
+		*now = now.Add(delay)
+		message, err := client.Consume(&MessageConsumeOptions{QueueName: "finance", VisibilityTimeout: 30})
+		require.NoError(t, err)
+		require.NotNil(t, message)
+		c.process(context.Background(), message)
+	}
+
+	t.Run("test ok full partition is written", func(t *testing.T) {
+		keys, err := store.List(context.Background(), archivePartition(DefaultArchivePrefix, "finance", time.Now())+"/")
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.True(t, strings.HasSuffix(keys[0], "-000001.ndjson"), keys[0])
+
+		records, err := readArchiveRecords(store.objects[keys[0]])
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		assert.Equal(t, []MessageOutcome{OutcomeAcked, OutcomeRetried, OutcomeAcked}, []MessageOutcome{records[0].Outcome, records[1].Outcome, records[2].Outcome})
+		assert.Equal(t, "stoploss not found", records[1].Error)
+		assert.Equal(t, "req-UPDATE_STOPLOSS_1", records[0].Attributes[AttributeRequestID])
+	})
+
+	t.Run("test ok buffered records are written on flush", func(t *testing.T) {
+		assert.Nil(t, archiver.Flush(context.Background()))
+		assert.Len(t, store.objects, 2)
+	})
+
+	replayer := NewReplayer(store, "", client)
+	filter := ReplayFilter{Queue: "finance", From: start}
+
+	t.Run("test ok dry run selects without publishing", func(t *testing.T) {
+		result, err := replayer.Replay(context.Background(), ReplayFilter{Queue: "finance", From: start, Outcomes: []MessageOutcome{OutcomeDeadLettered}}, ReplayOption{DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, 4, result.Scanned)
+		require.Len(t, result.Records, 1)
+		assert.Equal(t, "UPDATE_STOPLOSS_INVALID", result.Records[0].Body)
+		assert.Equal(t, 0, result.Published)
+		assert.Equal(t, 0, client.Depth("finance-replay"))
+	})
+
+	t.Run("test ok selected messages are published in order", func(t *testing.T) {
+		filter.BodyContains = "UPDATE_STOPLOSS_"
+		filter.Attributes = map[string]string{AttributeRequestID: "req-UPDATE_STOPLOSS_2"}
+		result, err := replayer.Replay(context.Background(), filter, ReplayOption{TargetQueue: "finance-replay"})
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Published)
+
+		message, err := client.Consume(&MessageConsumeOptions{QueueName: "finance-replay"})
+		require.NoError(t, err)
+		require.NotNil(t, message)
+		assert.Equal(t, "UPDATE_STOPLOSS_2", message.MessageBody)
+		assert.Equal(t, "req-UPDATE_STOPLOSS_2", message.Attributes[AttributeRequestID])
+		assert.NotEmpty(t, message.Attributes[AttributeReplayOf])
+		assert.NotContains(t, message.Attributes, AttributeIdempotencyKey)
+		assert.NotContains(t, message.Attributes, "x-datadog-trace-id")
+	})
+
+	t.Run("test ok limit", func(t *testing.T) {
+		result, err := replayer.Replay(context.Background(), ReplayFilter{Queue: "finance", From: start}, ReplayOption{DryRun: true, Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, result.Records, 2)
+		assert.Equal(t, "UPDATE_STOPLOSS_1", result.Records[0].Body)
+	})
+
+	t.Run("test wrong time range", func(t *testing.T) {
+		_, err := replayer.Replay(context.Background(), ReplayFilter{Queue: "finance", From: start, To: start.Add(-time.Hour)}, ReplayOption{DryRun: true})
+		assert.NotNil(t, err)
+		_, err = replayer.Replay(context.Background(), ReplayFilter{From: start}, ReplayOption{DryRun: true})
+		assert.EqualError(t, err, "replay filter has no queue")
+	})
+
+	t.Run("test ok range outside the archive", func(t *testing.T) {
+		result, err := replayer.Replay(context.Background(), ReplayFilter{Queue: "finance", From: start.Add(-48 * time.Hour), To: start.Add(-47 * time.Hour)}, ReplayOption{DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, 0, result.Scanned)
+		assert.Empty(t, result.Records)
+	})
+}
+
+func TestReplayToFIFOQueue(t *testing.T) {
+	client, _ := newMemoryTestClient(t)
+	store := &fakeArchiveStore{objects: map[string][]byte{}}
+	archiver, err := NewArchiver(&ArchiveOption{Store: store})
+	require.NoError(t, err)
+
+	start := time.Now().Add(-time.Second)
+	archiver.Record(context.Background(), ArchiveRecord{Queue: "finance.fifo", MessageID: "1", MessageGroupID: "stoploss", Body: "UPDATE_STOPLOSS_1", ReceivedAt: time.Now().UTC(), Outcome: OutcomeAcked})
+	archiver.Record(context.Background(), ArchiveRecord{Queue: "finance.fifo", MessageID: "2", Body: "UPDATE_STOPLOSS_2", ReceivedAt: time.Now().UTC(), Outcome: OutcomeAcked})
+	require.NoError(t, archiver.Flush(context.Background()))
+	replayer := NewReplayer(store, "", client)
+
+	t.Run("test wrong message without group", func(t *testing.T) {
+		result, err := replayer.Replay(context.Background(), ReplayFilter{Queue: "finance.fifo", From: start}, ReplayOption{})
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, result.Published)
+	})
+
+	t.Run("test ok archived group is kept", func(t *testing.T) {
+		result, err := replayer.Replay(context.Background(), ReplayFilter{Queue: "finance.fifo", From: start, MessageIDs: []string{"1"}}, ReplayOption{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Published)
+
+		message, err := client.Consume(&MessageConsumeOptions{QueueName: "finance.fifo"})
+		require.NoError(t, err)
+		require.NotNil(t, message)
+		assert.Equal(t, "stoploss", message.MessageGroupID)
+		require.NoError(t, client.DeleteMessage("finance.fifo", message))
+	})
+
+	t.Run("test ok group of the option", func(t *testing.T) {
+		result, err := replayer.Replay(context.Background(), ReplayFilter{Queue: "finance.fifo", From: start, MessageIDs: []string{"2"}}, ReplayOption{MessageGroupID: "replay"})
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Published)
+
+		message, err := client.Consume(&MessageConsumeOptions{QueueName: "finance.fifo"})
+		require.NoError(t, err)
+		require.NotNil(t, message)
+		assert.Equal(t, "replay", message.MessageGroupID)
+	})
+}
+
+func TestArchiverKeepsRecordsWhenStoreFails(t *testing.T) {
+	store := &fakeArchiveStore{objects: map[string][]byte{}, err: errors.New("service unavailable")}
+	archiver, err := NewArchiver(&ArchiveOption{Store: store, Prefix: "qoala/archive"})
+	require.NoError(t, err)
+
+	receivedAt := time.Date(2023, 7, 1, 10, 15, 0, 0, time.UTC)
+	archiver.Record(context.Background(), ArchiveRecord{Queue: "policy", MessageID: "1", Body: "CREATE_POLICY", ReceivedAt: receivedAt, Outcome: OutcomeAcked})
+	archiver.Record(context.Background(), ArchiveRecord{Queue: "policy", MessageID: "2", Body: "CREATE_POLICY", ReceivedAt: receivedAt.Add(time.Hour), Outcome: OutcomeAcked})
+
+	t.Run("test wrong store fails", func(t *testing.T) {
+		assert.NotNil(t, archiver.Flush(context.Background()))
+		assert.Empty(t, store.objects)
+	})
+
+	t.Run("test ok records are written once the store recovers", func(t *testing.T) {
+		store.err = nil
+		assert.Nil(t, archiver.Flush(context.Background()))
+		keys, _ := store.List(context.Background(), "qoala/archive/policy/2023/07/01/")
+		require.Len(t, keys, 2)
+		assert.True(t, strings.HasPrefix(keys[0], "qoala/archive/policy/2023/07/01/10/"), keys[0])
+		assert.True(t, strings.HasPrefix(keys[1], "qoala/archive/policy/2023/07/01/11/"), keys[1])
+	})
+
+	t.Run("test wrong archiver without store", func(t *testing.T) {
+		_, err := NewArchiver(&ArchiveOption{})
+		assert.EqualError(t, err, "archive store is empty")
+	})
+}
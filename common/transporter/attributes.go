@@ -0,0 +1,136 @@
+package transporter
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/rohanchauhan02/clean/common/requestid"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// AttributeRequestID carries the X-Request-ID of the request that published the message
+	AttributeRequestID = "X-Request-ID"
+	// AttributeTraceContext carries the trace propagation headers packed as JSON on the
+	// providers limiting the number of attributes of a message, SQS and SNS accept 10
+	AttributeTraceContext = "X-Trace-Context"
+)
+
+// InjectContext adds the Datadog span context and the request ID of ctx to attributes
+// and returns them. Attributes already set by the caller are kept
+func InjectContext(ctx context.Context, attributes map[string]string) map[string]string {
+	if ctx == nil {
+		return attributes
+	}
+
+	injected := map[string]string{}
+	if span, ok := tracer.SpanFromContext(ctx); ok {
+		if err := tracer.Inject(span.Context(), tracer.TextMapCarrier(injected)); err != nil {
+			logger.Errorf("failed to inject span context into message attributes: %s", err)
+		}
+	}
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		injected[AttributeRequestID] = requestID
+	}
+	return mergeAttributes(injected, attributes)
+}
+
+// ContextWithAttributes returns a copy of ctx holding the request ID carried by the
+// message attributes, the trace is continued by util.StartSpanFromContextConsumer
+func ContextWithAttributes(ctx context.Context, attributes map[string]string) context.Context {
+	requestID := attributes[AttributeRequestID]
+	if requestID == "" || RequestIDFromContext(ctx) != "" {
+		return ctx
+	}
+	return WithRequestID(ctx, requestID)
+}
+
+// WithRequestID returns a copy of ctx holding requestID, see requestid.NewContext
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return requestid.NewContext(ctx, requestID)
+}
+
+// RequestIDFromContext returns the request ID stored by the request ID middleware
+// or by ContextWithAttributes
+func RequestIDFromContext(ctx context.Context) string {
+	return requestid.FromContext(ctx)
+}
+
+// dropsAttributes reports whether client publishes the messages without their attributes,
+// which is the case of MNS without PropagateAttributes
+func dropsAttributes(client Client) bool {
+	mns, ok := Unwrap(client).(*MNS)
+	return ok && !mns.PropagateAttributes
+}
+
+// mergeAttributes returns the attributes of base overridden by the ones of override,
+// nil is returned when both are empty
+func mergeAttributes(base, override map[string]string) map[string]string {
+	if len(base) == 0 && len(override) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+// packTraceAttributes returns attributes with the trace propagation headers moved into
+// a single AttributeTraceContext attribute, attributes is returned when it has none
+func packTraceAttributes(attributes map[string]string) map[string]string {
+	trace := map[string]string{}
+	for name, value := range attributes {
+		if isTraceAttribute(name) {
+			trace[name] = value
+		}
+	}
+	if len(trace) == 0 {
+		return attributes
+	}
+	data, err := json.Marshal(trace)
+	if err != nil {
+		return attributes
+	}
+
+	packed := map[string]string{AttributeTraceContext: string(data)}
+	for name, value := range attributes {
+		if !isTraceAttribute(name) {
+			packed[name] = value
+		}
+	}
+	return packed
+}
+
+// unpackTraceAttributes restores the trace propagation headers packed by packTraceAttributes
+func unpackTraceAttributes(attributes map[string]string) map[string]string {
+	data, ok := attributes[AttributeTraceContext]
+	if !ok {
+		return attributes
+	}
+	var trace map[string]string
+	if err := json.Unmarshal([]byte(data), &trace); err != nil {
+		return attributes
+	}
+
+	unpacked := make(map[string]string, len(attributes)+len(trace))
+	for name, value := range attributes {
+		if name != AttributeTraceContext {
+			unpacked[name] = value
+		}
+	}
+	for name, value := range trace {
+		unpacked[name] = value
+	}
+	return unpacked
+}
+
+// isTraceAttribute reports whether name is a Datadog or W3C trace propagation header
+func isTraceAttribute(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "x-datadog-") || name == "traceparent" || name == "tracestate"
+}
@@ -0,0 +1,105 @@
+package transporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+func TestProducerPropagatesTraceAndRequestID(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	client := NewMemory()
+	producer, err := NewServiceProducer(&ProducerOption{TransporterClient: client, QueueName: "add-product"})
+	require.NoError(t, err)
+
+	span, ctx := tracer.StartSpanFromContext(context.Background(), "rest.product.add")
+	ctx = WithRequestID(ctx, "req-1")
+	require.NoError(t, producer.ProduceWithContext(ctx, "h1"))
+	span.Finish()
+
+	consumer, err := NewServiceConsumer(&ConsumerOption{TransporterClient: client, QueueName: "add-product"})
+	require.NoError(t, err)
+
+	handled := make(chan context.Context, 1)
+	var received *MessageReceiveResponse
+	consumer.Handle(func(ctx context.Context, message *MessageReceiveResponse) error {
+		received = message
+		handled <- ctx
+		return nil
+	})
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- consumer.Run(runCtx) }()
+
+	var handlerCtx context.Context
+	select {
+	case handlerCtx = <-handled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("message was not handled")
+	}
+	cancel()
+	require.NoError(t, <-done)
+
+	t.Run("test ok request ID is restored in the handler context", func(t *testing.T) {
+		assert.Equal(t, "req-1", received.Attributes[AttributeRequestID])
+		assert.Equal(t, "req-1", RequestIDFromContext(handlerCtx))
+	})
+
+	t.Run("test ok consumer continues the trace of the producer", func(t *testing.T) {
+		spanContext, err := tracer.Extract(tracer.TextMapCarrier(received.Attributes))
+		assert.Nil(t, err)
+		require.NotNil(t, spanContext)
+		assert.Equal(t, span.Context().TraceID(), spanContext.TraceID())
+		assert.Equal(t, span.Context().SpanID(), spanContext.SpanID())
+	})
+}
+
+func TestInjectContext(t *testing.T) {
+	t.Run("test ok attributes set by the caller are kept", func(t *testing.T) {
+		ctx := WithRequestID(context.Background(), "req-1")
+		attributes := InjectContext(ctx, map[string]string{AttributeRequestID: "req-2", "tenant": "qoala"})
+		assert.Equal(t, map[string]string{AttributeRequestID: "req-2", "tenant": "qoala"}, attributes)
+	})
+
+	t.Run("test ok nothing to inject", func(t *testing.T) {
+		assert.Nil(t, InjectContext(context.Background(), nil))
+	})
+
+	t.Run("test ok request ID of the context is not overridden", func(t *testing.T) {
+		ctx := WithRequestID(context.Background(), "req-1")
+		ctx = ContextWithAttributes(ctx, map[string]string{AttributeRequestID: "req-2"})
+		assert.Equal(t, "req-1", RequestIDFromContext(ctx))
+	})
+}
+
+func TestMNSAttributesEnvelope(t *testing.T) {
+	mns := &MNS{PropagateAttributes: true}
+	attributes := map[string]string{AttributeRequestID: "req-1"}
+
+	t.Run("test ok body is wrapped and unwrapped", func(t *testing.T) {
+		wrapped := mns.wrapAttributes("HALO", attributes)
+		assert.NotEqual(t, "HALO", wrapped)
+
+		body, unwrapped := unwrapMNSAttributes(wrapped)
+		assert.Equal(t, "HALO", body)
+		assert.Equal(t, attributes, unwrapped)
+	})
+
+	t.Run("test ok body is sent as is when propagation is disabled", func(t *testing.T) {
+		assert.Equal(t, "HALO", (&MNS{}).wrapAttributes("HALO", attributes))
+	})
+
+	t.Run("test ok plain body is not unwrapped", func(t *testing.T) {
+		body, unwrapped := unwrapMNSAttributes(`{"transporter_envelope":"other"}`)
+		assert.Equal(t, `{"transporter_envelope":"other"}`, body)
+		assert.Nil(t, unwrapped)
+	})
+}
@@ -0,0 +1,227 @@
+package transporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rohanchauhan02/clean/common/schemas"
+)
+
+// QueueConfig declares a queue in the YAML config, the keys of the map it is read from
+// name the queue in GetSQSActions and, unless Handler is set, the handler it is bound to
+//   - Provider - the client the queue is consumed with, the default one when empty
+//   - DelayInSeconds - returned by schemas.GetSQSActions to the producers of the queue
+//   - Consume - false for the queues the service only produces to
+//   - HeartbeatSecond - extends the visibility of the messages every HeartbeatSecond while
+//     their handler runs, for handlers that may take longer than VisibilityTimeout
+//   - Archive - records the handled messages with the archiver given to SetArchiver
+type QueueConfig struct {
+	Name                  string      `mapstructure:"NAME"`
+	Provider              string      `mapstructure:"PROVIDER"`
+	Handler               string      `mapstructure:"HANDLER"`
+	NumberRetrieveMessage int         `mapstructure:"NUMBER_RETRIEVE_MESSAGE"`
+	WaitTimeSecond        int         `mapstructure:"WAIT_TIME_SECOND"`
+	VisibilityTimeout     int         `mapstructure:"VISIBILITY_TIMEOUT"`
+	HeartbeatSecond       int         `mapstructure:"HEARTBEAT_SECOND"`
+	WorkerPool            int         `mapstructure:"WORKER_POOL"`
+	DelayInSeconds        int         `mapstructure:"DELAY_IN_SECONDS"`
+	DeadLetterQueue       string      `mapstructure:"DEAD_LETTER_QUEUE"`
+	Retry                 RetryConfig `mapstructure:"RETRY"`
+	Consume               *bool       `mapstructure:"CONSUME"`
+	Archive               bool        `mapstructure:"ARCHIVE"`
+}
+
+// RetryConfig is the RetryPolicy of a QueueConfig
+type RetryConfig struct {
+	MaxAttempts        int    `mapstructure:"MAX_ATTEMPTS"`
+	Backoff            string `mapstructure:"BACKOFF"`
+	InitialDelaySecond int    `mapstructure:"INITIAL_DELAY_SECOND"`
+	MaxDelaySecond     int    `mapstructure:"MAX_DELAY_SECOND"`
+	Mode               string `mapstructure:"MODE"`
+}
+
+// RetryPolicy returns the policy of the queue, nil when it has neither retries nor a dead-letter queue
+func (c QueueConfig) RetryPolicy() *RetryPolicy {
+	if c.Retry.MaxAttempts <= 0 && c.DeadLetterQueue == "" {
+		return nil
+	}
+	return &RetryPolicy{
+		MaxAttempts:     c.Retry.MaxAttempts,
+		Backoff:         BackoffStrategy(strings.ToLower(c.Retry.Backoff)),
+		InitialDelay:    time.Duration(c.Retry.InitialDelaySecond) * time.Second,
+		MaxDelay:        time.Duration(c.Retry.MaxDelaySecond) * time.Second,
+		Mode:            RetryMode(strings.ToLower(c.Retry.Mode)),
+		DeadLetterQueue: c.DeadLetterQueue,
+	}
+}
+
+// QueueBootstrap builds one consumer per queue declared in the config and binds it to
+// the handler registered under its name, so adding a queue is a config change.
+// Names of providers, handlers and queues are case insensitive, viper lowercases the keys
+type QueueBootstrap struct {
+	mu       sync.RWMutex
+	clients  map[string]Client
+	handlers map[string]Handler
+	archiver *Archiver
+}
+
+// NewQueueBootstrap returns a bootstrap consuming the queues without provider with defaultClient
+func NewQueueBootstrap(defaultClient Client) *QueueBootstrap {
+	b := &QueueBootstrap{
+		clients:  map[string]Client{},
+		handlers: map[string]Handler{},
+	}
+	if defaultClient != nil {
+		b.clients[""] = defaultClient
+	}
+	return b
+}
+
+// AddClient makes client available to the queues with Provider set to provider
+func (b *QueueBootstrap) AddClient(provider string, client Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clients[strings.ToLower(provider)] = client
+}
+
+// RegisterHandler binds handler to the queues named name or with Handler set to name
+func (b *QueueBootstrap) RegisterHandler(name string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[strings.ToLower(name)] = handler
+}
+
+// SetArchiver archives the messages of the queues with Archive set, Run runs it
+func (b *QueueBootstrap) SetArchiver(archiver *Archiver) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.archiver = archiver
+}
+
+// RegisterQueues makes the queues known to schemas.GetSQSActions for env,
+// one of LOCAL, DEV, UAT or PROD
+func RegisterQueues(env string, queues map[string]QueueConfig) {
+	for key, queue := range queues {
+		schemas.RegisterSQSActions(env, key, schemas.SQSActionsData{
+			Queue: queue.Name,
+			Delay: queue.DelayInSeconds,
+		})
+	}
+}
+
+// Consumers builds the consumers of queues, keyed like queues. The queues with Consume
+// set to false are skipped, a queue without a client or a handler is an error
+func (b *QueueBootstrap) Consumers(queues map[string]QueueConfig) (map[string]ServiceConsumer, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	consumers := map[string]ServiceConsumer{}
+	for _, key := range sortedQueueKeys(queues) {
+		queue := queues[key]
+		if queue.Consume != nil && !*queue.Consume {
+			continue
+		}
+		if queue.Name == "" {
+			return nil, fmt.Errorf("queue: %s has no name", key)
+		}
+
+		client, ok := b.clients[strings.ToLower(queue.Provider)]
+		if !ok {
+			return nil, fmt.Errorf("no client added for provider: %q of queue: %s", queue.Provider, key)
+		}
+		handlerName := firstNonEmpty(queue.Handler, key)
+		handler, ok := b.handlers[strings.ToLower(handlerName)]
+		if !ok {
+			return nil, fmt.Errorf("no handler registered with name: %s for queue: %s", handlerName, key)
+		}
+		retryPolicy := queue.RetryPolicy()
+		if retryPolicy != nil && retryPolicy.MaxAttempts < 1 {
+			return nil, fmt.Errorf("queue: %s has a dead letter queue but no retry max attempts", key)
+		}
+		var archiver *Archiver
+		if queue.Archive {
+			if b.archiver == nil {
+				return nil, fmt.Errorf("queue: %s is archived but no archiver is set", key)
+			}
+			archiver = b.archiver
+		}
+
+		consumer, err := NewServiceConsumer(&ConsumerOption{
+			TransporterClient: client,
+			QueueName:         queue.Name,
+			WorkerPool:        queue.WorkerPool,
+			NumberOfMessage:   queue.NumberRetrieveMessage,
+			WaitTimeSecond:    queue.WaitTimeSecond,
+			VisibilityTimeout: queue.VisibilityTimeout,
+			HeartbeatInterval: time.Duration(queue.HeartbeatSecond) * time.Second,
+			RetryPolicy:       retryPolicy,
+			Archiver:          archiver,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create consumer of queue: %s, %w", key, err)
+		}
+		consumer.Handle(handler)
+		consumers[key] = consumer
+	}
+	return consumers, nil
+}
+
+// Run builds the consumers of queues and runs them until ctx is cancelled or the process
+// is stopped, see ServiceConsumer.Run. It returns once all of them returned, after the
+// archiver wrote the messages they archived
+func (b *QueueBootstrap) Run(ctx context.Context, queues map[string]QueueConfig) error {
+	consumers, err := b.Consumers(queues)
+	if err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	archiver := b.archiver
+	b.mu.RUnlock()
+	if archiver != nil {
+		archiverCtx, stopArchiver := context.WithCancel(detachedContext{parent: ctx})
+		archiverDone := make(chan struct{})
+		go func() {
+			defer close(archiverDone)
+			archiver.Run(archiverCtx)
+		}()
+		defer func() {
+			stopArchiver()
+			<-archiverDone
+		}()
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for key, consumer := range consumers {
+		wg.Add(1)
+		go func(key string, consumer ServiceConsumer) {
+			defer wg.Done()
+			logger.Infof("starting consumer of queue: %s", key)
+			if err := consumer.Run(ctx); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("consumer of queue: %s, %w", key, err))
+				mu.Unlock()
+			}
+		}(key, consumer)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func sortedQueueKeys(queues map[string]QueueConfig) []string {
+	keys := make([]string, 0, len(queues))
+	for key := range queues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,162 @@
+package transporter
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rohanchauhan02/clean/common/schemas"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const bootstrapTestConfig = `
+SQS:
+  QUEUES:
+    FINANCE_UPDATE:
+      NAME: "local-finance-update"
+      NUMBER_RETRIEVE_MESSAGE: 5
+      WAIT_TIME_SECOND: 0
+      VISIBILITY_TIMEOUT: 30
+      WORKER_POOL: 2
+      DELAY_IN_SECONDS: 1
+      DEAD_LETTER_QUEUE: "local-finance-update-dlq"
+      RETRY:
+        MAX_ATTEMPTS: 3
+        BACKOFF: exponential
+        INITIAL_DELAY_SECOND: 10
+      ARCHIVE: true
+    ADD_PRODUCT:
+      NAME: "local-add-product"
+      PROVIDER: nats-jetstream
+      HANDLER: product
+    CLAIM_UPDATE:
+      NAME: "local-claim-update"
+      CONSUME: false
+`
+
+func loadBootstrapTestConfig(t *testing.T) map[string]QueueConfig {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	require.NoError(t, v.ReadConfig(bytes.NewBufferString(bootstrapTestConfig)))
+
+	var config struct {
+		SQS struct {
+			Queues map[string]QueueConfig `mapstructure:"QUEUES"`
+		} `mapstructure:"SQS"`
+	}
+	require.NoError(t, v.Unmarshal(&config))
+	return config.SQS.Queues
+}
+
+func TestQueueConfig(t *testing.T) {
+	queues := loadBootstrapTestConfig(t)
+
+	t.Run("test ok queues are read from yaml", func(t *testing.T) {
+		require.Len(t, queues, 3)
+		assert.Equal(t, "local-finance-update", queues["finance_update"].Name)
+		assert.Equal(t, 2, queues["finance_update"].WorkerPool)
+		assert.Equal(t, "product", queues["add_product"].Handler)
+		assert.False(t, *queues["claim_update"].Consume)
+	})
+
+	t.Run("test ok retry policy", func(t *testing.T) {
+		assert.Equal(t, &RetryPolicy{
+			MaxAttempts:     3,
+			Backoff:         BackoffExponential,
+			InitialDelay:    10 * time.Second,
+			DeadLetterQueue: "local-finance-update-dlq",
+		}, queues["finance_update"].RetryPolicy())
+		assert.Nil(t, queues["add_product"].RetryPolicy())
+	})
+
+	t.Run("test ok queues are registered for GetSQSActions", func(t *testing.T) {
+		t.Cleanup(schemas.ResetSQSActions)
+		RegisterQueues("local", queues)
+
+		result, err := schemas.GetSQSActions("LOCAL", "FINANCE_UPDATE")
+		assert.Nil(t, err)
+		assert.Equal(t, schemas.SQSActionsData{Queue: "local-finance-update", Delay: 1}, result)
+
+		result, err = schemas.GetSQSActions("LOCAL", "CLAIM_UPDATE")
+		assert.Nil(t, err)
+		assert.Equal(t, "local-claim-update", result.Queue)
+	})
+}
+
+func TestQueueBootstrap(t *testing.T) {
+	queues := loadBootstrapTestConfig(t)
+	client := NewMemory()
+	jetStream := NewMemory()
+
+	var finance, product int32
+	bootstrap := NewQueueBootstrap(client)
+	bootstrap.RegisterHandler("FINANCE_UPDATE", func(ctx context.Context, message *MessageReceiveResponse) error {
+		atomic.AddInt32(&finance, 1)
+		return nil
+	})
+
+	t.Run("test wrong provider without client", func(t *testing.T) {
+		_, err := bootstrap.Consumers(queues)
+		assert.EqualError(t, err, `no client added for provider: "nats-jetstream" of queue: add_product`)
+	})
+
+	bootstrap.AddClient("NATS-JETSTREAM", jetStream)
+
+	t.Run("test wrong queue without handler", func(t *testing.T) {
+		_, err := bootstrap.Consumers(queues)
+		assert.EqualError(t, err, "no handler registered with name: product for queue: add_product")
+	})
+
+	bootstrap.RegisterHandler("product", func(ctx context.Context, message *MessageReceiveResponse) error {
+		atomic.AddInt32(&product, 1)
+		return nil
+	})
+
+	t.Run("test wrong dead letter queue without max attempts", func(t *testing.T) {
+		_, err := bootstrap.Consumers(map[string]QueueConfig{
+			"finance_update": {Name: "local-finance-update", DeadLetterQueue: "local-finance-update-dlq"},
+		})
+		assert.EqualError(t, err, "queue: finance_update has a dead letter queue but no retry max attempts")
+	})
+
+	t.Run("test wrong archived queue without archiver", func(t *testing.T) {
+		_, err := bootstrap.Consumers(queues)
+		assert.EqualError(t, err, "queue: finance_update is archived but no archiver is set")
+	})
+
+	store := &fakeArchiveStore{objects: map[string][]byte{}}
+	archiver, err := NewArchiver(&ArchiveOption{Store: store})
+	require.NoError(t, err)
+	bootstrap.SetArchiver(archiver)
+
+	t.Run("test ok one consumer per consumed queue", func(t *testing.T) {
+		consumers, err := bootstrap.Consumers(queues)
+		require.NoError(t, err)
+		assert.Len(t, consumers, 2)
+		assert.Equal(t, 2, consumers["finance_update"].GetWorkerPool())
+		assert.Equal(t, "local-finance-update-dlq", consumers["finance_update"].(*consumer).retryPolicy.DeadLetterQueue)
+		assert.Same(t, archiver, consumers["finance_update"].(*consumer).archiver)
+		assert.Nil(t, consumers["add_product"].(*consumer).archiver)
+	})
+
+	t.Run("test ok run binds the queues to their handlers", func(t *testing.T) {
+		require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "local-finance-update", MessageBody: "UPDATE_STOPLOSS"}))
+		require.NoError(t, jetStream.Publish(&MessagePublishOptions{QueueName: "local-add-product", MessageBody: "ADD_PRODUCT"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- bootstrap.Run(ctx, queues) }()
+
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt32(&finance) == 1 && atomic.LoadInt32(&product) == 1
+		}, 2*time.Second, 10*time.Millisecond)
+		cancel()
+		assert.Nil(t, <-done)
+		assert.Equal(t, 0, client.Depth("local-finance-update")+client.InFlight("local-finance-update"))
+		assert.Len(t, store.objects, 1, "archived messages are written before Run returns")
+	})
+}
@@ -0,0 +1,170 @@
+package transporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultClaimCheckThreshold keeps bodies under the 64 KB MNS limit with room for the
+	// attributes, SQS queues can raise it up to 256 KB
+	DefaultClaimCheckThreshold = 60 * 1024
+	DefaultClaimCheckPrefix    = "claim-check"
+
+	claimCheckEnvelopeMarker = "claim_check"
+	claimCheckEnvelopePrefix = `{"transporter_envelope":"claim_check"`
+)
+
+// ErrClaimCheckNotFound is wrapped by the errors of ClaimCheckStore.Get when nothing is
+// stored under the key, the message then goes to the dead-letter queue right away
+var ErrClaimCheckNotFound = errors.New("claim check not found")
+
+// ClaimCheckStore keeps the bodies too large to be sent through the queue
+type ClaimCheckStore interface {
+	// Put stores body under key and returns the key it can be read with
+	Put(ctx context.Context, key string, body []byte) (string, error)
+	// Get returns the body stored under key, the error wraps ErrClaimCheckNotFound
+	// when there is none
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// ClaimCheckDeleter is implemented by the stores that can remove a body once its message
+// was processed
+type ClaimCheckDeleter interface {
+	Delete(ctx context.Context, key string) error
+}
+
+// ClaimCheckOption offloads the bodies larger than Threshold bytes to Store and sends
+// a reference instead, the consumer restores the body before calling the handler.
+//   - Prefix - the keys are Prefix/<queue>/<yyyy/mm/dd>/<id>.bin, a lifecycle rule on
+//     the prefix should expire the objects the consumers do not delete
+//   - DeleteAfterAck - deletes the object once the message is acked, when Store
+//     implements ClaimCheckDeleter. Messages moved to a dead-letter queue keep theirs
+type ClaimCheckOption struct {
+	Store          ClaimCheckStore
+	Threshold      int
+	Prefix         string
+	DeleteAfterAck bool
+}
+
+type claimCheckEnvelope struct {
+	Envelope string `json:"transporter_envelope"`
+	Key      string `json:"key"`
+	Size     int    `json:"size"`
+}
+
+func (o *ClaimCheckOption) threshold() int {
+	if o.Threshold > 0 {
+		return o.Threshold
+	}
+	return DefaultClaimCheckThreshold
+}
+
+// offload stores body when it is larger than the threshold and returns the reference
+// to publish instead, other bodies are returned as is
+func (o *ClaimCheckOption) offload(ctx context.Context, queueName string, body interface{}) (interface{}, error) {
+	var data []byte
+	switch v := body.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	case *string:
+		if v == nil {
+			return body, nil
+		}
+		data = []byte(*v)
+	default:
+		return body, nil
+	}
+	if len(data) <= o.threshold() {
+		return body, nil
+	}
+
+	id, err := newScheduledMessageID()
+	if err != nil {
+		return nil, err
+	}
+	key := path.Join(firstNonEmpty(o.Prefix, DefaultClaimCheckPrefix), queueName, time.Now().UTC().Format("2006/01/02"), id+".bin")
+	key, err = o.Store.Put(ctx, key, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store the body of %d bytes for queue: %s, %w", len(data), queueName, err)
+	}
+
+	envelope, err := json.Marshal(claimCheckEnvelope{
+		Envelope: claimCheckEnvelopeMarker,
+		Key:      key,
+		Size:     len(data),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return string(envelope), nil
+}
+
+func parseClaimCheckEnvelope(body interface{}) (*claimCheckEnvelope, bool) {
+	text, ok := body.(string)
+	if !ok || !strings.HasPrefix(text, claimCheckEnvelopePrefix) {
+		return nil, false
+	}
+	var envelope claimCheckEnvelope
+	if err := json.Unmarshal([]byte(text), &envelope); err != nil || envelope.Envelope != claimCheckEnvelopeMarker || envelope.Key == "" {
+		return nil, false
+	}
+	return &envelope, true
+}
+
+// restoreClaimCheck replaces the reference of an offloaded message by its body, the
+// error is returned when the body cannot be read. It is marked Permanent when the body
+// is not coming back, that is without store or when the store has nothing under the key
+func (c *consumer) restoreClaimCheck(ctx context.Context, message *MessageReceiveResponse) error {
+	envelope, ok := parseClaimCheckEnvelope(message.MessageBody)
+	if !ok {
+		return nil
+	}
+	if c.claimCheck == nil || c.claimCheck.Store == nil {
+		return Permanent(fmt.Errorf("message has a claim check: %s but the consumer has no claim check store", envelope.Key))
+	}
+
+	data, err := c.claimCheck.Store.Get(ctx, envelope.Key)
+	if err != nil {
+		err = fmt.Errorf("failed to read claim check: %s, %w", envelope.Key, err)
+		if errors.Is(err, ErrClaimCheckNotFound) {
+			return Permanent(err)
+		}
+		return err
+	}
+
+	message.claimCheck = message.MessageBody.(string)
+	message.MessageBody = string(data)
+	return nil
+}
+
+// deleteClaimCheck removes the body of an acked offloaded message
+func (c *consumer) deleteClaimCheck(ctx context.Context, message *MessageReceiveResponse) {
+	if message.claimCheck == "" || c.claimCheck == nil || !c.claimCheck.DeleteAfterAck {
+		return
+	}
+	deleter, ok := c.claimCheck.Store.(ClaimCheckDeleter)
+	if !ok {
+		return
+	}
+	envelope, _ := parseClaimCheckEnvelope(message.claimCheck)
+	if err := deleter.Delete(ctx, envelope.Key); err != nil {
+		logger.Errorf("failed to delete claim check: %s of message with ID: %s on queue: %s, %s", envelope.Key, message.MessageID, c.queueName, err)
+	}
+}
+
+// publishedBody returns the body the message was published with, which is the reference
+// of an offloaded message so it is not sent through the queue again
+func (m *MessageReceiveResponse) publishedBody() ([]byte, error) {
+	if m.claimCheck != "" {
+		return []byte(m.claimCheck), nil
+	}
+	return messageBodyBytes(m.MessageBody)
+}
@@ -0,0 +1,156 @@
+package transporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClaimCheckStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	err     error
+}
+
+func (s *fakeClaimCheckStore) Put(ctx context.Context, key string, body []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = body
+	return key, nil
+}
+
+func (s *fakeClaimCheckStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	body, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrClaimCheckNotFound, key)
+	}
+	return body, nil
+}
+
+func (s *fakeClaimCheckStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func TestClaimCheck(t *testing.T) {
+	client, now := newMemoryTestClient(t)
+	store := &fakeClaimCheckStore{objects: map[string][]byte{}}
+	claimCheck := &ClaimCheckOption{Store: store, Threshold: 64, DeleteAfterAck: true}
+	largeBody := `{"insurer_partner_response":"` + strings.Repeat("x", 128) + `"}`
+
+	producer, err := NewServiceProducer(&ProducerOption{
+		TransporterClient: client,
+		QueueName:         "callback",
+		ClaimCheck:        claimCheck,
+	})
+	require.NoError(t, err)
+
+	var handled []string
+	failures := 1
+	c := newRetryTestConsumer(t, client, &RetryPolicy{MaxAttempts: 3, InitialDelay: time.Second, DeadLetterQueue: "callback-dlq"}, func(ctx context.Context, message *MessageReceiveResponse) error {
+		handled = append(handled, message.MessageBody.(string))
+		if failures > 0 && message.MessageBody == largeBody {
+			failures--
+			return errors.New("insurer partner is down")
+		}
+		return nil
+	})
+	c.queueName = "callback"
+	c.claimCheck = claimCheck
+
+	consume := func() *MessageReceiveResponse {
+		msg, err := client.Consume(&MessageConsumeOptions{QueueName: "callback", VisibilityTimeout: 30})
+		require.NoError(t, err)
+		return msg
+	}
+
+	t.Run("test ok small body is sent inline", func(t *testing.T) {
+		require.NoError(t, producer.Produce("UPDATE_STATUS"))
+		msg := consume()
+		require.NotNil(t, msg)
+		assert.Equal(t, "UPDATE_STATUS", msg.MessageBody)
+		c.process(context.Background(), msg)
+		assert.Empty(t, store.objects)
+	})
+
+	t.Run("test ok large body is offloaded and restored", func(t *testing.T) {
+		require.NoError(t, producer.Produce(largeBody))
+		require.Len(t, store.objects, 1)
+
+		msg := consume()
+		require.NotNil(t, msg)
+		assert.True(t, strings.HasPrefix(msg.MessageBody.(string), claimCheckEnvelopePrefix))
+		assert.Less(t, len(msg.MessageBody.(string)), len(largeBody))
+		for key := range store.objects {
+			assert.True(t, strings.HasPrefix(key, "claim-check/callback/"), key)
+		}
+
+		c.process(context.Background(), msg)
+		assert.Equal(t, largeBody, handled[len(handled)-1])
+	})
+
+	t.Run("test ok retried message keeps the reference and is deleted after ack", func(t *testing.T) {
+		*now = now.Add(2 * time.Second)
+		msg := consume()
+		require.NotNil(t, msg)
+		assert.Contains(t, msg.MessageBody.(string), claimCheckEnvelopeMarker)
+		assert.NotContains(t, msg.MessageBody.(string), strings.Repeat("x", 128))
+		require.Len(t, store.objects, 1)
+
+		c.process(context.Background(), msg)
+		assert.Equal(t, largeBody, handled[len(handled)-1])
+		assert.Empty(t, store.objects)
+		assert.Equal(t, 0, client.Depth("callback")+client.InFlight("callback"))
+	})
+
+	t.Run("test wrong store failure retries the message", func(t *testing.T) {
+		require.NoError(t, producer.Produce(largeBody))
+		store.err = errors.New("SlowDown: please reduce your request rate")
+		calls := len(handled)
+
+		msg := consume()
+		require.NotNil(t, msg)
+		c.process(context.Background(), msg)
+		assert.Equal(t, calls, len(handled))
+
+		store.err = nil
+		*now = now.Add(2 * time.Second)
+		msg = consume()
+		require.NotNil(t, msg)
+		c.process(context.Background(), msg)
+		assert.Equal(t, largeBody, handled[len(handled)-1])
+		assert.Equal(t, 0, client.Depth("callback")+client.InFlight("callback"))
+	})
+
+	t.Run("test wrong missing object moves the message to the dead letter queue", func(t *testing.T) {
+		require.NoError(t, producer.Produce(largeBody))
+		store.objects = map[string][]byte{}
+		calls := len(handled)
+
+		msg := consume()
+		require.NotNil(t, msg)
+		c.process(context.Background(), msg)
+		assert.Equal(t, calls, len(handled))
+		assert.Equal(t, 0, client.Depth("callback")+client.InFlight("callback"))
+
+		deadLetters, err := NewDeadLetterQueue(client, "callback-dlq").List(10, 60)
+		require.NoError(t, err)
+		require.Len(t, deadLetters, 1)
+		assert.True(t, strings.HasPrefix(deadLetters[0].Body, claimCheckEnvelopePrefix))
+		assert.Contains(t, deadLetters[0].FinalError, "failed to read claim check")
+	})
+}
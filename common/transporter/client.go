@@ -6,6 +6,7 @@ import (
 
 	ali_mns "github.com/aliyun/aliyun-mns-go-sdk"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/go-redis/redis"
 	"github.com/nats-io/nats.go"
 )
 
@@ -21,6 +22,12 @@ type ClientOptions struct {
 	AccessKeySecret string `json:"accessKeySecret"`
 	Region          string `json:"region"`
 	QueueName       string `json:"queueName"`
+	Password        string `json:"password"`
+	ConsumerName    string `json:"consumerName"`
+	StreamMaxLength int64  `json:"streamMaxLength"`
+	// PropagateAttributes wraps the MNS message body with its attributes, the MNS
+	// SDK cannot send message properties. Other providers always send them
+	PropagateAttributes bool `json:"propagateAttributes"`
 }
 
 type HealthCheckOptions struct {
@@ -30,15 +37,20 @@ type HealthCheckOptions struct {
 //Client is interface for vendor provider wrapper to implement
 //publish and consume methods from the cloud provider.
 //Options are shared across vendors so some of the Publish/Consume options
-//may be unused across different implementors
+//may be unused across different implementors.
+//BatchPublish and BatchDeleteMessage return a BatchResult listing the entries
+//that succeeded and failed, the error is only set when the whole call failed.
+//ChangeVisibility hides a received message for visibilityTimeout seconds from now,
+//zero makes it visible right away
 type Client interface {
 	HealthCheck(options *HealthCheckOptions) (bool, error)
 	Publish(options *MessagePublishOptions) error
-	BatchPublish(options *MessagePublishOptions) error
+	BatchPublish(options *MessagePublishOptions) (*BatchResult, error)
 	Consume(options *MessageConsumeOptions) (*MessageReceiveResponse, error)
 	BatchConsume(options *MessageConsumeOptions) ([]MessageReceiveResponse, error)
 	DeleteMessage(queueName string, message *MessageReceiveResponse) error
-	BatchDeleteMessage(queueName string, messages []MessageReceiveResponse) error
+	BatchDeleteMessage(queueName string, messages []MessageReceiveResponse) (*BatchResult, error)
+	ChangeVisibility(queueName string, message *MessageReceiveResponse, visibilityTimeout int64) error
 }
 
 //NewClient initializes a new client depending on the provided/vendor type
@@ -56,7 +68,8 @@ func NewClient(options *ClientOptions) (Client, error) {
 				options.AccessKeySecret)
 
 			mns := &MNS{
-				Client: client,
+				Client:              client,
+				PropagateAttributes: options.PropagateAttributes,
 			}
 			return mns, nil
 		}
@@ -76,15 +89,38 @@ func NewClient(options *ClientOptions) (Client, error) {
 			logger.Error("Error in initializing NATS client")
 			return nil, err
 		}
+	case "nats-jetstream":
+		{
+			connString := fmt.Sprintf("%s:%s", options.Host, options.Port)
+			client, err := nats.Connect(connString)
+			if err != nil {
+				logger.Error("Error in initializing NATS JetStream client")
+				return nil, err
+			}
+			return NewJetStream(client)
+		}
+	case "memory":
+		{
+			return NewMemory(), nil
+		}
+	case "redis":
+		{
+			client := redis.NewClient(&redis.Options{
+				Addr:     fmt.Sprintf("%s:%s", options.Host, options.Port),
+				Password: options.Password,
+			})
+			redisStreams := NewRedisStreams(client, RedisStreamsOptions{
+				ConsumerName: options.ConsumerName,
+				MaxLength:    options.StreamMaxLength,
+			})
+			return redisStreams, nil
+		}
 	case "aws":
 		{
 			awsSess, err := GetAWSSession(options.AccessKeyID, options.AccessKeySecret, options.Region)
 			if err == nil {
 				client := sqs.New(awsSess)
-				sqsClient := &SQS{
-					SQSClient: client,
-				}
-				return sqsClient, nil
+				return NewSQS(client), nil
 			}
 
 			return nil, err
@@ -0,0 +1,332 @@
+package transporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+const (
+	// AttributeContentType names the codec the body was encoded with
+	AttributeContentType = "Content-Type"
+	// AttributeContentEncoding is set to gzip when the encoded body was compressed
+	AttributeContentEncoding = "Content-Encoding"
+	// AttributeContentTransferEncoding is set to base64 when the body is binary,
+	// SQS and MNS only accept text bodies
+	AttributeContentTransferEncoding = "Content-Transfer-Encoding"
+
+	ContentTypeJSON        = "application/json"
+	ContentTypeMessagePack = "application/msgpack"
+	ContentTypeRaw         = "application/octet-stream"
+
+	contentEncodingGzip           = "gzip"
+	contentTransferEncodingBase64 = "base64"
+)
+
+// Codec encodes the data given to a producer into a message body and decodes it back
+// on the consumer side. ContentType is sent as the Content-Type attribute so the
+// consumer picks the same codec, custom codecs are made known with RegisterCodec
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		ContentTypeJSON:        JSONCodec{},
+		ContentTypeMessagePack: MessagePackCodec{},
+		ContentTypeRaw:         RawCodec{},
+	}
+)
+
+// RegisterCodec makes codec available to the consumers under its content type
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.ContentType()] = codec
+}
+
+// CodecFor returns the codec registered for contentType
+func CodecFor(contentType string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("no codec registered for content type: %s", contentType)
+	}
+	return codec, nil
+}
+
+// JSONCodec encodes the data with encoding/json
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string { return ContentTypeJSON }
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// MessagePackCodec encodes the data with MessagePack. Types generated by msgp are
+// encoded directly, other types go through their JSON representation so the json
+// struct tags are honoured
+type MessagePackCodec struct{}
+
+func (MessagePackCodec) ContentType() string { return ContentTypeMessagePack }
+
+func (MessagePackCodec) Marshal(v interface{}) ([]byte, error) {
+	if marshaler, ok := v.(msgp.Marshaler); ok {
+		return marshaler.MarshalMsg(nil)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return msgp.AppendIntf(nil, messagePackValue(generic))
+}
+
+func (MessagePackCodec) Unmarshal(data []byte, v interface{}) error {
+	if unmarshaler, ok := v.(msgp.Unmarshaler); ok {
+		_, err := unmarshaler.UnmarshalMsg(data)
+		return err
+	}
+
+	generic, _, err := msgp.ReadIntfBytes(data)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, v)
+}
+
+// messagePackValue replaces the json.Number of a decoded JSON value by an
+// int64 or a float64 which msgp can encode
+func messagePackValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = messagePackValue(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = messagePackValue(item)
+		}
+	}
+	return v
+}
+
+// RawCodec sends string and []byte data as is
+type RawCodec struct{}
+
+func (RawCodec) ContentType() string { return ContentTypeRaw }
+
+func (RawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch value := v.(type) {
+	case []byte:
+		return value, nil
+	case string:
+		return []byte(value), nil
+	case *string:
+		if value != nil {
+			return []byte(*value), nil
+		}
+	}
+	return nil, fmt.Errorf("raw codec cannot encode data of type %T, only string and []byte", v)
+}
+
+func (RawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch value := v.(type) {
+	case *[]byte:
+		*value = append([]byte(nil), data...)
+		return nil
+	case *string:
+		*value = string(data)
+		return nil
+	}
+	return fmt.Errorf("raw codec cannot decode into %T, only *string and *[]byte", v)
+}
+
+// GzipCodec compresses the body encoded by Codec, the content type stays the one
+// of Codec and the Content-Encoding attribute is set to gzip
+type GzipCodec struct {
+	Codec Codec
+}
+
+func (c GzipCodec) ContentType() string { return c.Codec.ContentType() }
+
+func (c GzipCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := c.Codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c GzipCodec) Unmarshal(data []byte, v interface{}) error {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return c.Codec.Unmarshal(decompressed, v)
+}
+
+// EncodeBody encodes v with codec into a text body and the attributes a consumer
+// needs to decode it. Binary bodies are base64 encoded
+func EncodeBody(codec Codec, v interface{}) (string, map[string]string, error) {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return "", nil, err
+	}
+
+	attributes := map[string]string{AttributeContentType: codec.ContentType()}
+	if isGzipCodec(codec) {
+		attributes[AttributeContentEncoding] = contentEncodingGzip
+	}
+	if !isTextBody(data) {
+		attributes[AttributeContentTransferEncoding] = contentTransferEncodingBase64
+		return base64.StdEncoding.EncodeToString(data), attributes, nil
+	}
+	return string(data), attributes, nil
+}
+
+// isGzipCodec reports whether codec is a GzipCodec, given by value or by pointer
+func isGzipCodec(codec Codec) bool {
+	switch codec.(type) {
+	case GzipCodec, *GzipCodec:
+		return true
+	}
+	return false
+}
+
+// codecNeedsAttributes reports whether the consumers need the attributes set by
+// EncodeBody to decode the body, only uncompressed JSON is decoded without them
+func codecNeedsAttributes(codec Codec) bool {
+	return isGzipCodec(codec) || codec.ContentType() != ContentTypeJSON
+}
+
+// DecodeMessage decodes the body of message into v with the codec named by its
+// Content-Type attribute. Messages without it are decoded as JSON, or as is when
+// v is a *string or *[]byte
+func DecodeMessage(message *MessageReceiveResponse, v interface{}) error {
+	if message == nil {
+		return fmt.Errorf("message is nil")
+	}
+
+	data, err := messageBodyBytes(message.MessageBody)
+	if err != nil {
+		return err
+	}
+	if message.Attributes[AttributeContentTransferEncoding] == contentTransferEncodingBase64 {
+		if data, err = base64.StdEncoding.DecodeString(string(data)); err != nil {
+			return fmt.Errorf("failed to decode base64 body of message with ID: %s, %w", message.MessageID, err)
+		}
+	}
+
+	var codec Codec
+	switch contentType := message.Attributes[AttributeContentType]; {
+	case contentType != "":
+		if codec, err = CodecFor(contentType); err != nil {
+			return err
+		}
+	case isRawTarget(v):
+		codec = RawCodec{}
+	default:
+		codec = JSONCodec{}
+	}
+	if strings.EqualFold(message.Attributes[AttributeContentEncoding], contentEncodingGzip) {
+		codec = GzipCodec{Codec: codec}
+	}
+
+	if err := codec.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode body of message with ID: %s as %s, %w", message.MessageID, codec.ContentType(), err)
+	}
+	return nil
+}
+
+// Decode decodes the body of message into a T, see DecodeMessage
+func Decode[T any](message *MessageReceiveResponse) (T, error) {
+	var v T
+	err := DecodeMessage(message, &v)
+	return v, err
+}
+
+// Produce encodes data with the codec of the producer, JSON when it has none,
+// and publishes it with the trace and request ID of ctx
+func Produce[T any](ctx context.Context, serviceProducer ServiceProducer, data T) error {
+	return serviceProducer.ProduceEncoded(ctx, data)
+}
+
+// TypedHandler returns a Handler decoding every message into a T before calling handler.
+// A message that cannot be decoded is treated as a failed one
+func TypedHandler[T any](handler func(ctx context.Context, data T, message *MessageReceiveResponse) error) Handler {
+	return func(ctx context.Context, message *MessageReceiveResponse) error {
+		data, err := Decode[T](message)
+		if err != nil {
+			return err
+		}
+		return handler(ctx, data, message)
+	}
+}
+
+func isRawTarget(v interface{}) bool {
+	switch v.(type) {
+	case *string, *[]byte:
+		return true
+	}
+	return false
+}
+
+// isTextBody reports whether data can be sent as a SQS or MNS body as is,
+// which rejects invalid UTF-8 and most control characters
+func isTextBody(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+package transporter
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stoplossEvent struct {
+	PolicyNumber string  `json:"policy_number"`
+	Amount       int64   `json:"amount"`
+	Ratio        float64 `json:"ratio"`
+	Tags         []string
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	event := stoplossEvent{PolicyNumber: "QOALA-1", Amount: 9007199254740993, Ratio: 0.25, Tags: []string{"a", "b"}}
+
+	for _, codec := range []Codec{
+		JSONCodec{},
+		MessagePackCodec{},
+		GzipCodec{Codec: JSONCodec{}},
+		GzipCodec{Codec: MessagePackCodec{}},
+		&GzipCodec{Codec: JSONCodec{}},
+	} {
+		codec := codec
+		t.Run("test ok round trip "+codec.ContentType(), func(t *testing.T) {
+			client := NewMemory()
+			producer, err := NewServiceProducer(&ProducerOption{TransporterClient: client, QueueName: "stoploss", Codec: codec})
+			require.NoError(t, err)
+			require.NoError(t, Produce(context.Background(), producer, event))
+
+			msg, err := client.Consume(&MessageConsumeOptions{QueueName: "stoploss"})
+			require.NoError(t, err)
+			require.NotNil(t, msg)
+			assert.IsType(t, "", msg.MessageBody)
+			assert.Equal(t, codec.ContentType(), msg.Attributes[AttributeContentType])
+			assert.Equal(t, isGzipCodec(codec), msg.Attributes[AttributeContentEncoding] == "gzip")
+
+			decoded, err := Decode[stoplossEvent](msg)
+			assert.Nil(t, err)
+			assert.Equal(t, event, decoded)
+		})
+	}
+}
+
+func TestProduceToMNSWithoutAttributes(t *testing.T) {
+	for _, codec := range []Codec{MessagePackCodec{}, GzipCodec{Codec: JSONCodec{}}} {
+		t.Run("test wrong "+codec.ContentType()+" is rejected", func(t *testing.T) {
+			producer, err := NewServiceProducer(&ProducerOption{TransporterClient: &MNS{}, QueueName: "stoploss", Codec: codec})
+			require.NoError(t, err)
+			assert.Error(t, Produce(context.Background(), producer, stoplossEvent{PolicyNumber: "QOALA-1"}))
+		})
+	}
+}
+
+// countingProducer wraps a ServiceProducer like the decorators of the services do
+type countingProducer struct {
+	ServiceProducer
+	calls int
+}
+
+func (p *countingProducer) ProduceEncoded(ctx context.Context, data interface{}) error {
+	p.calls++
+	return p.ServiceProducer.ProduceEncoded(ctx, data)
+}
+
+func TestProduceThroughWrappedProducer(t *testing.T) {
+	client := NewMemory()
+	producer, err := NewServiceProducer(&ProducerOption{TransporterClient: client, QueueName: "stoploss"})
+	require.NoError(t, err)
+	wrapped := &countingProducer{ServiceProducer: producer}
+
+	require.NoError(t, Produce(context.Background(), wrapped, stoplossEvent{PolicyNumber: "QOALA-1"}))
+	assert.Equal(t, 1, wrapped.calls)
+
+	msg, err := client.Consume(&MessageConsumeOptions{QueueName: "stoploss"})
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	assert.Equal(t, ContentTypeJSON, msg.Attributes[AttributeContentType])
+	decoded, err := Decode[stoplossEvent](msg)
+	assert.Nil(t, err)
+	assert.Equal(t, "QOALA-1", decoded.PolicyNumber)
+}
+
+func TestEncodeBody(t *testing.T) {
+	t.Run("test ok binary body is base64 encoded", func(t *testing.T) {
+		body, attributes, err := EncodeBody(GzipCodec{Codec: RawCodec{}}, "HALO")
+		assert.Nil(t, err)
+		assert.Equal(t, ContentTypeRaw, attributes[AttributeContentType])
+		assert.Equal(t, "gzip", attributes[AttributeContentEncoding])
+		assert.Equal(t, "base64", attributes[AttributeContentTransferEncoding])
+
+		decoded, err := Decode[string](&MessageReceiveResponse{MessageBody: body, Attributes: attributes})
+		assert.Nil(t, err)
+		assert.Equal(t, "HALO", decoded)
+	})
+
+	t.Run("test ok text body is sent as is", func(t *testing.T) {
+		body, attributes, err := EncodeBody(JSONCodec{}, map[string]int{"id": 1})
+		assert.Nil(t, err)
+		assert.Equal(t, `{"id":1}`, body)
+		assert.Empty(t, attributes[AttributeContentTransferEncoding])
+	})
+
+	t.Run("test raw codec rejects other types", func(t *testing.T) {
+		_, _, err := EncodeBody(RawCodec{}, 1)
+		assert.Error(t, err)
+	})
+}
+
+func TestDecodeMessage(t *testing.T) {
+	t.Run("test ok message without content type is decoded as JSON", func(t *testing.T) {
+		decoded, err := Decode[map[string]int](&MessageReceiveResponse{MessageBody: []byte(`{"id":1}`)})
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]int{"id": 1}, decoded)
+	})
+
+	t.Run("test ok message without content type is decoded as is into a string", func(t *testing.T) {
+		decoded, err := Decode[string](&MessageReceiveResponse{MessageBody: "UPDATE_STOPLOSS_INCREMENT"})
+		assert.Nil(t, err)
+		assert.Equal(t, "UPDATE_STOPLOSS_INCREMENT", decoded)
+	})
+
+	t.Run("test unknown content type", func(t *testing.T) {
+		_, err := Decode[string](&MessageReceiveResponse{
+			MessageBody: "HALO",
+			Attributes:  map[string]string{AttributeContentType: "application/xml"},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid body", func(t *testing.T) {
+		_, err := Decode[stoplossEvent](&MessageReceiveResponse{MessageBody: "not json"})
+		assert.Error(t, err)
+		_, err = Decode[stoplossEvent](&MessageReceiveResponse{MessageBody: 1})
+		assert.Error(t, err)
+	})
+}
+
+func TestTypedHandler(t *testing.T) {
+	var received stoplossEvent
+	handler := TypedHandler(func(ctx context.Context, data stoplossEvent, message *MessageReceiveResponse) error {
+		received = data
+		return nil
+	})
+
+	t.Run("test ok handler receives decoded data", func(t *testing.T) {
+		err := handler(context.Background(), &MessageReceiveResponse{MessageBody: `{"policy_number":"QOALA-1"}`})
+		assert.Nil(t, err)
+		assert.Equal(t, "QOALA-1", received.PolicyNumber)
+	})
+
+	t.Run("test handler is not called when the body cannot be decoded", func(t *testing.T) {
+		err := handler(context.Background(), &MessageReceiveResponse{MessageBody: "not json"})
+		assert.True(t, err != nil && strings.Contains(err.Error(), "failed to decode"))
+	})
+}
+
+func TestPublishWrongBodyTypeReturnsError(t *testing.T) {
+	t.Run("test SQS publish with struct body", func(t *testing.T) {
+		err := SQS{SQSClient: &fakeSQS{}}.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: stoplossEvent{}})
+		assert.Error(t, err)
+	})
+
+	t.Run("test MNS publish with struct body", func(t *testing.T) {
+		err := (&MNS{}).Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: stoplossEvent{}})
+		assert.Error(t, err)
+	})
+}
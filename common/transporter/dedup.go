@@ -0,0 +1,146 @@
+package transporter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// DedupState is the state of a message key in a DedupStore
+type DedupState string
+
+const (
+	// DedupStateNew means the key was unknown or expired, the caller now owns it
+	// and should process the message
+	DedupStateNew DedupState = "new"
+	// DedupStateProcessing means another consumer is processing the message
+	DedupStateProcessing DedupState = "processing"
+	// DedupStateCompleted means the message was already processed
+	DedupStateCompleted DedupState = "completed"
+
+	// AttributeIdempotencyKey lets the producer choose the key a message is deduplicated
+	// with, so a message published twice by the producer is only processed once
+	AttributeIdempotencyKey = "Idempotency-Key"
+
+	DefaultDedupProcessingTTL = 5 * time.Minute
+	DefaultDedupCompletedTTL  = 24 * time.Hour
+)
+
+// DedupStore records which messages were processed. A key goes from processing to
+// completed once the handler succeeded. The processing state expires after its TTL
+// so a consumer that crashed mid-handler does not mark the message done
+type DedupStore interface {
+	// Begin moves key to processing by owner for ttl when it is unknown or expired and
+	// returns DedupStateNew, otherwise the current state is returned
+	Begin(ctx context.Context, key, owner string, ttl time.Duration) (DedupState, error)
+	// Complete moves key to completed for ttl
+	Complete(ctx context.Context, key string, ttl time.Duration) error
+	// Release forgets key when it is still processing by owner, so a redelivery is processed
+	// again. A key that expired and was taken by another consumer is left alone
+	Release(ctx context.Context, key, owner string) error
+}
+
+// DeduplicationOption makes the consumer skip the messages it already processed
+//   - Key - returns the key of a message, the Idempotency-Key attribute and then the
+//     MessageID are used when it is nil. Keys are prefixed with the queue name
+//   - ProcessingTTL - how long a message stays processing, it should be longer than the
+//     handler and at most the visibility timeout
+//   - CompletedTTL - how long a processed message is remembered
+type DeduplicationOption struct {
+	Store         DedupStore
+	Key           func(message *MessageReceiveResponse) string
+	ProcessingTTL time.Duration
+	CompletedTTL  time.Duration
+}
+
+func (o *DeduplicationOption) key(queueName string, message *MessageReceiveResponse) string {
+	var key string
+	if o.Key != nil {
+		key = o.Key(message)
+	} else {
+		key = firstNonEmpty(message.Attributes[AttributeIdempotencyKey], message.MessageID)
+	}
+	if key == "" {
+		return ""
+	}
+	return queueName + ":" + key
+}
+
+func (o *DeduplicationOption) processingTTL() time.Duration {
+	if o.ProcessingTTL > 0 {
+		return o.ProcessingTTL
+	}
+	return DefaultDedupProcessingTTL
+}
+
+func (o *DeduplicationOption) completedTTL() time.Duration {
+	if o.CompletedTTL > 0 {
+		return o.CompletedTTL
+	}
+	return DefaultDedupCompletedTTL
+}
+
+// dedupLease is a dedup key taken by the consumer for a single message
+type dedupLease struct {
+	key   string
+	owner string
+}
+
+// beginDedup returns the dedup lease of the message, or true when the message must not
+// be handled: it was already processed and is acked, it is being processed by another
+// consumer, or the store failed in which case it is redelivered
+func (c *consumer) beginDedup(ctx context.Context, message *MessageReceiveResponse) (dedupLease, bool) {
+	if c.deduplication == nil || c.deduplication.Store == nil {
+		return dedupLease{}, false
+	}
+	key := c.deduplication.key(c.queueName, message)
+	if key == "" {
+		return dedupLease{}, false
+	}
+	owner, err := newDedupOwner()
+	if err != nil {
+		logger.Errorf("failed to generate dedup owner of message with ID: %s on queue: %s, it will be redelivered: %s", message.MessageID, c.queueName, err)
+		return dedupLease{}, true
+	}
+
+	state, err := c.deduplication.Store.Begin(ctx, key, owner, c.deduplication.processingTTL())
+	switch {
+	case err != nil:
+		logger.Errorf("failed to check dedup key: %s of message with ID: %s on queue: %s, it will be redelivered: %s", key, message.MessageID, c.queueName, err)
+		return dedupLease{}, true
+	case state == DedupStateCompleted:
+		logger.Infof("skipped duplicate message with ID: %s on queue: %s, dedup key: %s", message.MessageID, c.queueName, key)
+		_ = c.Acknowledge(message)
+		return dedupLease{}, true
+	case state != DedupStateNew:
+		logger.Infof("message with ID: %s on queue: %s is processed by another consumer, dedup key: %s", message.MessageID, c.queueName, key)
+		return dedupLease{}, true
+	}
+	return dedupLease{key: key, owner: owner}, false
+}
+
+// endDedup completes or releases the dedup lease depending on the handler result
+func (c *consumer) endDedup(ctx context.Context, lease dedupLease, handlerErr error) {
+	if lease.key == "" {
+		return
+	}
+
+	var err error
+	if handlerErr != nil {
+		err = c.deduplication.Store.Release(ctx, lease.key, lease.owner)
+	} else {
+		err = c.deduplication.Store.Complete(ctx, lease.key, c.deduplication.completedTTL())
+	}
+	if err != nil {
+		logger.Errorf("failed to update dedup key: %s on queue: %s, %s", lease.key, c.queueName, err)
+	}
+}
+
+func newDedupOwner() (string, error) {
+	owner := make([]byte, 16)
+	if _, err := rand.Read(owner); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(owner), nil
+}
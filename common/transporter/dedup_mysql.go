@@ -0,0 +1,103 @@
+package transporter
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DedupRecord is a row of the MySQL dedup table
+//
+//	CREATE TABLE `transporter_dedup` (
+//	  `dedup_key` varchar(255) NOT NULL,
+//	  `state` varchar(16) NOT NULL,
+//	  `owner` varchar(64) NOT NULL DEFAULT '',
+//	  `expires_at` datetime(3) NOT NULL,
+//	  `updated_at` datetime(3) DEFAULT NULL,
+//	  PRIMARY KEY (`dedup_key`),
+//	  KEY `idx_transporter_dedup_expires_at` (`expires_at`)
+//	);
+type DedupRecord struct {
+	Key       string     `gorm:"column:dedup_key;type:varchar(255);primaryKey"`
+	State     DedupState `gorm:"column:state;type:varchar(16);not null"`
+	Owner     string     `gorm:"column:owner;type:varchar(64);not null;default:''"`
+	ExpiresAt time.Time  `gorm:"column:expires_at;not null;index"`
+	UpdatedAt time.Time  `gorm:"column:updated_at"`
+}
+
+func (DedupRecord) TableName() string {
+	return "transporter_dedup"
+}
+
+// MySQLDedupStore keeps the state of every key in the transporter_dedup table. Expired rows
+// are taken over by Begin and can be deleted with PurgeExpired
+type MySQLDedupStore struct {
+	DB  *gorm.DB
+	Now func() time.Time
+}
+
+// NewMySQLDedupStore returns a DedupStore backed by db
+func NewMySQLDedupStore(db *gorm.DB) *MySQLDedupStore {
+	return &MySQLDedupStore{
+		DB:  db,
+		Now: time.Now,
+	}
+}
+
+func (s *MySQLDedupStore) Begin(ctx context.Context, key, owner string, ttl time.Duration) (DedupState, error) {
+	db := s.DB.WithContext(ctx)
+	now := s.Now()
+
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&DedupRecord{
+		Key:       key,
+		State:     DedupStateProcessing,
+		Owner:     owner,
+		ExpiresAt: now.Add(ttl),
+	})
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 1 {
+		return DedupStateNew, nil
+	}
+
+	result = db.Model(&DedupRecord{}).
+		Where("dedup_key = ? AND expires_at <= ?", key, now).
+		Updates(map[string]interface{}{"state": DedupStateProcessing, "owner": owner, "expires_at": now.Add(ttl)})
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 1 {
+		return DedupStateNew, nil
+	}
+
+	var record DedupRecord
+	if err := db.Where("dedup_key = ?", key).Take(&record).Error; err != nil {
+		return "", err
+	}
+	return record.State, nil
+}
+
+func (s *MySQLDedupStore) Complete(ctx context.Context, key string, ttl time.Duration) error {
+	return s.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"state", "expires_at", "updated_at"}),
+	}).Create(&DedupRecord{
+		Key:       key,
+		State:     DedupStateCompleted,
+		ExpiresAt: s.Now().Add(ttl),
+	}).Error
+}
+
+func (s *MySQLDedupStore) Release(ctx context.Context, key, owner string) error {
+	return s.DB.WithContext(ctx).
+		Where("dedup_key = ? AND state = ? AND owner = ?", key, DedupStateProcessing, owner).
+		Delete(&DedupRecord{}).Error
+}
+
+// PurgeExpired deletes the expired keys and returns how many were deleted
+func (s *MySQLDedupStore) PurgeExpired(ctx context.Context) (int64, error) {
+	result := s.DB.WithContext(ctx).Where("expires_at <= ?", s.Now()).Delete(&DedupRecord{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,66 @@
+package transporter
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// releaseDedupScript deletes the key only while it is processing by the caller
+const releaseDedupScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`
+
+// RedisDedupStore keeps the state of every key in a Redis string expiring with its TTL,
+// processing keys are stored as "processing:<owner>"
+type RedisDedupStore struct {
+	Client redis.Cmdable
+	// Prefix is prepended to every key, it defaults to "transporter:dedup:"
+	Prefix string
+}
+
+// NewRedisDedupStore returns a DedupStore backed by client
+func NewRedisDedupStore(client redis.Cmdable) *RedisDedupStore {
+	return &RedisDedupStore{
+		Client: client,
+		Prefix: "transporter:dedup:",
+	}
+}
+
+func (s *RedisDedupStore) Begin(ctx context.Context, key, owner string, ttl time.Duration) (DedupState, error) {
+	// the key may expire between SETNX and GET, in which case it is taken again
+	for i := 0; i < 2; i++ {
+		acquired, err := s.Client.SetNX(s.Prefix+key, redisDedupProcessing(owner), ttl).Result()
+		if err != nil {
+			return "", err
+		}
+		if acquired {
+			return DedupStateNew, nil
+		}
+
+		state, err := s.Client.Get(s.Prefix + key).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(state, string(DedupStateProcessing)) {
+			return DedupStateProcessing, nil
+		}
+		return DedupState(state), nil
+	}
+	return DedupStateProcessing, nil
+}
+
+func (s *RedisDedupStore) Complete(ctx context.Context, key string, ttl time.Duration) error {
+	return s.Client.Set(s.Prefix+key, string(DedupStateCompleted), ttl).Err()
+}
+
+func (s *RedisDedupStore) Release(ctx context.Context, key, owner string) error {
+	return s.Client.Eval(releaseDedupScript, []string{s.Prefix + key}, redisDedupProcessing(owner)).Err()
+}
+
+func redisDedupProcessing(owner string) string {
+	return string(DedupStateProcessing) + ":" + owner
+}
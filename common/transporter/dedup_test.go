@@ -0,0 +1,192 @@
+package transporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormMysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+)
+
+func newRedisDedupTestStore(t *testing.T) (*RedisDedupStore, *miniredis.Miniredis) {
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	t.Cleanup(func() { _ = redisClient.Close() })
+	return NewRedisDedupStore(redisClient), redisServer
+}
+
+func TestRedisDedupStore(t *testing.T) {
+	store, redisServer := newRedisDedupTestStore(t)
+	ctx := context.Background()
+
+	t.Run("test ok unknown key is taken", func(t *testing.T) {
+		state, err := store.Begin(ctx, "finance:1", "consumer-1", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, DedupStateNew, state)
+	})
+
+	t.Run("test ok key is processing for the other consumers", func(t *testing.T) {
+		state, err := store.Begin(ctx, "finance:1", "consumer-2", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, DedupStateProcessing, state)
+	})
+
+	t.Run("test ok processing key expires after a crash", func(t *testing.T) {
+		redisServer.FastForward(2 * time.Minute)
+		state, err := store.Begin(ctx, "finance:1", "consumer-2", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, DedupStateNew, state)
+	})
+
+	t.Run("test ok key taken by another consumer is not released", func(t *testing.T) {
+		assert.Nil(t, store.Release(ctx, "finance:1", "consumer-1"))
+
+		state, err := store.Begin(ctx, "finance:1", "consumer-3", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, DedupStateProcessing, state)
+	})
+
+	t.Run("test ok completed key is not released", func(t *testing.T) {
+		assert.Nil(t, store.Complete(ctx, "finance:1", time.Hour))
+		assert.Nil(t, store.Release(ctx, "finance:1", "consumer-1"))
+
+		state, err := store.Begin(ctx, "finance:1", "consumer-1", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, DedupStateCompleted, state)
+		assert.Equal(t, time.Hour, redisServer.TTL("transporter:dedup:finance:1"))
+	})
+
+	t.Run("test ok released key is taken again", func(t *testing.T) {
+		_, _ = store.Begin(ctx, "finance:2", "consumer-1", time.Minute)
+		assert.Nil(t, store.Release(ctx, "finance:2", "consumer-1"))
+
+		state, err := store.Begin(ctx, "finance:2", "consumer-1", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, DedupStateNew, state)
+	})
+}
+
+func TestMySQLDedupStore(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer sqlDB.Close()
+	db, err := gorm.Open(gormMysql.New(gormMysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}),
+		&gorm.Config{Logger: gormLogger.Default.LogMode(gormLogger.Silent), SkipDefaultTransaction: true})
+	require.NoError(t, err)
+
+	now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	store := NewMySQLDedupStore(db)
+	store.Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	t.Run("test ok unknown key is inserted", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO `transporter_dedup` .* ON DUPLICATE KEY UPDATE").
+			WithArgs("finance:1", DedupStateProcessing, "consumer-1", now.Add(time.Minute), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		state, err := store.Begin(ctx, "finance:1", "consumer-1", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, DedupStateNew, state)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test ok expired key is taken over", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO `transporter_dedup`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE `transporter_dedup` SET .* WHERE dedup_key = \\? AND expires_at <= \\?").
+			WithArgs(now.Add(time.Minute), "consumer-1", DedupStateProcessing, sqlmock.AnyArg(), "finance:1", now).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		state, err := store.Begin(ctx, "finance:1", "consumer-1", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, DedupStateNew, state)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test ok completed key is returned", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO `transporter_dedup`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE `transporter_dedup`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT \\* FROM `transporter_dedup` WHERE dedup_key = \\? LIMIT 1").
+			WithArgs("finance:1").
+			WillReturnRows(sqlmock.NewRows([]string{"dedup_key", "state", "owner", "expires_at", "updated_at"}).
+				AddRow("finance:1", DedupStateCompleted, "", now.Add(time.Hour), now))
+
+		state, err := store.Begin(ctx, "finance:1", "consumer-1", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, DedupStateCompleted, state)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test ok complete and release", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO `transporter_dedup` .* ON DUPLICATE KEY UPDATE `state`=VALUES\\(`state`\\),`expires_at`=VALUES\\(`expires_at`\\)").
+			WithArgs("finance:1", DedupStateCompleted, "", now.Add(time.Hour), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec("DELETE FROM `transporter_dedup` WHERE dedup_key = \\? AND state = \\? AND owner = \\?").
+			WithArgs("finance:2", DedupStateProcessing, "consumer-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.Nil(t, store.Complete(ctx, "finance:1", time.Hour))
+		assert.Nil(t, store.Release(ctx, "finance:2", "consumer-1"))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestServiceConsumerSkipsDuplicates(t *testing.T) {
+	client := NewMemory()
+	store, _ := newRedisDedupTestStore(t)
+
+	for _, body := range []string{"increment-1", "increment-1-again", "fail"} {
+		key := "stoploss-1"
+		if body == "fail" {
+			key = "stoploss-2"
+		}
+		require.NoError(t, client.Publish(&MessagePublishOptions{
+			QueueName:   "finance",
+			MessageBody: body,
+			Attributes:  map[string]string{AttributeIdempotencyKey: key},
+		}))
+	}
+
+	serviceConsumer, err := NewServiceConsumer(&ConsumerOption{
+		TransporterClient: client,
+		QueueName:         "finance",
+		NumberOfMessage:   10,
+		VisibilityTimeout: 30,
+		Deduplication:     &DeduplicationOption{Store: store},
+	})
+	require.NoError(t, err)
+
+	var handled []string
+	serviceConsumer.Handle(func(ctx context.Context, message *MessageReceiveResponse) error {
+		handled = append(handled, message.MessageBody.(string))
+		if message.MessageBody == "fail" {
+			return errors.New("stoploss not found")
+		}
+		return nil
+	})
+	c := serviceConsumer.(*consumer)
+
+	msgs, err := client.BatchConsume(&MessageConsumeOptions{QueueName: "finance", NumberOfMessages: 10, VisibilityTimeout: 30})
+	require.NoError(t, err)
+	for i := range msgs {
+		c.process(context.Background(), &msgs[i])
+	}
+
+	t.Run("test ok duplicate is acked without calling the handler", func(t *testing.T) {
+		assert.Equal(t, []string{"increment-1", "fail"}, handled)
+		assert.Equal(t, []interface{}{"fail"}, client.Drain("finance"))
+	})
+
+	t.Run("test ok failed message is processed again on redelivery", func(t *testing.T) {
+		state, err := store.Begin(context.Background(), "finance:stoploss-2", "consumer-1", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, DedupStateNew, state)
+	})
+}
@@ -0,0 +1,35 @@
+package transporter
+
+import "time"
+
+// startHeartbeat extends the visibility of message to the visibility timeout of the
+// consumer every heartbeat interval, until the returned function is called. The function
+// waits for an extension in progress so the receipt handle is not changed after it returns
+func (c *consumer) startHeartbeat(message *MessageReceiveResponse) func() {
+	if c.heartbeatInterval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(c.heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := c.transporterClient.ChangeVisibility(c.queueName, message, int64(c.visibilityTimeout)); err != nil {
+					logger.Errorf("failed to extend visibility of message with ID: %s on queue: %s, %s", message.MessageID, c.queueName, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
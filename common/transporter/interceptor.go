@@ -0,0 +1,149 @@
+package transporter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ClientCall names the Client method an interceptor runs around
+type ClientCall string
+
+const (
+	CallPublish      ClientCall = "publish"
+	CallBatchPublish ClientCall = "batch_publish"
+	CallConsume      ClientCall = "consume"
+	CallBatchConsume ClientCall = "batch_consume"
+)
+
+// PublishInvoker sends options, the BatchResult is only set for CallBatchPublish
+type PublishInvoker func(options *MessagePublishOptions) (*BatchResult, error)
+
+// ConsumeInvoker receives the messages of options, Consume returns at most one
+type ConsumeInvoker func(options *MessageConsumeOptions) ([]MessageReceiveResponse, error)
+
+// PublishInterceptor runs around Publish and BatchPublish, it calls next to continue the chain
+type PublishInterceptor func(call ClientCall, options *MessagePublishOptions, next PublishInvoker) (*BatchResult, error)
+
+// ConsumeInterceptor runs around Consume and BatchConsume, it calls next to continue the chain
+type ConsumeInterceptor func(call ClientCall, options *MessageConsumeOptions, next ConsumeInvoker) ([]MessageReceiveResponse, error)
+
+// Interceptor is a pair of publish and consume interceptors, either may be nil
+type Interceptor struct {
+	Publish PublishInterceptor
+	Consume ConsumeInterceptor
+}
+
+// Wrap returns a Client running interceptors around the publish and consume calls of
+// client, the first interceptor is the outermost one. The other calls go to client as is
+func Wrap(client Client, interceptors ...Interceptor) Client {
+	return &wrappedClient{
+		Client:       client,
+		interceptors: interceptors,
+	}
+}
+
+// wrappedClient is the Client returned by Wrap
+type wrappedClient struct {
+	Client
+	interceptors []Interceptor
+}
+
+// Unwrap returns the client given to Wrap
+func (w *wrappedClient) Unwrap() Client {
+	return w.Client
+}
+
+func (w *wrappedClient) Publish(options *MessagePublishOptions) error {
+	_, err := w.runPublish(CallPublish, options, func(options *MessagePublishOptions) (*BatchResult, error) {
+		return nil, w.Client.Publish(options)
+	})
+	return err
+}
+
+func (w *wrappedClient) BatchPublish(options *MessagePublishOptions) (*BatchResult, error) {
+	return w.runPublish(CallBatchPublish, options, w.Client.BatchPublish)
+}
+
+func (w *wrappedClient) Consume(options *MessageConsumeOptions) (*MessageReceiveResponse, error) {
+	messages, err := w.runConsume(CallConsume, options, func(options *MessageConsumeOptions) ([]MessageReceiveResponse, error) {
+		message, err := w.Client.Consume(options)
+		if message == nil {
+			return nil, err
+		}
+		return []MessageReceiveResponse{*message}, err
+	})
+	if len(messages) == 0 {
+		return nil, err
+	}
+	return &messages[0], err
+}
+
+func (w *wrappedClient) BatchConsume(options *MessageConsumeOptions) ([]MessageReceiveResponse, error) {
+	return w.runConsume(CallBatchConsume, options, w.Client.BatchConsume)
+}
+
+func (w *wrappedClient) runPublish(call ClientCall, options *MessagePublishOptions, invoker PublishInvoker) (*BatchResult, error) {
+	for i := len(w.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := w.interceptors[i].Publish, invoker
+		if interceptor == nil {
+			continue
+		}
+		invoker = func(options *MessagePublishOptions) (*BatchResult, error) {
+			return interceptor(call, options, next)
+		}
+	}
+	return invoker(options)
+}
+
+func (w *wrappedClient) runConsume(call ClientCall, options *MessageConsumeOptions, invoker ConsumeInvoker) ([]MessageReceiveResponse, error) {
+	for i := len(w.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := w.interceptors[i].Consume, invoker
+		if interceptor == nil {
+			continue
+		}
+		invoker = func(options *MessageConsumeOptions) ([]MessageReceiveResponse, error) {
+			return interceptor(call, options, next)
+		}
+	}
+	return invoker(options)
+}
+
+// Unwrap returns the client given to Wrap, or client itself when it was not wrapped
+func Unwrap(client Client) Client {
+	for {
+		wrapped, ok := client.(interface{ Unwrap() Client })
+		if !ok {
+			return client
+		}
+		client = wrapped.Unwrap()
+	}
+}
+
+// publishedQueue returns the queue or topic of a publish, for the logs and metrics
+func publishedQueue(options *MessagePublishOptions) string {
+	return firstNonEmpty(options.QueueName, options.TopicName)
+}
+
+// callStatus is the status tag of a call
+func callStatus(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// formatFields renders the fields of a structured log line as key=value pairs, in order
+func formatFields(fields ...interface{}) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		value := fmt.Sprint(fields[i+1])
+		if strings.ContainsAny(value, " \"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, "%s=%s", fields[i], value)
+	}
+	return b.String()
+}
@@ -0,0 +1,208 @@
+package transporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedactedMask replaces the PII found by a Redactor
+const RedactedMask = "[REDACTED]"
+
+var (
+	// DefaultPIIFields are the JSON keys and attribute names a Redactor masks by default
+	DefaultPIIFields = []string{
+		"name", "full_name", "fullname", "first_name", "last_name",
+		"email", "phone", "phone_number", "mobile", "address",
+		"nik", "ktp", "identity_number", "date_of_birth", "dob", "birth_date",
+		"account_number", "card_number", "password", "token",
+	}
+	// DefaultPIIPatterns are masked anywhere in the text, emails and Indonesian mobile numbers
+	DefaultPIIPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+		regexp.MustCompile(`(?:\+62|\b62|\b0)8\d{7,11}\b`),
+	}
+)
+
+// Logger is the logger of LoggingInterceptor, logs.CommonLogger implements it
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// LoggingOption configures LoggingInterceptor
+//   - Logger - where the calls are logged, the transporter logger when nil
+//   - LogBodies - adds the bodies and attributes of the messages to the logs
+//   - Redactor - masks the PII of the logged bodies and attributes, NewRedactor() when nil
+type LoggingOption struct {
+	Logger    Logger
+	LogBodies bool
+	Redactor  *Redactor
+}
+
+// LoggingInterceptor logs every publish and consume call as key=value fields, the
+// failed calls are logged as errors
+func LoggingInterceptor(option LoggingOption) Interceptor {
+	var log Logger = logger
+	if option.Logger != nil {
+		log = option.Logger
+	}
+	redactor := option.Redactor
+	if redactor == nil {
+		redactor = NewRedactor()
+	}
+
+	return Interceptor{
+		Publish: func(call ClientCall, options *MessagePublishOptions, next PublishInvoker) (*BatchResult, error) {
+			start := time.Now()
+			result, err := next(options)
+
+			fields := []interface{}{"call", call, "queue", publishedQueue(options), "status", callStatus(err), "duration", time.Since(start)}
+			if call == CallBatchPublish {
+				fields = append(fields, "entries", len(options.Entries))
+				if result != nil {
+					fields = append(fields, "failed", len(result.Failed))
+				}
+			}
+			if option.LogBodies {
+				if call == CallPublish {
+					fields = append(fields, "body", redactor.RedactBody(options.MessageBody))
+				}
+				fields = append(fields, "attributes", redactor.RedactAttributes(options.Attributes))
+			}
+			if err != nil {
+				log.Errorf("transporter %s error=%q", formatFields(fields...), err.Error())
+			} else {
+				log.Infof("transporter %s", formatFields(fields...))
+			}
+			return result, err
+		},
+		Consume: func(call ClientCall, options *MessageConsumeOptions, next ConsumeInvoker) ([]MessageReceiveResponse, error) {
+			start := time.Now()
+			messages, err := next(options)
+
+			fields := []interface{}{"call", call, "queue", options.QueueName, "status", callStatus(err), "duration", time.Since(start), "messages", len(messages)}
+			if err != nil {
+				log.Errorf("transporter %s error=%q", formatFields(fields...), err.Error())
+				return messages, err
+			}
+			if len(messages) == 0 {
+				return messages, err
+			}
+			log.Infof("transporter %s", formatFields(fields...))
+			if option.LogBodies {
+				for i := range messages {
+					log.Infof("transporter %s", formatFields(
+						"call", call,
+						"queue", options.QueueName,
+						"message_id", messages[i].MessageID,
+						"body", redactor.RedactBody(messages[i].MessageBody),
+						"attributes", redactor.RedactAttributes(messages[i].Attributes),
+					))
+				}
+			}
+			return messages, err
+		},
+	}
+}
+
+// Redactor masks the PII of the message bodies and attributes before they are logged.
+// The values of Fields are masked in JSON bodies and attributes, whatever their depth,
+// and Patterns are masked anywhere in the text
+type Redactor struct {
+	Fields   []string
+	Patterns []*regexp.Regexp
+
+	once   sync.Once
+	fields map[string]bool
+}
+
+// NewRedactor returns a redactor masking DefaultPIIFields, fields and DefaultPIIPatterns
+func NewRedactor(fields ...string) *Redactor {
+	return &Redactor{
+		Fields:   append(append([]string{}, DefaultPIIFields...), fields...),
+		Patterns: DefaultPIIPatterns,
+	}
+}
+
+// RedactBody returns body as text with its PII masked
+func (r *Redactor) RedactBody(body interface{}) string {
+	if body == nil {
+		return ""
+	}
+	data, err := messageBodyBytes(body)
+	if err != nil {
+		return ""
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.UseNumber()
+		var value interface{}
+		if decoder.Decode(&value) == nil {
+			if redacted, err := json.Marshal(r.redactValue(value)); err == nil {
+				return string(redacted)
+			}
+		}
+	}
+	return r.redactText(string(data))
+}
+
+// RedactAttributes returns a copy of attributes with the PII masked
+func (r *Redactor) RedactAttributes(attributes map[string]string) map[string]string {
+	if len(attributes) == 0 {
+		return attributes
+	}
+	redacted := make(map[string]string, len(attributes))
+	for key, value := range attributes {
+		if r.isField(key) {
+			redacted[key] = RedactedMask
+			continue
+		}
+		redacted[key] = r.redactText(value)
+	}
+	return redacted
+}
+
+func (r *Redactor) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			if r.isField(key) {
+				v[key] = RedactedMask
+				continue
+			}
+			v[key] = r.redactValue(nested)
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = r.redactValue(v[i])
+		}
+		return v
+	case string:
+		return r.redactText(v)
+	}
+	return value
+}
+
+func (r *Redactor) redactText(text string) string {
+	for _, pattern := range r.Patterns {
+		text = pattern.ReplaceAllString(text, RedactedMask)
+	}
+	return text
+}
+
+func (r *Redactor) isField(key string) bool {
+	r.once.Do(func() {
+		r.fields = make(map[string]bool, len(r.Fields))
+		for _, field := range r.Fields {
+			r.fields[strings.ToLower(field)] = true
+		}
+	})
+	return r.fields[strings.ToLower(key)]
+}
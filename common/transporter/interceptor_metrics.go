@@ -0,0 +1,52 @@
+package transporter
+
+import "time"
+
+const (
+	// MetricClientCalls counts the publish and consume calls, tagged with call, queue and status
+	MetricClientCalls = "transporter.client.calls"
+	// MetricClientDuration is the time spent in the publish and consume calls
+	MetricClientDuration = "transporter.client.duration"
+	// MetricClientMessages counts the published and consumed messages, tagged with call and queue
+	MetricClientMessages = "transporter.client.messages"
+)
+
+// MetricsInterceptor sends the count and duration of the publish and consume calls to
+// metrics, a datadog.Datadog client
+func MetricsInterceptor(metrics Metrics) Interceptor {
+	return Interceptor{
+		Publish: func(call ClientCall, options *MessagePublishOptions, next PublishInvoker) (*BatchResult, error) {
+			start := time.Now()
+			result, err := next(options)
+
+			tags := []string{"call:" + string(call), "queue:" + publishedQueue(options), "status:" + callStatus(err)}
+			metrics.SendCountMetric(MetricClientCalls, tags...)
+			metrics.SendDurationMetric(MetricClientDuration, start, time.Now(), tags...)
+
+			published := 0
+			switch {
+			case err != nil:
+			case call == CallBatchPublish && result != nil:
+				published = len(result.Successful)
+			default:
+				published = 1
+			}
+			for i := 0; i < published; i++ {
+				metrics.SendCountMetric(MetricClientMessages, tags[:2]...)
+			}
+			return result, err
+		},
+		Consume: func(call ClientCall, options *MessageConsumeOptions, next ConsumeInvoker) ([]MessageReceiveResponse, error) {
+			start := time.Now()
+			messages, err := next(options)
+
+			tags := []string{"call:" + string(call), "queue:" + options.QueueName, "status:" + callStatus(err)}
+			metrics.SendCountMetric(MetricClientCalls, tags...)
+			metrics.SendDurationMetric(MetricClientDuration, start, time.Now(), tags...)
+			for range messages {
+				metrics.SendCountMetric(MetricClientMessages, tags[:2]...)
+			}
+			return messages, err
+		},
+	}
+}
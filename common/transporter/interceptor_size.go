@@ -0,0 +1,52 @@
+package transporter
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// SQSMaxMessageSize and MNSMaxMessageSize are the largest messages the providers accept
+	SQSMaxMessageSize = 256 * 1024
+	MNSMaxMessageSize = 64 * 1024
+)
+
+// ErrMessageTooLarge is returned by SizeLimitInterceptor for the messages over its limit
+var ErrMessageTooLarge = errors.New("message is too large")
+
+// SizeLimitInterceptor rejects the messages larger than maxBytes before they reach the
+// provider. The size counts the body and the attribute names and values like SQS does,
+// a batch is rejected when one of its entries is too large. The error is Permanent
+func SizeLimitInterceptor(maxBytes int) Interceptor {
+	return Interceptor{
+		Publish: func(call ClientCall, options *MessagePublishOptions, next PublishInvoker) (*BatchResult, error) {
+			if call != CallBatchPublish {
+				if size := messageSize(options.MessageBody, options.Attributes); size > maxBytes {
+					return nil, Permanent(fmt.Errorf("%w: %d bytes for queue: %s, the limit is %d", ErrMessageTooLarge, size, publishedQueue(options), maxBytes))
+				}
+				return next(options)
+			}
+
+			for i, entry := range options.Entries {
+				attributes := mergeAttributes(options.Attributes, entry.Attributes)
+				if size := messageSize(entry.MessageBody, attributes); size > maxBytes {
+					return nil, Permanent(fmt.Errorf("%w: entry %s has %d bytes for queue: %s, the limit is %d", ErrMessageTooLarge, firstNonEmpty(entry.ID, fmt.Sprint(i)), size, publishedQueue(options), maxBytes))
+				}
+			}
+			return next(options)
+		},
+	}
+}
+
+func messageSize(body interface{}, attributes map[string]string) int {
+	size := 0
+	if body != nil {
+		if data, err := messageBodyBytes(body); err == nil {
+			size = len(data)
+		}
+	}
+	for name, value := range attributes {
+		size += len(name) + len(value)
+	}
+	return size
+}
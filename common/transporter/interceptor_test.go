@@ -0,0 +1,209 @@
+package transporter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rohanchauhan02/clean/common/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ Metrics = datadog.Datadog{}
+
+type fakeLogger struct {
+	infos  []string
+	errors []string
+}
+
+func (l *fakeLogger) Infof(format string, args ...interface{}) {
+	l.infos = append(l.infos, fmt.Sprintf(format, args...))
+}
+
+func (l *fakeLogger) Errorf(format string, args ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+type fakeMetrics struct {
+	counts    []string
+	durations []string
+}
+
+func (m *fakeMetrics) SendCountMetric(name string, tags ...string) {
+	m.counts = append(m.counts, name+" "+strings.Join(tags, ","))
+}
+
+func (m *fakeMetrics) SendDurationMetric(name string, t1, t2 time.Time, tags ...string) {
+	m.durations = append(m.durations, name+" "+strings.Join(tags, ","))
+}
+
+func TestWrap(t *testing.T) {
+	memory := NewMemory()
+	var order []string
+	trace := func(name string) Interceptor {
+		return Interceptor{
+			Publish: func(call ClientCall, options *MessagePublishOptions, next PublishInvoker) (*BatchResult, error) {
+				order = append(order, name+" before "+string(call))
+				result, err := next(options)
+				order = append(order, name+" after "+string(call))
+				return result, err
+			},
+			Consume: func(call ClientCall, options *MessageConsumeOptions, next ConsumeInvoker) ([]MessageReceiveResponse, error) {
+				order = append(order, name+" "+string(call))
+				return next(options)
+			},
+		}
+	}
+	stamp := Interceptor{
+		Publish: func(call ClientCall, options *MessagePublishOptions, next PublishInvoker) (*BatchResult, error) {
+			options.Attributes = mergeAttributes(options.Attributes, map[string]string{"Team": "finance"})
+			return next(options)
+		},
+	}
+	client := Wrap(memory, trace("first"), stamp, trace("second"))
+
+	t.Run("test ok interceptors run in order", func(t *testing.T) {
+		require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "UPDATE_STOPLOSS"}))
+		assert.Equal(t, []string{"first before publish", "second before publish", "second after publish", "first after publish"}, order)
+
+		message, err := client.Consume(&MessageConsumeOptions{QueueName: "finance"})
+		require.NoError(t, err)
+		require.NotNil(t, message)
+		assert.Equal(t, "finance", message.Attributes["Team"])
+		assert.Equal(t, []string{"first consume", "second consume"}, order[4:])
+	})
+
+	t.Run("test ok batch calls are intercepted", func(t *testing.T) {
+		order = nil
+		result, err := client.BatchPublish(&MessagePublishOptions{QueueName: "finance", Entries: []MessagePublishEntry{{MessageBody: "1"}, {MessageBody: "2"}}})
+		require.NoError(t, err)
+		assert.Len(t, result.Successful, 2)
+
+		messages, err := client.BatchConsume(&MessageConsumeOptions{QueueName: "finance", NumberOfMessages: 10})
+		require.NoError(t, err)
+		assert.Len(t, messages, 2)
+		assert.Equal(t, []string{"first before batch_publish", "second before batch_publish", "second after batch_publish", "first after batch_publish", "first batch_consume", "second batch_consume"}, order)
+	})
+
+	t.Run("test ok empty consume", func(t *testing.T) {
+		message, err := client.Consume(&MessageConsumeOptions{QueueName: "policy"})
+		assert.Nil(t, err)
+		assert.Nil(t, message)
+	})
+
+	t.Run("test ok wrapped provider is still reachable", func(t *testing.T) {
+		assert.Same(t, memory, Unwrap(Wrap(client)))
+
+		require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "UPDATE_STOPLOSS"}))
+		message, err := client.Consume(&MessageConsumeOptions{QueueName: "finance", VisibilityTimeout: 30})
+		require.NoError(t, err)
+		assert.Nil(t, client.ChangeVisibility("finance", message, 0))
+		assert.Equal(t, 1, memory.Depth("finance"))
+	})
+}
+
+func TestSizeLimitInterceptor(t *testing.T) {
+	memory := NewMemory()
+	client := Wrap(memory, SizeLimitInterceptor(32))
+
+	t.Run("test ok message under the limit", func(t *testing.T) {
+		assert.Nil(t, client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "UPDATE_STOPLOSS"}))
+	})
+
+	t.Run("test wrong attributes count in the size", func(t *testing.T) {
+		err := client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "UPDATE_STOPLOSS", Attributes: map[string]string{AttributeRequestID: "req-10"}})
+		assert.True(t, errors.Is(err, ErrMessageTooLarge))
+		assert.True(t, IsPermanent(err))
+	})
+
+	t.Run("test wrong batch entry too large", func(t *testing.T) {
+		_, err := client.BatchPublish(&MessagePublishOptions{QueueName: "finance", Entries: []MessagePublishEntry{
+			{ID: "small", MessageBody: "UPDATE_STOPLOSS"},
+			{ID: "large", MessageBody: strings.Repeat("x", 33)},
+		}})
+		assert.EqualError(t, err, "message is too large: entry large has 33 bytes for queue: finance, the limit is 32")
+		assert.Equal(t, 1, memory.Depth("finance"))
+	})
+}
+
+func TestMetricsInterceptor(t *testing.T) {
+	metrics := &fakeMetrics{}
+	client := Wrap(NewMemory(), MetricsInterceptor(metrics), SizeLimitInterceptor(16))
+
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "UPDATE_STOPLOSS"}))
+	require.Error(t, client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "UPDATE_STOPLOSS_INCREMENT"}))
+	_, err := client.BatchConsume(&MessageConsumeOptions{QueueName: "finance", NumberOfMessages: 10})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		MetricClientCalls + " call:publish,queue:finance,status:success",
+		MetricClientMessages + " call:publish,queue:finance",
+		MetricClientCalls + " call:publish,queue:finance,status:error",
+		MetricClientCalls + " call:batch_consume,queue:finance,status:success",
+		MetricClientMessages + " call:batch_consume,queue:finance",
+	}, metrics.counts)
+	assert.Equal(t, []string{
+		MetricClientDuration + " call:publish,queue:finance,status:success",
+		MetricClientDuration + " call:publish,queue:finance,status:error",
+		MetricClientDuration + " call:batch_consume,queue:finance,status:success",
+	}, metrics.durations)
+}
+
+func TestLoggingInterceptor(t *testing.T) {
+	log := &fakeLogger{}
+	client := Wrap(NewMemory(), LoggingInterceptor(LoggingOption{Logger: log, LogBodies: true, Redactor: NewRedactor("policy_holder")}))
+
+	body := `{"policy_number":"QOALA-1","policy_holder":{"id":7},"customer":{"email":"budi@qoala.id","phone":"081234567890","amount":150000},"note":"call 6281234567890"}`
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "policy", MessageBody: body, Attributes: map[string]string{"Email": "budi@qoala.id", AttributeRequestID: "req-1"}}))
+	_, err := client.BatchConsume(&MessageConsumeOptions{QueueName: "policy", NumberOfMessages: 10})
+	require.NoError(t, err)
+
+	t.Run("test ok calls are logged with their fields", func(t *testing.T) {
+		require.Len(t, log.infos, 3)
+		assert.True(t, strings.HasPrefix(log.infos[0], "transporter call=publish queue=policy status=success duration="), log.infos[0])
+		assert.True(t, strings.HasPrefix(log.infos[1], "transporter call=batch_consume queue=policy status=success duration="), log.infos[1])
+		assert.Contains(t, log.infos[1], "messages=1")
+		assert.Contains(t, log.infos[2], "message_id=memory-1")
+	})
+
+	t.Run("test ok pii is redacted", func(t *testing.T) {
+		for _, line := range log.infos {
+			assert.NotContains(t, line, "budi@qoala.id")
+			assert.NotContains(t, line, "081234567890")
+			assert.NotContains(t, line, "6281234567890")
+			assert.NotContains(t, line, `\"id\":7`)
+		}
+		assert.Contains(t, log.infos[0], "QOALA-1")
+		assert.Contains(t, log.infos[0], "150000")
+		assert.Contains(t, log.infos[0], "req-1")
+	})
+
+	t.Run("test wrong call is logged as error", func(t *testing.T) {
+		failing := Wrap(NewMemory(), LoggingInterceptor(LoggingOption{Logger: log}), SizeLimitInterceptor(1))
+		assert.Error(t, failing.Publish(&MessagePublishOptions{QueueName: "policy", MessageBody: "CREATE_POLICY"}))
+		require.Len(t, log.errors, 1)
+		assert.Contains(t, log.errors[0], "status=error")
+		assert.Contains(t, log.errors[0], `error="message is too large`)
+	})
+}
+
+func TestRedactor(t *testing.T) {
+	redactor := NewRedactor()
+
+	t.Run("test ok nested json fields", func(t *testing.T) {
+		assert.Equal(t,
+			`[{"full_name":"[REDACTED]","policies":[{"nik":"[REDACTED]","number":"QOALA-1"}]}]`,
+			redactor.RedactBody(`[{"full_name":"Budi","policies":[{"nik":"3171234567890001","number":"QOALA-1"}]}]`))
+	})
+
+	t.Run("test ok plain text", func(t *testing.T) {
+		assert.Equal(t, "contact [REDACTED] or [REDACTED]", redactor.RedactBody([]byte("contact budi@qoala.id or +6281234567890")))
+	})
+
+	t.Run("test ok large numbers are kept", func(t *testing.T) {
+		assert.Equal(t, `{"amount":12345678901234567890}`, redactor.RedactBody(`{"amount":12345678901234567890}`))
+	})
+}
@@ -0,0 +1,620 @@
+package transporter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// JetStream will implement the Client interface on top of NATS JetStream.
+// Every queue is backed by a stream with the queue name as its subject and
+// a durable pull consumer shared by all the instances consuming the queue,
+// so a message is only delivered to one of them until it is acked or its
+// AckWait (the visibility timeout) expires.
+type JetStream struct {
+	Connection *nats.Conn
+	JetStream  nats.JetStreamContext
+
+	// Retention is used when a stream has to be created for a queue,
+	// it defaults to nats.WorkQueuePolicy which removes acked messages
+	Retention nats.RetentionPolicy
+
+	mu            sync.Mutex
+	subscriptions map[string]*nats.Subscription
+
+	// streams caches the name of the stream listening on each subject so the
+	// stream is only looked up once per subject
+	streamsMu sync.RWMutex
+	streams   map[string]string
+}
+
+// NewJetStream returns a JetStream client for an established NATS connection
+func NewJetStream(conn *nats.Conn) (*JetStream, error) {
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	return &JetStream{
+		Connection:    conn,
+		JetStream:     js,
+		Retention:     nats.WorkQueuePolicy,
+		subscriptions: map[string]*nats.Subscription{},
+		streams:       map[string]string{},
+	}, nil
+}
+
+// HealthCheck reports an error when the connection is not established or, if a
+// queue name is given, when the stream backing the queue cannot be found
+func (c *JetStream) HealthCheck(options *HealthCheckOptions) (bool, error) {
+	if status := c.Connection.Status(); status != nats.CONNECTED {
+		return false, fmt.Errorf("NATS connection is not established, status: %d", status)
+	}
+
+	if options == nil || options.QueueName == "" {
+		return true, nil
+	}
+
+	streamName, err := c.lookupStream(options.QueueName)
+	if err != nil {
+		return false, err
+	}
+	info, err := c.JetStream.StreamInfo(streamName)
+	if err != nil {
+		if errors.Is(err, nats.ErrStreamNotFound) {
+			c.forgetStream(options.QueueName)
+		}
+		return false, err
+	}
+	logger.Infof("stream %s has %d messages and %d consumers", info.Config.Name, info.State.Msgs, info.State.Consumers)
+	return true, nil
+}
+
+// Publish module to publish a message to the stream of the given queue.
+// MessageDeduplicationID is sent as the Nats-Msg-Id header so the stream
+// drops duplicates inside its duplicate window
+func (c *JetStream) Publish(options *MessagePublishOptions) error {
+	if options.DelayInSeconds > 0 {
+		return fmt.Errorf("DelayInSeconds is not supported by the NATS JetStream provider")
+	}
+
+	subject := jetStreamSubject(options)
+	if _, err := c.ensureStream(subject); err != nil {
+		return err
+	}
+
+	data, err := messageBodyBytes(options.MessageBody)
+	if err != nil {
+		return err
+	}
+
+	var opts []nats.PubOpt
+	if options.MessageDeduplicationID != "" {
+		opts = append(opts, nats.MsgId(options.MessageDeduplicationID))
+	}
+
+	_, err = c.JetStream.PublishMsg(&nats.Msg{
+		Subject: subject,
+		Data:    data,
+		Header:  natsHeader(options.Attributes),
+	}, opts...)
+	if err != nil {
+		if errors.Is(err, nats.ErrNoStreamResponse) {
+			// the stream was deleted, it is created again on the next publish
+			c.forgetStream(subject)
+		}
+		logger.Errorf("error in publishing message for subject: %s, for: %s", subject, err)
+		return err
+	}
+	return nil
+}
+
+// BatchPublish publishes options.Entries asynchronously and waits for every
+// publish acknowledgement. Entries rejected by the server are reported in
+// BatchResult.Failed
+func (c *JetStream) BatchPublish(options *MessagePublishOptions) (*BatchResult, error) {
+	if len(options.Entries) == 0 {
+		return nil, fmt.Errorf("no entries to batch publish to NATS subject: %s", jetStreamSubject(options))
+	}
+
+	subject := jetStreamSubject(options)
+	if _, err := c.ensureStream(subject); err != nil {
+		return nil, err
+	}
+
+	result := &BatchResult{}
+	ids := make([]string, 0, len(options.Entries))
+	futures := make([]nats.PubAckFuture, 0, len(options.Entries))
+	for i, entry := range options.Entries {
+		id := firstNonEmpty(entry.ID, strconv.Itoa(i))
+		if entry.DelayInSeconds > 0 {
+			result.Failed = append(result.Failed, BatchResultEntry{
+				ID:          id,
+				Code:        "DelayNotSupported",
+				Message:     "DelayInSeconds is not supported by the NATS JetStream provider",
+				SenderFault: true,
+			})
+			continue
+		}
+
+		data, err := messageBodyBytes(entry.MessageBody)
+		if err != nil {
+			result.Failed = append(result.Failed, BatchResultEntry{
+				ID:          id,
+				Code:        "InvalidMessageBody",
+				Message:     err.Error(),
+				SenderFault: true,
+			})
+			continue
+		}
+
+		var opts []nats.PubOpt
+		if dedupID := firstNonEmpty(entry.MessageDeduplicationID, options.MessageDeduplicationID); dedupID != "" {
+			opts = append(opts, nats.MsgId(dedupID))
+		}
+
+		future, err := c.JetStream.PublishMsgAsync(&nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  natsHeader(mergeAttributes(options.Attributes, entry.Attributes)),
+		}, opts...)
+		if err != nil {
+			result.Failed = append(result.Failed, BatchResultEntry{
+				ID:      id,
+				Code:    "RequestFailed",
+				Message: err.Error(),
+			})
+			continue
+		}
+		ids = append(ids, id)
+		futures = append(futures, future)
+	}
+
+	for i, future := range futures {
+		select {
+		case ack := <-future.Ok():
+			result.Successful = append(result.Successful, BatchResultEntry{
+				ID:        ids[i],
+				MessageID: jetStreamMessageID(ack.Stream, ack.Sequence),
+			})
+		case err := <-future.Err():
+			logger.Errorf("message failed to be published to NATS subject: %s with ID: %s, for: %s", subject, ids[i], err)
+			result.Failed = append(result.Failed, BatchResultEntry{
+				ID:      ids[i],
+				Code:    "PublishFailed",
+				Message: err.Error(),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// Consume fetches a single message from the durable consumer of the queue.
+// nats.ErrTimeout is returned when no message is available within WaitTimeSeconds
+func (c *JetStream) Consume(options *MessageConsumeOptions) (*MessageReceiveResponse, error) {
+	consumeOptions := *options
+	consumeOptions.NumberOfMessages = 1
+
+	messages, err := c.BatchConsume(&consumeOptions)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, nats.ErrTimeout
+	}
+	return &messages[0], nil
+}
+
+// BatchConsume fetches up to NumberOfMessages through the pull subscription of the
+// queue, waiting at most WaitTimeSeconds. An empty slice is returned when no message
+// arrived in time
+func (c *JetStream) BatchConsume(options *MessageConsumeOptions) ([]MessageReceiveResponse, error) {
+	consumerTimeout := options.WaitTimeSeconds
+	if consumerTimeout <= 0 {
+		consumerTimeout = WaitTimeSeconds
+	}
+
+	numberOfMessages := int(options.NumberOfMessages)
+	if numberOfMessages <= 0 {
+		numberOfMessages = 1
+	}
+
+	sub, err := c.getSubscription(options.QueueName, options.VisibilityTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := sub.Fetch(numberOfMessages, nats.MaxWait(time.Duration(consumerTimeout)*time.Second))
+	if err != nil && err != nats.ErrTimeout {
+		logger.Errorf("error in consuming message from NATS JetStream for queueName: %s for error: %s", options.QueueName, err)
+		return nil, err
+	}
+
+	responses := make([]MessageReceiveResponse, 0, len(msgs))
+	for _, msg := range msgs {
+		response := MessageReceiveResponse{
+			MessageBody:              string(msg.Data),
+			MessageSubject:           msg.Subject,
+			MessageReceiptHandle:     msg.Reply,
+			MessageVisibilityTimeout: options.VisibilityTimeout,
+			Attributes:               natsAttributes(msg.Header),
+		}
+		if meta, err := msg.Metadata(); err == nil {
+			response.MessageID = jetStreamMessageID(meta.Stream, meta.Sequence.Stream)
+			response.ReceiveCount = int64(meta.NumDelivered)
+		}
+		responses = append(responses, response)
+	}
+
+	if options.DeleteMessageAfterAck && len(responses) > 0 {
+		_, _ = c.BatchDeleteMessage(options.QueueName, responses)
+	}
+
+	return responses, nil
+}
+
+// DeleteMessage acks the message so the stream does not deliver it again
+func (c *JetStream) DeleteMessage(queueName string, message *MessageReceiveResponse) error {
+	msg, err := c.boundMessage(queueName, message)
+	if err != nil {
+		return err
+	}
+
+	if err := msg.AckSync(); err != nil {
+		logger.Errorf("error in acking NATS message with ID: %s, %s", message.MessageID, err)
+		return err
+	}
+	return nil
+}
+
+// BatchDeleteMessage acks every message, acks that fail are reported in BatchResult.Failed
+func (c *JetStream) BatchDeleteMessage(queueName string, messages []MessageReceiveResponse) (*BatchResult, error) {
+	result := &BatchResult{}
+	for i := range messages {
+		entry := BatchResultEntry{
+			ID:        messages[i].MessageID,
+			MessageID: messages[i].MessageID,
+		}
+		if err := c.DeleteMessage(queueName, &messages[i]); err != nil {
+			entry.Code = "AckFailed"
+			entry.Message = err.Error()
+			result.Failed = append(result.Failed, entry)
+			continue
+		}
+		result.Successful = append(result.Successful, entry)
+	}
+	return result, nil
+}
+
+// Nak tells the server the message was not processed so it is redelivered
+// once delay has passed, or right away when delay is zero
+func (c *JetStream) Nak(queueName string, message *MessageReceiveResponse, delay time.Duration) error {
+	msg, err := c.boundMessage(queueName, message)
+	if err != nil {
+		return err
+	}
+	return msg.NakWithDelay(delay)
+}
+
+// InProgress resets the AckWait of the message, it is used as a heartbeat by
+// handlers that need longer than the visibility timeout
+func (c *JetStream) InProgress(queueName string, message *MessageReceiveResponse) error {
+	msg, err := c.boundMessage(queueName, message)
+	if err != nil {
+		return err
+	}
+	return msg.InProgress()
+}
+
+// ChangeVisibility naks the message when visibilityTimeout is zero so it is redelivered
+// right away. Any other value restarts its AckWait with InProgress, the message is then
+// hidden for the visibility timeout of the queue as a heartbeat expects. Use Nak to
+// redeliver the message after a given delay instead
+func (c *JetStream) ChangeVisibility(queueName string, message *MessageReceiveResponse, visibilityTimeout int64) error {
+	if visibilityTimeout <= 0 {
+		return c.Nak(queueName, message, 0)
+	}
+	return c.InProgress(queueName, message)
+}
+
+// Close drains the pull subscriptions and closes the connection
+func (c *JetStream) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for queueName, sub := range c.subscriptions {
+		if err := sub.Unsubscribe(); err != nil {
+			logger.Errorf("error in unsubscribing from queue: %s, %s", queueName, err)
+		}
+		delete(c.subscriptions, queueName)
+	}
+	c.Connection.Close()
+	return nil
+}
+
+// boundMessage rebuilds a message that can be acked from the receipt handle,
+// which is the reply subject the server expects the ack on
+func (c *JetStream) boundMessage(queueName string, message *MessageReceiveResponse) (*nats.Msg, error) {
+	if message == nil || message.MessageReceiptHandle == "" {
+		return nil, fmt.Errorf("message has no receipt handle for NATS queue: %s", queueName)
+	}
+
+	sub, err := c.getSubscription(queueName, message.MessageVisibilityTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nats.Msg{
+		Subject: message.MessageSubject,
+		Reply:   message.MessageReceiptHandle,
+		Sub:     sub,
+	}, nil
+}
+
+// getSubscription creates or binds the stream and the durable pull consumer of the
+// queue, the consumer is bound to the stream found for the queue which may have been
+// created under another name. Subscriptions are cached as a pull subscription can be
+// reused across fetches
+func (c *JetStream) getSubscription(queueName string, visibilityTimeout int64) (*nats.Subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sub, ok := c.subscriptions[queueName]; ok && sub.IsValid() {
+		return sub, nil
+	}
+
+	streamName, err := c.ensureStream(queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []nats.SubOpt{
+		nats.BindStream(streamName),
+		nats.ManualAck(),
+		nats.AckExplicit(),
+	}
+	if visibilityTimeout > 0 {
+		opts = append(opts, nats.AckWait(time.Duration(visibilityTimeout)*time.Second))
+	}
+
+	sub, err := c.JetStream.PullSubscribe(queueName, jetStreamName(queueName), opts...)
+	if err != nil {
+		logger.Errorf("error in subscribing to NATS JetStream queue: %s, %s", queueName, err)
+		return nil, err
+	}
+
+	if c.subscriptions == nil {
+		c.subscriptions = map[string]*nats.Subscription{}
+	}
+	c.subscriptions[queueName] = sub
+	return sub, nil
+}
+
+// ensureStream returns the name of the stream listening on the subject, the stream is
+// created when none of the existing streams listens on it
+func (c *JetStream) ensureStream(subject string) (string, error) {
+	streamName, err := c.lookupStream(subject)
+	if err == nil {
+		return streamName, nil
+	}
+	if err != nats.ErrNoMatchingStream {
+		return "", err
+	}
+
+	streamName = jetStreamName(subject)
+	_, err = c.JetStream.AddStream(&nats.StreamConfig{
+		Name:      streamName,
+		Subjects:  []string{subject},
+		Retention: c.Retention,
+	})
+	if err != nil && err != nats.ErrStreamNameAlreadyInUse {
+		logger.Errorf("error in creating NATS stream for subject: %s, %s", subject, err)
+		return "", err
+	}
+	c.rememberStream(subject, streamName)
+	return streamName, nil
+}
+
+// lookupStream returns the name of the stream listening on the subject without creating
+// it, nats.ErrNoMatchingStream is returned when there is none. The name is cached per subject
+func (c *JetStream) lookupStream(subject string) (string, error) {
+	c.streamsMu.RLock()
+	streamName, ok := c.streams[subject]
+	c.streamsMu.RUnlock()
+	if ok {
+		return streamName, nil
+	}
+
+	streamName, err := c.JetStream.StreamNameBySubject(subject)
+	if err != nil {
+		return "", err
+	}
+	c.rememberStream(subject, streamName)
+	return streamName, nil
+}
+
+func (c *JetStream) rememberStream(subject, streamName string) {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	if c.streams == nil {
+		c.streams = map[string]string{}
+	}
+	c.streams[subject] = streamName
+}
+
+func (c *JetStream) forgetStream(subject string) {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	delete(c.streams, subject)
+}
+
+// jetStreamName converts a queue name to a valid stream and durable name,
+// which cannot contain dots, wildcards or whitespace
+func jetStreamName(queueName string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(queueName)
+}
+
+func jetStreamSubject(options *MessagePublishOptions) string {
+	return firstNonEmpty(options.QueueName, options.TopicName)
+}
+
+func jetStreamMessageID(stream string, sequence uint64) string {
+	return fmt.Sprintf("%s-%d", stream, sequence)
+}
+
+// CreateQueue creates the stream of the queue and, when a visibility timeout or a redrive
+// policy is given, its durable consumer. Consumers of the queue must then use the same
+// visibility timeout. Streams keep the publish order so FIFO needs no setting, delays and
+// dead-letter queues are not supported, use ConsumerOption.RetryPolicy to route failed
+// messages to a dead-letter queue
+func (c *JetStream) CreateQueue(queueName string, attributes *QueueAttributes) error {
+	if attributes == nil {
+		attributes = &QueueAttributes{}
+	}
+	if attributes.DelayInSeconds > 0 {
+		return fmt.Errorf("DelayInSeconds is not supported by the NATS JetStream provider")
+	}
+	if attributes.RedrivePolicy != nil && attributes.RedrivePolicy.DeadLetterQueueName != "" {
+		return fmt.Errorf("dead-letter queues are not supported by the NATS JetStream provider, queue: %s", queueName)
+	}
+
+	streamConfig := &nats.StreamConfig{
+		Name:      jetStreamName(queueName),
+		Subjects:  []string{queueName},
+		Retention: c.Retention,
+	}
+	if attributes.MessageRetentionPeriod > 0 {
+		streamConfig.MaxAge = time.Duration(attributes.MessageRetentionPeriod) * time.Second
+	}
+	if attributes.MaxMessageSize > 0 {
+		streamConfig.MaxMsgSize = int32(attributes.MaxMessageSize)
+	}
+	if _, err := c.JetStream.AddStream(streamConfig); err != nil && err != nats.ErrStreamNameAlreadyInUse {
+		logger.Errorf("error in creating NATS stream for queue: %s, %s", queueName, err)
+		return err
+	}
+	c.rememberStream(queueName, streamConfig.Name)
+
+	if attributes.VisibilityTimeout <= 0 && attributes.RedrivePolicy == nil {
+		return nil
+	}
+	consumerConfig := &nats.ConsumerConfig{
+		Durable:       jetStreamName(queueName),
+		FilterSubject: queueName,
+		AckPolicy:     nats.AckExplicitPolicy,
+	}
+	if attributes.VisibilityTimeout > 0 {
+		consumerConfig.AckWait = time.Duration(attributes.VisibilityTimeout) * time.Second
+	}
+	if attributes.RedrivePolicy != nil && attributes.RedrivePolicy.MaxReceiveCount > 0 {
+		consumerConfig.MaxDeliver = int(attributes.RedrivePolicy.MaxReceiveCount)
+	}
+	if _, err := c.JetStream.AddConsumer(streamConfig.Name, consumerConfig); err != nil {
+		logger.Errorf("error in creating NATS consumer for queue: %s, %s", queueName, err)
+		return err
+	}
+	return nil
+}
+
+// DeleteQueue deletes the stream of the queue along with its consumers and messages.
+// When the stream also listens on other subjects only the durable consumer and the
+// messages of the queue are deleted
+func (c *JetStream) DeleteQueue(queueName string) error {
+	streamName, err := c.lookupStream(queueName)
+	if err != nil {
+		logger.Errorf("error in finding NATS stream of queue: %s, %s", queueName, err)
+		return err
+	}
+	info, err := c.JetStream.StreamInfo(streamName)
+	if err != nil {
+		logger.Errorf("error in getting NATS stream of queue: %s, %s", queueName, err)
+		return err
+	}
+
+	c.mu.Lock()
+	if sub, ok := c.subscriptions[queueName]; ok {
+		_ = sub.Unsubscribe()
+		delete(c.subscriptions, queueName)
+	}
+	c.mu.Unlock()
+	c.forgetStream(queueName)
+
+	if len(info.Config.Subjects) == 1 && info.Config.Subjects[0] == queueName {
+		if err := c.JetStream.DeleteStream(streamName); err != nil {
+			logger.Errorf("error in deleting NATS stream of queue: %s, %s", queueName, err)
+			return err
+		}
+		return nil
+	}
+
+	if err := c.JetStream.DeleteConsumer(streamName, jetStreamName(queueName)); err != nil && !errors.Is(err, nats.ErrConsumerNotFound) {
+		logger.Errorf("error in deleting NATS consumer of queue: %s, %s", queueName, err)
+		return err
+	}
+	if err := c.JetStream.PurgeStream(streamName, &nats.StreamPurgeRequest{Subject: queueName}); err != nil {
+		logger.Errorf("error in purging NATS stream of queue: %s, %s", queueName, err)
+		return err
+	}
+	return nil
+}
+
+// GetQueueCounts returns the pending and ack pending messages of the durable consumer,
+// or the messages of the stream when nothing consumed the queue yet
+func (c *JetStream) GetQueueCounts(queueName string) (*QueueCounts, error) {
+	streamName, err := c.lookupStream(queueName)
+	if err != nil {
+		logger.Errorf("error in finding NATS stream of queue: %s, %s", queueName, err)
+		return nil, err
+	}
+
+	info, err := c.JetStream.ConsumerInfo(streamName, jetStreamName(queueName))
+	if err == nil {
+		return &QueueCounts{
+			Visible:  int64(info.NumPending),
+			InFlight: int64(info.NumAckPending),
+		}, nil
+	}
+	if !errors.Is(err, nats.ErrConsumerNotFound) {
+		logger.Errorf("error in getting NATS consumer of queue: %s, %s", queueName, err)
+		return nil, err
+	}
+
+	streamInfo, err := c.JetStream.StreamInfo(streamName, &nats.StreamInfoRequest{SubjectsFilter: queueName})
+	if err != nil {
+		logger.Errorf("error in getting NATS stream of queue: %s, %s", queueName, err)
+		return nil, err
+	}
+	return &QueueCounts{Visible: int64(streamInfo.State.Subjects[queueName])}, nil
+}
+
+// PurgeQueue removes the messages of the queue from its stream
+func (c *JetStream) PurgeQueue(queueName string) error {
+	streamName, err := c.lookupStream(queueName)
+	if err != nil {
+		logger.Errorf("error in finding NATS stream of queue: %s, %s", queueName, err)
+		return err
+	}
+	if err := c.JetStream.PurgeStream(streamName, &nats.StreamPurgeRequest{Subject: queueName}); err != nil {
+		logger.Errorf("error in purging NATS stream of queue: %s, %s", queueName, err)
+		return err
+	}
+	logger.Info("purged queue:", queueName)
+	return nil
+}
+
+// ListQueues returns the subjects of the streams, which are the queue names
+func (c *JetStream) ListQueues(prefix string) ([]string, error) {
+	var queueNames []string
+	for info := range c.JetStream.StreamsInfo() {
+		for _, subject := range info.Config.Subjects {
+			if strings.HasPrefix(subject, prefix) {
+				queueNames = append(queueNames, subject)
+			}
+		}
+	}
+	return queueNames, nil
+}
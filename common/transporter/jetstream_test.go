@@ -0,0 +1,294 @@
+package transporter
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newJetStreamTestClient(t *testing.T) *JetStream {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("embedded NATS server is not ready for connections")
+	}
+	t.Cleanup(s.Shutdown)
+
+	conf := ClientOptions{
+		Host:     "127.0.0.1",
+		Port:     strconv.Itoa(s.Addr().(*net.TCPAddr).Port),
+		Provider: "nats-jetstream",
+	}
+
+	client, err := NewClient(&conf)
+	require.NoError(t, err)
+
+	js := client.(*JetStream)
+	t.Cleanup(func() { _ = js.Close() })
+	return js
+}
+
+func TestJetStreamPublishAndConsume(t *testing.T) {
+	client := newJetStreamTestClient(t)
+
+	t.Run("test ok publish and consume JetStream message", func(t *testing.T) {
+		err := client.Publish(&MessagePublishOptions{
+			QueueName:   "stoploss.update",
+			MessageBody: "HALO",
+		})
+		assert.Nil(t, err)
+
+		msgs, err := client.BatchConsume(&MessageConsumeOptions{
+			QueueName:        "stoploss.update",
+			NumberOfMessages: 5,
+			WaitTimeSeconds:  1,
+		})
+		assert.Nil(t, err)
+		require.Len(t, msgs, 1)
+		assert.Equal(t, "HALO", msgs[0].MessageBody)
+		assert.Equal(t, "stoploss_update-1", msgs[0].MessageID)
+		assert.Equal(t, int64(1), msgs[0].ReceiveCount)
+		assert.NotEmpty(t, msgs[0].MessageReceiptHandle)
+
+		assert.Nil(t, client.DeleteMessage("stoploss.update", &msgs[0]))
+	})
+
+	t.Run("test ok acked message is removed from the stream", func(t *testing.T) {
+		msgs, err := client.BatchConsume(&MessageConsumeOptions{
+			QueueName:        "stoploss.update",
+			NumberOfMessages: 5,
+			WaitTimeSeconds:  1,
+		})
+		assert.Nil(t, err)
+		assert.Empty(t, msgs)
+	})
+
+	t.Run("test ok health check with stream", func(t *testing.T) {
+		ok, err := client.HealthCheck(&HealthCheckOptions{QueueName: "stoploss.update"})
+		assert.True(t, ok)
+		assert.Nil(t, err)
+	})
+
+	t.Run("test health check with unknown stream", func(t *testing.T) {
+		ok, err := client.HealthCheck(&HealthCheckOptions{QueueName: "unknown"})
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("test publish with delay is not supported", func(t *testing.T) {
+		err := client.Publish(&MessagePublishOptions{
+			QueueName:      "stoploss.update",
+			MessageBody:    "HALO",
+			DelayInSeconds: 10,
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("test ok attributes are sent as headers", func(t *testing.T) {
+		err := client.Publish(&MessagePublishOptions{
+			QueueName:              "stoploss.update",
+			MessageBody:            "HALO",
+			MessageDeduplicationID: "halo-1",
+			Attributes:             map[string]string{AttributeRequestID: "req-1", "x-datadog-trace-id": "42"},
+		})
+		assert.Nil(t, err)
+
+		msg, err := client.Consume(&MessageConsumeOptions{QueueName: "stoploss.update", WaitTimeSeconds: 1})
+		assert.Nil(t, err)
+		require.NotNil(t, msg)
+		assert.Equal(t, map[string]string{AttributeRequestID: "req-1", "x-datadog-trace-id": "42"}, msg.Attributes)
+		assert.Nil(t, client.DeleteMessage("stoploss.update", msg))
+	})
+}
+
+func TestJetStreamBatchPublishDeduplicates(t *testing.T) {
+	client := newJetStreamTestClient(t)
+
+	result, err := client.BatchPublish(&MessagePublishOptions{
+		QueueName: "quotation",
+		Entries: []MessagePublishEntry{
+			{ID: "a", MessageBody: map[string]int{"id": 1}, MessageDeduplicationID: "dedup-1"},
+			{ID: "b", MessageBody: map[string]int{"id": 1}, MessageDeduplicationID: "dedup-1"},
+			{ID: "c", MessageBody: []byte("raw")},
+			{ID: "d", MessageBody: "delayed", DelayInSeconds: 5},
+		},
+	})
+
+	t.Run("test ok batch publish result", func(t *testing.T) {
+		assert.Nil(t, err)
+		assert.Len(t, result.Successful, 3)
+		require.Len(t, result.Failed, 1)
+		assert.Equal(t, "d", result.Failed[0].ID)
+	})
+
+	t.Run("test ok duplicated entry is delivered once", func(t *testing.T) {
+		msgs, err := client.BatchConsume(&MessageConsumeOptions{
+			QueueName:             "quotation",
+			NumberOfMessages:      10,
+			WaitTimeSeconds:       1,
+			DeleteMessageAfterAck: true,
+		})
+		assert.Nil(t, err)
+		require.Len(t, msgs, 2)
+		assert.Equal(t, `{"id":1}`, msgs[0].MessageBody)
+		assert.Equal(t, "raw", msgs[1].MessageBody)
+	})
+}
+
+func TestJetStreamNakRedeliversMessage(t *testing.T) {
+	client := newJetStreamTestClient(t)
+	consumerOptions := &MessageConsumeOptions{
+		QueueName:         "finance",
+		WaitTimeSeconds:   1,
+		VisibilityTimeout: 30,
+	}
+
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "HALO"}))
+
+	msg, err := client.Consume(consumerOptions)
+	require.NoError(t, err)
+
+	t.Run("test ok in progress heartbeat", func(t *testing.T) {
+		assert.Nil(t, client.InProgress("finance", msg))
+	})
+
+	t.Run("test ok nak redelivers message", func(t *testing.T) {
+		assert.Nil(t, client.Nak("finance", msg, 0))
+
+		redelivered, err := client.Consume(consumerOptions)
+		assert.Nil(t, err)
+		assert.Equal(t, msg.MessageID, redelivered.MessageID)
+		assert.Equal(t, int64(2), redelivered.ReceiveCount)
+	})
+
+	t.Run("test consume with no message returns timeout", func(t *testing.T) {
+		_, err := client.Consume(consumerOptions)
+		assert.Error(t, err)
+	})
+}
+
+func TestJetStreamExistingStream(t *testing.T) {
+	client := newJetStreamTestClient(t)
+	_, err := client.JetStream.AddStream(&nats.StreamConfig{
+		Name:      "policy",
+		Subjects:  []string{"policy.>"},
+		Retention: nats.WorkQueuePolicy,
+	})
+	require.NoError(t, err)
+
+	t.Run("test ok consume from the stream listening on the queue", func(t *testing.T) {
+		require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "policy.renewal", MessageBody: "P-1"}))
+		require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "policy.renewal", MessageBody: "P-2"}))
+		assert.Equal(t, map[string]string{"policy.renewal": "policy"}, client.streams)
+
+		msgs, err := client.BatchConsume(&MessageConsumeOptions{
+			QueueName:        "policy.renewal",
+			NumberOfMessages: 5,
+			WaitTimeSeconds:  1,
+		})
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+		assert.Equal(t, "policy-1", msgs[0].MessageID)
+		assert.Nil(t, client.DeleteMessage("policy.renewal", &msgs[0]))
+	})
+
+	t.Run("test ok admin of the queue acts on the stream listening on it", func(t *testing.T) {
+		require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "policy.expiry", MessageBody: "E-1"}))
+
+		ok, err := client.HealthCheck(&HealthCheckOptions{QueueName: "policy.renewal"})
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		counts, err := client.GetQueueCounts("policy.renewal")
+		require.NoError(t, err)
+		assert.Equal(t, &QueueCounts{InFlight: 1}, counts)
+		counts, err = client.GetQueueCounts("policy.expiry")
+		require.NoError(t, err)
+		assert.Equal(t, &QueueCounts{Visible: 1}, counts)
+
+		require.NoError(t, client.PurgeQueue("policy.renewal"))
+		require.NoError(t, client.DeleteQueue("policy.renewal"))
+		info, err := client.JetStream.StreamInfo("policy")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), info.State.Msgs)
+		assert.Equal(t, 0, info.State.Consumers)
+	})
+
+	t.Run("test ok deleted stream is created again", func(t *testing.T) {
+		require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "policy.renewal", MessageBody: "P-3"}))
+		require.NoError(t, client.JetStream.DeleteStream("policy"))
+
+		assert.Error(t, client.Publish(&MessagePublishOptions{QueueName: "policy.renewal", MessageBody: "P-4"}))
+		require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "policy.renewal", MessageBody: "P-4"}))
+		assert.Equal(t, "policy_renewal", client.streams["policy.renewal"])
+	})
+}
+
+func TestJetStreamQueueAdmin(t *testing.T) {
+	client := newJetStreamTestClient(t)
+	admin, err := NewQueueAdmin(client)
+	require.NoError(t, err)
+
+	t.Run("test wrong dead-letter queue", func(t *testing.T) {
+		err := admin.CreateQueue("claim.sla", &QueueAttributes{
+			RedrivePolicy: &RedrivePolicy{DeadLetterQueueName: "claim.dlq", MaxReceiveCount: 3},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("test ok create queue and consume with its visibility timeout", func(t *testing.T) {
+		require.NoError(t, admin.CreateQueue("claim.sla", &QueueAttributes{
+			VisibilityTimeout: 30,
+			RedrivePolicy:     &RedrivePolicy{MaxReceiveCount: 3},
+		}))
+		for _, body := range []string{"sla-1", "sla-2", "sla-3"} {
+			require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "claim.sla", MessageBody: body}))
+		}
+
+		_, err := client.Consume(&MessageConsumeOptions{QueueName: "claim.sla", WaitTimeSeconds: 1, VisibilityTimeout: 30})
+		require.NoError(t, err)
+
+		counts, err := admin.GetQueueCounts("claim.sla")
+		require.NoError(t, err)
+		assert.Equal(t, &QueueCounts{Visible: 2, InFlight: 1}, counts)
+	})
+
+	t.Run("test ok counts of a queue without consumer", func(t *testing.T) {
+		require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "policy.expiry", MessageBody: "P-1"}))
+
+		counts, err := admin.GetQueueCounts("policy.expiry")
+		require.NoError(t, err)
+		assert.Equal(t, &QueueCounts{Visible: 1}, counts)
+	})
+
+	t.Run("test ok list, purge and delete", func(t *testing.T) {
+		queueNames, err := admin.ListQueues("claim.")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"claim.sla"}, queueNames)
+
+		require.NoError(t, admin.PurgeQueue("policy.expiry"))
+		counts, err := admin.GetQueueCounts("policy.expiry")
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), counts.Visible)
+
+		require.NoError(t, admin.DeleteQueue("claim.sla"))
+		queueNames, err = admin.ListQueues("")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"policy.expiry"}, queueNames)
+	})
+}
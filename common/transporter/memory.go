@@ -0,0 +1,429 @@
+package transporter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MemoryDefaultVisibilityTimeout is used when neither the queue nor the consume
+	// options set a visibility timeout, it matches the SQS default
+	MemoryDefaultVisibilityTimeout = 30 * time.Second
+	// MemoryDeduplicationInterval is how long a MessageDeduplicationID is remembered
+	// by a FIFO queue, it matches the SQS deduplication interval
+	MemoryDeduplicationInterval = 5 * time.Minute
+)
+
+// MemoryQueueOptions configures a queue of the in-memory provider
+//   - FIFO - messages of the same MessageGroupID are delivered one at a time in publish order,
+//     queues ending with ".fifo" are FIFO by default
+//   - VisibilityTimeout - used when the consume options do not set one
+//   - MaxReceiveCount - when greater than zero, a message received more than MaxReceiveCount
+//     times is moved to DeadLetterQueue instead of being delivered again
+type MemoryQueueOptions struct {
+	FIFO              bool
+	VisibilityTimeout time.Duration
+	MaxReceiveCount   int64
+	DeadLetterQueue   string
+}
+
+// Memory implements the Client interface with in-process queues. It is meant for
+// unit tests and local development, messages are lost when the process exits.
+// Queues are created on first use, CreateQueue is only needed to change their options.
+type Memory struct {
+	// Now returns the current time, tests can replace it to move visibility
+	// timeouts and delays forward without sleeping
+	Now func() time.Time
+
+	mu       sync.Mutex
+	queues   map[string]*memoryQueue
+	sequence int64
+}
+
+type memoryQueue struct {
+	options       MemoryQueueOptions
+	messages      []*memoryMessage
+	deduplication map[string]memoryDeduplication
+}
+
+// memoryDeduplication is the message first sent with a MessageDeduplicationID,
+// its ID is returned for the duplicates like SQS does
+type memoryDeduplication struct {
+	messageID string
+	sentAt    time.Time
+}
+
+type memoryMessage struct {
+	id            string
+	body          interface{}
+	subject       string
+	groupID       string
+	sentAt        time.Time
+	visibleAt     time.Time
+	receiveCount  int64
+	receiptHandle string
+	inFlight      bool
+	attributes    map[string]string
+}
+
+// NewMemory returns an empty in-memory provider
+func NewMemory() *Memory {
+	return &Memory{
+		Now:    time.Now,
+		queues: map[string]*memoryQueue{},
+	}
+}
+
+// CreateQueue creates the queue or replaces the options of an existing one
+func (m *Memory) CreateQueue(queueName string, options MemoryQueueOptions) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue(queueName).options = options
+}
+
+func (m *Memory) HealthCheck(options *HealthCheckOptions) (bool, error) {
+	return true, nil
+}
+
+// Publish appends the message to the queue, it becomes visible after DelayInSeconds.
+// FIFO queues drop messages whose MessageDeduplicationID was already seen in the
+// last MemoryDeduplicationInterval
+func (m *Memory) Publish(options *MessagePublishOptions) error {
+	_, err := m.send(options.QueueName, options.TopicName, MessagePublishEntry{
+		MessageBody:            options.MessageBody,
+		MessageGroupID:         options.MessageGroupID,
+		MessageDeduplicationID: options.MessageDeduplicationID,
+		DelayInSeconds:         options.DelayInSeconds,
+		Attributes:             options.Attributes,
+	})
+	return err
+}
+
+// BatchPublish publishes every entry of options.Entries, falling back to the
+// group ID, deduplication ID and delay of options when an entry does not set them
+func (m *Memory) BatchPublish(options *MessagePublishOptions) (*BatchResult, error) {
+	if len(options.Entries) == 0 {
+		return nil, fmt.Errorf("no entries to batch publish to memory queue: %s", options.QueueName)
+	}
+
+	result := &BatchResult{}
+	for i, entry := range options.Entries {
+		id := firstNonEmpty(entry.ID, strconv.Itoa(i))
+		entry.MessageGroupID = firstNonEmpty(entry.MessageGroupID, options.MessageGroupID)
+		entry.MessageDeduplicationID = firstNonEmpty(entry.MessageDeduplicationID, options.MessageDeduplicationID)
+		if entry.DelayInSeconds <= 0 {
+			entry.DelayInSeconds = options.DelayInSeconds
+		}
+		entry.Attributes = mergeAttributes(options.Attributes, entry.Attributes)
+
+		messageID, err := m.send(options.QueueName, options.TopicName, entry)
+		if err != nil {
+			result.Failed = append(result.Failed, BatchResultEntry{
+				ID:          id,
+				Code:        "InvalidParameterValue",
+				Message:     err.Error(),
+				SenderFault: true,
+			})
+			continue
+		}
+		result.Successful = append(result.Successful, BatchResultEntry{ID: id, MessageID: messageID})
+	}
+	return result, nil
+}
+
+// Consume receives a single message, nil is returned when the queue has no visible message
+func (m *Memory) Consume(options *MessageConsumeOptions) (*MessageReceiveResponse, error) {
+	consumeOptions := *options
+	consumeOptions.NumberOfMessages = 1
+
+	messages, err := m.BatchConsume(&consumeOptions)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	return &messages[0], nil
+}
+
+// BatchConsume receives up to NumberOfMessages visible messages and hides them for the
+// visibility timeout. When the queue is empty it waits up to WaitTimeSeconds for a message
+// to become visible, like SQS long polling
+func (m *Memory) BatchConsume(options *MessageConsumeOptions) ([]MessageReceiveResponse, error) {
+	if options.QueueName == "" {
+		return nil, fmt.Errorf("queue name is empty")
+	}
+
+	deadline := time.Now().Add(time.Duration(options.WaitTimeSeconds) * time.Second)
+	for {
+		messages := m.receive(options)
+		if len(messages) > 0 || !time.Now().Before(deadline) {
+			if options.DeleteMessageAfterAck && len(messages) > 0 {
+				_, _ = m.BatchDeleteMessage(options.QueueName, messages)
+			}
+			return messages, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// DeleteMessage removes a received message, the receipt handle must be the one
+// of the latest receive
+func (m *Memory) DeleteMessage(queueName string, message *MessageReceiveResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queue := m.queue(queueName)
+	for i, msg := range queue.messages {
+		if msg.receiptHandle != "" && msg.receiptHandle == message.MessageReceiptHandle {
+			queue.messages = append(queue.messages[:i], queue.messages[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("receipt handle: %s is not valid for memory queue: %s", message.MessageReceiptHandle, queueName)
+}
+
+func (m *Memory) BatchDeleteMessage(queueName string, messages []MessageReceiveResponse) (*BatchResult, error) {
+	result := &BatchResult{}
+	for i := range messages {
+		entry := BatchResultEntry{
+			ID:        messages[i].MessageID,
+			MessageID: messages[i].MessageID,
+		}
+		if err := m.DeleteMessage(queueName, &messages[i]); err != nil {
+			entry.Code = "ReceiptHandleIsInvalid"
+			entry.Message = err.Error()
+			entry.SenderFault = true
+			result.Failed = append(result.Failed, entry)
+			continue
+		}
+		result.Successful = append(result.Successful, entry)
+	}
+	return result, nil
+}
+
+// ChangeVisibility hides a received message for visibilityTimeout seconds from now,
+// zero makes it visible right away
+func (m *Memory) ChangeVisibility(queueName string, message *MessageReceiveResponse, visibilityTimeout int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, msg := range m.queue(queueName).messages {
+		if msg.receiptHandle != "" && msg.receiptHandle == message.MessageReceiptHandle {
+			msg.visibleAt = m.Now().Add(time.Duration(visibilityTimeout) * time.Second)
+			return nil
+		}
+	}
+	return fmt.Errorf("receipt handle: %s is not valid for memory queue: %s", message.MessageReceiptHandle, queueName)
+}
+
+// Depth returns the number of messages of the queue that are not in flight,
+// including the ones still delayed
+func (m *Memory) Depth(queueName string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Now()
+	depth := 0
+	for _, msg := range m.queue(queueName).messages {
+		if !m.isInFlight(msg, now) {
+			depth++
+		}
+	}
+	return depth
+}
+
+// InFlight returns the number of messages received but not deleted whose
+// visibility timeout has not expired yet
+func (m *Memory) InFlight(queueName string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Now()
+	inFlight := 0
+	for _, msg := range m.queue(queueName).messages {
+		if m.isInFlight(msg, now) {
+			inFlight++
+		}
+	}
+	return inFlight
+}
+
+// Drain removes every message of the queue, in flight or not, and returns their
+// bodies in publish order so tests can assert on what was published
+func (m *Memory) Drain(queueName string) []interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queue := m.queue(queueName)
+	bodies := make([]interface{}, len(queue.messages))
+	for i, msg := range queue.messages {
+		bodies[i] = msg.body
+	}
+	queue.messages = nil
+	return bodies
+}
+
+// QueueNames returns the name of every queue known by the provider
+func (m *Memory) QueueNames() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.queues))
+	for name := range m.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *Memory) send(queueName string, subject string, entry MessagePublishEntry) (string, error) {
+	if queueName == "" {
+		return "", fmt.Errorf("queue name is empty")
+	}
+	if entry.MessageBody == nil {
+		return "", fmt.Errorf("message body is empty for memory queue: %s", queueName)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queue := m.queue(queueName)
+	now := m.Now()
+	if queue.options.FIFO {
+		if entry.MessageGroupID == "" {
+			return "", fmt.Errorf("message group ID is required for FIFO memory queue: %s", queueName)
+		}
+		if entry.MessageDeduplicationID != "" {
+			if sent, ok := queue.deduplication[entry.MessageDeduplicationID]; ok && now.Sub(sent.sentAt) < MemoryDeduplicationInterval {
+				return sent.messageID, nil
+			}
+		}
+	}
+
+	m.sequence++
+	msg := &memoryMessage{
+		id:         fmt.Sprintf("memory-%d", m.sequence),
+		body:       entry.MessageBody,
+		subject:    subject,
+		groupID:    entry.MessageGroupID,
+		sentAt:     now,
+		visibleAt:  now.Add(time.Duration(entry.DelayInSeconds) * time.Second),
+		attributes: mergeAttributes(nil, entry.Attributes),
+	}
+	queue.messages = append(queue.messages, msg)
+	if queue.options.FIFO && entry.MessageDeduplicationID != "" {
+		queue.deduplication[entry.MessageDeduplicationID] = memoryDeduplication{messageID: msg.id, sentAt: now}
+	}
+	return msg.id, nil
+}
+
+func (m *Memory) receive(options *MessageConsumeOptions) []MessageReceiveResponse {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queue := m.queue(options.QueueName)
+	now := m.Now()
+
+	numberOfMessages := int(options.NumberOfMessages)
+	if numberOfMessages <= 0 {
+		numberOfMessages = 1
+	}
+
+	visibilityTimeout := time.Duration(options.VisibilityTimeout) * time.Second
+	if visibilityTimeout <= 0 {
+		visibilityTimeout = queue.options.VisibilityTimeout
+	}
+	if visibilityTimeout <= 0 {
+		visibilityTimeout = MemoryDefaultVisibilityTimeout
+	}
+
+	// a FIFO group is blocked while one of its messages is in flight
+	blockedGroups := map[string]bool{}
+	if queue.options.FIFO {
+		for _, msg := range queue.messages {
+			if m.isInFlight(msg, now) {
+				blockedGroups[msg.groupID] = true
+			}
+		}
+	}
+
+	var responses []MessageReceiveResponse
+	var deadLetters []*memoryMessage
+	remaining := queue.messages[:0]
+	for _, msg := range queue.messages {
+		if len(responses) >= numberOfMessages || now.Before(msg.visibleAt) || blockedGroups[msg.groupID] {
+			if queue.options.FIFO {
+				blockedGroups[msg.groupID] = true
+			}
+			remaining = append(remaining, msg)
+			continue
+		}
+
+		if queue.options.MaxReceiveCount > 0 && msg.receiveCount >= queue.options.MaxReceiveCount && queue.options.DeadLetterQueue != "" {
+			deadLetters = append(deadLetters, msg)
+			continue
+		}
+
+		msg.receiveCount++
+		msg.inFlight = true
+		msg.visibleAt = now.Add(visibilityTimeout)
+		msg.receiptHandle = fmt.Sprintf("%s-%d", msg.id, msg.receiveCount)
+		if queue.options.FIFO {
+			blockedGroups[msg.groupID] = true
+		}
+		remaining = append(remaining, msg)
+
+		responses = append(responses, MessageReceiveResponse{
+			MessageBody:              msg.body,
+			MessageSubject:           msg.subject,
+			MessageID:                msg.id,
+			MessageGroupID:           msg.groupID,
+			MessageReceiptHandle:     msg.receiptHandle,
+			MessageVisibilityTimeout: int64(visibilityTimeout / time.Second),
+			ReceiveCount:             msg.receiveCount,
+			Attributes:               mergeAttributes(nil, msg.attributes),
+		})
+	}
+	queue.messages = remaining
+
+	if len(deadLetters) > 0 {
+		deadLetterQueue := m.queue(queue.options.DeadLetterQueue)
+		for _, msg := range deadLetters {
+			logger.Infof("moving message: %s to dead letter queue: %s after %d receives", msg.id, queue.options.DeadLetterQueue, msg.receiveCount)
+			msg.inFlight = false
+			msg.receiveCount = 0
+			msg.receiptHandle = ""
+			msg.visibleAt = now
+			deadLetterQueue.messages = append(deadLetterQueue.messages, msg)
+		}
+	}
+
+	return responses
+}
+
+func (m *Memory) isInFlight(msg *memoryMessage, now time.Time) bool {
+	return msg.inFlight && now.Before(msg.visibleAt)
+}
+
+// queue returns the queue with the given name, creating it when needed.
+// The caller must hold m.mu
+func (m *Memory) queue(queueName string) *memoryQueue {
+	if m.queues == nil {
+		m.queues = map[string]*memoryQueue{}
+	}
+	if m.Now == nil {
+		m.Now = time.Now
+	}
+
+	queue, ok := m.queues[queueName]
+	if !ok {
+		queue = &memoryQueue{
+			options:       MemoryQueueOptions{FIFO: strings.HasSuffix(queueName, ".fifo")},
+			deduplication: map[string]memoryDeduplication{},
+		}
+		m.queues[queueName] = queue
+	}
+	return queue
+}
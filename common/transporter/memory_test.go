@@ -0,0 +1,163 @@
+package transporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMemoryTestClient(t *testing.T) (*Memory, *time.Time) {
+	conf := ClientOptions{Provider: "memory"}
+	client, err := NewClient(&conf)
+	require.NoError(t, err)
+
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	memory := client.(*Memory)
+	memory.Now = func() time.Time { return now }
+	return memory, &now
+}
+
+func TestMemoryPublishAndConsume(t *testing.T) {
+	client, _ := newMemoryTestClient(t)
+
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "add-product", MessageBody: "h1"}))
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "add-product", MessageBody: "h2"}))
+
+	t.Run("test ok consume memory messages", func(t *testing.T) {
+		msgs, err := client.BatchConsume(&MessageConsumeOptions{QueueName: "add-product", NumberOfMessages: 10})
+		assert.Nil(t, err)
+		require.Len(t, msgs, 2)
+		assert.Equal(t, "h1", msgs[0].MessageBody)
+		assert.Equal(t, int64(1), msgs[0].ReceiveCount)
+		assert.NotEmpty(t, msgs[0].MessageReceiptHandle)
+		assert.Equal(t, 0, client.Depth("add-product"))
+		assert.Equal(t, 2, client.InFlight("add-product"))
+
+		assert.Nil(t, client.DeleteMessage("add-product", &msgs[0]))
+		assert.Equal(t, 1, client.InFlight("add-product"))
+	})
+
+	t.Run("test delete message with invalid receipt handle", func(t *testing.T) {
+		err := client.DeleteMessage("add-product", &MessageReceiveResponse{MessageReceiptHandle: "INVALID_RECEIPT"})
+		assert.Error(t, err)
+	})
+
+	t.Run("test publish with empty body", func(t *testing.T) {
+		err := client.Publish(&MessagePublishOptions{QueueName: "add-product"})
+		assert.Error(t, err)
+	})
+}
+
+func TestMemoryVisibilityTimeoutAndDelay(t *testing.T) {
+	client, now := newMemoryTestClient(t)
+	options := &MessageConsumeOptions{QueueName: "policy", NumberOfMessages: 1, VisibilityTimeout: 10}
+
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "policy", MessageBody: "delayed", DelayInSeconds: 60}))
+
+	t.Run("test delayed message is not visible", func(t *testing.T) {
+		msg, err := client.Consume(options)
+		assert.Nil(t, err)
+		assert.Nil(t, msg)
+		assert.Equal(t, 1, client.Depth("policy"))
+	})
+
+	t.Run("test ok message is redelivered after visibility timeout", func(t *testing.T) {
+		*now = now.Add(time.Minute)
+		first, err := client.Consume(options)
+		require.NoError(t, err)
+		require.NotNil(t, first)
+
+		msg, _ := client.Consume(options)
+		assert.Nil(t, msg)
+
+		*now = now.Add(11 * time.Second)
+		second, err := client.Consume(options)
+		require.NoError(t, err)
+		require.NotNil(t, second)
+		assert.Equal(t, first.MessageID, second.MessageID)
+		assert.Equal(t, int64(2), second.ReceiveCount)
+		assert.NotEqual(t, first.MessageReceiptHandle, second.MessageReceiptHandle)
+
+		assert.Error(t, client.DeleteMessage("policy", first))
+		assert.Nil(t, client.DeleteMessage("policy", second))
+	})
+}
+
+func TestMemoryFIFOMessageGroups(t *testing.T) {
+	client, _ := newMemoryTestClient(t)
+
+	result, err := client.BatchPublish(&MessagePublishOptions{
+		QueueName: "stoploss.fifo",
+		Entries: []MessagePublishEntry{
+			{MessageBody: "a1", MessageGroupID: "a", MessageDeduplicationID: "a1"},
+			{MessageBody: "a1", MessageGroupID: "a", MessageDeduplicationID: "a1"},
+			{MessageBody: "a2", MessageGroupID: "a", MessageDeduplicationID: "a2"},
+			{MessageBody: "b1", MessageGroupID: "b", MessageDeduplicationID: "b1"},
+			{MessageBody: "no-group"},
+		},
+	})
+	require.NoError(t, err)
+
+	t.Run("test message without group is rejected", func(t *testing.T) {
+		require.Len(t, result.Failed, 1)
+		assert.Equal(t, "4", result.Failed[0].ID)
+	})
+
+	t.Run("test ok duplicate returns the ID of the first message", func(t *testing.T) {
+		require.Len(t, result.Successful, 4)
+		assert.NotEmpty(t, result.Successful[0].MessageID)
+		assert.Equal(t, result.Successful[0].MessageID, result.Successful[1].MessageID)
+		assert.NotEqual(t, result.Successful[0].MessageID, result.Successful[2].MessageID)
+	})
+
+	t.Run("test ok one message in flight per group", func(t *testing.T) {
+		msgs, err := client.BatchConsume(&MessageConsumeOptions{QueueName: "stoploss.fifo", NumberOfMessages: 10})
+		assert.Nil(t, err)
+		require.Len(t, msgs, 2)
+		assert.Equal(t, "a1", msgs[0].MessageBody)
+		assert.Equal(t, "b1", msgs[1].MessageBody)
+
+		_, err = client.BatchDeleteMessage("stoploss.fifo", msgs)
+		assert.Nil(t, err)
+
+		msgs, err = client.BatchConsume(&MessageConsumeOptions{QueueName: "stoploss.fifo", NumberOfMessages: 10})
+		assert.Nil(t, err)
+		require.Len(t, msgs, 1)
+		assert.Equal(t, "a2", msgs[0].MessageBody)
+	})
+}
+
+func TestMemoryDeadLetterQueue(t *testing.T) {
+	client, now := newMemoryTestClient(t)
+	client.CreateQueue("finance", MemoryQueueOptions{
+		VisibilityTimeout: time.Second,
+		MaxReceiveCount:   2,
+		DeadLetterQueue:   "finance-dlq",
+	})
+	options := &MessageConsumeOptions{QueueName: "finance"}
+
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "poison"}))
+
+	for i := 0; i < 2; i++ {
+		msg, err := client.Consume(options)
+		require.NoError(t, err)
+		require.NotNil(t, msg)
+		*now = now.Add(2 * time.Second)
+	}
+
+	t.Run("test ok message is moved to dead letter queue after max receive count", func(t *testing.T) {
+		msg, err := client.Consume(options)
+		assert.Nil(t, err)
+		assert.Nil(t, msg)
+		assert.Equal(t, 0, client.Depth("finance"))
+		assert.Equal(t, 1, client.Depth("finance-dlq"))
+		assert.Equal(t, []string{"finance", "finance-dlq"}, client.QueueNames())
+	})
+
+	t.Run("test ok drain dead letter queue", func(t *testing.T) {
+		assert.Equal(t, []interface{}{"poison"}, client.Drain("finance-dlq"))
+		assert.Equal(t, 0, client.Depth("finance-dlq"))
+	})
+}
@@ -0,0 +1,98 @@
+package transporter
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MemoryTopic is an in-process TopicClient for tests and local development, it
+// publishes a copy of every topic message to the matching subscribed queues of Client
+type MemoryTopic struct {
+	Client Client
+
+	mu            sync.RWMutex
+	subscriptions map[string][]TopicSubscription
+}
+
+// NewMemoryTopic returns a MemoryTopic fanning out to the queues of client, which
+// is usually a Memory client
+func NewMemoryTopic(client Client) *MemoryTopic {
+	return &MemoryTopic{
+		Client:        client,
+		subscriptions: map[string][]TopicSubscription{},
+	}
+}
+
+// PublishToTopic publishes the message to every matching queue, it stops at the
+// first queue that fails
+func (t *MemoryTopic) PublishToTopic(options *MessagePublishOptions) error {
+	if options.TopicName == "" {
+		return fmt.Errorf("topic name is empty")
+	}
+
+	t.mu.RLock()
+	subscriptions := append([]TopicSubscription(nil), t.subscriptions[options.TopicName]...)
+	t.mu.RUnlock()
+
+	for _, subscription := range subscriptions {
+		if !subscription.FilterPolicy.Match(options.Attributes) {
+			continue
+		}
+		err := t.Client.Publish(&MessagePublishOptions{
+			QueueName:   subscription.QueueName,
+			TopicName:   options.TopicName,
+			MessageBody: options.MessageBody,
+			Attributes:  options.Attributes,
+		})
+		if err != nil {
+			logger.Errorf("error in publishing message of topic: %s to queue: %s, %s", options.TopicName, subscription.QueueName, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe adds the queue to the topic, subscribing the same queue again replaces
+// its filter policy
+func (t *MemoryTopic) Subscribe(topicName string, queueName string, filterPolicy FilterPolicy) (*TopicSubscription, error) {
+	subscription := TopicSubscription{
+		ID:           topicName + "/" + queueName,
+		TopicName:    topicName,
+		QueueName:    queueName,
+		FilterPolicy: filterPolicy,
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.subscriptions == nil {
+		t.subscriptions = map[string][]TopicSubscription{}
+	}
+	subscriptions := t.subscriptions[topicName]
+	for i := range subscriptions {
+		if subscriptions[i].QueueName == queueName {
+			subscriptions[i] = subscription
+			return &subscription, nil
+		}
+	}
+	t.subscriptions[topicName] = append(subscriptions, subscription)
+	return &subscription, nil
+}
+
+func (t *MemoryTopic) Unsubscribe(subscription *TopicSubscription) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	subscriptions := t.subscriptions[subscription.TopicName]
+	for i := range subscriptions {
+		if subscriptions[i].ID == subscription.ID {
+			t.subscriptions[subscription.TopicName] = append(subscriptions[:i:i], subscriptions[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("subscription: %s does not exist", subscription.ID)
+}
+
+func (t *MemoryTopic) ListSubscriptions(topicName string) ([]TopicSubscription, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]TopicSubscription(nil), t.subscriptions[topicName]...), nil
+}
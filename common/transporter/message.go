@@ -5,17 +5,37 @@ package transporter
 //* MessageBody will be the payload sent to the queue
 //* Alicloud casts the MessageBody to String
 //* NATS casts the MessageBody to []byte
-//* TopicName is the name of topic - this is optional for Alicloud
+//* TopicName is the name of topic - this is optional for Alicloud, TopicClient.PublishToTopic fans it out to the subscribed queues
 //* Priority is used in a PriorityQueue setting in Alicloud - (only used for Alicloud)
 //* value for Priority should be between 1 to 16
 //* DelayInSeconds states the messages cannot be consumed until the period specified by the DelayInSeconds parameter ends.
+//* MessageDeduplicationID is used by FIFO queues to drop duplicates sent within the deduplication interval
+//* Entries carries the messages for BatchPublish, each with its own group ID, dedup ID and delay
+//* Attributes are sent along the body as SQS MessageAttributes, NATS headers or MNS properties
 type MessagePublishOptions struct {
-	MessageBody    interface{} `json:"message_body"`
-	QueueName      string
-	TopicName      string
-	Priority       int64
-	DelayInSeconds int64
-	MessageGroupID string
+	MessageBody            interface{} `json:"message_body"`
+	QueueName              string
+	TopicName              string
+	Priority               int64
+	DelayInSeconds         int64
+	MessageGroupID         string
+	MessageDeduplicationID string
+	Entries                []MessagePublishEntry
+	Attributes             map[string]string
+}
+
+//MessagePublishEntry is a single message sent through BatchPublish.
+//* ID identifies the entry in the BatchResult, it is generated from the entry index when empty
+//* MessageBody, MessageGroupID, MessageDeduplicationID and DelayInSeconds override the
+//values on MessagePublishOptions for this entry only
+//* Attributes are merged with the ones of MessagePublishOptions, the entry wins on conflicts
+type MessagePublishEntry struct {
+	ID                     string
+	MessageBody            interface{}
+	MessageGroupID         string
+	MessageDeduplicationID string
+	DelayInSeconds         int64
+	Attributes             map[string]string
 }
 
 //MessageConsumeOptions used when consuming a message across different
@@ -40,10 +60,54 @@ type MessageConsumeOptions struct {
 //* MessageSubject - similar to nats.Msg subject
 //* MessageBody - payload from consumer
 //* MessageId - unique ID when message is sent
+//* MessageGroupID - the group of a message received from a FIFO queue
+//* ReceiveCount - number of times the message has been delivered, when the provider reports it
+//* RetryAttempts - failed attempts of a message republished by a RetryPolicy
+//* Attributes - the attributes the message was published with, including the trace and request ID
 type MessageReceiveResponse struct {
 	MessageBody              interface{}
 	MessageSubject           string
 	MessageID                string
+	MessageGroupID           string
 	MessageReceiptHandle     string
 	MessageVisibilityTimeout int64
+	ReceiveCount             int64
+	RetryAttempts            []RetryAttempt
+	Attributes               map[string]string
+
+	// claimCheck is the reference the body was restored from, see ClaimCheckOption
+	claimCheck string
+}
+
+//BatchResultEntry is the outcome of a single entry in a batch request
+//* ID - the entry ID for publish or the MessageID for delete
+//* MessageID - the ID assigned by the provider for a published message
+//* Code and Message - the provider error code and description for failed entries
+//* SenderFault - true when the entry failed because of the request and retrying it as is will not help
+type BatchResultEntry struct {
+	ID          string
+	MessageID   string
+	Code        string
+	Message     string
+	SenderFault bool
+}
+
+//BatchResult lists which entries of a BatchPublish or BatchDeleteMessage call
+//succeeded and which failed.
+type BatchResult struct {
+	Successful []BatchResultEntry
+	Failed     []BatchResultEntry
+}
+
+//HasFailed returns true if at least one entry of the batch failed
+func (r *BatchResult) HasFailed() bool {
+	return r != nil && len(r.Failed) > 0
+}
+
+func (r *BatchResult) merge(other *BatchResult) {
+	if other == nil {
+		return
+	}
+	r.Successful = append(r.Successful, other.Successful...)
+	r.Failed = append(r.Failed, other.Failed...)
 }
@@ -33,16 +33,23 @@ func (natsClient NatsClient) Consume(options *MessageConsumeOptions) (*MessageRe
 	return &MessageReceiveResponse{
 		MessageBody:    natsMsg.Data,
 		MessageSubject: natsMsg.Subject,
+		Attributes:     natsAttributes(natsMsg.Header),
 	}, nil
 }
 func (natsClient NatsClient) BatchConsume(options *MessageConsumeOptions) ([]MessageReceiveResponse, error) {
 	return nil, fmt.Errorf("Method BatchConsume not implemented for NATS provider")
 }
 
-// Publish module to publish nats message for given topic and data
+// Publish module to publish nats message for given topic and data,
+// Attributes are sent as the message headers
 func (natsClient NatsClient) Publish(options *MessagePublishOptions) error {
 
-	err := natsClient.EncodedConnection.Publish(options.TopicName, options.MessageBody)
+	var err error
+	if len(options.Attributes) == 0 {
+		err = natsClient.EncodedConnection.Publish(options.TopicName, options.MessageBody)
+	} else {
+		err = natsClient.publishWithHeader(options)
+	}
 	if err != nil {
 		logger.Errorf("Error in publishing message for topic: %s, for: %s", options.TopicName, err)
 		return err
@@ -50,14 +57,59 @@ func (natsClient NatsClient) Publish(options *MessagePublishOptions) error {
 	return nil
 }
 
-func (natsClient NatsClient) BatchPublish(options *MessagePublishOptions) error {
-	return fmt.Errorf("Method BatchPublish not implemented yet for NATS provider")
+func (natsClient NatsClient) publishWithHeader(options *MessagePublishOptions) error {
+	data, err := natsClient.EncodedConnection.Enc.Encode(options.TopicName, options.MessageBody)
+	if err != nil {
+		return err
+	}
+	return natsClient.EncodedConnection.Conn.PublishMsg(&nats.Msg{
+		Subject: options.TopicName,
+		Data:    data,
+		Header:  natsHeader(options.Attributes),
+	})
+}
+
+func (natsClient NatsClient) BatchPublish(options *MessagePublishOptions) (*BatchResult, error) {
+	return nil, fmt.Errorf("Method BatchPublish not implemented yet for NATS provider")
 }
 
 func (natsClient NatsClient) DeleteMessage(queueName string, message *MessageReceiveResponse) error {
 	return fmt.Errorf("Method DeleteMessage not implemented yet for NATS provider")
 }
 
-func (natsClient NatsClient) BatchDeleteMessage(queueName string, messages []MessageReceiveResponse) error {
-	return fmt.Errorf("Method BatchDeleteMessage not implemented yet for NATS provider")
+func (natsClient NatsClient) BatchDeleteMessage(queueName string, messages []MessageReceiveResponse) (*BatchResult, error) {
+	return nil, fmt.Errorf("Method BatchDeleteMessage not implemented yet for NATS provider")
+}
+
+func (natsClient NatsClient) ChangeVisibility(queueName string, message *MessageReceiveResponse, visibilityTimeout int64) error {
+	return fmt.Errorf("Method ChangeVisibility not implemented yet for NATS provider")
+}
+
+// natsHeader sends the attributes as NATS headers, nil is returned when there is none
+func natsHeader(attributes map[string]string) nats.Header {
+	if len(attributes) == 0 {
+		return nil
+	}
+	header := nats.Header{}
+	for name, value := range attributes {
+		// Set would canonicalize the name, keep it as published
+		header[name] = []string{value}
+	}
+	return header
+}
+
+// natsAttributes returns the headers of a received message, leaving out the
+// Nats- headers set by the server and by the publish options
+func natsAttributes(header nats.Header) map[string]string {
+	var attributes map[string]string
+	for name, values := range header {
+		if len(values) == 0 || strings.HasPrefix(name, "Nats-") {
+			continue
+		}
+		if attributes == nil {
+			attributes = map[string]string{}
+		}
+		attributes[name] = values[0]
+	}
+	return attributes
 }
@@ -0,0 +1,131 @@
+package transporter
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NatsTopic implements TopicClient with NATS subjects. A topic is a core NATS subject
+// and a subscription is a queue group on it, named after the queue, which publishes the
+// matching messages to the queue through Client. Only one replica of the group forwards
+// each message.
+//
+// Subscriptions live as long as the process that made them, every replica of the
+// consuming service should subscribe at startup. Messages published to a topic with
+// no subscription are dropped like any core NATS message
+type NatsTopic struct {
+	Connection *nats.Conn
+	// Client receives the messages of the subscribed queues, usually the JetStream client
+	Client Client
+
+	mu            sync.Mutex
+	subscriptions map[string]*natsTopicSubscription
+}
+
+type natsTopicSubscription struct {
+	TopicSubscription
+	sub *nats.Subscription
+}
+
+// NewNatsTopic returns a NATS topic client forwarding to the queues of client
+func NewNatsTopic(conn *nats.Conn, client Client) *NatsTopic {
+	return &NatsTopic{
+		Connection:    conn,
+		Client:        client,
+		subscriptions: map[string]*natsTopicSubscription{},
+	}
+}
+
+func (t *NatsTopic) PublishToTopic(options *MessagePublishOptions) error {
+	if options.TopicName == "" {
+		return fmt.Errorf("topic name is empty")
+	}
+	data, err := messageBodyBytes(options.MessageBody)
+	if err != nil {
+		return err
+	}
+
+	err = t.Connection.PublishMsg(&nats.Msg{
+		Subject: options.TopicName,
+		Data:    data,
+		Header:  natsHeader(options.Attributes),
+	})
+	if err != nil {
+		logger.Errorf("error in publishing message to NATS topic: %s, for: %s", options.TopicName, err)
+		return err
+	}
+	return nil
+}
+
+// Subscribe starts forwarding the messages of the topic matching filterPolicy to the
+// queue, subscribing the same queue again replaces the subscription
+func (t *NatsTopic) Subscribe(topicName string, queueName string, filterPolicy FilterPolicy) (*TopicSubscription, error) {
+	subscription := &natsTopicSubscription{
+		TopicSubscription: TopicSubscription{
+			ID:           topicName + "/" + queueName,
+			TopicName:    topicName,
+			QueueName:    queueName,
+			FilterPolicy: filterPolicy,
+		},
+	}
+
+	sub, err := t.Connection.QueueSubscribe(topicName, jetStreamName(queueName), func(msg *nats.Msg) {
+		attributes := natsAttributes(msg.Header)
+		if !filterPolicy.Match(attributes) {
+			return
+		}
+		err := t.Client.Publish(&MessagePublishOptions{
+			QueueName:   queueName,
+			TopicName:   topicName,
+			MessageBody: string(msg.Data),
+			Attributes:  attributes,
+		})
+		if err != nil {
+			logger.Errorf("error in forwarding message of NATS topic: %s to queue: %s, %s", topicName, queueName, err)
+		}
+	})
+	if err != nil {
+		logger.Errorf("error in subscribing queue: %s to NATS topic: %s, %s", queueName, topicName, err)
+		return nil, err
+	}
+	subscription.sub = sub
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.subscriptions == nil {
+		t.subscriptions = map[string]*natsTopicSubscription{}
+	}
+	if previous, ok := t.subscriptions[subscription.ID]; ok {
+		_ = previous.sub.Unsubscribe()
+	}
+	t.subscriptions[subscription.ID] = subscription
+	return &subscription.TopicSubscription, nil
+}
+
+func (t *NatsTopic) Unsubscribe(subscription *TopicSubscription) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	current, ok := t.subscriptions[subscription.ID]
+	if !ok {
+		return fmt.Errorf("subscription: %s does not exist", subscription.ID)
+	}
+	delete(t.subscriptions, subscription.ID)
+	return current.sub.Unsubscribe()
+}
+
+// ListSubscriptions returns the subscriptions made by this process
+func (t *NatsTopic) ListSubscriptions(topicName string) ([]TopicSubscription, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var subscriptions []TopicSubscription
+	for _, subscription := range t.subscriptions {
+		if subscription.TopicName == topicName {
+			subscriptions = append(subscriptions, subscription.TopicSubscription)
+		}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].ID < subscriptions[j].ID })
+	return subscriptions, nil
+}
@@ -0,0 +1,458 @@
+package transporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	ali_mns "github.com/aliyun/aliyun-mns-go-sdk"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	DefaultPublishMaxAttempts      = 3
+	DefaultPublishInitialBackoff   = 100 * time.Millisecond
+	DefaultPublishMaxBackoff       = 5 * time.Second
+	DefaultBreakerFailureThreshold = 5
+	DefaultBreakerOpenTimeout      = 30 * time.Second
+	DefaultSpoolFlushInterval      = 10 * time.Second
+
+	// MetricSpoolMessages, MetricSpoolBytes and MetricSpoolAge are gauges of the messages
+	// waiting in the spool, the age is the one of the oldest message in seconds
+	MetricSpoolMessages = "transporter.spool.messages"
+	MetricSpoolBytes    = "transporter.spool.bytes"
+	MetricSpoolAge      = "transporter.spool.age_seconds"
+	// MetricBreakerOpen is 1 while the circuit breaker of the publisher is not closed
+	MetricBreakerOpen = "transporter.publisher.breaker_open"
+)
+
+// ErrCircuitBreakerOpen is returned by a ResilientPublisher without spool while its
+// circuit breaker is open
+var ErrCircuitBreakerOpen = errors.New("circuit breaker is open")
+
+// GaugeMetrics receives the spool metrics of a ResilientPublisher, datadog.Datadog implements it
+type GaugeMetrics interface {
+	SendGaugeMetric(name string, value float64, tags ...string)
+}
+
+// PublisherOption configures a ResilientPublisher
+//   - MaxAttempts, InitialBackoff and MaxBackoff - a transient error is retried with
+//     a full jitter backoff doubling from InitialBackoff up to MaxBackoff
+//   - FailureThreshold and OpenTimeout - the breaker opens after FailureThreshold
+//     consecutive transient errors and lets a single publish through after OpenTimeout
+//   - SpoolPath - the file the messages are appended to while the breaker is open or
+//     after their last attempt, without it they are returned as errors
+//   - FlushInterval - how often RunFlusher re-drives the spool and sends the metrics
+type PublisherOption struct {
+	MaxAttempts      int
+	InitialBackoff   time.Duration
+	MaxBackoff       time.Duration
+	FailureThreshold int
+	OpenTimeout      time.Duration
+	SpoolPath        string
+	FlushInterval    time.Duration
+	Metrics          GaugeMetrics
+}
+
+// ResilientPublisher publishes through a Client with retries and a circuit breaker,
+// spilling the messages to a local spool while the provider is down. It is shared by
+// the producers of a provider through ProducerOption.Publisher and RunFlusher should
+// be running for the spooled messages to be re-driven. Spooled messages are published
+// after the newer ones sent once the provider recovered, except for the messages of a
+// MessageGroupID with spooled messages which are spooled behind them to keep their order
+type ResilientPublisher struct {
+	client  Client
+	option  PublisherOption
+	breaker *CircuitBreaker
+	spool   *Spool
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+// NewResilientPublisher returns a publisher sending the messages with client
+func NewResilientPublisher(client Client, option *PublisherOption) (*ResilientPublisher, error) {
+	if client == nil {
+		return nil, errors.New("client is nil")
+	}
+	o := PublisherOption{}
+	if option != nil {
+		o = *option
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultPublishMaxAttempts
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = DefaultPublishInitialBackoff
+	}
+	if o.MaxBackoff < o.InitialBackoff {
+		o.MaxBackoff = DefaultPublishMaxBackoff
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = DefaultSpoolFlushInterval
+	}
+
+	p := &ResilientPublisher{
+		client:  client,
+		option:  o,
+		breaker: NewCircuitBreaker(o.FailureThreshold, o.OpenTimeout),
+		sleep:   sleepContext,
+	}
+	if o.SpoolPath != "" {
+		spool, err := NewSpool(o.SpoolPath)
+		if err != nil {
+			return nil, err
+		}
+		p.spool = spool
+	}
+	return p, nil
+}
+
+// Breaker returns the circuit breaker of the publisher
+func (p *ResilientPublisher) Breaker() *CircuitBreaker {
+	return p.breaker
+}
+
+// Spool returns the spool of the publisher, nil without PublisherOption.SpoolPath
+func (p *ResilientPublisher) Spool() *Spool {
+	return p.spool
+}
+
+// Publish sends options, retrying the transient errors. The message is spooled instead
+// when the breaker is open or its last attempt failed with a transient error, in which
+// case nil is returned
+func (p *ResilientPublisher) Publish(ctx context.Context, options *MessagePublishOptions) error {
+	if options.MessageGroupID != "" && p.spool != nil {
+		spooled, err := p.spool.HasGroup(options.QueueName, options.TopicName, options.MessageGroupID)
+		if err != nil {
+			return fmt.Errorf("failed to read spool for message group: %s, %w", options.MessageGroupID, err)
+		}
+		if spooled {
+			return p.spill(options, fmt.Errorf("message group: %s has spooled messages", options.MessageGroupID))
+		}
+	}
+	if !p.breaker.Allow() {
+		return p.spill(options, ErrCircuitBreakerOpen)
+	}
+
+	var err error
+	for attempt := 1; attempt <= p.option.MaxAttempts; attempt++ {
+		err = p.client.Publish(options)
+		if err == nil {
+			p.breaker.Success()
+			return nil
+		}
+		if !IsTransientError(err) {
+			p.breaker.Release()
+			return err
+		}
+		p.breaker.Failure()
+		if attempt == p.option.MaxAttempts || !p.breaker.Allow() {
+			break
+		}
+
+		backoff := fullJitter(p.option.InitialBackoff, p.option.MaxBackoff, attempt)
+		logger.Errorf("failed to publish message to queue: %s, attempt %d of %d, retrying in %s: %s", options.QueueName, attempt, p.option.MaxAttempts, backoff, err)
+		if sleepErr := p.sleep(ctx, backoff); sleepErr != nil {
+			break
+		}
+	}
+	return p.spill(options, err)
+}
+
+// spill appends options to the spool, cause is returned when there is no spool
+func (p *ResilientPublisher) spill(options *MessagePublishOptions, cause error) error {
+	if p.spool == nil {
+		return cause
+	}
+	if err := p.spool.Append(options); err != nil {
+		logger.Errorf("failed to spool message to queue: %s: %s", options.QueueName, err)
+		return cause
+	}
+	logger.Infof("spooled message to queue: %s: %s", options.QueueName, cause)
+	return nil
+}
+
+// Flush re-drives the spooled messages while the breaker lets them through and returns
+// how many were published
+func (p *ResilientPublisher) Flush() (int, error) {
+	if p.spool == nil {
+		return 0, nil
+	}
+	return p.spool.Flush(func(options *MessagePublishOptions) error {
+		if !p.breaker.Allow() {
+			return ErrCircuitBreakerOpen
+		}
+		err := p.client.Publish(options)
+		switch {
+		case err == nil:
+			p.breaker.Success()
+		case IsTransientError(err):
+			p.breaker.Failure()
+		default:
+			p.breaker.Release()
+		}
+		return err
+	})
+}
+
+// RunFlusher flushes the spool and sends its metrics every FlushInterval until ctx is cancelled
+func (p *ResilientPublisher) RunFlusher(ctx context.Context) {
+	ticker := time.NewTicker(p.option.FlushInterval)
+	defer ticker.Stop()
+	for {
+		p.flushAndReport()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *ResilientPublisher) flushAndReport() {
+	if p.spool != nil {
+		flushed, err := p.Flush()
+		if flushed > 0 {
+			logger.Infof("re-drove %d spooled messages", flushed)
+		}
+		if err != nil && !errors.Is(err, ErrCircuitBreakerOpen) {
+			logger.Errorf("failed to flush spool: %s", err)
+		}
+	}
+	p.reportMetrics()
+}
+
+func (p *ResilientPublisher) reportMetrics() {
+	if p.option.Metrics == nil {
+		return
+	}
+	breakerOpen := 0.0
+	if p.breaker.State() != BreakerClosed {
+		breakerOpen = 1
+	}
+	p.option.Metrics.SendGaugeMetric(MetricBreakerOpen, breakerOpen)
+
+	if p.spool == nil {
+		return
+	}
+	stats, err := p.spool.Stats()
+	if err != nil {
+		logger.Errorf("failed to read spool stats: %s", err)
+		return
+	}
+	age := 0.0
+	if stats.Messages > 0 {
+		age = time.Since(stats.OldestSpooledAt).Seconds()
+	}
+	p.option.Metrics.SendGaugeMetric(MetricSpoolMessages, float64(stats.Messages))
+	p.option.Metrics.SendGaugeMetric(MetricSpoolBytes, float64(stats.Bytes))
+	p.option.Metrics.SendGaugeMetric(MetricSpoolAge, age)
+}
+
+// IsTransientError reports whether publishing again may succeed. Throttling, server and
+// network errors are transient, errors caused by the request or the credentials are not,
+// nor are the errors raised locally before the message reached the provider
+func IsTransientError(err error) bool {
+	if err == nil || IsPermanent(err) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if request.IsErrorThrottle(err) || errors.Is(err, ErrCircuitBreakerOpen) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case sqs.ErrCodeQueueDoesNotExist, sqs.ErrCodeInvalidMessageContents, sqs.ErrCodeUnsupportedOperation,
+			"InvalidParameterValue", "MissingParameter", "AccessDenied", "InvalidClientTokenId", "SignatureDoesNotMatch":
+			return false
+		}
+		var failure awserr.RequestFailure
+		if errors.As(err, &failure) && failure.StatusCode() >= 400 && failure.StatusCode() < 500 {
+			return false
+		}
+		return true
+	}
+
+	var mnsErr interface{ Namespace() string }
+	if errors.As(err, &mnsErr) && mnsErr.Namespace() == ali_mns.ALI_MNS_ERR_NS {
+		for _, nonTransient := range []interface{ IsEqual(error) bool }{
+			&ali_mns.ERR_SIGN_MESSAGE_FAILED,
+			&ali_mns.ERR_MARSHAL_MESSAGE_FAILED,
+			&ali_mns.ERR_MNS_ACCESS_DENIED,
+			&ali_mns.ERR_MNS_INVALID_ACCESS_KEY_ID,
+			&ali_mns.ERR_MNS_INVALID_ARGUMENT,
+			&ali_mns.ERR_MNS_QUEUE_NOT_EXIST,
+			&ali_mns.ERR_MNS_INVALID_QUEUE_NAME,
+			&ali_mns.ERR_MNS_SIGNATURE_DOES_NOT_MATCH,
+			&ali_mns.ERR_MNS_TOPIC_NOT_EXIST,
+		} {
+			if nonTransient.IsEqual(err) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return isNetworkError(err)
+}
+
+// isNetworkError reports whether err comes from the connection to the provider rather
+// than from the message or the caller
+func isNetworkError(err error) bool {
+	var (
+		netErr net.Error
+		errno  syscall.Errno
+	)
+	if errors.As(err, &netErr) || errors.As(err, &errno) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	for _, natsErr := range []error{
+		nats.ErrTimeout, nats.ErrConnectionClosed, nats.ErrConnectionDraining, nats.ErrConnectionReconnecting,
+		nats.ErrDisconnected, nats.ErrNoServers, nats.ErrNoResponders, nats.ErrNoStreamResponse,
+	} {
+		if errors.Is(err, natsErr) {
+			return true
+		}
+	}
+	// redis replies while the server is loading, failing over or out of connections
+	message := err.Error()
+	for _, prefix := range []string{"LOADING ", "READONLY ", "CLUSTERDOWN ", "TRYAGAIN ", "MASTERDOWN ", "ERR max number of clients reached"} {
+		if strings.HasPrefix(message, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// fullJitter returns a random backoff up to initial doubled attempt-1 times, capped at max
+func fullJitter(initial, max time.Duration, attempt int) time.Duration {
+	backoff := time.Duration(float64(initial) * math.Pow(2, float64(attempt-1)))
+	if backoff > max || backoff <= 0 {
+		backoff = max
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// BreakerState is the state of a CircuitBreaker
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// CircuitBreaker opens after FailureThreshold consecutive failures and stays open for
+// OpenTimeout, it then lets a single call through and closes again when it succeeds
+type CircuitBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	openTimeout      time.Duration
+	failures         int
+	state            BreakerState
+	openedAt         time.Time
+	trialInFlight    bool
+	now              func() time.Time
+}
+
+// NewCircuitBreaker returns a closed breaker, the defaults are used for zero values
+func NewCircuitBreaker(failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	if failureThreshold <= 0 {
+		failureThreshold = DefaultBreakerFailureThreshold
+	}
+	if openTimeout <= 0 {
+		openTimeout = DefaultBreakerOpenTimeout
+	}
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		state:            BreakerClosed,
+		now:              time.Now,
+	}
+}
+
+// Allow reports whether a call may go through
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.trialInFlight = true
+		return true
+	case BreakerHalfOpen:
+		if b.trialInFlight {
+			return false
+		}
+		b.trialInFlight = true
+		return true
+	}
+	return true
+}
+
+// Success closes the breaker
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.trialInFlight = false
+}
+
+// Release gives back the trial of a half-open breaker without closing or opening it,
+// for calls that failed for a reason unrelated to the provider
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trialInFlight = false
+}
+
+// Failure counts a failed call, it opens the breaker again after a failed trial
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trialInFlight = false
+	if b.state == BreakerHalfOpen || b.failures >= b.failureThreshold {
+		if b.state != BreakerOpen {
+			logger.Errorf("circuit breaker opened after %d consecutive failures", b.failures)
+		}
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// State returns the current state of the breaker
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.openTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
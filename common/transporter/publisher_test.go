@@ -0,0 +1,326 @@
+package transporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	ali_mns "github.com/aliyun/aliyun-mns-go-sdk"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyClient fails the publishes with err while it is set
+type flakyClient struct {
+	*Memory
+	mu    sync.Mutex
+	err   error
+	calls int
+}
+
+func (f *flakyClient) Publish(options *MessagePublishOptions) error {
+	f.mu.Lock()
+	f.calls++
+	err := f.err
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return f.Memory.Publish(options)
+}
+
+func (f *flakyClient) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+	f.calls = 0
+}
+
+type fakeGaugeMetrics struct {
+	gauges map[string]float64
+}
+
+func (m *fakeGaugeMetrics) SendGaugeMetric(name string, value float64, tags ...string) {
+	m.gauges[name] = value
+}
+
+func newTestResilientPublisher(t *testing.T, client Client, option *PublisherOption) (*ResilientPublisher, *time.Time) {
+	publisher, err := NewResilientPublisher(client, option)
+	require.NoError(t, err)
+	publisher.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	publisher.breaker.now = func() time.Time { return now }
+	return publisher, &now
+}
+
+func TestResilientPublisher(t *testing.T) {
+	unavailable := awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "service unavailable", nil), 503, "req-1")
+	client := &flakyClient{Memory: NewMemory()}
+	metrics := &fakeGaugeMetrics{gauges: map[string]float64{}}
+	publisher, now := newTestResilientPublisher(t, client, &PublisherOption{
+		MaxAttempts:      3,
+		FailureThreshold: 5,
+		OpenTimeout:      time.Minute,
+		SpoolPath:        filepath.Join(t.TempDir(), "spool", "finance.ndjson"),
+		Metrics:          metrics,
+	})
+
+	publish := func(body string) error {
+		return publisher.Publish(context.Background(), &MessagePublishOptions{
+			QueueName:   "finance",
+			MessageBody: body,
+			Attributes:  map[string]string{AttributeRequestID: "req-" + body},
+		})
+	}
+
+	t.Run("test ok transient error is retried", func(t *testing.T) {
+		client.fail(unavailable)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			client.fail(nil)
+		}()
+		publisher.sleep = func(ctx context.Context, d time.Duration) error {
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		}
+		defer func() { publisher.sleep = func(ctx context.Context, d time.Duration) error { return nil } }()
+
+		assert.Nil(t, publish("UPDATE_STOPLOSS_1"))
+		assert.Equal(t, []interface{}{"UPDATE_STOPLOSS_1"}, client.Drain("finance"))
+		assert.Equal(t, BreakerClosed, publisher.Breaker().State())
+	})
+
+	t.Run("test wrong non transient error is not retried", func(t *testing.T) {
+		client.fail(awserr.New(sqs.ErrCodeQueueDoesNotExist, "queue does not exist", nil))
+		assert.NotNil(t, publish("UPDATE_STOPLOSS_2"))
+		assert.Equal(t, 1, client.calls)
+		stats, err := publisher.Spool().Stats()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, stats.Messages)
+	})
+
+	t.Run("test ok messages are spooled once retries are exhausted and the breaker is open", func(t *testing.T) {
+		client.fail(unavailable)
+		assert.Nil(t, publish("UPDATE_STOPLOSS_3"))
+		assert.Equal(t, 3, client.calls)
+		assert.Equal(t, BreakerClosed, publisher.Breaker().State())
+
+		assert.Nil(t, publish("UPDATE_STOPLOSS_4"))
+		assert.Equal(t, 5, client.calls)
+		assert.Equal(t, BreakerOpen, publisher.Breaker().State())
+
+		assert.Nil(t, publish("UPDATE_STOPLOSS_5"))
+		assert.Equal(t, 5, client.calls, "the provider is not called while the breaker is open")
+
+		stats, err := publisher.Spool().Stats()
+		require.NoError(t, err)
+		assert.Equal(t, 3, stats.Messages)
+		assert.Greater(t, stats.Bytes, int64(0))
+		assert.False(t, stats.OldestSpooledAt.IsZero())
+	})
+
+	t.Run("test ok metrics of the spool and the breaker", func(t *testing.T) {
+		publisher.reportMetrics()
+		assert.Equal(t, 3.0, metrics.gauges[MetricSpoolMessages])
+		assert.Greater(t, metrics.gauges[MetricSpoolBytes], 0.0)
+		assert.Equal(t, 1.0, metrics.gauges[MetricBreakerOpen])
+	})
+
+	t.Run("test ok flush waits for the breaker", func(t *testing.T) {
+		flushed, err := publisher.Flush()
+		assert.Equal(t, 0, flushed)
+		assert.True(t, errors.Is(err, ErrCircuitBreakerOpen))
+	})
+
+	t.Run("test wrong failed trial opens the breaker again", func(t *testing.T) {
+		*now = now.Add(time.Minute)
+		flushed, err := publisher.Flush()
+		assert.Equal(t, 0, flushed)
+		assert.NotNil(t, err)
+		assert.Equal(t, BreakerOpen, publisher.Breaker().State())
+	})
+
+	t.Run("test ok spool is re-driven in order once the provider recovers", func(t *testing.T) {
+		client.fail(nil)
+		*now = now.Add(time.Minute)
+		publisher.flushAndReport()
+
+		assert.Equal(t, BreakerClosed, publisher.Breaker().State())
+		messages, err := client.BatchConsume(&MessageConsumeOptions{QueueName: "finance", NumberOfMessages: 10})
+		require.NoError(t, err)
+		require.Len(t, messages, 3)
+		for i, body := range []string{"UPDATE_STOPLOSS_3", "UPDATE_STOPLOSS_4", "UPDATE_STOPLOSS_5"} {
+			assert.Equal(t, body, messages[i].MessageBody)
+			assert.Equal(t, "req-"+body, messages[i].Attributes[AttributeRequestID])
+		}
+		assert.Equal(t, 0.0, metrics.gauges[MetricSpoolMessages])
+		assert.Equal(t, 0.0, metrics.gauges[MetricBreakerOpen])
+	})
+}
+
+func TestResilientPublisherWithoutSpool(t *testing.T) {
+	client := &flakyClient{Memory: NewMemory(), err: syscall.ECONNRESET}
+	publisher, _ := newTestResilientPublisher(t, client, &PublisherOption{MaxAttempts: 2, FailureThreshold: 2})
+
+	producer, err := NewServiceProducer(&ProducerOption{QueueName: "finance", Publisher: publisher})
+	require.NoError(t, err)
+
+	t.Run("test wrong error is returned after the last attempt", func(t *testing.T) {
+		assert.EqualError(t, producer.Produce("UPDATE_STOPLOSS"), "connection reset by peer")
+		assert.Equal(t, 2, client.calls)
+	})
+
+	t.Run("test wrong breaker is open", func(t *testing.T) {
+		assert.Equal(t, ErrCircuitBreakerOpen, producer.Produce("UPDATE_STOPLOSS"))
+	})
+}
+
+func TestResilientPublisherReleasesTrial(t *testing.T) {
+	client := &flakyClient{Memory: NewMemory(), err: syscall.ECONNREFUSED}
+	publisher, now := newTestResilientPublisher(t, client, &PublisherOption{MaxAttempts: 1, FailureThreshold: 1, OpenTimeout: time.Minute})
+	publish := func(queueName string) error {
+		return publisher.Publish(context.Background(), &MessagePublishOptions{QueueName: queueName, MessageBody: "UPDATE_STOPLOSS"})
+	}
+
+	assert.NotNil(t, publish("finance"))
+	assert.Equal(t, BreakerOpen, publisher.Breaker().State())
+
+	t.Run("test wrong non transient error of the trial does not keep the breaker half-open", func(t *testing.T) {
+		*now = now.Add(time.Minute)
+		client.fail(awserr.New(sqs.ErrCodeQueueDoesNotExist, "queue does not exist", nil))
+		assert.NotNil(t, publish("missing"))
+		assert.Equal(t, 1, client.calls)
+
+		client.fail(nil)
+		assert.Nil(t, publish("finance"))
+		assert.Equal(t, BreakerClosed, publisher.Breaker().State())
+		assert.Equal(t, []interface{}{"UPDATE_STOPLOSS"}, client.Drain("finance"))
+	})
+}
+
+func TestResilientPublisherKeepsGroupOrder(t *testing.T) {
+	client := &flakyClient{Memory: NewMemory(), err: syscall.ECONNREFUSED}
+	publisher, _ := newTestResilientPublisher(t, client, &PublisherOption{
+		MaxAttempts:      1,
+		FailureThreshold: 5,
+		SpoolPath:        filepath.Join(t.TempDir(), "spool.ndjson"),
+	})
+	publish := func(groupID, body string) error {
+		return publisher.Publish(context.Background(), &MessagePublishOptions{QueueName: "finance.fifo", MessageBody: body, MessageGroupID: groupID})
+	}
+
+	require.NoError(t, publish("stoploss", "UPDATE_STOPLOSS_1"))
+	client.fail(nil)
+
+	t.Run("test ok new messages of a spooled group are spooled behind it", func(t *testing.T) {
+		assert.Nil(t, publish("stoploss", "UPDATE_STOPLOSS_2"))
+		assert.Nil(t, publish("claim", "CREATE_CLAIM"))
+		assert.Equal(t, 1, client.calls)
+		assert.Equal(t, []interface{}{"CREATE_CLAIM"}, client.Drain("finance.fifo"))
+	})
+
+	t.Run("test ok group is published directly once its spool is flushed", func(t *testing.T) {
+		flushed, err := publisher.Flush()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, flushed)
+		assert.Nil(t, publish("stoploss", "UPDATE_STOPLOSS_3"))
+		assert.Equal(t, []interface{}{"UPDATE_STOPLOSS_1", "UPDATE_STOPLOSS_2", "UPDATE_STOPLOSS_3"}, client.Drain("finance.fifo"))
+	})
+}
+
+func TestSpoolDropsNonTransientFailures(t *testing.T) {
+	spool, err := NewSpool(filepath.Join(t.TempDir(), "spool.ndjson"))
+	require.NoError(t, err)
+	for _, queueName := range []string{"missing", "policy", "policy"} {
+		require.NoError(t, spool.Append(&MessagePublishOptions{QueueName: queueName, MessageBody: "CREATE_POLICY"}))
+	}
+
+	var published []string
+	flushed, err := spool.Flush(func(options *MessagePublishOptions) error {
+		if options.QueueName == "missing" {
+			return awserr.New(sqs.ErrCodeQueueDoesNotExist, "queue does not exist", nil)
+		}
+		published = append(published, options.QueueName)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, flushed)
+	assert.Equal(t, []string{"policy", "policy"}, published)
+
+	stats, err := spool.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Messages)
+}
+
+func TestSpoolIsDurable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool.ndjson")
+	spool, err := NewSpool(path)
+	require.NoError(t, err)
+	require.NoError(t, spool.Append(&MessagePublishOptions{QueueName: "policy", MessageBody: []byte("CREATE_POLICY"), DelayInSeconds: 5}))
+	require.NoError(t, spool.Append(&MessagePublishOptions{QueueName: "policy", MessageBody: map[string]string{"status": "PAID"}}))
+
+	reopened, err := NewSpool(path)
+	require.NoError(t, err)
+
+	var published []*MessagePublishOptions
+	flushed, err := reopened.Flush(func(options *MessagePublishOptions) error {
+		if len(published) == 1 {
+			return syscall.ECONNREFUSED
+		}
+		published = append(published, options)
+		return nil
+	})
+	assert.Equal(t, 1, flushed)
+	assert.NotNil(t, err)
+	assert.Equal(t, "CREATE_POLICY", published[0].MessageBody)
+	assert.Equal(t, int64(5), published[0].DelayInSeconds)
+
+	stats, err := reopened.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Messages)
+
+	flushed, err = reopened.Flush(func(options *MessagePublishOptions) error {
+		published = append(published, options)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, flushed)
+	assert.Equal(t, `{"status":"PAID"}`, published[1].MessageBody)
+}
+
+func TestIsTransientError(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"throttling", awserr.New("Throttling", "rate exceeded", nil), true},
+		{"server error", awserr.NewRequestFailure(awserr.New("InternalError", "internal error", nil), 500, "req-1"), true},
+		{"request timeout", awserr.New("RequestError", "send request failed", errors.New("i/o timeout")), true},
+		{"queue does not exist", awserr.New(sqs.ErrCodeQueueDoesNotExist, "queue does not exist", nil), false},
+		{"bad request", awserr.NewRequestFailure(awserr.New("InvalidAttributeValue", "invalid", nil), 400, "req-1"), false},
+		{"mns queue does not exist", ali_mns.ERR_MNS_QUEUE_NOT_EXIST.New(), false},
+		{"mns internal error", ali_mns.ERR_MNS_INTERNAL_ERROR.New(), true},
+		{"permanent", Permanent(errors.New("invalid body")), false},
+		{"network", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{"nats timeout", nats.ErrTimeout, true},
+		{"redis loading", errors.New("LOADING Redis is loading the dataset in memory"), true},
+		{"breaker open", ErrCircuitBreakerOpen, true},
+		{"local", fmt.Errorf("DelayInSeconds is not supported by the NATS JetStream provider"), false},
+	}
+	for _, c := range cases {
+		t.Run("test "+c.name, func(t *testing.T) {
+			assert.Equal(t, c.transient, IsTransientError(c.err))
+		})
+	}
+}
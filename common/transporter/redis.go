@@ -0,0 +1,431 @@
+package transporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	// RedisStreamsDefaultVisibilityTimeout is how long a message stays pending before
+	// another consumer reclaims it, when the consume options do not set a visibility timeout
+	RedisStreamsDefaultVisibilityTimeout = 30 * time.Second
+
+	redisStreamsBodyField       = "body"
+	redisStreamsSubjectField    = "subject"
+	redisStreamsAttributesField = "attributes"
+)
+
+// RedisStreams implements the Client interface with Redis Streams. Every queue is a
+// stream with a consumer group named after the queue, so each message is delivered to a
+// single consumer of the group. Messages that stay pending longer than the visibility
+// timeout are reclaimed by the next BatchConsume with XAUTOCLAIM.
+type RedisStreams struct {
+	Client redis.UniversalClient
+	// ConsumerName identifies this instance inside the consumer groups,
+	// it defaults to the hostname and the process ID
+	ConsumerName string
+	// MaxLength caps every stream with an approximate MAXLEN on XADD, zero means no cap
+	MaxLength int64
+	// DeleteOnAck removes the entry from the stream with XDEL after it is acked
+	DeleteOnAck bool
+
+	mu     sync.Mutex
+	groups map[string]bool
+}
+
+// RedisStreamsOptions configures NewRedisStreams
+type RedisStreamsOptions struct {
+	ConsumerName string
+	MaxLength    int64
+	DeleteOnAck  bool
+}
+
+// NewRedisStreams returns a Redis Streams client using an existing redis connection,
+// such as the RedisSession of the application context
+func NewRedisStreams(client redis.UniversalClient, options RedisStreamsOptions) *RedisStreams {
+	consumerName := options.ConsumerName
+	if consumerName == "" {
+		hostname, _ := os.Hostname()
+		consumerName = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	return &RedisStreams{
+		Client:       client,
+		ConsumerName: consumerName,
+		MaxLength:    options.MaxLength,
+		DeleteOnAck:  options.DeleteOnAck,
+		groups:       map[string]bool{},
+	}
+}
+
+// HealthCheck pings redis and, if a queue name is given, checks that its stream exists
+func (r *RedisStreams) HealthCheck(options *HealthCheckOptions) (bool, error) {
+	if err := r.Client.Ping().Err(); err != nil {
+		return false, err
+	}
+
+	if options == nil || options.QueueName == "" {
+		return true, nil
+	}
+
+	exists, err := r.Client.Exists(options.QueueName).Result()
+	if err != nil {
+		return false, err
+	}
+	if exists == 0 {
+		return false, fmt.Errorf("stream for queue: %s does not exist", options.QueueName)
+	}
+	return true, nil
+}
+
+// Publish appends the message to the stream of the queue with XADD
+func (r *RedisStreams) Publish(options *MessagePublishOptions) error {
+	args, err := r.addArgs(options.QueueName, options.TopicName, options.MessageBody, options.DelayInSeconds, options.Attributes)
+	if err != nil {
+		return err
+	}
+
+	if err := r.Client.XAdd(args).Err(); err != nil {
+		logger.Errorf("error in publishing message to redis stream: %s, for: %s", options.QueueName, err)
+		return err
+	}
+	return nil
+}
+
+// BatchPublish appends options.Entries to the stream in a single pipeline
+func (r *RedisStreams) BatchPublish(options *MessagePublishOptions) (*BatchResult, error) {
+	if len(options.Entries) == 0 {
+		return nil, fmt.Errorf("no entries to batch publish to redis stream: %s", options.QueueName)
+	}
+
+	result := &BatchResult{}
+	ids := make([]string, 0, len(options.Entries))
+	cmds := make([]*redis.StringCmd, 0, len(options.Entries))
+	pipe := r.Client.Pipeline()
+	for i, entry := range options.Entries {
+		id := firstNonEmpty(entry.ID, strconv.Itoa(i))
+		args, err := r.addArgs(options.QueueName, options.TopicName, entry.MessageBody, entry.DelayInSeconds, mergeAttributes(options.Attributes, entry.Attributes))
+		if err != nil {
+			result.Failed = append(result.Failed, BatchResultEntry{
+				ID:          id,
+				Code:        "InvalidParameterValue",
+				Message:     err.Error(),
+				SenderFault: true,
+			})
+			continue
+		}
+		ids = append(ids, id)
+		cmds = append(cmds, pipe.XAdd(args))
+	}
+
+	if len(cmds) > 0 {
+		if _, err := pipe.Exec(); err != nil {
+			logger.Errorf("error in batch publishing messages to redis stream: %s, for: %s", options.QueueName, err)
+		}
+	}
+
+	for i, cmd := range cmds {
+		messageID, err := cmd.Result()
+		if err != nil {
+			result.Failed = append(result.Failed, BatchResultEntry{
+				ID:      ids[i],
+				Code:    "RequestFailed",
+				Message: err.Error(),
+			})
+			continue
+		}
+		result.Successful = append(result.Successful, BatchResultEntry{ID: ids[i], MessageID: messageID})
+	}
+	return result, nil
+}
+
+// Consume receives a single message, nil is returned when no message arrived in time
+func (r *RedisStreams) Consume(options *MessageConsumeOptions) (*MessageReceiveResponse, error) {
+	consumeOptions := *options
+	consumeOptions.NumberOfMessages = 1
+
+	messages, err := r.BatchConsume(&consumeOptions)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	return &messages[0], nil
+}
+
+// BatchConsume first reclaims messages pending longer than the visibility timeout, then
+// reads new messages with XREADGROUP, blocking up to WaitTimeSeconds when none is available
+func (r *RedisStreams) BatchConsume(options *MessageConsumeOptions) ([]MessageReceiveResponse, error) {
+	if err := r.ensureGroup(options.QueueName); err != nil {
+		return nil, err
+	}
+
+	numberOfMessages := int64(options.NumberOfMessages)
+	if numberOfMessages <= 0 {
+		numberOfMessages = 1
+	}
+
+	visibilityTimeout := time.Duration(options.VisibilityTimeout) * time.Second
+	if visibilityTimeout <= 0 {
+		visibilityTimeout = RedisStreamsDefaultVisibilityTimeout
+	}
+
+	messages, err := r.autoClaim(options.QueueName, visibilityTimeout, numberOfMessages)
+	if err != nil {
+		logger.Errorf("error in reclaiming pending messages from redis stream: %s, for: %s", options.QueueName, err)
+		return nil, err
+	}
+
+	if remaining := numberOfMessages - int64(len(messages)); remaining > 0 {
+		block := time.Duration(-1)
+		if options.WaitTimeSeconds > 0 && len(messages) == 0 {
+			block = time.Duration(options.WaitTimeSeconds) * time.Second
+		}
+
+		streams, err := r.Client.XReadGroup(&redis.XReadGroupArgs{
+			Group:    options.QueueName,
+			Consumer: r.ConsumerName,
+			Streams:  []string{options.QueueName, ">"},
+			Count:    remaining,
+			Block:    block,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			logger.Errorf("error in consuming message from redis stream: %s, for: %s", options.QueueName, err)
+			return nil, err
+		}
+		for _, stream := range streams {
+			messages = append(messages, stream.Messages...)
+		}
+	}
+
+	if len(messages) == 0 {
+		return []MessageReceiveResponse{}, nil
+	}
+
+	receiveCounts := r.receiveCounts(options.QueueName, messages)
+	responses := make([]MessageReceiveResponse, len(messages))
+	for i, msg := range messages {
+		responses[i] = MessageReceiveResponse{
+			MessageBody:              fmt.Sprint(msg.Values[redisStreamsBodyField]),
+			MessageID:                msg.ID,
+			MessageReceiptHandle:     msg.ID,
+			MessageVisibilityTimeout: int64(visibilityTimeout / time.Second),
+			ReceiveCount:             receiveCounts[msg.ID],
+		}
+		if subject, ok := msg.Values[redisStreamsSubjectField]; ok {
+			responses[i].MessageSubject = fmt.Sprint(subject)
+		}
+		if attributes, ok := msg.Values[redisStreamsAttributesField]; ok {
+			if err := json.Unmarshal([]byte(fmt.Sprint(attributes)), &responses[i].Attributes); err != nil {
+				logger.Errorf("attributes of message: %s on redis stream: %s cannot be decoded, %s", msg.ID, options.QueueName, err)
+			}
+		}
+	}
+
+	if options.DeleteMessageAfterAck {
+		_, _ = r.BatchDeleteMessage(options.QueueName, responses)
+	}
+
+	return responses, nil
+}
+
+// DeleteMessage acks the message with XACK and removes it with XDEL when DeleteOnAck is set
+func (r *RedisStreams) DeleteMessage(queueName string, message *MessageReceiveResponse) error {
+	acked, err := r.Client.XAck(queueName, queueName, message.MessageReceiptHandle).Result()
+	if err != nil {
+		logger.Errorf("error in acking redis stream message with ID: %s, %s", message.MessageID, err)
+		return err
+	}
+	if acked == 0 {
+		return fmt.Errorf("message with ID: %s is not pending on redis stream: %s", message.MessageReceiptHandle, queueName)
+	}
+
+	if r.DeleteOnAck {
+		if err := r.Client.XDel(queueName, message.MessageReceiptHandle).Err(); err != nil {
+			logger.Errorf("error in deleting redis stream message with ID: %s, %s", message.MessageID, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchDeleteMessage acks the messages in a single pipeline, messages that were not
+// pending anymore are reported in BatchResult.Failed
+func (r *RedisStreams) BatchDeleteMessage(queueName string, messages []MessageReceiveResponse) (*BatchResult, error) {
+	result := &BatchResult{}
+	if len(messages) == 0 {
+		return result, nil
+	}
+
+	pipe := r.Client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(messages))
+	for i, message := range messages {
+		cmds[i] = pipe.XAck(queueName, queueName, message.MessageReceiptHandle)
+		if r.DeleteOnAck {
+			pipe.XDel(queueName, message.MessageReceiptHandle)
+		}
+	}
+	if _, err := pipe.Exec(); err != nil {
+		logger.Errorf("error in batch acking messages on redis stream: %s, for: %s", queueName, err)
+	}
+
+	for i, cmd := range cmds {
+		entry := BatchResultEntry{
+			ID:        messages[i].MessageID,
+			MessageID: messages[i].MessageID,
+		}
+		acked, err := cmd.Result()
+		if err != nil || acked == 0 {
+			entry.Code = "ReceiptHandleIsInvalid"
+			if err != nil {
+				entry.Code = "RequestFailed"
+				entry.Message = err.Error()
+			}
+			result.Failed = append(result.Failed, entry)
+			continue
+		}
+		result.Successful = append(result.Successful, entry)
+	}
+	return result, nil
+}
+
+// ChangeVisibility sets the idle time of the pending message with XCLAIM so it is
+// reclaimed visibilityTimeout seconds from now, by consumers polling with the visibility
+// timeout the message was received with. It cannot be hidden for longer than that timeout
+func (r *RedisStreams) ChangeVisibility(queueName string, message *MessageReceiveResponse, visibilityTimeout int64) error {
+	received := time.Duration(message.MessageVisibilityTimeout) * time.Second
+	if received <= 0 {
+		received = RedisStreamsDefaultVisibilityTimeout
+	}
+	idle := received - time.Duration(visibilityTimeout)*time.Second
+	if idle < 0 {
+		idle = 0
+	}
+
+	cmd := redis.NewStringSliceCmd("xclaim", queueName, queueName, r.ConsumerName, 0, message.MessageReceiptHandle, "idle", int64(idle/time.Millisecond), "justid")
+	if err := r.Client.Process(cmd); err != nil {
+		logger.Errorf("error in changing visibility of redis stream message with ID: %s, %s", message.MessageID, err)
+		return err
+	}
+	if len(cmd.Val()) == 0 {
+		return fmt.Errorf("message with ID: %s is not pending on redis stream: %s", message.MessageReceiptHandle, queueName)
+	}
+	return nil
+}
+
+func (r *RedisStreams) addArgs(queueName string, subject string, body interface{}, delayInSeconds int64, attributes map[string]string) (*redis.XAddArgs, error) {
+	if delayInSeconds > 0 {
+		return nil, fmt.Errorf("DelayInSeconds is not supported by the redis streams provider")
+	}
+
+	data, err := messageBodyBytes(body)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{redisStreamsBodyField: string(data)}
+	if subject != "" {
+		values[redisStreamsSubjectField] = subject
+	}
+	if len(attributes) > 0 {
+		encoded, err := json.Marshal(attributes)
+		if err != nil {
+			return nil, err
+		}
+		values[redisStreamsAttributesField] = string(encoded)
+	}
+
+	return &redis.XAddArgs{
+		Stream:       queueName,
+		MaxLenApprox: r.MaxLength,
+		Values:       values,
+	}, nil
+}
+
+// ensureGroup creates the consumer group of the queue and its stream when missing.
+// The group starts from the beginning of the stream so messages published before
+// the first consumer started are not skipped
+func (r *RedisStreams) ensureGroup(queueName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.groups[queueName] {
+		return nil
+	}
+
+	err := r.Client.XGroupCreateMkStream(queueName, queueName, "0").Err()
+	if err != nil && !isRedisBusyGroupError(err) {
+		logger.Errorf("error in creating consumer group for redis stream: %s, %s", queueName, err)
+		return err
+	}
+
+	if r.groups == nil {
+		r.groups = map[string]bool{}
+	}
+	r.groups[queueName] = true
+	return nil
+}
+
+// autoClaim moves up to count messages idle for longer than minIdle to this consumer
+func (r *RedisStreams) autoClaim(queueName string, minIdle time.Duration, count int64) ([]redis.XMessage, error) {
+	cmd := redis.NewSliceCmd("xautoclaim", queueName, queueName, r.ConsumerName, int64(minIdle/time.Millisecond), "0-0", "count", count)
+	if err := r.Client.Process(cmd); err != nil {
+		return nil, err
+	}
+
+	reply := cmd.Val()
+	if len(reply) < 2 {
+		return nil, nil
+	}
+	entries, ok := reply[1].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected XAUTOCLAIM reply for redis stream: %s", queueName)
+	}
+
+	messages := make([]redis.XMessage, 0, len(entries))
+	for _, entry := range entries {
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) < 2 {
+			// deleted entries are returned without fields
+			continue
+		}
+		id, _ := fields[0].(string)
+		pairs, _ := fields[1].([]interface{})
+		values := make(map[string]interface{}, len(pairs)/2)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			values[fmt.Sprint(pairs[i])] = pairs[i+1]
+		}
+		messages = append(messages, redis.XMessage{ID: id, Values: values})
+	}
+	return messages, nil
+}
+
+// receiveCounts returns the delivery count of the given pending messages by their ID.
+// Reclaimed messages are older than the new ones, so the batch is already sorted by ID
+func (r *RedisStreams) receiveCounts(queueName string, messages []redis.XMessage) map[string]int64 {
+	counts := make(map[string]int64, len(messages))
+	pending, err := r.Client.XPendingExt(&redis.XPendingExtArgs{
+		Stream:   queueName,
+		Group:    queueName,
+		Start:    messages[0].ID,
+		End:      messages[len(messages)-1].ID,
+		Count:    int64(len(messages)) * 10,
+		Consumer: r.ConsumerName,
+	}).Result()
+	if err != nil {
+		logger.Errorf("error in reading pending messages of redis stream: %s, %s", queueName, err)
+		return counts
+	}
+	for _, p := range pending {
+		counts[p.Id] = p.RetryCount
+	}
+	return counts
+}
+
+func isRedisBusyGroupError(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP")
+}
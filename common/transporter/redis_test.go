@@ -0,0 +1,170 @@
+package transporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRedisStreamsTestClient(t *testing.T, options RedisStreamsOptions) (*RedisStreams, *miniredis.Miniredis) {
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	t.Cleanup(func() { _ = redisClient.Close() })
+	return NewRedisStreams(redisClient, options), redisServer
+}
+
+func TestRedisStreamsPublishAndConsume(t *testing.T) {
+	client, redisServer := newRedisStreamsTestClient(t, RedisStreamsOptions{ConsumerName: "worker-1"})
+
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "add-product", MessageBody: "h1"}))
+	require.NoError(t, client.Publish(&MessagePublishOptions{
+		QueueName:   "add-product",
+		MessageBody: map[string]int{"id": 1},
+		Attributes:  map[string]string{AttributeRequestID: "req-1"},
+	}))
+
+	t.Run("test ok consume redis stream messages", func(t *testing.T) {
+		msgs, err := client.BatchConsume(&MessageConsumeOptions{QueueName: "add-product", NumberOfMessages: 10})
+		assert.Nil(t, err)
+		require.Len(t, msgs, 2)
+		assert.Equal(t, "h1", msgs[0].MessageBody)
+		assert.Equal(t, `{"id":1}`, msgs[1].MessageBody)
+		assert.Equal(t, map[string]string{AttributeRequestID: "req-1"}, msgs[1].Attributes)
+		assert.Equal(t, int64(1), msgs[0].ReceiveCount)
+		assert.Equal(t, msgs[0].MessageID, msgs[0].MessageReceiptHandle)
+		assert.Nil(t, msgs[0].Attributes)
+
+		result, err := client.BatchDeleteMessage("add-product", msgs)
+		assert.Nil(t, err)
+		assert.Len(t, result.Successful, 2)
+		assert.False(t, result.HasFailed())
+	})
+
+	t.Run("test delete message that is not pending", func(t *testing.T) {
+		err := client.DeleteMessage("add-product", &MessageReceiveResponse{MessageReceiptHandle: "1-1"})
+		assert.Error(t, err)
+	})
+
+	t.Run("test ok consume with no message", func(t *testing.T) {
+		msg, err := client.Consume(&MessageConsumeOptions{QueueName: "add-product"})
+		assert.Nil(t, err)
+		assert.Nil(t, msg)
+	})
+
+	t.Run("test ok health check", func(t *testing.T) {
+		ok, err := client.HealthCheck(&HealthCheckOptions{QueueName: "add-product"})
+		assert.True(t, ok)
+		assert.Nil(t, err)
+
+		ok, err = client.HealthCheck(&HealthCheckOptions{QueueName: "unknown"})
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("test publish with delay is not supported", func(t *testing.T) {
+		err := client.Publish(&MessagePublishOptions{QueueName: "add-product", MessageBody: "h1", DelayInSeconds: 5})
+		assert.Error(t, err)
+	})
+
+	t.Run("test acked messages stay in the stream without DeleteOnAck", func(t *testing.T) {
+		entries, err := redisServer.Stream("add-product")
+		assert.Nil(t, err)
+		assert.Len(t, entries, 2)
+	})
+}
+
+func TestRedisStreamsReclaimsStuckMessages(t *testing.T) {
+	client, redisServer := newRedisStreamsTestClient(t, RedisStreamsOptions{ConsumerName: "worker-1", DeleteOnAck: true})
+	other := NewRedisStreams(client.Client, RedisStreamsOptions{ConsumerName: "worker-2"})
+	options := &MessageConsumeOptions{QueueName: "finance", NumberOfMessages: 1, VisibilityTimeout: 30}
+
+	now := time.Now()
+	redisServer.SetTime(now)
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "stuck"}))
+
+	first, err := client.Consume(options)
+	require.NoError(t, err)
+	require.NotNil(t, first)
+
+	t.Run("test pending message is not delivered before visibility timeout", func(t *testing.T) {
+		msg, err := other.Consume(options)
+		assert.Nil(t, err)
+		assert.Nil(t, msg)
+	})
+
+	t.Run("test ok pending message is reclaimed after visibility timeout", func(t *testing.T) {
+		redisServer.SetTime(now.Add(time.Minute))
+		msg, err := other.Consume(options)
+		assert.Nil(t, err)
+		require.NotNil(t, msg)
+		assert.Equal(t, first.MessageID, msg.MessageID)
+		assert.Equal(t, "stuck", msg.MessageBody)
+		assert.Equal(t, int64(2), msg.ReceiveCount)
+
+		assert.Nil(t, other.DeleteMessage("finance", msg))
+	})
+}
+
+func TestRedisStreamsChangeVisibility(t *testing.T) {
+	client, redisServer := newRedisStreamsTestClient(t, RedisStreamsOptions{ConsumerName: "worker-1"})
+	other := NewRedisStreams(client.Client, RedisStreamsOptions{ConsumerName: "worker-2"})
+	options := &MessageConsumeOptions{QueueName: "certificate", NumberOfMessages: 1, VisibilityTimeout: 30}
+
+	now := time.Now()
+	redisServer.SetTime(now)
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "certificate", MessageBody: "GENERATE_CERTIFICATE"}))
+	first, err := client.Consume(options)
+	require.NoError(t, err)
+	require.NotNil(t, first)
+
+	t.Run("test ok message is visible once its new timeout expires", func(t *testing.T) {
+		require.NoError(t, client.ChangeVisibility("certificate", first, 10))
+
+		redisServer.SetTime(now.Add(5 * time.Second))
+		msg, err := other.Consume(options)
+		assert.Nil(t, err)
+		assert.Nil(t, msg)
+
+		redisServer.SetTime(now.Add(11 * time.Second))
+		msg, err = other.Consume(options)
+		assert.Nil(t, err)
+		require.NotNil(t, msg)
+		assert.Equal(t, first.MessageID, msg.MessageID)
+		assert.Nil(t, other.DeleteMessage("certificate", msg))
+	})
+
+	t.Run("test wrong message is not pending", func(t *testing.T) {
+		assert.Error(t, client.ChangeVisibility("certificate", first, 10))
+	})
+}
+
+func TestRedisStreamsBatchPublishCapsLength(t *testing.T) {
+	client, redisServer := newRedisStreamsTestClient(t, RedisStreamsOptions{ConsumerName: "worker-1", MaxLength: 2})
+
+	result, err := client.BatchPublish(&MessagePublishOptions{
+		QueueName: "quotation",
+		Entries: []MessagePublishEntry{
+			{ID: "a", MessageBody: "a"},
+			{ID: "b", MessageBody: "b"},
+			{ID: "c", MessageBody: "c"},
+			{ID: "d", MessageBody: nil},
+		},
+	})
+
+	t.Run("test ok batch publish result", func(t *testing.T) {
+		assert.Nil(t, err)
+		assert.Len(t, result.Successful, 3)
+		require.Len(t, result.Failed, 1)
+		assert.Equal(t, "d", result.Failed[0].ID)
+	})
+
+	t.Run("test ok stream is capped", func(t *testing.T) {
+		entries, err := redisServer.Stream("quotation")
+		assert.Nil(t, err)
+		assert.Len(t, entries, 2)
+	})
+}
@@ -0,0 +1,217 @@
+package transporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AttributeReplayOf carries the ID of the archived message a replayed message is a copy of
+const AttributeReplayOf = "X-Replay-Of"
+
+// ReplayFilter selects the archived messages of Queue received between From and To.
+// The other fields are optional, a record has to match all of those that are set
+//   - Outcomes - the outcomes the record may have, e.g. OutcomeDeadLettered
+//   - MessageIDs - the IDs the record may have
+//   - Attributes - attributes the record must carry with the same value
+//   - BodyContains - text the body must contain
+type ReplayFilter struct {
+	Queue        string
+	From         time.Time
+	To           time.Time
+	Outcomes     []MessageOutcome
+	MessageIDs   []string
+	Attributes   map[string]string
+	BodyContains string
+}
+
+// Match reports whether record is selected by the filter
+func (f ReplayFilter) Match(record *ArchiveRecord) bool {
+	if record.ReceivedAt.Before(f.From) || (!f.To.IsZero() && record.ReceivedAt.After(f.To)) {
+		return false
+	}
+	if len(f.Outcomes) > 0 && !containsOutcome(f.Outcomes, record.Outcome) {
+		return false
+	}
+	if len(f.MessageIDs) > 0 && !containsString(f.MessageIDs, record.MessageID) {
+		return false
+	}
+	for key, value := range f.Attributes {
+		if record.Attributes[key] != value {
+			return false
+		}
+	}
+	return f.BodyContains == "" || strings.Contains(record.Body, f.BodyContains)
+}
+
+// ReplayOption configures Replayer.Replay
+//   - TargetQueue - the queue the messages are published to, their source queue when empty
+//   - DryRun - returns the selected messages without publishing them
+//   - Limit - the most messages replayed, all of them when zero
+//   - MessageGroupID - the group the messages are published with, their archived group
+//     when empty. FIFO target queues need one of the two
+type ReplayOption struct {
+	TargetQueue    string
+	DryRun         bool
+	Limit          int
+	MessageGroupID string
+}
+
+// ReplayResult describes a replay, Records are the selected messages in receive order
+type ReplayResult struct {
+	Scanned   int
+	Published int
+	Records   []ArchiveRecord
+}
+
+// Replayer reads the archive written by an Archiver and re-publishes the selected messages
+type Replayer struct {
+	store  ArchiveStore
+	prefix string
+	client Client
+}
+
+// NewReplayer returns a replayer reading the archive under prefix, DefaultArchivePrefix
+// when empty, and publishing with client. Client may be nil for dry runs
+func NewReplayer(store ArchiveStore, prefix string, client Client) *Replayer {
+	return &Replayer{
+		store:  store,
+		prefix: firstNonEmpty(prefix, DefaultArchivePrefix),
+		client: client,
+	}
+}
+
+// Records returns the archived messages selected by filter in receive order, with the
+// number of records scanned. Only the hourly partitions between From and To are listed
+func (r *Replayer) Records(ctx context.Context, filter ReplayFilter) ([]ArchiveRecord, int, error) {
+	if filter.Queue == "" {
+		return nil, 0, errors.New("replay filter has no queue")
+	}
+	if filter.From.IsZero() {
+		return nil, 0, errors.New("replay filter has no start time")
+	}
+	to := filter.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if to.Before(filter.From) {
+		return nil, 0, fmt.Errorf("replay filter ends at %s before it starts at %s", to.Format(time.RFC3339), filter.From.Format(time.RFC3339))
+	}
+
+	var (
+		selected []ArchiveRecord
+		scanned  int
+	)
+	for hour := filter.From.UTC().Truncate(time.Hour); !hour.After(to); hour = hour.Add(time.Hour) {
+		keys, err := r.store.List(ctx, archivePartition(r.prefix, filter.Queue, hour)+"/")
+		if err != nil {
+			return nil, scanned, fmt.Errorf("failed to list archive of queue: %s at %s, %w", filter.Queue, hour.Format(time.RFC3339), err)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			data, err := r.store.Get(ctx, key)
+			if err != nil {
+				return nil, scanned, fmt.Errorf("failed to read archive object: %s, %w", key, err)
+			}
+			records, err := readArchiveRecords(data)
+			if err != nil {
+				return nil, scanned, fmt.Errorf("failed to decode archive object: %s, %w", key, err)
+			}
+			for i := range records {
+				scanned++
+				if filter.Match(&records[i]) {
+					selected = append(selected, records[i])
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].ReceivedAt.Before(selected[j].ReceivedAt)
+	})
+	return selected, scanned, nil
+}
+
+// Replay publishes the archived messages selected by filter to the target queue, in
+// receive order with their attributes and AttributeReplayOf. The idempotency key and the
+// trace headers are not replayed so the message is not skipped as a duplicate and starts
+// a new trace. On FIFO queues the replayed
+// message is deduplicated by the archived message ID. It stops at the first failed
+// publish, Published tells how many messages were sent before it
+func (r *Replayer) Replay(ctx context.Context, filter ReplayFilter, option ReplayOption) (*ReplayResult, error) {
+	records, scanned, err := r.Records(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if option.Limit > 0 && len(records) > option.Limit {
+		records = records[:option.Limit]
+	}
+
+	result := &ReplayResult{Scanned: scanned, Records: records}
+	if option.DryRun {
+		return result, nil
+	}
+	if r.client == nil {
+		return result, errors.New("replayer has no transporter client")
+	}
+	for i := range records {
+		target := firstNonEmpty(option.TargetQueue, records[i].Queue)
+		if isFIFOQueue(target) && firstNonEmpty(option.MessageGroupID, records[i].MessageGroupID) == "" {
+			return result, fmt.Errorf("message with ID: %s has no group to replay to FIFO queue: %s, set a message group ID", records[i].MessageID, target)
+		}
+	}
+
+	for i := range records {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		record := &records[i]
+		target := firstNonEmpty(option.TargetQueue, record.Queue)
+		err := r.client.Publish(&MessagePublishOptions{
+			QueueName:              target,
+			MessageBody:            record.Body,
+			MessageGroupID:         firstNonEmpty(option.MessageGroupID, record.MessageGroupID),
+			MessageDeduplicationID: fifoDeduplicationID(target, "replay-"+record.MessageID),
+			Attributes:             replayAttributes(record),
+		})
+		if err != nil {
+			return result, fmt.Errorf("failed to replay message with ID: %s to queue: %s, %w", record.MessageID, target, err)
+		}
+		result.Published++
+	}
+	return result, nil
+}
+
+// replayAttributes returns the attributes of record without the idempotency key and the
+// trace headers, with AttributeReplayOf set to the archived message ID
+func replayAttributes(record *ArchiveRecord) map[string]string {
+	attributes := map[string]string{AttributeReplayOf: record.MessageID}
+	for name, value := range record.Attributes {
+		if name == AttributeIdempotencyKey || name == AttributeTraceContext || isTraceAttribute(name) {
+			continue
+		}
+		attributes[name] = value
+	}
+	return attributes
+}
+
+func containsOutcome(outcomes []MessageOutcome, outcome MessageOutcome) bool {
+	for _, o := range outcomes {
+		if o == outcome {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,374 @@
+package transporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// BackoffStrategy decides how the delay grows between two attempts of a message
+type BackoffStrategy string
+
+// RetryMode decides how a failed message is delayed before its next attempt
+type RetryMode string
+
+const (
+	BackoffFixed       BackoffStrategy = "fixed"
+	BackoffExponential BackoffStrategy = "exponential"
+
+	// RetryModeRepublish publishes the message again with DelayInSeconds and acks the
+	// original one. The attempt history travels with the message so it is kept in the DLQ.
+	// SQS FIFO queues and NATS JetStream cannot delay a single message, the message is kept
+	// in the queue for the backoff there like RetryModeVisibility does. Redis Streams can do
+	// neither, only policies without backoff are accepted there
+	RetryModeRepublish RetryMode = "republish"
+	// RetryModeVisibility keeps the message in the queue and changes its visibility timeout,
+	// the attempt is read from the provider receive count. It keeps FIFO ordering but only
+	// the last error is kept in the DLQ
+	RetryModeVisibility RetryMode = "visibility"
+
+	// DefaultRetryMaxDelay is the longest delay supported by SQS DelaySeconds
+	DefaultRetryMaxDelay = 15 * time.Minute
+
+	retryEnvelopeMarker = "retry"
+	retryEnvelopePrefix = `{"transporter_envelope":"retry"`
+)
+
+// RetryPolicy configures how a consumer retries the messages its handler failed on
+//   - MaxAttempts - number of times the handler is called before the message goes to DeadLetterQueue,
+//     errors marked with Permanent send it there after the current attempt
+//   - Backoff, InitialDelay and MaxDelay - the delay before the next attempt, exponential
+//     backoff doubles InitialDelay after every attempt up to MaxDelay
+//   - DeadLetterQueue - where the message goes after the last attempt, when empty the
+//     message is acked and dropped
+type RetryPolicy struct {
+	MaxAttempts     int
+	Backoff         BackoffStrategy
+	InitialDelay    time.Duration
+	MaxDelay        time.Duration
+	Mode            RetryMode
+	DeadLetterQueue string
+}
+
+// RetryAttempt is a failed attempt of a message
+type RetryAttempt struct {
+	Attempt  int       `json:"attempt"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// DeadLetterMessage is the body published to the dead-letter queue after the last attempt.
+// Message is the DLQ message it was read from, it is needed to redrive or delete it
+type DeadLetterMessage struct {
+	SourceQueue    string                 `json:"source_queue"`
+	MessageGroupID string                 `json:"message_group_id,omitempty"`
+	Body           string                 `json:"body"`
+	Attributes     map[string]string      `json:"attributes,omitempty"`
+	Attempts       []RetryAttempt         `json:"attempts"`
+	FinalError     string                 `json:"final_error"`
+	DeadLetteredAt time.Time              `json:"dead_lettered_at"`
+	Message        MessageReceiveResponse `json:"-"`
+}
+
+type retryEnvelope struct {
+	Envelope string         `json:"transporter_envelope"`
+	Body     string         `json:"body"`
+	Attempts []RetryAttempt `json:"attempts"`
+}
+
+// permanentError is a handler error retrying will not fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as an error retrying will not fix, a RetryPolicy moves the
+// message to its dead-letter queue without waiting for the remaining attempts
+func Permanent(err error) error {
+	if err == nil || IsPermanent(err) {
+		return err
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err or an error it wraps was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// validate reports the modes RetryPolicy does not know and the ones client cannot run
+func (p RetryPolicy) validate(client Client) error {
+	if p.MaxAttempts < 1 {
+		return errors.New("retry policy max attempts should be at least 1")
+	}
+	if _, ok := Unwrap(client).(*RedisStreams); ok && p.InitialDelay > 0 {
+		return errors.New("retry policy backoff is not supported by the redis streams provider, messages cannot be delayed")
+	}
+	switch p.Mode {
+	case "", RetryModeRepublish:
+	case RetryModeVisibility:
+		if _, ok := Unwrap(client).(*NatsClient); ok {
+			return errors.New("retry policy mode visibility is not supported by the NATS provider")
+		}
+	default:
+		return fmt.Errorf("unknown retry policy mode: %q", p.Mode)
+	}
+	return nil
+}
+
+// Delay returns how long to wait after the given attempt, starting at 1
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultRetryMaxDelay
+	}
+
+	delay := p.InitialDelay
+	if p.Backoff == BackoffExponential && attempt > 1 {
+		delay = time.Duration(float64(p.InitialDelay) * math.Pow(2, float64(attempt-1)))
+	}
+	if delay > maxDelay || delay < 0 {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// unwrapRetryEnvelope restores the original body of a republished message
+// and its attempt history
+func unwrapRetryEnvelope(message *MessageReceiveResponse) {
+	body, ok := message.MessageBody.(string)
+	if !ok || !strings.HasPrefix(body, retryEnvelopePrefix) {
+		return
+	}
+
+	var envelope retryEnvelope
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil || envelope.Envelope != retryEnvelopeMarker {
+		return
+	}
+	message.MessageBody = envelope.Body
+	message.RetryAttempts = envelope.Attempts
+}
+
+// retry schedules the next attempt of a message the handler failed on, or moves it
+// to the dead-letter queue after the last attempt, and returns which of the two it did
+func (c *consumer) retry(message *MessageReceiveResponse, handlerErr error) (MessageOutcome, error) {
+	policy := *c.retryPolicy
+
+	attempt := len(message.RetryAttempts) + 1
+	if c.retriesInQueue() && message.ReceiveCount > 0 {
+		attempt = int(message.ReceiveCount)
+	}
+
+	body, err := message.publishedBody()
+	if err != nil {
+		return OutcomeFailed, err
+	}
+
+	attempts := append(append([]RetryAttempt{}, message.RetryAttempts...), RetryAttempt{
+		Attempt:  attempt,
+		Error:    handlerErr.Error(),
+		FailedAt: time.Now(),
+	})
+
+	if attempt >= policy.MaxAttempts || IsPermanent(handlerErr) {
+		if policy.DeadLetterQueue != "" {
+			deadLetter, err := json.Marshal(DeadLetterMessage{
+				SourceQueue:    c.queueName,
+				MessageGroupID: message.MessageGroupID,
+				Body:           string(body),
+				Attributes:     message.Attributes,
+				Attempts:       attempts,
+				FinalError:     handlerErr.Error(),
+				DeadLetteredAt: time.Now(),
+			})
+			if err != nil {
+				return OutcomeFailed, err
+			}
+
+			err = c.transporterClient.Publish(&MessagePublishOptions{
+				QueueName:              policy.DeadLetterQueue,
+				MessageBody:            string(deadLetter),
+				MessageGroupID:         message.MessageGroupID,
+				MessageDeduplicationID: fifoDeduplicationID(policy.DeadLetterQueue, message.MessageID),
+				Attributes:             message.Attributes,
+			})
+			if err != nil {
+				return OutcomeFailed, fmt.Errorf("failed to publish message with ID: %s to dead letter queue: %s, %w", message.MessageID, policy.DeadLetterQueue, err)
+			}
+			logger.Infof("moved message with ID: %s from queue: %s to dead letter queue: %s after %d attempts", message.MessageID, c.queueName, policy.DeadLetterQueue, attempt)
+		}
+		return OutcomeDeadLettered, c.Acknowledge(message)
+	}
+
+	delay := policy.Delay(attempt)
+	if c.retriesInQueue() {
+		return OutcomeRetried, c.delayInQueue(message, delay)
+	}
+
+	envelope, err := json.Marshal(retryEnvelope{
+		Envelope: retryEnvelopeMarker,
+		Body:     string(body),
+		Attempts: attempts,
+	})
+	if err != nil {
+		return OutcomeFailed, err
+	}
+
+	options := &MessagePublishOptions{
+		QueueName:              c.queueName,
+		MessageBody:            string(envelope),
+		MessageGroupID:         message.MessageGroupID,
+		MessageDeduplicationID: fifoDeduplicationID(c.queueName, fmt.Sprintf("%s-%d", message.MessageID, attempt)),
+		Attributes:             message.Attributes,
+	}
+	if supportsMessageDelay(c.transporterClient, c.queueName) {
+		options.DelayInSeconds = int64(delay / time.Second)
+	}
+	err = c.transporterClient.Publish(options)
+	if err != nil {
+		return OutcomeFailed, fmt.Errorf("failed to republish message with ID: %s on queue: %s, %w", message.MessageID, c.queueName, err)
+	}
+	return OutcomeRetried, c.Acknowledge(message)
+}
+
+// retriesInQueue reports whether failed messages are kept in the queue until their next
+// attempt instead of being republished, which is the case in visibility mode and when
+// the provider cannot republish a message with the backoff delay
+func (c *consumer) retriesInQueue() bool {
+	policy := c.retryPolicy
+	if policy.Mode == RetryModeVisibility {
+		return true
+	}
+	return policy.InitialDelay > 0 && !supportsMessageDelay(c.transporterClient, c.queueName)
+}
+
+// delayInQueue makes the message visible again after delay. JetStream naks it with the
+// delay since its ChangeVisibility can only restart the AckWait
+func (c *consumer) delayInQueue(message *MessageReceiveResponse, delay time.Duration) error {
+	if js, ok := Unwrap(c.transporterClient).(*JetStream); ok {
+		return js.Nak(c.queueName, message, delay)
+	}
+	return c.transporterClient.ChangeVisibility(c.queueName, message, int64(delay/time.Second))
+}
+
+// DeadLetterQueue reads the messages a consumer moved to its dead-letter queue
+// and re-drives them to their source queue
+type DeadLetterQueue struct {
+	client    Client
+	queueName string
+}
+
+// NewDeadLetterQueue returns a DeadLetterQueue reading from queueName
+func NewDeadLetterQueue(client Client, queueName string) *DeadLetterQueue {
+	return &DeadLetterQueue{client: client, queueName: queueName}
+}
+
+// List receives up to limit dead-lettered messages. They stay hidden for
+// visibilityTimeout seconds so they can be inspected and re-driven, and become
+// visible again in the dead-letter queue otherwise
+func (d *DeadLetterQueue) List(limit int32, visibilityTimeout int64) ([]DeadLetterMessage, error) {
+	messages, err := d.client.BatchConsume(&MessageConsumeOptions{
+		QueueName:         d.queueName,
+		NumberOfMessages:  limit,
+		VisibilityTimeout: visibilityTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters := make([]DeadLetterMessage, 0, len(messages))
+	for _, message := range messages {
+		deadLetter, err := d.Inspect(&message)
+		if err != nil {
+			logger.Errorf("message with ID: %s on dead letter queue: %s cannot be decoded, %s", message.MessageID, d.queueName, err)
+			continue
+		}
+		deadLetters = append(deadLetters, *deadLetter)
+	}
+	return deadLetters, nil
+}
+
+// Inspect decodes a message received from the dead-letter queue
+func (d *DeadLetterQueue) Inspect(message *MessageReceiveResponse) (*DeadLetterMessage, error) {
+	body, err := messageBodyBytes(message.MessageBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var deadLetter DeadLetterMessage
+	if err := json.Unmarshal(body, &deadLetter); err != nil {
+		return nil, err
+	}
+	if deadLetter.SourceQueue == "" {
+		return nil, fmt.Errorf("message with ID: %s has no source queue", message.MessageID)
+	}
+	deadLetter.Message = *message
+	return &deadLetter, nil
+}
+
+// Redrive publishes the original body of the messages back to their source queue,
+// or to targetQueue when it is not empty, and deletes them from the dead-letter queue
+func (d *DeadLetterQueue) Redrive(deadLetters []DeadLetterMessage, targetQueue string) *BatchResult {
+	result := &BatchResult{}
+	for i := range deadLetters {
+		deadLetter := &deadLetters[i]
+		entry := BatchResultEntry{
+			ID:        deadLetter.Message.MessageID,
+			MessageID: deadLetter.Message.MessageID,
+		}
+
+		queueName := firstNonEmpty(targetQueue, deadLetter.SourceQueue)
+		err := d.client.Publish(&MessagePublishOptions{
+			QueueName:              queueName,
+			MessageBody:            deadLetter.Body,
+			MessageGroupID:         deadLetter.MessageGroupID,
+			MessageDeduplicationID: fifoDeduplicationID(queueName, deadLetter.Message.MessageID),
+			Attributes:             deadLetter.Attributes,
+		})
+		if err == nil {
+			err = d.client.DeleteMessage(d.queueName, &deadLetter.Message)
+		}
+		if err != nil {
+			entry.Code = "RedriveFailed"
+			entry.Message = err.Error()
+			result.Failed = append(result.Failed, entry)
+			continue
+		}
+		result.Successful = append(result.Successful, entry)
+	}
+	return result
+}
+
+// isFIFOQueue reports whether queueName is a FIFO queue, SQS requires them to end with .fifo
+func isFIFOQueue(queueName string) bool {
+	return strings.HasSuffix(queueName, ".fifo")
+}
+
+// fifoDeduplicationID returns id on FIFO queues, standard SQS queues reject a deduplication ID
+func fifoDeduplicationID(queueName, id string) string {
+	if !isFIFOQueue(queueName) {
+		return ""
+	}
+	return id
+}
+
+// supportsMessageDelay reports whether a message published to queueName with client can
+// have its own DelayInSeconds. SQS FIFO queues only have a queue delay, NATS JetStream
+// and Redis Streams have none
+func supportsMessageDelay(client Client, queueName string) bool {
+	if isFIFOQueue(queueName) {
+		return false
+	}
+	switch Unwrap(client).(type) {
+	case *JetStream, *RedisStreams:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,244 @@
+package transporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	t.Run("test fixed backoff", func(t *testing.T) {
+		policy := RetryPolicy{Backoff: BackoffFixed, InitialDelay: 10 * time.Second}
+		assert.Equal(t, 10*time.Second, policy.Delay(1))
+		assert.Equal(t, 10*time.Second, policy.Delay(5))
+	})
+
+	t.Run("test exponential backoff is capped", func(t *testing.T) {
+		policy := RetryPolicy{Backoff: BackoffExponential, InitialDelay: 10 * time.Second, MaxDelay: time.Minute}
+		assert.Equal(t, 10*time.Second, policy.Delay(1))
+		assert.Equal(t, 20*time.Second, policy.Delay(2))
+		assert.Equal(t, 40*time.Second, policy.Delay(3))
+		assert.Equal(t, time.Minute, policy.Delay(4))
+		assert.Equal(t, time.Minute, policy.Delay(100))
+	})
+}
+
+func newRetryTestConsumer(t *testing.T, client Client, policy *RetryPolicy, handler Handler) *consumer {
+	serviceConsumer, err := NewServiceConsumer(&ConsumerOption{
+		TransporterClient: client,
+		QueueName:         "finance",
+		VisibilityTimeout: 30,
+		RetryPolicy:       policy,
+	})
+	require.NoError(t, err)
+	serviceConsumer.Handle(handler)
+	return serviceConsumer.(*consumer)
+}
+
+func TestRetryRepublishesUntilDeadLetterQueue(t *testing.T) {
+	client, now := newMemoryTestClient(t)
+	policy := &RetryPolicy{
+		MaxAttempts:     3,
+		Backoff:         BackoffExponential,
+		InitialDelay:    10 * time.Second,
+		DeadLetterQueue: "finance-dlq",
+	}
+
+	var attempts []int
+	c := newRetryTestConsumer(t, client, policy, func(ctx context.Context, message *MessageReceiveResponse) error {
+		assert.Equal(t, "UPDATE_STOPLOSS_INCREMENT", message.MessageBody)
+		attempts = append(attempts, len(message.RetryAttempts)+1)
+		return errors.New("stoploss not found")
+	})
+
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "UPDATE_STOPLOSS_INCREMENT"}))
+
+	consumeOptions := &MessageConsumeOptions{QueueName: "finance", VisibilityTimeout: 30}
+	for _, delay := range []time.Duration{0, 10 * time.Second, 20 * time.Second} {
+		*now = now.Add(delay)
+		msg, err := client.Consume(consumeOptions)
+		require.NoError(t, err)
+		require.NotNil(t, msg, "message should be visible after %s", delay)
+		c.process(context.Background(), msg)
+	}
+
+	t.Run("test ok handler is called max attempts times", func(t *testing.T) {
+		assert.Equal(t, []int{1, 2, 3}, attempts)
+		assert.Equal(t, 0, client.Depth("finance"))
+		assert.Equal(t, 0, client.InFlight("finance"))
+	})
+
+	dlq := NewDeadLetterQueue(client, "finance-dlq")
+	deadLetters, err := dlq.List(10, 60)
+
+	t.Run("test ok dead letter message keeps body and attempt history", func(t *testing.T) {
+		assert.Nil(t, err)
+		require.Len(t, deadLetters, 1)
+		assert.Equal(t, "finance", deadLetters[0].SourceQueue)
+		assert.Equal(t, "UPDATE_STOPLOSS_INCREMENT", deadLetters[0].Body)
+		assert.Equal(t, "stoploss not found", deadLetters[0].FinalError)
+		require.Len(t, deadLetters[0].Attempts, 3)
+		assert.Equal(t, 3, deadLetters[0].Attempts[2].Attempt)
+	})
+
+	t.Run("test ok redrive to source queue", func(t *testing.T) {
+		result := dlq.Redrive(deadLetters, "")
+		assert.Len(t, result.Successful, 1)
+		assert.Equal(t, 0, client.Depth("finance-dlq")+client.InFlight("finance-dlq"))
+		assert.Equal(t, []interface{}{"UPDATE_STOPLOSS_INCREMENT"}, client.Drain("finance"))
+	})
+}
+
+func TestRetryWithVisibilityTimeout(t *testing.T) {
+	client, now := newMemoryTestClient(t)
+	policy := &RetryPolicy{
+		MaxAttempts:  2,
+		Backoff:      BackoffFixed,
+		InitialDelay: 5 * time.Second,
+		Mode:         RetryModeVisibility,
+	}
+	c := newRetryTestConsumer(t, client, policy, func(ctx context.Context, message *MessageReceiveResponse) error {
+		return errors.New("insurer is down")
+	})
+
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "claim"}))
+	consumeOptions := &MessageConsumeOptions{QueueName: "finance", VisibilityTimeout: 30}
+
+	msg, err := client.Consume(consumeOptions)
+	require.NoError(t, err)
+	c.process(context.Background(), msg)
+
+	t.Run("test ok message is visible again after the retry delay", func(t *testing.T) {
+		*now = now.Add(6 * time.Second)
+		msg, err := client.Consume(consumeOptions)
+		assert.Nil(t, err)
+		require.NotNil(t, msg)
+		assert.Equal(t, int64(2), msg.ReceiveCount)
+		c.process(context.Background(), msg)
+	})
+
+	t.Run("test ok message is dropped after the last attempt without dead letter queue", func(t *testing.T) {
+		assert.Equal(t, 0, client.Depth("finance"))
+		assert.Equal(t, 0, client.InFlight("finance"))
+	})
+}
+
+func TestRetryOnFIFOQueue(t *testing.T) {
+	client, now := newMemoryTestClient(t)
+	policy := &RetryPolicy{
+		MaxAttempts:     2,
+		Backoff:         BackoffFixed,
+		InitialDelay:    10 * time.Second,
+		DeadLetterQueue: "finance-dlq.fifo",
+	}
+	serviceConsumer, err := NewServiceConsumer(&ConsumerOption{
+		TransporterClient: client,
+		QueueName:         "finance.fifo",
+		VisibilityTimeout: 30,
+		RetryPolicy:       policy,
+	})
+	require.NoError(t, err)
+	serviceConsumer.Handle(func(ctx context.Context, message *MessageReceiveResponse) error {
+		return errors.New("stoploss not found")
+	})
+	c := serviceConsumer.(*consumer)
+
+	require.NoError(t, client.Publish(&MessagePublishOptions{
+		QueueName:              "finance.fifo",
+		MessageBody:            "UPDATE_STOPLOSS_INCREMENT",
+		MessageGroupID:         "stoploss",
+		MessageDeduplicationID: "stoploss-1",
+	}))
+	consumeOptions := &MessageConsumeOptions{QueueName: "finance.fifo", VisibilityTimeout: 30}
+
+	msg, err := client.Consume(consumeOptions)
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	c.process(context.Background(), msg)
+
+	t.Run("test ok message is kept in its group for the retry delay", func(t *testing.T) {
+		assert.Equal(t, 0, client.Depth("finance.fifo"))
+		assert.Equal(t, 1, client.InFlight("finance.fifo"))
+
+		*now = now.Add(11 * time.Second)
+		msg, err := client.Consume(consumeOptions)
+		assert.Nil(t, err)
+		require.NotNil(t, msg)
+		assert.Equal(t, "stoploss", msg.MessageGroupID)
+		assert.Equal(t, int64(2), msg.ReceiveCount)
+		c.process(context.Background(), msg)
+	})
+
+	t.Run("test ok message is moved to the FIFO dead letter queue with its group", func(t *testing.T) {
+		assert.Equal(t, 0, client.Depth("finance.fifo")+client.InFlight("finance.fifo"))
+		dlq := NewDeadLetterQueue(client, "finance-dlq.fifo")
+		deadLetters, err := dlq.List(10, 60)
+		assert.Nil(t, err)
+		require.Len(t, deadLetters, 1)
+		assert.Equal(t, "stoploss", deadLetters[0].MessageGroupID)
+		assert.Equal(t, "stoploss", deadLetters[0].Message.MessageGroupID)
+
+		result := dlq.Redrive(deadLetters, "")
+		assert.Len(t, result.Successful, 1)
+		assert.Equal(t, []interface{}{"UPDATE_STOPLOSS_INCREMENT"}, client.Drain("finance.fifo"))
+	})
+}
+
+func TestRetryOnJetStream(t *testing.T) {
+	client := newJetStreamTestClient(t)
+	c := newRetryTestConsumer(t, client, &RetryPolicy{
+		MaxAttempts:  2,
+		Backoff:      BackoffFixed,
+		InitialDelay: 2 * time.Second,
+	}, func(ctx context.Context, message *MessageReceiveResponse) error {
+		return errors.New("insurer is down")
+	})
+
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "claim"}))
+	consumeOptions := &MessageConsumeOptions{QueueName: "finance", VisibilityTimeout: 30, WaitTimeSeconds: 1}
+
+	msg, err := client.Consume(consumeOptions)
+	require.NoError(t, err)
+	c.process(context.Background(), msg)
+
+	t.Run("test ok message is naked with the retry delay", func(t *testing.T) {
+		_, err := client.Consume(consumeOptions)
+		assert.Error(t, err)
+
+		consumeOptions.WaitTimeSeconds = 3
+		msg, err := client.Consume(consumeOptions)
+		assert.Nil(t, err)
+		require.NotNil(t, msg)
+		assert.Equal(t, int64(2), msg.ReceiveCount)
+		assert.Equal(t, "claim", msg.MessageBody)
+	})
+}
+
+func TestNewServiceConsumerWithInvalidRetryPolicy(t *testing.T) {
+	for name, policy := range map[string]*RetryPolicy{
+		"no max attempts": {},
+		"unknown mode":    {MaxAttempts: 3, Mode: "visibilty"},
+	} {
+		t.Run("test wrong "+name, func(t *testing.T) {
+			_, err := NewServiceConsumer(&ConsumerOption{
+				TransporterClient: NewMemory(),
+				QueueName:         "finance",
+				RetryPolicy:       policy,
+			})
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("test wrong backoff on redis streams", func(t *testing.T) {
+		_, err := NewServiceConsumer(&ConsumerOption{
+			TransporterClient: &RedisStreams{},
+			QueueName:         "finance",
+			RetryPolicy:       &RetryPolicy{MaxAttempts: 3, InitialDelay: time.Second},
+		})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,185 @@
+package transporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rohanchauhan02/clean/common/schemas"
+)
+
+const (
+	// MetricOperationProcessed counts the routed messages, tagged with their operation and status
+	MetricOperationProcessed = "transporter.operation.processed"
+	// MetricOperationDuration is the time spent in the operation handler
+	MetricOperationDuration = "transporter.operation.duration"
+
+	OperationStatusSuccess = "success"
+	OperationStatusFailed  = "failed"
+	OperationStatusInvalid = "invalid"
+	OperationStatusUnknown = "unknown"
+)
+
+// ErrUnknownOperation is returned for the messages no handler is registered for
+var ErrUnknownOperation = errors.New("unknown operation")
+
+// Validator validates a decoded payload, util.CustomValidator implements it
+type Validator interface {
+	Validate(i interface{}) error
+}
+
+// Metrics receives count and duration metrics, datadog.Datadog implements it
+type Metrics interface {
+	SendCountMetric(name string, tags ...string)
+	SendDurationMetric(name string, t1, t2 time.Time, tags ...string)
+}
+
+// RouterMetrics receives the per operation metrics of a Router
+type RouterMetrics = Metrics
+
+// OperationHandler processes the payload of a single operation, decoded from the
+// Data field of schemas.SQSActionsRequest
+type OperationHandler[T any] func(ctx context.Context, payload T, message *MessageReceiveResponse) error
+
+// operationRequest is schemas.SQSActionsRequest with its Data left to the route to decode
+type operationRequest struct {
+	Operation string          `json:"operation"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// routeHandler decodes and handles the data of an operation, it returns the status of the metrics
+type routeHandler func(ctx context.Context, data json.RawMessage, message *MessageReceiveResponse) (string, error)
+
+// Router dispatches the schemas.SQSActionsRequest messages to the handler registered
+// for their Operation, its Handle method is given to ServiceConsumer.Handle.
+// Unknown operations and payloads that cannot be decoded or validated are returned as
+// Permanent errors, so a RetryPolicy moves them to its dead-letter queue at once
+type Router struct {
+	Validator Validator
+	Metrics   RouterMetrics
+
+	mu       sync.RWMutex
+	handlers map[string]routeHandler
+}
+
+// NewRouter returns a router validating the payloads with validator and sending its
+// metrics to metrics, both are optional
+func NewRouter(validator Validator, metrics RouterMetrics) *Router {
+	return &Router{
+		Validator: validator,
+		Metrics:   metrics,
+		handlers:  map[string]routeHandler{},
+	}
+}
+
+// Route registers handler for operation, the Data of its messages is decoded into T.
+// Registering an operation twice replaces its handler
+func Route[T any](r *Router, operation string, handler OperationHandler[T]) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.handlers == nil {
+		r.handlers = map[string]routeHandler{}
+	}
+	r.handlers[operation] = func(ctx context.Context, data json.RawMessage, message *MessageReceiveResponse) (string, error) {
+		var payload T
+		if len(data) > 0 && string(data) != "null" {
+			if err := json.Unmarshal(data, &payload); err != nil {
+				return OperationStatusInvalid, Permanent(fmt.Errorf("failed to decode data of operation: %s, %w", operation, err))
+			}
+		}
+		if err := r.validate(&payload); err != nil {
+			return OperationStatusInvalid, Permanent(fmt.Errorf("invalid data of operation: %s, %w", operation, err))
+		}
+		if err := handler(ctx, payload, message); err != nil {
+			return OperationStatusFailed, err
+		}
+		return OperationStatusSuccess, nil
+	}
+}
+
+// Operations returns the registered operations, sorted
+func (r *Router) Operations() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	operations := make([]string, 0, len(r.handlers))
+	for operation := range r.handlers {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+// Handle decodes message into a schemas.SQSActionsRequest and calls the handler of its operation
+func (r *Router) Handle(ctx context.Context, message *MessageReceiveResponse) error {
+	start := time.Now()
+
+	var request operationRequest
+	if err := r.decodeRequest(message, &request); err != nil {
+		r.sendMetrics("", OperationStatusInvalid, start)
+		return Permanent(fmt.Errorf("failed to decode operation request, %w", err))
+	}
+
+	r.mu.RLock()
+	handler, ok := r.handlers[request.Operation]
+	r.mu.RUnlock()
+	if !ok {
+		r.sendMetrics(request.Operation, OperationStatusUnknown, start)
+		return Permanent(fmt.Errorf("%w: %s", ErrUnknownOperation, request.Operation))
+	}
+
+	status, err := handler(ctx, request.Data, message)
+	r.sendMetrics(request.Operation, status, start)
+	return err
+}
+
+// decodeRequest reads the operation request with the codec of the message, the Data of
+// the bodies that are not JSON is converted to JSON so the handlers decode it the same way
+func (r *Router) decodeRequest(message *MessageReceiveResponse, request *operationRequest) error {
+	contentType := message.Attributes[AttributeContentType]
+	if contentType == "" || contentType == ContentTypeJSON {
+		return DecodeMessage(message, request)
+	}
+
+	var decoded schemas.SQSActionsRequest
+	if err := DecodeMessage(message, &decoded); err != nil {
+		return err
+	}
+	data, err := json.Marshal(decoded.Data)
+	if err != nil {
+		return err
+	}
+	request.Operation, request.Data = decoded.Operation, data
+	return nil
+}
+
+// validate runs the Validator on the struct payloads, payload is a pointer to the decoded T
+func (r *Router) validate(payload interface{}) error {
+	if r.Validator == nil {
+		return nil
+	}
+	value := reflect.ValueOf(payload)
+	if value.Elem().Kind() == reflect.Ptr {
+		if value.Elem().IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	return r.Validator.Validate(value.Interface())
+}
+
+func (r *Router) sendMetrics(operation, status string, start time.Time) {
+	if r.Metrics == nil {
+		return
+	}
+	tags := []string{"operation:" + firstNonEmpty(operation, "none"), "status:" + status}
+	r.Metrics.SendCountMetric(MetricOperationProcessed, tags...)
+	r.Metrics.SendDurationMetric(MetricOperationDuration, start, time.Now(), tags...)
+}
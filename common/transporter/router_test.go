@@ -0,0 +1,161 @@
+package transporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rohanchauhan02/clean/common/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+type testValidator struct {
+	validator *validator.Validate
+}
+
+func (v testValidator) Validate(i interface{}) error { return v.validator.Struct(i) }
+
+type fakeRouterMetrics struct {
+	counts    []string
+	durations int
+}
+
+func (m *fakeRouterMetrics) SendCountMetric(name string, tags ...string) {
+	m.counts = append(m.counts, name+" "+tags[0]+" "+tags[1])
+}
+
+func (m *fakeRouterMetrics) SendDurationMetric(name string, t1, t2 time.Time, tags ...string) {
+	m.durations++
+}
+
+type stoplossPayload struct {
+	PolicyNumber string  `json:"policy_number" validate:"required"`
+	Amount       float64 `json:"amount"`
+}
+
+func TestRouter(t *testing.T) {
+	metrics := &fakeRouterMetrics{}
+	router := NewRouter(testValidator{validator: validator.New()}, metrics)
+
+	var increments []stoplossPayload
+	Route(router, schemas.OPERATION_FINANCE_UPDATE_STOPLOSS_INCREMENT, func(ctx context.Context, payload stoplossPayload, message *MessageReceiveResponse) error {
+		increments = append(increments, payload)
+		return nil
+	})
+	Route(router, schemas.OPERATION_FINANCE_UPDATE_STOPLOSS_DECREMENT, func(ctx context.Context, payload *stoplossPayload, message *MessageReceiveResponse) error {
+		return errors.New("stoploss not found")
+	})
+	Route(router, schemas.OPERATION_QUOTATION_UPDATE_STATUS, func(ctx context.Context, payload string, message *MessageReceiveResponse) error {
+		return nil
+	})
+
+	message := func(operation string, data interface{}) *MessageReceiveResponse {
+		body, err := json.Marshal(schemas.SQSActionsRequest{Operation: operation, Data: data})
+		require.NoError(t, err)
+		return &MessageReceiveResponse{MessageID: "1", MessageBody: string(body)}
+	}
+
+	t.Run("test ok payload is decoded into the type of the route", func(t *testing.T) {
+		err := router.Handle(context.Background(), message(schemas.OPERATION_FINANCE_UPDATE_STOPLOSS_INCREMENT, stoplossPayload{PolicyNumber: "QOALA-1", Amount: 100}))
+		assert.Nil(t, err)
+		assert.Equal(t, []stoplossPayload{{PolicyNumber: "QOALA-1", Amount: 100}}, increments)
+
+		err = router.Handle(context.Background(), message(schemas.OPERATION_QUOTATION_UPDATE_STATUS, "PAID"))
+		assert.Nil(t, err)
+	})
+
+	t.Run("test ok payload encoded with another codec", func(t *testing.T) {
+		body, attributes, err := EncodeBody(MessagePackCodec{}, map[string]interface{}{
+			"operation": schemas.OPERATION_FINANCE_UPDATE_STOPLOSS_INCREMENT,
+			"data":      map[string]interface{}{"policy_number": "QOALA-2"},
+		})
+		require.NoError(t, err)
+
+		err = router.Handle(context.Background(), &MessageReceiveResponse{MessageBody: body, Attributes: attributes})
+		assert.Nil(t, err)
+		assert.Equal(t, "QOALA-2", increments[len(increments)-1].PolicyNumber)
+	})
+
+	t.Run("test wrong payload fails validation", func(t *testing.T) {
+		err := router.Handle(context.Background(), message(schemas.OPERATION_FINANCE_UPDATE_STOPLOSS_INCREMENT, stoplossPayload{Amount: 100}))
+		assert.NotNil(t, err)
+		assert.True(t, IsPermanent(err))
+
+		err = router.Handle(context.Background(), message(schemas.OPERATION_FINANCE_UPDATE_STOPLOSS_DECREMENT, map[string]interface{}{"amount": 1}))
+		assert.True(t, IsPermanent(err))
+	})
+
+	t.Run("test wrong payload type", func(t *testing.T) {
+		err := router.Handle(context.Background(), message(schemas.OPERATION_FINANCE_UPDATE_STOPLOSS_INCREMENT, "QOALA-1"))
+		assert.True(t, IsPermanent(err))
+	})
+
+	t.Run("test wrong handler error is retryable", func(t *testing.T) {
+		err := router.Handle(context.Background(), message(schemas.OPERATION_FINANCE_UPDATE_STOPLOSS_DECREMENT, stoplossPayload{PolicyNumber: "QOALA-1"}))
+		assert.EqualError(t, err, "stoploss not found")
+		assert.False(t, IsPermanent(err))
+	})
+
+	t.Run("test wrong unknown operation", func(t *testing.T) {
+		err := router.Handle(context.Background(), message(schemas.OPERATION_FINANCE_UPDATE_STOPLOSS_HISTORY, nil))
+		assert.True(t, errors.Is(err, ErrUnknownOperation))
+		assert.True(t, IsPermanent(err))
+
+		err = router.Handle(context.Background(), &MessageReceiveResponse{MessageBody: "UPDATE_STATUS"})
+		assert.True(t, IsPermanent(err))
+	})
+
+	t.Run("test ok metrics are tagged with operation and status", func(t *testing.T) {
+		assert.Equal(t, []string{
+			MetricOperationProcessed + " operation:UPDATE_STOPLOSS_INCREMENT status:success",
+			MetricOperationProcessed + " operation:UPDATE_STATUS status:success",
+			MetricOperationProcessed + " operation:UPDATE_STOPLOSS_INCREMENT status:success",
+			MetricOperationProcessed + " operation:UPDATE_STOPLOSS_INCREMENT status:invalid",
+			MetricOperationProcessed + " operation:UPDATE_STOPLOSS_DECREMENT status:invalid",
+			MetricOperationProcessed + " operation:UPDATE_STOPLOSS_INCREMENT status:invalid",
+			MetricOperationProcessed + " operation:UPDATE_STOPLOSS_DECREMENT status:failed",
+			MetricOperationProcessed + " operation:UPDATE_STOPLOSS_CLAIMED_STOPLOSS_HISTORY status:unknown",
+			MetricOperationProcessed + " operation:none status:invalid",
+		}, metrics.counts)
+		assert.Equal(t, len(metrics.counts), metrics.durations)
+	})
+
+	t.Run("test ok operations are listed", func(t *testing.T) {
+		assert.Equal(t, []string{
+			schemas.OPERATION_QUOTATION_UPDATE_STATUS,
+			schemas.OPERATION_FINANCE_UPDATE_STOPLOSS_DECREMENT,
+			schemas.OPERATION_FINANCE_UPDATE_STOPLOSS_INCREMENT,
+		}, router.Operations())
+	})
+}
+
+func TestRouterUnknownOperationGoesToDeadLetterQueue(t *testing.T) {
+	client, _ := newMemoryTestClient(t)
+	router := NewRouter(nil, nil)
+	Route(router, schemas.OPERATION_FINANCE_UPDATE_STOPLOSS, func(ctx context.Context, payload stoplossPayload, message *MessageReceiveResponse) error {
+		return nil
+	})
+	c := newRetryTestConsumer(t, client, &RetryPolicy{MaxAttempts: 5, InitialDelay: time.Second, DeadLetterQueue: "finance-dlq"}, router.Handle)
+
+	body, err := json.Marshal(schemas.SQSActionsRequest{Operation: "UPDATE_STOPLOSS_UNKNOWN"})
+	require.NoError(t, err)
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: string(body)}))
+
+	msg, err := client.Consume(&MessageConsumeOptions{QueueName: "finance", VisibilityTimeout: 30})
+	require.NoError(t, err)
+	c.process(context.Background(), msg)
+
+	assert.Equal(t, 0, client.Depth("finance")+client.InFlight("finance"))
+	deadLetters := client.Drain("finance-dlq")
+	require.Len(t, deadLetters, 1)
+
+	var deadLetter DeadLetterMessage
+	require.NoError(t, json.Unmarshal([]byte(deadLetters[0].(string)), &deadLetter))
+	assert.Equal(t, string(body), deadLetter.Body)
+	assert.Equal(t, "unknown operation: UPDATE_STOPLOSS_UNKNOWN", deadLetter.FinalError)
+	assert.Len(t, deadLetter.Attempts, 1)
+}
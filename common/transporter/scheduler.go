@@ -0,0 +1,185 @@
+package transporter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultSchedulerPollInterval = time.Second
+	DefaultSchedulerBatchSize    = 100
+	// DefaultSchedulerLease is how long a claimed message is hidden from the other
+	// schedulers, it is released again if it was not published within the lease
+	DefaultSchedulerLease = time.Minute
+)
+
+// ScheduledMessage is a message kept by a ScheduleStore until DeliverAt
+type ScheduledMessage struct {
+	ID                     string            `json:"id"`
+	QueueName              string            `json:"queue_name"`
+	TopicName              string            `json:"topic_name,omitempty"`
+	MessageBody            string            `json:"message_body"`
+	MessageGroupID         string            `json:"message_group_id,omitempty"`
+	MessageDeduplicationID string            `json:"message_deduplication_id,omitempty"`
+	Attributes             map[string]string `json:"attributes,omitempty"`
+	DeliverAt              time.Time         `json:"deliver_at"`
+}
+
+// ScheduleStore keeps the scheduled messages until they are due
+type ScheduleStore interface {
+	Add(ctx context.Context, message *ScheduledMessage) error
+	// Cancel removes a message that was not claimed yet, false is returned when the
+	// message is unknown, claimed or already published
+	Cancel(ctx context.Context, id string) (bool, error)
+	// Claim returns up to limit messages due at now and hides them until now+lease,
+	// so a message claimed by a scheduler that crashed is claimed again after the lease
+	Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]ScheduledMessage, error)
+	// Ack removes a published message
+	Ack(ctx context.Context, id string) error
+}
+
+// SchedulerOptions configures a Scheduler, zero values use the defaults above
+type SchedulerOptions struct {
+	PollInterval time.Duration
+	BatchSize    int
+	Lease        time.Duration
+}
+
+// Scheduler publishes messages at a given time, beyond the DelayInSeconds limit of the
+// providers (15 minutes on SQS, 7 days on MNS). Messages are kept in a ScheduleStore and
+// released to their queue by Run, which can run on every replica.
+//
+// A message is published at least once. The scheduled ID is sent as the Idempotency-Key
+// attribute, and as the MessageDeduplicationID on FIFO queues, so a message published again
+// after a crash is dropped by the queue or skipped by a consumer with Deduplication
+type Scheduler struct {
+	Client  Client
+	Store   ScheduleStore
+	Options SchedulerOptions
+	Now     func() time.Time
+}
+
+// NewScheduler returns a Scheduler publishing through client the messages kept in store
+func NewScheduler(client Client, store ScheduleStore, options SchedulerOptions) *Scheduler {
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultSchedulerPollInterval
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultSchedulerBatchSize
+	}
+	if options.Lease <= 0 {
+		options.Lease = DefaultSchedulerLease
+	}
+	return &Scheduler{
+		Client:  client,
+		Store:   store,
+		Options: options,
+		Now:     time.Now,
+	}
+}
+
+// Schedule keeps the message described by options until deliverAt and returns its ID,
+// DelayInSeconds and Entries are ignored. The Datadog span context and the request ID of
+// ctx are kept with the message
+func (s *Scheduler) Schedule(ctx context.Context, options *MessagePublishOptions, deliverAt time.Time) (string, error) {
+	if options.QueueName == "" && options.TopicName == "" {
+		return "", fmt.Errorf("queue name is empty")
+	}
+
+	body, err := messageBodyBytes(options.MessageBody)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := newScheduledMessageID()
+	if err != nil {
+		return "", err
+	}
+
+	attributes := mergeAttributes(map[string]string{AttributeIdempotencyKey: id}, InjectContext(ctx, options.Attributes))
+	message := &ScheduledMessage{
+		ID:                     id,
+		QueueName:              options.QueueName,
+		TopicName:              options.TopicName,
+		MessageBody:            string(body),
+		MessageGroupID:         options.MessageGroupID,
+		MessageDeduplicationID: options.MessageDeduplicationID,
+		Attributes:             attributes,
+		DeliverAt:              deliverAt,
+	}
+	if err := s.Store.Add(ctx, message); err != nil {
+		logger.Errorf("failed to schedule message for queue: %s at %s, %s", options.QueueName, deliverAt, err)
+		return "", err
+	}
+	return id, nil
+}
+
+// Cancel removes a scheduled message, false is returned when it is unknown or was
+// already claimed to be published
+func (s *Scheduler) Cancel(ctx context.Context, id string) (bool, error) {
+	return s.Store.Cancel(ctx, id)
+}
+
+// Run releases the due messages until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		released, err := s.ReleaseDue(ctx)
+		if err != nil {
+			logger.Errorf("failed to release scheduled messages, %s", err)
+		}
+		if err == nil && released >= s.Options.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(s.Options.PollInterval):
+		}
+	}
+	return nil
+}
+
+// ReleaseDue publishes a batch of due messages and returns how many were published.
+// Messages that fail to publish are claimed again once the lease expires
+func (s *Scheduler) ReleaseDue(ctx context.Context) (int, error) {
+	messages, err := s.Store.Claim(ctx, s.Now(), s.Options.BatchSize, s.Options.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for i := range messages {
+		message := &messages[i]
+		publishOptions := &MessagePublishOptions{
+			QueueName:      message.QueueName,
+			TopicName:      message.TopicName,
+			MessageBody:    message.MessageBody,
+			MessageGroupID: message.MessageGroupID,
+			Attributes:     message.Attributes,
+		}
+		if message.MessageGroupID != "" {
+			publishOptions.MessageDeduplicationID = firstNonEmpty(message.MessageDeduplicationID, message.ID)
+		}
+
+		if err := s.Client.Publish(publishOptions); err != nil {
+			logger.Errorf("failed to publish scheduled message with ID: %s to queue: %s, %s", message.ID, message.QueueName, err)
+			continue
+		}
+		if err := s.Store.Ack(ctx, message.ID); err != nil {
+			logger.Errorf("failed to remove published scheduled message with ID: %s, %s", message.ID, err)
+		}
+		released++
+	}
+	return released, nil
+}
+
+func newScheduledMessageID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,132 @@
+package transporter
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ScheduledMessageRecord is a row of the MySQL schedule table. AvailableAt is DeliverAt
+// until the message is claimed, then the end of the claim lease
+//
+//	CREATE TABLE `transporter_scheduled_message` (
+//	  `id` varchar(64) NOT NULL,
+//	  `queue_name` varchar(255) NOT NULL,
+//	  `topic_name` varchar(255) DEFAULT NULL,
+//	  `message_body` mediumtext NOT NULL,
+//	  `message_group_id` varchar(128) DEFAULT NULL,
+//	  `message_deduplication_id` varchar(128) DEFAULT NULL,
+//	  `attributes` text,
+//	  `deliver_at` datetime(3) NOT NULL,
+//	  `available_at` datetime(3) NOT NULL,
+//	  `created_at` datetime(3) DEFAULT NULL,
+//	  PRIMARY KEY (`id`),
+//	  KEY `idx_transporter_scheduled_message_available_at` (`available_at`)
+//	);
+type ScheduledMessageRecord struct {
+	ID                     string    `gorm:"column:id;type:varchar(64);primaryKey"`
+	QueueName              string    `gorm:"column:queue_name;type:varchar(255);not null"`
+	TopicName              string    `gorm:"column:topic_name;type:varchar(255)"`
+	MessageBody            string    `gorm:"column:message_body;type:mediumtext;not null"`
+	MessageGroupID         string    `gorm:"column:message_group_id;type:varchar(128)"`
+	MessageDeduplicationID string    `gorm:"column:message_deduplication_id;type:varchar(128)"`
+	Attributes             string    `gorm:"column:attributes;type:text"`
+	DeliverAt              time.Time `gorm:"column:deliver_at;not null"`
+	AvailableAt            time.Time `gorm:"column:available_at;not null;index"`
+	CreatedAt              time.Time `gorm:"column:created_at"`
+}
+
+func (ScheduledMessageRecord) TableName() string {
+	return "transporter_scheduled_message"
+}
+
+// MySQLScheduleStore keeps the scheduled messages in the transporter_scheduled_message
+// table. Claim locks the rows with SELECT ... FOR UPDATE SKIP LOCKED, which needs MySQL 8
+type MySQLScheduleStore struct {
+	DB *gorm.DB
+}
+
+// NewMySQLScheduleStore returns a ScheduleStore backed by db
+func NewMySQLScheduleStore(db *gorm.DB) *MySQLScheduleStore {
+	return &MySQLScheduleStore{DB: db}
+}
+
+func (s *MySQLScheduleStore) Add(ctx context.Context, message *ScheduledMessage) error {
+	record := &ScheduledMessageRecord{
+		ID:                     message.ID,
+		QueueName:              message.QueueName,
+		TopicName:              message.TopicName,
+		MessageBody:            message.MessageBody,
+		MessageGroupID:         message.MessageGroupID,
+		MessageDeduplicationID: message.MessageDeduplicationID,
+		DeliverAt:              message.DeliverAt,
+		AvailableAt:            message.DeliverAt,
+	}
+	if len(message.Attributes) > 0 {
+		encoded, err := json.Marshal(message.Attributes)
+		if err != nil {
+			return err
+		}
+		record.Attributes = string(encoded)
+	}
+	return s.DB.WithContext(ctx).Create(record).Error
+}
+
+// Cancel deletes the message while it was not claimed, a claimed message has its
+// available_at moved to the end of the lease
+func (s *MySQLScheduleStore) Cancel(ctx context.Context, id string) (bool, error) {
+	result := s.DB.WithContext(ctx).Where("id = ? AND available_at = deliver_at", id).Delete(&ScheduledMessageRecord{})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (s *MySQLScheduleStore) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]ScheduledMessage, error) {
+	var records []ScheduledMessageRecord
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("available_at <= ?", now).
+			Order("available_at").
+			Limit(limit).
+			Find(&records).Error
+		if err != nil || len(records) == 0 {
+			return err
+		}
+
+		ids := make([]string, len(records))
+		for i := range records {
+			ids[i] = records[i].ID
+		}
+		return tx.Model(&ScheduledMessageRecord{}).
+			Where("id IN ?", ids).
+			Update("available_at", now.Add(lease)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]ScheduledMessage, 0, len(records))
+	for _, record := range records {
+		message := ScheduledMessage{
+			ID:                     record.ID,
+			QueueName:              record.QueueName,
+			TopicName:              record.TopicName,
+			MessageBody:            record.MessageBody,
+			MessageGroupID:         record.MessageGroupID,
+			MessageDeduplicationID: record.MessageDeduplicationID,
+			DeliverAt:              record.DeliverAt,
+		}
+		if record.Attributes != "" {
+			if err := json.Unmarshal([]byte(record.Attributes), &message.Attributes); err != nil {
+				logger.Errorf("attributes of scheduled message with ID: %s cannot be decoded, %s", record.ID, err)
+			}
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func (s *MySQLScheduleStore) Ack(ctx context.Context, id string) error {
+	return s.DB.WithContext(ctx).Where("id = ?", id).Delete(&ScheduledMessageRecord{}).Error
+}
@@ -0,0 +1,125 @@
+package transporter
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// claimScheduledScript takes the due members of the sorted set and moves their score
+// to the end of the lease, so they are only claimed again if they were not acked. The
+// claimed members are added to the claimed set until they are acked
+const claimScheduledScript = `
+local ids = redis.call("zrangebyscore", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call("zadd", KEYS[1], ARGV[3], id)
+	redis.call("sadd", KEYS[2], id)
+end
+return ids`
+
+// cancelScheduledScript removes a member that was not claimed, a claimed member may
+// already be published by the scheduler holding its lease
+const cancelScheduledScript = `
+if redis.call("sismember", KEYS[3], ARGV[1]) == 1 then return 0 end
+redis.call("hdel", KEYS[2], ARGV[1])
+return redis.call("zrem", KEYS[1], ARGV[1])`
+
+// RedisScheduleStore keeps the scheduled messages in a hash, their delivery time in a
+// sorted set and the IDs of the claimed ones in a set
+type RedisScheduleStore struct {
+	Client redis.Cmdable
+	// Prefix of the three keys, it defaults to "transporter:scheduler"
+	Prefix string
+}
+
+// NewRedisScheduleStore returns a ScheduleStore backed by client
+func NewRedisScheduleStore(client redis.Cmdable) *RedisScheduleStore {
+	return &RedisScheduleStore{
+		Client: client,
+		Prefix: "transporter:scheduler",
+	}
+}
+
+func (s *RedisScheduleStore) dueKey() string {
+	return s.Prefix + ":due"
+}
+
+func (s *RedisScheduleStore) messagesKey() string {
+	return s.Prefix + ":messages"
+}
+
+func (s *RedisScheduleStore) claimedKey() string {
+	return s.Prefix + ":claimed"
+}
+
+func (s *RedisScheduleStore) Add(ctx context.Context, message *ScheduledMessage) error {
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.Client.TxPipeline()
+	pipe.HSet(s.messagesKey(), message.ID, string(encoded))
+	pipe.ZAdd(s.dueKey(), redis.Z{Score: redisScheduleScore(message.DeliverAt), Member: message.ID})
+	_, err = pipe.Exec()
+	return err
+}
+
+func (s *RedisScheduleStore) Cancel(ctx context.Context, id string) (bool, error) {
+	removed, err := s.Client.Eval(cancelScheduledScript, []string{s.dueKey(), s.messagesKey(), s.claimedKey()}, id).Int64()
+	if err != nil {
+		return false, err
+	}
+	return removed == 1, nil
+}
+
+func (s *RedisScheduleStore) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]ScheduledMessage, error) {
+	result, err := s.Client.Eval(claimScheduledScript, []string{s.dueKey(), s.claimedKey()},
+		redisScheduleScore(now), limit, redisScheduleScore(now.Add(lease))).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	values, _ := result.([]interface{})
+	if len(values) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(values))
+	for i, value := range values {
+		ids[i], _ = value.(string)
+	}
+
+	encoded, err := s.Client.HMGet(s.messagesKey(), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]ScheduledMessage, 0, len(ids))
+	for i, value := range encoded {
+		var message ScheduledMessage
+		data, ok := value.(string)
+		if !ok || json.Unmarshal([]byte(data), &message) != nil {
+			// the message was cancelled after it was claimed or cannot be decoded
+			logger.Errorf("scheduled message with ID: %s cannot be read, it is dropped", ids[i])
+			_ = s.Ack(ctx, ids[i])
+			continue
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func (s *RedisScheduleStore) Ack(ctx context.Context, id string) error {
+	pipe := s.Client.TxPipeline()
+	pipe.ZRem(s.dueKey(), id)
+	pipe.HDel(s.messagesKey(), id)
+	pipe.SRem(s.claimedKey(), id)
+	_, err := pipe.Exec()
+	return err
+}
+
+func redisScheduleScore(t time.Time) float64 {
+	return float64(t.UnixNano() / int64(time.Millisecond))
+}
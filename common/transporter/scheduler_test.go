@@ -0,0 +1,168 @@
+package transporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormMysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+)
+
+func TestScheduler(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	t.Cleanup(func() { _ = redisClient.Close() })
+
+	now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	client := NewMemory()
+	scheduler := NewScheduler(client, NewRedisScheduleStore(redisClient), SchedulerOptions{Lease: time.Minute})
+	scheduler.Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	reminderID, err := scheduler.Schedule(ctx, &MessagePublishOptions{
+		QueueName:   "policy",
+		MessageBody: "policy-expiry-reminder",
+		Attributes:  map[string]string{"policy": "P-1"},
+	}, now.Add(30*24*time.Hour))
+	require.NoError(t, err)
+	slaID, err := scheduler.Schedule(ctx, &MessagePublishOptions{
+		QueueName:   "claim",
+		MessageBody: []byte("claim-sla-check"),
+	}, now.Add(14*24*time.Hour))
+	require.NoError(t, err)
+
+	t.Run("test wrong empty queue name", func(t *testing.T) {
+		_, err := scheduler.Schedule(ctx, &MessagePublishOptions{MessageBody: "body"}, now)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("test ok nothing is released before it is due", func(t *testing.T) {
+		released, err := scheduler.ReleaseDue(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, released)
+	})
+
+	t.Run("test ok cancelled message is not released", func(t *testing.T) {
+		cancelled, err := scheduler.Cancel(ctx, slaID)
+		assert.Nil(t, err)
+		assert.True(t, cancelled)
+
+		cancelled, err = scheduler.Cancel(ctx, slaID)
+		assert.Nil(t, err)
+		assert.False(t, cancelled)
+	})
+
+	t.Run("test ok due message is released once", func(t *testing.T) {
+		now = now.Add(31 * 24 * time.Hour)
+		released, err := scheduler.ReleaseDue(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, released)
+
+		msgs, err := client.BatchConsume(&MessageConsumeOptions{QueueName: "policy", NumberOfMessages: 10, VisibilityTimeout: 30})
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+		assert.Equal(t, "policy-expiry-reminder", msgs[0].MessageBody)
+		assert.Equal(t, "P-1", msgs[0].Attributes["policy"])
+		assert.Equal(t, reminderID, msgs[0].Attributes[AttributeIdempotencyKey])
+
+		released, err = scheduler.ReleaseDue(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, released)
+		assert.Empty(t, client.Drain("claim"))
+	})
+
+	t.Run("test ok claimed message is released again after the lease", func(t *testing.T) {
+		id, err := scheduler.Schedule(ctx, &MessagePublishOptions{QueueName: "claim", MessageBody: "claim-sla-check"}, now)
+		require.NoError(t, err)
+
+		// a scheduler claims the message and crashes before publishing it
+		messages, err := scheduler.Store.Claim(ctx, now, 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, messages, 1)
+		assert.Equal(t, id, messages[0].ID)
+
+		cancelled, err := scheduler.Cancel(ctx, id)
+		assert.Nil(t, err)
+		assert.False(t, cancelled)
+
+		released, err := scheduler.ReleaseDue(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, released)
+
+		now = now.Add(2 * time.Minute)
+		released, err = scheduler.ReleaseDue(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, released)
+		assert.Equal(t, []interface{}{"claim-sla-check"}, client.Drain("claim"))
+	})
+}
+
+func TestMySQLScheduleStore(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer sqlDB.Close()
+	db, err := gorm.Open(gormMysql.New(gormMysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}),
+		&gorm.Config{Logger: gormLogger.Default.LogMode(gormLogger.Silent), SkipDefaultTransaction: true})
+	require.NoError(t, err)
+
+	now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	store := NewMySQLScheduleStore(db)
+	ctx := context.Background()
+
+	t.Run("test ok add", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO `transporter_scheduled_message`").
+			WithArgs("s-1", "policy", "", "reminder", "", "", `{"policy":"P-1"}`, now, now, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := store.Add(ctx, &ScheduledMessage{
+			ID:          "s-1",
+			QueueName:   "policy",
+			MessageBody: "reminder",
+			Attributes:  map[string]string{"policy": "P-1"},
+			DeliverAt:   now,
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test ok claim locks the due rows and extends them by the lease", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT \\* FROM `transporter_scheduled_message` WHERE available_at <= \\? ORDER BY available_at LIMIT 10 FOR UPDATE SKIP LOCKED").
+			WithArgs(now).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "queue_name", "message_body", "attributes", "deliver_at", "available_at"}).
+				AddRow("s-1", "policy", "reminder", `{"policy":"P-1"}`, now, now))
+		mock.ExpectExec("UPDATE `transporter_scheduled_message` SET `available_at`=\\? WHERE id IN \\(\\?\\)").
+			WithArgs(now.Add(time.Minute), "s-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		messages, err := store.Claim(ctx, now, 10, time.Minute)
+		assert.Nil(t, err)
+		require.Len(t, messages, 1)
+		assert.Equal(t, "reminder", messages[0].MessageBody)
+		assert.Equal(t, map[string]string{"policy": "P-1"}, messages[0].Attributes)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("test ok cancel and ack", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM `transporter_scheduled_message` WHERE id = \\? AND available_at = deliver_at").
+			WithArgs("s-2").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM `transporter_scheduled_message` WHERE id = \\?").
+			WithArgs("s-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		cancelled, err := store.Cancel(ctx, "s-2")
+		assert.Nil(t, err)
+		assert.False(t, cancelled)
+		assert.Nil(t, store.Ack(ctx, "s-1"))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
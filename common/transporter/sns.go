@@ -0,0 +1,232 @@
+package transporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+const (
+	snsProtocolSQS                 = "sqs"
+	snsSubscriptionPendingName     = "PendingConfirmation"
+	snsAttributeFilterPolicy       = "FilterPolicy"
+	snsAttributeRawMessageDelivery = "RawMessageDelivery"
+)
+
+// SNS implements TopicClient with SNS topics delivering to SQS queues. Subscriptions use
+// raw message delivery so consumers read the message body and attributes as published.
+// The access policy of the queue must allow the topic to send messages
+type SNS struct {
+	SNSClient snsiface.SNSAPI
+	// SQSClient resolves the ARN of the subscribed queues
+	SQSClient sqsiface.SQSAPI
+
+	topicARNs sync.Map
+}
+
+// NewSNS returns an SNS topic client
+func NewSNS(snsClient snsiface.SNSAPI, sqsClient sqsiface.SQSAPI) *SNS {
+	return &SNS{
+		SNSClient: snsClient,
+		SQSClient: sqsClient,
+	}
+}
+
+// PublishToTopic publishes the message to the topic, MessageGroupID and
+// MessageDeduplicationID are sent for FIFO topics
+func (s *SNS) PublishToTopic(options *MessagePublishOptions) error {
+	msgBody, ok := sqsMessageBody(options.MessageBody)
+	if !ok {
+		return fmt.Errorf("message body is not of type string, *string or []byte to publish to SNS topic: %s", options.TopicName)
+	}
+
+	topicARN, err := s.getTopicARN(options.TopicName)
+	if err != nil {
+		return err
+	}
+
+	messageAttributes, err := snsMessageAttributes(options.Attributes)
+	if err != nil {
+		return fmt.Errorf("failed to publish message to SNS topic: %s, %w", options.TopicName, err)
+	}
+
+	input := &sns.PublishInput{
+		TopicArn:          aws.String(topicARN),
+		Message:           msgBody,
+		MessageAttributes: messageAttributes,
+	}
+	if options.MessageGroupID != "" {
+		input.MessageGroupId = aws.String(options.MessageGroupID)
+	}
+	if options.MessageDeduplicationID != "" {
+		input.MessageDeduplicationId = aws.String(options.MessageDeduplicationID)
+	}
+
+	if _, err := s.SNSClient.Publish(input); err != nil {
+		logger.Errorf("error in publishing message to SNS topic: %s, for: %s", options.TopicName, err)
+		return err
+	}
+	return nil
+}
+
+// Subscribe subscribes the SQS queue to the topic with raw message delivery, subscribing
+// again with the same filter policy returns the existing subscription
+func (s *SNS) Subscribe(topicName string, queueName string, filterPolicy FilterPolicy) (*TopicSubscription, error) {
+	topicARN, err := s.getTopicARN(topicName)
+	if err != nil {
+		return nil, err
+	}
+	queueARN, err := SQS{SQSClient: s.SQSClient}.getSQSQueueARN(queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := map[string]*string{
+		snsAttributeRawMessageDelivery: aws.String("true"),
+	}
+	if len(filterPolicy) > 0 {
+		policy, err := json.Marshal(filterPolicy)
+		if err != nil {
+			return nil, err
+		}
+		attributes[snsAttributeFilterPolicy] = aws.String(string(policy))
+	}
+
+	output, err := s.SNSClient.Subscribe(&sns.SubscribeInput{
+		TopicArn:              aws.String(topicARN),
+		Protocol:              aws.String(snsProtocolSQS),
+		Endpoint:              aws.String(queueARN),
+		Attributes:            attributes,
+		ReturnSubscriptionArn: aws.Bool(true),
+	})
+	if err != nil {
+		logger.Errorf("error in subscribing SQS queue: %s to SNS topic: %s, %s", queueName, topicName, err)
+		return nil, err
+	}
+
+	return &TopicSubscription{
+		ID:           aws.StringValue(output.SubscriptionArn),
+		TopicName:    topicName,
+		QueueName:    queueName,
+		FilterPolicy: filterPolicy,
+	}, nil
+}
+
+func (s *SNS) Unsubscribe(subscription *TopicSubscription) error {
+	_, err := s.SNSClient.Unsubscribe(&sns.UnsubscribeInput{SubscriptionArn: aws.String(subscription.ID)})
+	if err != nil {
+		logger.Errorf("error in unsubscribing: %s from SNS topic: %s, %s", subscription.ID, subscription.TopicName, err)
+		return err
+	}
+	return nil
+}
+
+// ListSubscriptions returns the SQS subscriptions of the topic, subscriptions of other
+// protocols are skipped
+func (s *SNS) ListSubscriptions(topicName string) ([]TopicSubscription, error) {
+	topicARN, err := s.getTopicARN(topicName)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions []TopicSubscription
+	err = s.SNSClient.ListSubscriptionsByTopicPages(&sns.ListSubscriptionsByTopicInput{TopicArn: aws.String(topicARN)},
+		func(output *sns.ListSubscriptionsByTopicOutput, lastPage bool) bool {
+			for _, subscription := range output.Subscriptions {
+				if aws.StringValue(subscription.Protocol) != snsProtocolSQS {
+					continue
+				}
+				subscriptions = append(subscriptions, TopicSubscription{
+					ID:        aws.StringValue(subscription.SubscriptionArn),
+					TopicName: topicName,
+					QueueName: arnResource(aws.StringValue(subscription.Endpoint)),
+				})
+			}
+			return true
+		})
+	if err != nil {
+		logger.Errorf("error in listing subscriptions of SNS topic: %s, %s", topicName, err)
+		return nil, err
+	}
+
+	for i := range subscriptions {
+		if subscriptions[i].ID == snsSubscriptionPendingName {
+			continue
+		}
+		output, err := s.SNSClient.GetSubscriptionAttributes(&sns.GetSubscriptionAttributesInput{
+			SubscriptionArn: aws.String(subscriptions[i].ID),
+		})
+		if err != nil {
+			logger.Errorf("error in getting attributes of SNS subscription: %s, %s", subscriptions[i].ID, err)
+			return nil, err
+		}
+		if policy := aws.StringValue(output.Attributes[snsAttributeFilterPolicy]); policy != "" {
+			if err := json.Unmarshal([]byte(policy), &subscriptions[i].FilterPolicy); err != nil {
+				logger.Errorf("filter policy of SNS subscription: %s is not a list of attribute values, %s", subscriptions[i].ID, err)
+			}
+		}
+	}
+	return subscriptions, nil
+}
+
+// getTopicARN finds the topic by name, ARNs are cached as they never change
+func (s *SNS) getTopicARN(topicName string) (string, error) {
+	if topicName == "" {
+		return "", fmt.Errorf("topic name is empty")
+	}
+	if topicARN, ok := s.topicARNs.Load(topicName); ok {
+		return topicARN.(string), nil
+	}
+
+	var topicARN string
+	err := s.SNSClient.ListTopicsPages(&sns.ListTopicsInput{}, func(output *sns.ListTopicsOutput, lastPage bool) bool {
+		for _, topic := range output.Topics {
+			if arnResource(aws.StringValue(topic.TopicArn)) == topicName {
+				topicARN = aws.StringValue(topic.TopicArn)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		logger.Errorf("error in listing SNS topics, %s", err)
+		return "", err
+	}
+	if topicARN == "" {
+		return "", fmt.Errorf("SNS topic: %s does not exist", topicName)
+	}
+
+	s.topicARNs.Store(topicName, topicARN)
+	return topicARN, nil
+}
+
+// snsMessageAttributes sends every attribute as a String MessageAttribute so filter
+// policies can match them, the trace headers are packed in one attribute like on SQS
+func snsMessageAttributes(attributes map[string]string) (map[string]*sns.MessageAttributeValue, error) {
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+	attributes = packTraceAttributes(attributes)
+	if len(attributes) > SQSMaxMessageAttributes {
+		return nil, fmt.Errorf("message has %d attributes, SNS accepts at most %d", len(attributes), SQSMaxMessageAttributes)
+	}
+	messageAttributes := make(map[string]*sns.MessageAttributeValue, len(attributes))
+	for name, value := range attributes {
+		messageAttributes[name] = &sns.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+	return messageAttributes, nil
+}
+
+// arnResource returns the last part of an ARN, which is the topic or queue name
+func arnResource(arn string) string {
+	return arn[strings.LastIndex(arn, ":")+1:]
+}
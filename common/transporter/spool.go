@@ -0,0 +1,252 @@
+package transporter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Spool is a local file the messages are appended to while their provider is down,
+// one JSON record per line synced to disk before Append returns
+type Spool struct {
+	mu      sync.Mutex
+	flushMu sync.Mutex
+	path    string
+}
+
+// SpoolStats describes the messages waiting in a spool
+type SpoolStats struct {
+	Messages        int
+	Bytes           int64
+	OldestSpooledAt time.Time
+}
+
+type spoolRecord struct {
+	QueueName              string            `json:"queue_name"`
+	TopicName              string            `json:"topic_name,omitempty"`
+	MessageBody            string            `json:"message_body"`
+	Priority               int64             `json:"priority,omitempty"`
+	DelayInSeconds         int64             `json:"delay_in_seconds,omitempty"`
+	MessageGroupID         string            `json:"message_group_id,omitempty"`
+	MessageDeduplicationID string            `json:"message_deduplication_id,omitempty"`
+	Attributes             map[string]string `json:"attributes,omitempty"`
+	SpooledAt              time.Time         `json:"spooled_at"`
+}
+
+// NewSpool opens the spool at path, creating the file and its directory when needed
+func NewSpool(path string) (*Spool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	return &Spool{path: path}, nil
+}
+
+// Append writes options to the end of the spool. The body is kept as text, bodies that
+// are neither strings nor bytes are JSON encoded like the providers do
+func (s *Spool) Append(options *MessagePublishOptions) error {
+	body, err := messageBodyBytes(options.MessageBody)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(spoolRecord{
+		QueueName:              options.QueueName,
+		TopicName:              options.TopicName,
+		MessageBody:            string(body),
+		Priority:               options.Priority,
+		DelayInSeconds:         options.DelayInSeconds,
+		MessageGroupID:         options.MessageGroupID,
+		MessageDeduplicationID: options.MessageDeduplicationID,
+		Attributes:             options.Attributes,
+		SpooledAt:              time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Flush publishes the spooled messages in order with publish and removes the published
+// ones, it stops at the first transient error. Records that cannot be decoded or that
+// failed with a non-transient error are dropped, publishing them again would never succeed
+func (s *Spool) Flush(publish func(options *MessagePublishOptions) error) (int, error) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	lines, err := s.readLines()
+	s.mu.Unlock()
+	if err != nil || len(lines) == 0 {
+		return 0, err
+	}
+
+	var (
+		done      int
+		published int
+		flushErr  error
+	)
+	for _, line := range lines {
+		var record spoolRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			logger.Errorf("dropped spool record that cannot be decoded: %s", err)
+			done++
+			continue
+		}
+		err := publish(&MessagePublishOptions{
+			QueueName:              record.QueueName,
+			TopicName:              record.TopicName,
+			MessageBody:            record.MessageBody,
+			Priority:               record.Priority,
+			DelayInSeconds:         record.DelayInSeconds,
+			MessageGroupID:         record.MessageGroupID,
+			MessageDeduplicationID: record.MessageDeduplicationID,
+			Attributes:             record.Attributes,
+		})
+		if err != nil && !IsTransientError(err) {
+			logger.Errorf("dropped spooled message to queue: %s that cannot be published: %s, body: %s", record.QueueName, err, record.MessageBody)
+			done++
+			continue
+		}
+		if err != nil {
+			flushErr = fmt.Errorf("failed to re-drive spooled message to queue: %s, %w", record.QueueName, err)
+			break
+		}
+		done++
+		published++
+	}
+
+	if done > 0 {
+		s.mu.Lock()
+		err := s.dropLines(done)
+		s.mu.Unlock()
+		if err != nil {
+			return published, err
+		}
+	}
+	return published, flushErr
+}
+
+// Stats returns the number, size and age of the spooled messages
+func (s *Spool) Stats() (SpoolStats, error) {
+	s.mu.Lock()
+	lines, err := s.readLines()
+	s.mu.Unlock()
+	if err != nil {
+		return SpoolStats{}, err
+	}
+
+	stats := SpoolStats{Messages: len(lines)}
+	for i, line := range lines {
+		stats.Bytes += int64(len(line) + 1)
+		if i == 0 {
+			var record spoolRecord
+			if json.Unmarshal(line, &record) == nil {
+				stats.OldestSpooledAt = record.SpooledAt
+			}
+		}
+	}
+	return stats, nil
+}
+
+// HasGroup reports whether messages of groupID to the queue or topic are waiting in the spool,
+// the new messages of the group must be spooled behind them to keep the order of the group
+func (s *Spool) HasGroup(queueName, topicName, groupID string) (bool, error) {
+	s.mu.Lock()
+	lines, err := s.readLines()
+	s.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range lines {
+		var record spoolRecord
+		if json.Unmarshal(line, &record) != nil {
+			continue
+		}
+		if record.MessageGroupID == groupID && record.QueueName == queueName && record.TopicName == topicName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Spool) readLines() ([][]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, append([]byte{}, line...))
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// dropLines removes the first n records, the records appended since they were read are kept.
+// The spool is replaced by a renamed temporary file so a crash leaves either version
+func (s *Spool) dropLines(n int) error {
+	lines, err := s.readLines()
+	if err != nil {
+		return err
+	}
+	if n > len(lines) {
+		n = len(lines)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	writer := bufio.NewWriter(tmp)
+	for _, line := range lines[n:] {
+		writer.Write(line)
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
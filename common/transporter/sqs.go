@@ -1,14 +1,39 @@
 package transporter
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
+// SQSBatchSize is the maximum number of entries accepted by
+// SendMessageBatch and DeleteMessageBatch in a single request
+const SQSBatchSize = 10
+
+// SQSMaxMessageAttributes is the maximum number of attributes SQS and SNS accept on a message
+const SQSMaxMessageAttributes = 10
+
 type SQS struct {
-	SQSClient *sqs.SQS
+	SQSClient sqsiface.SQSAPI
+
+	// queueURLs caches the URL of every queue by name so GetQueueUrl is only
+	// called once per queue, it is only set by NewSQS
+	queueURLs *sync.Map
+}
+
+// NewSQS returns an SQS client that caches the queue URLs it resolves
+func NewSQS(client sqsiface.SQSAPI) *SQS {
+	return &SQS{
+		SQSClient: client,
+		queueURLs: &sync.Map{},
+	}
 }
 
 func (c SQS) HealthCheck(options *HealthCheckOptions) (bool, error) {
@@ -26,7 +51,10 @@ func (c SQS) HealthCheck(options *HealthCheckOptions) (bool, error) {
 // Publish module to publish sqs message for given input
 func (c SQS) Publish(options *MessagePublishOptions) error {
 
-	msgBody := aws.String(options.MessageBody.(string))
+	msgBody, ok := sqsMessageBody(options.MessageBody)
+	if !ok {
+		return fmt.Errorf("message body is not of type string, *string or []byte to publish to SQS queue: %s", options.QueueName)
+	}
 
 	queueUrl, queueUrlError := getSQSQueueURL(c, options.QueueName)
 	if queueUrlError != nil {
@@ -42,8 +70,23 @@ func (c SQS) Publish(options *MessagePublishOptions) error {
 		message.MessageGroupId = aws.String(options.MessageGroupID)
 	}
 
-	_, err := c.SQSClient.SendMessage(message)
+	if options.MessageDeduplicationID != "" {
+		message.MessageDeduplicationId = aws.String(options.MessageDeduplicationID)
+	}
+
+	if options.DelayInSeconds > 0 {
+		message.DelaySeconds = aws.Int64(options.DelayInSeconds)
+	}
+
+	messageAttributes, err := sqsMessageAttributes(options.Attributes)
+	if err != nil {
+		return fmt.Errorf("failed to publish message to SQS queue: %s, %w", options.QueueName, err)
+	}
+	message.MessageAttributes = messageAttributes
+
+	_, err = c.SQSClient.SendMessage(message)
 	if err != nil {
+		forgetSQSQueueURL(c, options.QueueName, err)
 		logger.Errorf("error in publishing message for topic: %s, for: %s", options.TopicName, err)
 		return err
 	}
@@ -69,13 +112,19 @@ func (c SQS) BatchConsume(options *MessageConsumeOptions) ([]MessageReceiveRespo
 	}
 
 	result, err := c.SQSClient.ReceiveMessage(&sqs.ReceiveMessageInput{
-		QueueUrl:            queueUrl,
-		MaxNumberOfMessages: aws.Int64(int64(options.NumberOfMessages)),
-		VisibilityTimeout:   aws.Int64(options.VisibilityTimeout),
-		WaitTimeSeconds:     aws.Int64(options.WaitTimeSeconds),
+		QueueUrl:              queueUrl,
+		MaxNumberOfMessages:   aws.Int64(int64(options.NumberOfMessages)),
+		VisibilityTimeout:     aws.Int64(options.VisibilityTimeout),
+		WaitTimeSeconds:       aws.Int64(options.WaitTimeSeconds),
+		MessageAttributeNames: aws.StringSlice([]string{"All"}),
+		AttributeNames: aws.StringSlice([]string{
+			sqs.MessageSystemAttributeNameApproximateReceiveCount,
+			sqs.MessageSystemAttributeNameMessageGroupId,
+		}),
 	})
 
 	if err != nil {
+		forgetSQSQueueURL(c, options.QueueName, err)
 		logger.Errorf("error receive message from sqs, err: %s", err.Error())
 		return nil, err
 	}
@@ -86,13 +135,119 @@ func (c SQS) BatchConsume(options *MessageConsumeOptions) ([]MessageReceiveRespo
 		resp[i].MessageBody = *msg.Body
 		resp[i].MessageReceiptHandle = *msg.ReceiptHandle
 		resp[i].MessageID = *msg.MessageId
+		resp[i].Attributes = sqsAttributesFromMessage(msg.MessageAttributes)
+		if receiveCount, ok := msg.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]; ok && receiveCount != nil {
+			resp[i].ReceiveCount, _ = strconv.ParseInt(*receiveCount, 10, 64)
+		}
+		if groupID, ok := msg.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]; ok && groupID != nil {
+			resp[i].MessageGroupID = *groupID
+		}
 	}
 
 	return resp, nil
 }
 
-func (c SQS) BatchPublish(options *MessagePublishOptions) error {
-	return fmt.Errorf("method BatchPublish not implemented yet for SQS provider")
+// BatchPublish sends options.Entries with SendMessageBatch in chunks of SQSBatchSize.
+// Entries that fail are reported in BatchResult.Failed and do not stop the remaining chunks,
+// an error is returned only when the queue URL cannot be resolved or there is nothing to send
+func (c SQS) BatchPublish(options *MessagePublishOptions) (*BatchResult, error) {
+	if len(options.Entries) == 0 {
+		return nil, fmt.Errorf("no entries to batch publish to SQS queue: %s", options.QueueName)
+	}
+
+	queueUrl, queueUrlError := getSQSQueueURL(c, options.QueueName)
+	if queueUrlError != nil {
+		return nil, queueUrlError
+	}
+
+	result := &BatchResult{}
+	for start := 0; start < len(options.Entries); start += SQSBatchSize {
+		end := start + SQSBatchSize
+		if end > len(options.Entries) {
+			end = len(options.Entries)
+		}
+
+		requestEntries := make([]*sqs.SendMessageBatchRequestEntry, 0, end-start)
+		for i, entry := range options.Entries[start:end] {
+			id := entry.ID
+			if id == "" {
+				id = strconv.Itoa(start + i)
+			}
+
+			msgBody, ok := sqsMessageBody(entry.MessageBody)
+			if !ok {
+				result.Failed = append(result.Failed, BatchResultEntry{
+					ID:          id,
+					Code:        "InvalidMessageBody",
+					Message:     "message body is not of type string, *string or []byte",
+					SenderFault: true,
+				})
+				continue
+			}
+
+			requestEntry := &sqs.SendMessageBatchRequestEntry{
+				Id:          aws.String(id),
+				MessageBody: msgBody,
+			}
+			if groupID := firstNonEmpty(entry.MessageGroupID, options.MessageGroupID); groupID != "" {
+				requestEntry.MessageGroupId = aws.String(groupID)
+			}
+			if dedupID := firstNonEmpty(entry.MessageDeduplicationID, options.MessageDeduplicationID); dedupID != "" {
+				requestEntry.MessageDeduplicationId = aws.String(dedupID)
+			}
+			if delay := entry.DelayInSeconds; delay > 0 {
+				requestEntry.DelaySeconds = aws.Int64(delay)
+			} else if options.DelayInSeconds > 0 {
+				requestEntry.DelaySeconds = aws.Int64(options.DelayInSeconds)
+			}
+			messageAttributes, err := sqsMessageAttributes(mergeAttributes(options.Attributes, entry.Attributes))
+			if err != nil {
+				result.Failed = append(result.Failed, BatchResultEntry{
+					ID:          id,
+					Code:        "TooManyAttributes",
+					Message:     err.Error(),
+					SenderFault: true,
+				})
+				continue
+			}
+			requestEntry.MessageAttributes = messageAttributes
+			requestEntries = append(requestEntries, requestEntry)
+		}
+
+		if len(requestEntries) == 0 {
+			continue
+		}
+
+		output, err := c.SQSClient.SendMessageBatch(&sqs.SendMessageBatchInput{
+			QueueUrl: queueUrl,
+			Entries:  requestEntries,
+		})
+		if err != nil {
+			logger.Errorf("error in batch publishing messages to SQS queue: %s, for: %s", options.QueueName, err)
+			for _, requestEntry := range requestEntries {
+				result.Failed = append(result.Failed, BatchResultEntry{
+					ID:      aws.StringValue(requestEntry.Id),
+					Code:    "RequestFailed",
+					Message: err.Error(),
+				})
+			}
+			continue
+		}
+
+		for _, successful := range output.Successful {
+			result.Successful = append(result.Successful, BatchResultEntry{
+				ID:        aws.StringValue(successful.Id),
+				MessageID: aws.StringValue(successful.MessageId),
+			})
+		}
+		result.merge(sqsBatchFailures(output.Failed))
+	}
+
+	for _, failed := range result.Failed {
+		logger.Errorf("message failed to be published to SQS queue: %s with ID: %s, for error %s: %s", options.QueueName, failed.ID, failed.Code, failed.Message)
+	}
+
+	return result, nil
 }
 
 func (c SQS) DeleteMessage(queueName string, message *MessageReceiveResponse) error {
@@ -105,6 +260,7 @@ func (c SQS) DeleteMessage(queueName string, message *MessageReceiveResponse) er
 		ReceiptHandle: aws.String(message.MessageReceiptHandle),
 	})
 	if err != nil {
+		forgetSQSQueueURL(c, queueName, err)
 		logger.Errorf("error in deleting SQS message with ID: %s, %s", message.MessageID, err)
 		return err
 	}
@@ -112,16 +268,339 @@ func (c SQS) DeleteMessage(queueName string, message *MessageReceiveResponse) er
 	return nil
 }
 
-func (c SQS) BatchDeleteMessage(queueName string, messages []MessageReceiveResponse) error {
-	return fmt.Errorf("method BatchDeleteMessage not implemented yet for SQS provider")
+// ChangeVisibility hides the message for visibilityTimeout seconds from now with
+// ChangeMessageVisibility, SQS caps the total visibility of a message at 12 hours
+func (c SQS) ChangeVisibility(queueName string, message *MessageReceiveResponse, visibilityTimeout int64) error {
+	queueUrl, queueUrlError := getSQSQueueURL(c, queueName)
+	if queueUrlError != nil {
+		return queueUrlError
+	}
+	_, err := c.SQSClient.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          queueUrl,
+		ReceiptHandle:     aws.String(message.MessageReceiptHandle),
+		VisibilityTimeout: aws.Int64(visibilityTimeout),
+	})
+	if err != nil {
+		forgetSQSQueueURL(c, queueName, err)
+		logger.Errorf("error in changing visibility of SQS message with ID: %s, %s", message.MessageID, err)
+		return err
+	}
+	return nil
+}
+
+// BatchDeleteMessage deletes the messages by their receipt handle with DeleteMessageBatch in
+// chunks of SQSBatchSize. Entries are identified by MessageID in the returned BatchResult
+func (c SQS) BatchDeleteMessage(queueName string, messages []MessageReceiveResponse) (*BatchResult, error) {
+	if len(messages) == 0 {
+		return &BatchResult{}, nil
+	}
+
+	queueUrl, queueUrlError := getSQSQueueURL(c, queueName)
+	if queueUrlError != nil {
+		return nil, queueUrlError
+	}
+
+	result := &BatchResult{}
+	for start := 0; start < len(messages); start += SQSBatchSize {
+		end := start + SQSBatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+
+		requestEntries := make([]*sqs.DeleteMessageBatchRequestEntry, 0, end-start)
+		for i, message := range messages[start:end] {
+			id := message.MessageID
+			if id == "" {
+				id = strconv.Itoa(start + i)
+			}
+			requestEntries = append(requestEntries, &sqs.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(id),
+				ReceiptHandle: aws.String(message.MessageReceiptHandle),
+			})
+		}
+
+		output, err := c.SQSClient.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+			QueueUrl: queueUrl,
+			Entries:  requestEntries,
+		})
+		if err != nil {
+			logger.Errorf("error in batch deleting messages on SQS queue: %s, for: %s", queueName, err)
+			for _, requestEntry := range requestEntries {
+				result.Failed = append(result.Failed, BatchResultEntry{
+					ID:      aws.StringValue(requestEntry.Id),
+					Code:    "RequestFailed",
+					Message: err.Error(),
+				})
+			}
+			continue
+		}
+
+		for _, successful := range output.Successful {
+			result.Successful = append(result.Successful, BatchResultEntry{
+				ID:        aws.StringValue(successful.Id),
+				MessageID: aws.StringValue(successful.Id),
+			})
+		}
+		result.merge(sqsBatchFailures(output.Failed))
+	}
+
+	for _, failed := range result.Failed {
+		logger.Errorf("message failed to be deleted on SQS queue: %s with ID: %s, for error %s: %s", queueName, failed.ID, failed.Code, failed.Message)
+	}
+
+	return result, nil
 }
 
 func getSQSQueueURL(c SQS, queueName string) (*string, error) {
+	if c.queueURLs != nil {
+		if queueURL, ok := c.queueURLs.Load(queueName); ok {
+			return queueURL.(*string), nil
+		}
+	}
+
 	queueURL, err := c.SQSClient.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String(queueName)})
 	if err != nil || queueURL.QueueUrl == nil {
 		logger.Errorf("error in generating queue URL for queueName, %s with error: %s", queueName, err)
 		return nil, err
 	}
+
+	if c.queueURLs != nil {
+		c.queueURLs.Store(queueName, queueURL.QueueUrl)
+	}
 	return queueURL.QueueUrl, nil
+}
+
+// forgetSQSQueueURL drops the cached URL of a queue that does not exist anymore,
+// so it is resolved again once the queue is created again
+func forgetSQSQueueURL(c SQS, queueName string, err error) {
+	if c.queueURLs == nil {
+		return
+	}
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == sqs.ErrCodeQueueDoesNotExist {
+		c.queueURLs.Delete(queueName)
+	}
+}
+
+// CreateQueue creates the queue with the given attributes, a queue with the same name
+// and attributes is left as is. The dead-letter queue of the redrive policy must exist
+func (c SQS) CreateQueue(queueName string, attributes *QueueAttributes) error {
+	if attributes == nil {
+		attributes = &QueueAttributes{}
+	}
+	if attributes.FIFO && !strings.HasSuffix(queueName, ".fifo") {
+		return fmt.Errorf("name of FIFO SQS queue: %s must end with .fifo", queueName)
+	}
 
+	queueAttributes := map[string]*string{}
+	if attributes.FIFO {
+		queueAttributes[sqs.QueueAttributeNameFifoQueue] = aws.String("true")
+	}
+	if attributes.ContentBasedDeduplication {
+		queueAttributes[sqs.QueueAttributeNameContentBasedDeduplication] = aws.String("true")
+	}
+	for name, value := range map[string]int64{
+		sqs.QueueAttributeNameVisibilityTimeout:      attributes.VisibilityTimeout,
+		sqs.QueueAttributeNameMessageRetentionPeriod: attributes.MessageRetentionPeriod,
+		sqs.QueueAttributeNameDelaySeconds:           attributes.DelayInSeconds,
+		sqs.QueueAttributeNameMaximumMessageSize:     attributes.MaxMessageSize,
+	} {
+		if value > 0 {
+			queueAttributes[name] = aws.String(strconv.FormatInt(value, 10))
+		}
+	}
+	if policy := attributes.RedrivePolicy; policy != nil {
+		deadLetterARN, err := c.getSQSQueueARN(policy.DeadLetterQueueName)
+		if err != nil {
+			return err
+		}
+		redrivePolicy, err := json.Marshal(map[string]string{
+			"deadLetterTargetArn": deadLetterARN,
+			"maxReceiveCount":     strconv.FormatInt(policy.MaxReceiveCount, 10),
+		})
+		if err != nil {
+			return err
+		}
+		queueAttributes[sqs.QueueAttributeNameRedrivePolicy] = aws.String(string(redrivePolicy))
+	}
+
+	input := &sqs.CreateQueueInput{QueueName: aws.String(queueName)}
+	if len(queueAttributes) > 0 {
+		input.Attributes = queueAttributes
+	}
+	output, err := c.SQSClient.CreateQueue(input)
+	if err != nil {
+		logger.Errorf("error in creating SQS queue: %s, %s", queueName, err)
+		return err
+	}
+
+	if c.queueURLs != nil && output.QueueUrl != nil {
+		c.queueURLs.Store(queueName, output.QueueUrl)
+	}
+	return nil
+}
+
+func (c SQS) DeleteQueue(queueName string) error {
+	queueUrl, err := getSQSQueueURL(c, queueName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.SQSClient.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: queueUrl}); err != nil {
+		logger.Errorf("error in deleting SQS queue: %s, %s", queueName, err)
+		return err
+	}
+	if c.queueURLs != nil {
+		c.queueURLs.Delete(queueName)
+	}
+	return nil
+}
+
+// GetQueueCounts returns the ApproximateNumberOfMessages attributes of the queue
+func (c SQS) GetQueueCounts(queueName string) (*QueueCounts, error) {
+	attributes, err := c.getSQSQueueAttributes(queueName,
+		sqs.QueueAttributeNameApproximateNumberOfMessages,
+		sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+		sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed)
+	if err != nil {
+		return nil, err
+	}
+
+	count := func(name string) int64 {
+		value, _ := strconv.ParseInt(aws.StringValue(attributes[name]), 10, 64)
+		return value
+	}
+	return &QueueCounts{
+		Visible:  count(sqs.QueueAttributeNameApproximateNumberOfMessages),
+		InFlight: count(sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible),
+		Delayed:  count(sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed),
+	}, nil
+}
+
+// PurgeQueue deletes every message of the queue, SQS allows one purge per queue every 60 seconds
+func (c SQS) PurgeQueue(queueName string) error {
+	queueUrl, err := getSQSQueueURL(c, queueName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.SQSClient.PurgeQueue(&sqs.PurgeQueueInput{QueueUrl: queueUrl}); err != nil {
+		logger.Errorf("error in purging SQS queue: %s, %s", queueName, err)
+		return err
+	}
+	logger.Info("purged queue:", queueName)
+	return nil
+}
+
+func (c SQS) ListQueues(prefix string) ([]string, error) {
+	input := &sqs.ListQueuesInput{}
+	if prefix != "" {
+		input.QueueNamePrefix = aws.String(prefix)
+	}
+
+	var queueNames []string
+	err := c.SQSClient.ListQueuesPages(input, func(output *sqs.ListQueuesOutput, lastPage bool) bool {
+		for _, queueUrl := range output.QueueUrls {
+			queueName := aws.StringValue(queueUrl)
+			queueName = queueName[strings.LastIndex(queueName, "/")+1:]
+			if c.queueURLs != nil {
+				c.queueURLs.Store(queueName, queueUrl)
+			}
+			queueNames = append(queueNames, queueName)
+		}
+		return true
+	})
+	if err != nil {
+		logger.Errorf("error in listing SQS queues with prefix: %s, %s", prefix, err)
+		return nil, err
+	}
+	return queueNames, nil
+}
+
+func (c SQS) getSQSQueueARN(queueName string) (string, error) {
+	attributes, err := c.getSQSQueueAttributes(queueName, sqs.QueueAttributeNameQueueArn)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(attributes[sqs.QueueAttributeNameQueueArn]), nil
+}
+
+func (c SQS) getSQSQueueAttributes(queueName string, names ...string) (map[string]*string, error) {
+	queueUrl, err := getSQSQueueURL(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := c.SQSClient.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       queueUrl,
+		AttributeNames: aws.StringSlice(names),
+	})
+	if err != nil {
+		forgetSQSQueueURL(c, queueName, err)
+		logger.Errorf("error in getting attributes of SQS queue: %s, %s", queueName, err)
+		return nil, err
+	}
+	return output.Attributes, nil
+}
+
+func sqsBatchFailures(failed []*sqs.BatchResultErrorEntry) *BatchResult {
+	result := &BatchResult{}
+	for _, entry := range failed {
+		result.Failed = append(result.Failed, BatchResultEntry{
+			ID:          aws.StringValue(entry.Id),
+			Code:        aws.StringValue(entry.Code),
+			Message:     aws.StringValue(entry.Message),
+			SenderFault: aws.BoolValue(entry.SenderFault),
+		})
+	}
+	return result
+}
+
+func sqsMessageBody(body interface{}) (*string, bool) {
+	switch v := body.(type) {
+	case string:
+		return aws.String(v), true
+	case *string:
+		if v == nil {
+			return nil, false
+		}
+		return v, true
+	case []byte:
+		return aws.String(string(v)), true
+	}
+	return nil, false
+}
+
+// sqsMessageAttributes sends every attribute as a String MessageAttribute, the trace
+// headers are packed in one attribute. nil is returned when there is none since SQS
+// rejects an empty map, and an error when there are more than SQS accepts
+func sqsMessageAttributes(attributes map[string]string) (map[string]*sqs.MessageAttributeValue, error) {
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+	attributes = packTraceAttributes(attributes)
+	if len(attributes) > SQSMaxMessageAttributes {
+		return nil, fmt.Errorf("message has %d attributes, SQS accepts at most %d", len(attributes), SQSMaxMessageAttributes)
+	}
+	messageAttributes := make(map[string]*sqs.MessageAttributeValue, len(attributes))
+	for name, value := range attributes {
+		messageAttributes[name] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+	return messageAttributes, nil
+}
+
+func sqsAttributesFromMessage(messageAttributes map[string]*sqs.MessageAttributeValue) map[string]string {
+	if len(messageAttributes) == 0 {
+		return nil
+	}
+	attributes := make(map[string]string, len(messageAttributes))
+	for name, value := range messageAttributes {
+		if value == nil || value.StringValue == nil {
+			continue
+		}
+		attributes[name] = *value.StringValue
+	}
+	return unpackTraceAttributes(attributes)
 }
@@ -2,12 +2,18 @@ package transporter
 
 import (
 	"encoding/json"
-	"github.com/aws/aws-sdk-go/aws"
+	"fmt"
 	"os"
+	"strconv"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -296,3 +302,372 @@ func TestSQSPublishWithMessageGroupID(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	sendBatches   [][]*sqs.SendMessageBatchRequestEntry
+	deleteBatches [][]*sqs.DeleteMessageBatchRequestEntry
+	failedIDs     map[string]bool
+	sent          []*sqs.SendMessageInput
+	queueURLCalls int
+	created       []*sqs.CreateQueueInput
+	purged        []string
+	sendErr       error
+	visibility    []*sqs.ChangeMessageVisibilityInput
+}
+
+func (f *fakeSQS) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	if f.sendErr != nil {
+		return nil, f.sendErr
+	}
+	f.sent = append(f.sent, input)
+	return &sqs.SendMessageOutput{MessageId: aws.String(strconv.Itoa(len(f.sent)))}, nil
+}
+
+func (f *fakeSQS) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	output := &sqs.ReceiveMessageOutput{}
+	for i, sent := range f.sent {
+		message := &sqs.Message{
+			Body:          sent.MessageBody,
+			MessageId:     aws.String(strconv.Itoa(i + 1)),
+			ReceiptHandle: aws.String("handle-" + strconv.Itoa(i+1)),
+		}
+		if len(input.MessageAttributeNames) > 0 {
+			message.MessageAttributes = sent.MessageAttributes
+		}
+		if len(input.AttributeNames) > 0 {
+			message.Attributes = map[string]*string{sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("2")}
+			if sent.MessageGroupId != nil {
+				message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId] = sent.MessageGroupId
+			}
+		}
+		output.Messages = append(output.Messages, message)
+	}
+	f.sent = nil
+	return output, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	if aws.StringValue(input.ReceiptHandle) == "" {
+		return nil, awserr.New(sqs.ErrCodeReceiptHandleIsInvalid, "receipt handle is invalid", nil)
+	}
+	f.visibility = append(f.visibility, input)
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (f *fakeSQS) GetQueueUrl(input *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	f.queueURLCalls++
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String("https://sqs.local/" + aws.StringValue(input.QueueName))}, nil
+}
+
+func (f *fakeSQS) SendMessageBatch(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	f.sendBatches = append(f.sendBatches, input.Entries)
+	output := &sqs.SendMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		if f.failedIDs[aws.StringValue(entry.Id)] {
+			output.Failed = append(output.Failed, &sqs.BatchResultErrorEntry{
+				Id:          entry.Id,
+				Code:        aws.String("InvalidParameterValue"),
+				Message:     aws.String("invalid entry"),
+				SenderFault: aws.Bool(true),
+			})
+			continue
+		}
+		output.Successful = append(output.Successful, &sqs.SendMessageBatchResultEntry{
+			Id:        entry.Id,
+			MessageId: aws.String("msg-" + aws.StringValue(entry.Id)),
+		})
+	}
+	return output, nil
+}
+
+func (f *fakeSQS) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	f.deleteBatches = append(f.deleteBatches, input.Entries)
+	output := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		if f.failedIDs[aws.StringValue(entry.Id)] {
+			output.Failed = append(output.Failed, &sqs.BatchResultErrorEntry{
+				Id:          entry.Id,
+				Code:        aws.String("ReceiptHandleIsInvalid"),
+				SenderFault: aws.Bool(true),
+			})
+			continue
+		}
+		output.Successful = append(output.Successful, &sqs.DeleteMessageBatchResultEntry{Id: entry.Id})
+	}
+	return output, nil
+}
+
+func TestSQSBatchPublishChunksEntries(t *testing.T) {
+	fake := &fakeSQS{failedIDs: map[string]bool{"3": true}}
+	client := SQS{SQSClient: fake}
+
+	entries := make([]MessagePublishEntry, 23)
+	for i := range entries {
+		entries[i] = MessagePublishEntry{MessageBody: fmt.Sprintf("message %d", i)}
+	}
+	entries[5].MessageGroupID = "group-5"
+	entries[5].MessageDeduplicationID = "dedup-5"
+	entries[5].DelayInSeconds = 30
+	entries[7].MessageBody = 7
+
+	t.Run("test SQS batch publish splits entries in groups of 10", func(t *testing.T) {
+		result, err := client.BatchPublish(&MessagePublishOptions{
+			QueueName:      sqsQueue,
+			MessageGroupID: "default-group",
+			Entries:        entries,
+		})
+		assert.Nil(t, err)
+		assert.Len(t, fake.sendBatches, 3)
+		assert.Len(t, fake.sendBatches[0], 9)
+		assert.Len(t, fake.sendBatches[1], 10)
+		assert.Len(t, fake.sendBatches[2], 3)
+		assert.Len(t, result.Successful, 21)
+		assert.Len(t, result.Failed, 2)
+		assert.True(t, result.HasFailed())
+
+		sent := fake.sendBatches[0][5]
+		assert.Equal(t, "group-5", aws.StringValue(sent.MessageGroupId))
+		assert.Equal(t, "dedup-5", aws.StringValue(sent.MessageDeduplicationId))
+		assert.Equal(t, int64(30), aws.Int64Value(sent.DelaySeconds))
+		assert.Equal(t, "default-group", aws.StringValue(fake.sendBatches[0][0].MessageGroupId))
+	})
+
+	t.Run("test SQS batch publish reports failed entries", func(t *testing.T) {
+		failed := map[string]string{}
+		client := SQS{SQSClient: &fakeSQS{failedIDs: map[string]bool{"3": true}}}
+		result, _ := client.BatchPublish(&MessagePublishOptions{QueueName: sqsQueue, Entries: entries})
+		for _, entry := range result.Failed {
+			failed[entry.ID] = entry.Code
+		}
+		assert.Equal(t, map[string]string{"3": "InvalidParameterValue", "7": "InvalidMessageBody"}, failed)
+	})
+
+	t.Run("test SQS batch publish without entries", func(t *testing.T) {
+		_, err := client.BatchPublish(&MessagePublishOptions{QueueName: sqsQueue})
+		assert.Error(t, err)
+	})
+}
+
+func TestSQSBatchDeleteMessageChunksEntries(t *testing.T) {
+	fake := &fakeSQS{failedIDs: map[string]bool{"id-11": true}}
+	client := SQS{SQSClient: fake}
+
+	messages := make([]MessageReceiveResponse, 12)
+	for i := range messages {
+		messages[i] = MessageReceiveResponse{
+			MessageID:            fmt.Sprintf("id-%d", i),
+			MessageReceiptHandle: fmt.Sprintf("receipt-%d", i),
+		}
+	}
+
+	t.Run("test SQS batch delete splits messages in groups of 10", func(t *testing.T) {
+		result, err := client.BatchDeleteMessage(sqsQueue, messages)
+		assert.Nil(t, err)
+		assert.Len(t, fake.deleteBatches, 2)
+		assert.Len(t, fake.deleteBatches[1], 2)
+		assert.Len(t, result.Successful, 11)
+		assert.Equal(t, []BatchResultEntry{{ID: "id-11", Code: "ReceiptHandleIsInvalid", SenderFault: true}}, result.Failed)
+	})
+}
+
+func TestSQSPublishAndConsumeMessageAttributes(t *testing.T) {
+	fake := &fakeSQS{}
+	client := SQS{SQSClient: fake}
+
+	err := client.Publish(&MessagePublishOptions{
+		QueueName:   "finance",
+		MessageBody: "UPDATE_STOPLOSS_INCREMENT",
+		Attributes:  map[string]string{AttributeRequestID: "req-1"},
+	})
+
+	t.Run("test ok attributes are sent as string message attributes", func(t *testing.T) {
+		assert.Nil(t, err)
+		require.Len(t, fake.sent, 1)
+		attribute := fake.sent[0].MessageAttributes[AttributeRequestID]
+		require.NotNil(t, attribute)
+		assert.Equal(t, "String", aws.StringValue(attribute.DataType))
+		assert.Equal(t, "req-1", aws.StringValue(attribute.StringValue))
+	})
+
+	t.Run("test ok attributes are received", func(t *testing.T) {
+		msgs, err := client.BatchConsume(&MessageConsumeOptions{QueueName: "finance", NumberOfMessages: 1})
+		assert.Nil(t, err)
+		require.Len(t, msgs, 1)
+		assert.Equal(t, map[string]string{AttributeRequestID: "req-1"}, msgs[0].Attributes)
+	})
+}
+
+func TestSQSMessageAttributesLimit(t *testing.T) {
+	fake := &fakeSQS{}
+	client := SQS{SQSClient: fake}
+
+	attributes := map[string]string{
+		"x-datadog-trace-id":             "1",
+		"x-datadog-parent-id":            "2",
+		"x-datadog-sampling-priority":    "1",
+		"x-datadog-tags":                 "_dd.p.dm=-0",
+		"traceparent":                    "00-00000000000000000000000000000001-0000000000000002-01",
+		"tracestate":                     "dd=s:1",
+		AttributeRequestID:               "req-1",
+		AttributeContentType:             "application/json",
+		AttributeContentEncoding:         "gzip",
+		AttributeContentTransferEncoding: "base64",
+		AttributeIdempotencyKey:          "stoploss-1",
+	}
+
+	t.Run("test ok trace headers are packed in one attribute", func(t *testing.T) {
+		err := client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "HALO", Attributes: attributes})
+		assert.Nil(t, err)
+		require.Len(t, fake.sent, 1)
+		assert.Len(t, fake.sent[0].MessageAttributes, 6)
+		assert.NotNil(t, fake.sent[0].MessageAttributes[AttributeTraceContext])
+
+		msgs, err := client.BatchConsume(&MessageConsumeOptions{QueueName: "finance", NumberOfMessages: 1})
+		assert.Nil(t, err)
+		require.Len(t, msgs, 1)
+		assert.Equal(t, attributes, msgs[0].Attributes)
+	})
+
+	t.Run("test wrong more attributes than SQS accepts", func(t *testing.T) {
+		tooMany := map[string]string{}
+		for i := 0; i <= SQSMaxMessageAttributes; i++ {
+			tooMany["attribute-"+strconv.Itoa(i)] = "value"
+		}
+		err := client.Publish(&MessagePublishOptions{QueueName: "finance", MessageBody: "HALO", Attributes: tooMany})
+		assert.Error(t, err)
+		assert.Empty(t, fake.sent)
+	})
+}
+
+func TestSQSConsumeReceiveCountAndMessageGroupID(t *testing.T) {
+	fake := &fakeSQS{}
+	client := SQS{SQSClient: fake}
+	require.NoError(t, client.Publish(&MessagePublishOptions{
+		QueueName:              "finance.fifo",
+		MessageBody:            "UPDATE_STOPLOSS_INCREMENT",
+		MessageGroupID:         "stoploss",
+		MessageDeduplicationID: "stoploss-1",
+	}))
+
+	msgs, err := client.BatchConsume(&MessageConsumeOptions{QueueName: "finance.fifo", NumberOfMessages: 1})
+	assert.Nil(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, int64(2), msgs[0].ReceiveCount)
+	assert.Equal(t, "stoploss", msgs[0].MessageGroupID)
+}
+
+func (f *fakeSQS) CreateQueue(input *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error) {
+	f.created = append(f.created, input)
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String("https://sqs.local/" + aws.StringValue(input.QueueName))}, nil
+}
+
+func (f *fakeSQS) DeleteQueue(input *sqs.DeleteQueueInput) (*sqs.DeleteQueueOutput, error) {
+	return &sqs.DeleteQueueOutput{}, nil
+}
+
+func (f *fakeSQS) PurgeQueue(input *sqs.PurgeQueueInput) (*sqs.PurgeQueueOutput, error) {
+	f.purged = append(f.purged, aws.StringValue(input.QueueUrl))
+	return &sqs.PurgeQueueOutput{}, nil
+}
+
+func (f *fakeSQS) GetQueueAttributes(input *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	return &sqs.GetQueueAttributesOutput{Attributes: map[string]*string{
+		sqs.QueueAttributeNameQueueArn:                              aws.String("arn:aws:sqs:ap-southeast-1:1:" + aws.StringValue(input.QueueUrl)[len("https://sqs.local/"):]),
+		sqs.QueueAttributeNameApproximateNumberOfMessages:           aws.String("12"),
+		sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible: aws.String("3"),
+		sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed:    aws.String("1"),
+	}}, nil
+}
+
+func (f *fakeSQS) ListQueuesPages(input *sqs.ListQueuesInput, fn func(*sqs.ListQueuesOutput, bool) bool) error {
+	fn(&sqs.ListQueuesOutput{QueueUrls: aws.StringSlice([]string{"https://sqs.local/claim", "https://sqs.local/claim-dlq"})}, false)
+	fn(&sqs.ListQueuesOutput{QueueUrls: aws.StringSlice([]string{"https://sqs.local/claim.fifo"})}, true)
+	return nil
+}
+
+func TestSQSQueueURLCache(t *testing.T) {
+	fake := &fakeSQS{}
+	client := NewSQS(fake)
+
+	t.Run("test ok queue URL is resolved once", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "claim", MessageBody: "claim"}))
+		}
+		assert.Equal(t, 1, fake.queueURLCalls)
+	})
+
+	t.Run("test ok queue URL is resolved again when the queue does not exist", func(t *testing.T) {
+		fake.sendErr = awserr.New(sqs.ErrCodeQueueDoesNotExist, "queue does not exist", nil)
+		assert.Error(t, client.Publish(&MessagePublishOptions{QueueName: "claim", MessageBody: "claim"}))
+
+		fake.sendErr = nil
+		require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "claim", MessageBody: "claim"}))
+		assert.Equal(t, 2, fake.queueURLCalls)
+	})
+}
+
+func TestSQSChangeVisibility(t *testing.T) {
+	fake := &fakeSQS{}
+	client := NewSQS(fake)
+
+	t.Run("test ok visibility is changed with the receipt handle", func(t *testing.T) {
+		err := client.ChangeVisibility("certificate", &MessageReceiveResponse{MessageID: "1", MessageReceiptHandle: "handle-1"}, 120)
+		assert.Nil(t, err)
+		require.Len(t, fake.visibility, 1)
+		assert.Equal(t, "https://sqs.local/certificate", aws.StringValue(fake.visibility[0].QueueUrl))
+		assert.Equal(t, "handle-1", aws.StringValue(fake.visibility[0].ReceiptHandle))
+		assert.Equal(t, int64(120), aws.Int64Value(fake.visibility[0].VisibilityTimeout))
+	})
+
+	t.Run("test wrong receipt handle", func(t *testing.T) {
+		assert.Error(t, client.ChangeVisibility("certificate", &MessageReceiveResponse{MessageID: "2"}, 120))
+	})
+}
+
+func TestSQSQueueAdmin(t *testing.T) {
+	fake := &fakeSQS{}
+	admin, err := NewQueueAdmin(NewSQS(fake))
+	require.NoError(t, err)
+
+	t.Run("test wrong FIFO queue name", func(t *testing.T) {
+		err := admin.CreateQueue("claim", &QueueAttributes{FIFO: true})
+		assert.Error(t, err)
+	})
+
+	t.Run("test ok create queue with attributes", func(t *testing.T) {
+		err := admin.CreateQueue("claim.fifo", &QueueAttributes{
+			FIFO:              true,
+			VisibilityTimeout: 60,
+			RedrivePolicy:     &RedrivePolicy{DeadLetterQueueName: "claim-dlq", MaxReceiveCount: 5},
+		})
+		require.NoError(t, err)
+		require.Len(t, fake.created, 1)
+
+		attributes := aws.StringValueMap(fake.created[0].Attributes)
+		assert.Equal(t, "true", attributes[sqs.QueueAttributeNameFifoQueue])
+		assert.Equal(t, "60", attributes[sqs.QueueAttributeNameVisibilityTimeout])
+		assert.JSONEq(t, `{"deadLetterTargetArn":"arn:aws:sqs:ap-southeast-1:1:claim-dlq","maxReceiveCount":"5"}`,
+			attributes[sqs.QueueAttributeNameRedrivePolicy])
+		assert.NotContains(t, attributes, sqs.QueueAttributeNameDelaySeconds)
+	})
+
+	t.Run("test ok counts, purge and list", func(t *testing.T) {
+		counts, err := admin.GetQueueCounts("claim.fifo")
+		require.NoError(t, err)
+		assert.Equal(t, &QueueCounts{Visible: 12, InFlight: 3, Delayed: 1}, counts)
+
+		require.NoError(t, admin.PurgeQueue("claim.fifo"))
+		assert.Equal(t, []string{"https://sqs.local/claim.fifo"}, fake.purged)
+
+		queueNames, err := admin.ListQueues("claim")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"claim", "claim-dlq", "claim.fifo"}, queueNames)
+		assert.Nil(t, admin.DeleteQueue("claim.fifo"))
+	})
+
+	t.Run("test wrong provider without queue administration", func(t *testing.T) {
+		_, err := NewQueueAdmin(NewMemory())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,96 @@
+package transporter
+
+import (
+	"fmt"
+	"strings"
+
+	ali_mns "github.com/aliyun/aliyun-mns-go-sdk"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/nats-io/nats.go"
+)
+
+// AttributeEventType is the attribute MNS topics filter on by default, MNS can only
+// filter on the message tag
+const AttributeEventType = "Event-Type"
+
+// FilterPolicy selects the messages a subscription receives by their attributes.
+// A message matches when, for every attribute of the policy, its value is one of
+// the listed values. An empty policy matches every message
+type FilterPolicy map[string][]string
+
+// Match reports whether a message with attributes is delivered to the subscription
+func (p FilterPolicy) Match(attributes map[string]string) bool {
+	for name, values := range p {
+		value, ok := attributes[name]
+		if !ok {
+			return false
+		}
+		matched := false
+		for _, allowed := range values {
+			if value == allowed {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// TopicSubscription delivers the messages published to TopicName into QueueName,
+// ID identifies the subscription on the provider and is used to unsubscribe
+type TopicSubscription struct {
+	ID           string       `json:"id"`
+	TopicName    string       `json:"topicName"`
+	QueueName    string       `json:"queueName"`
+	FilterPolicy FilterPolicy `json:"filterPolicy,omitempty"`
+}
+
+// TopicClient fans out a message published to a topic to every queue subscribed
+// to it, consumers keep reading their queue with a Client. PublishToTopic uses
+// TopicName, MessageBody and Attributes of the options, the attributes are the
+// ones the filter policies are matched against
+type TopicClient interface {
+	PublishToTopic(options *MessagePublishOptions) error
+	Subscribe(topicName string, queueName string, filterPolicy FilterPolicy) (*TopicSubscription, error)
+	Unsubscribe(subscription *TopicSubscription) error
+	ListSubscriptions(topicName string) ([]TopicSubscription, error)
+}
+
+// NewTopicClient initializes a new topic client depending on the provider,
+// use NewMemoryTopic for the in-process implementation
+func NewTopicClient(options *ClientOptions) (TopicClient, error) {
+	provider := strings.ToLower(options.Provider)
+	switch provider {
+	case "alicloud":
+		client := ali_mns.NewAliMNSClient(
+			options.Endpoint,
+			options.AccessKeyID,
+			options.AccessKeySecret)
+		topic := NewMNSTopic(client)
+		topic.PropagateAttributes = options.PropagateAttributes
+		return topic, nil
+	case "nats-jetstream":
+		connString := fmt.Sprintf("%s:%s", options.Host, options.Port)
+		conn, err := nats.Connect(connString)
+		if err != nil {
+			logger.Error("Error in initializing NATS topic client")
+			return nil, err
+		}
+		jetStream, err := NewJetStream(conn)
+		if err != nil {
+			return nil, err
+		}
+		return NewNatsTopic(conn, jetStream), nil
+	case "aws":
+		awsSess, err := GetAWSSession(options.AccessKeyID, options.AccessKeySecret, options.Region)
+		if err != nil {
+			return nil, err
+		}
+		return NewSNS(sns.New(awsSess), sqs.New(awsSess)), nil
+	}
+	return nil, fmt.Errorf("provider:\"%s\" does not support topics in transporter module", options.Provider)
+}
@@ -0,0 +1,287 @@
+package transporter
+
+import (
+	"testing"
+	"time"
+
+	ali_mns "github.com/aliyun/aliyun-mns-go-sdk"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterPolicyMatch(t *testing.T) {
+	policy := FilterPolicy{"event": {"UPDATE_STATUS", "CANCEL"}, "product": {"car"}}
+
+	t.Run("test ok every attribute matches", func(t *testing.T) {
+		assert.True(t, policy.Match(map[string]string{"event": "CANCEL", "product": "car", "source": "quotation"}))
+		assert.True(t, FilterPolicy(nil).Match(nil))
+	})
+
+	t.Run("test ok missing or other value does not match", func(t *testing.T) {
+		assert.False(t, policy.Match(map[string]string{"event": "CANCEL"}))
+		assert.False(t, policy.Match(map[string]string{"event": "CREATE", "product": "car"}))
+	})
+}
+
+func TestMemoryTopic(t *testing.T) {
+	client := NewMemory()
+	topic := NewMemoryTopic(client)
+
+	_, err := topic.Subscribe("policy-status", "quotation", nil)
+	require.NoError(t, err)
+	_, err = topic.Subscribe("policy-status", "finance", FilterPolicy{"event": {"UPDATE_STATUS"}})
+	require.NoError(t, err)
+	notification, err := topic.Subscribe("policy-status", "notification", nil)
+	require.NoError(t, err)
+
+	t.Run("test ok message is fanned out to the matching queues", func(t *testing.T) {
+		require.NoError(t, topic.PublishToTopic(&MessagePublishOptions{
+			TopicName:   "policy-status",
+			MessageBody: "P-1 ACTIVE",
+			Attributes:  map[string]string{"event": "UPDATE_STATUS"},
+		}))
+		require.NoError(t, topic.PublishToTopic(&MessagePublishOptions{
+			TopicName:   "policy-status",
+			MessageBody: "P-1 CREATED",
+			Attributes:  map[string]string{"event": "CREATE"},
+		}))
+
+		assert.Equal(t, []interface{}{"P-1 ACTIVE", "P-1 CREATED"}, client.Drain("quotation"))
+		assert.Equal(t, []interface{}{"P-1 ACTIVE"}, client.Drain("finance"))
+		assert.Equal(t, []interface{}{"P-1 ACTIVE", "P-1 CREATED"}, client.Drain("notification"))
+	})
+
+	t.Run("test ok unsubscribe and list", func(t *testing.T) {
+		require.NoError(t, topic.Unsubscribe(notification))
+		assert.Error(t, topic.Unsubscribe(notification))
+
+		subscriptions, err := topic.ListSubscriptions("policy-status")
+		require.NoError(t, err)
+		require.Len(t, subscriptions, 2)
+		assert.Equal(t, "quotation", subscriptions[0].QueueName)
+		assert.Equal(t, FilterPolicy{"event": {"UPDATE_STATUS"}}, subscriptions[1].FilterPolicy)
+	})
+
+	t.Run("test wrong empty topic name", func(t *testing.T) {
+		assert.Error(t, topic.PublishToTopic(&MessagePublishOptions{MessageBody: "P-1"}))
+	})
+}
+
+func TestNatsTopic(t *testing.T) {
+	js := newJetStreamTestClient(t)
+	topic := NewNatsTopic(js.Connection, js)
+
+	_, err := topic.Subscribe("policy.status", "quotation", nil)
+	require.NoError(t, err)
+	_, err = topic.Subscribe("policy.status", "finance", FilterPolicy{"event": {"UPDATE_STATUS"}})
+	require.NoError(t, err)
+
+	consume := func(queueName string) *MessageReceiveResponse {
+		msg, err := js.Consume(&MessageConsumeOptions{QueueName: queueName, WaitTimeSeconds: 2, VisibilityTimeout: 30, DeleteMessageAfterAck: true})
+		if err != nil {
+			return nil
+		}
+		return msg
+	}
+
+	t.Run("test ok message is forwarded to the matching queues", func(t *testing.T) {
+		require.NoError(t, topic.PublishToTopic(&MessagePublishOptions{
+			TopicName:   "policy.status",
+			MessageBody: "P-1 ACTIVE",
+			Attributes:  map[string]string{"event": "UPDATE_STATUS"},
+		}))
+		require.NoError(t, topic.PublishToTopic(&MessagePublishOptions{
+			TopicName:   "policy.status",
+			MessageBody: "P-1 CREATED",
+			Attributes:  map[string]string{"event": "CREATE"},
+		}))
+		require.NoError(t, js.Connection.Flush())
+
+		require.Eventually(t, func() bool {
+			counts, err := js.GetQueueCounts("quotation")
+			return err == nil && counts.Visible == 2
+		}, 5*time.Second, 50*time.Millisecond)
+
+		msg := consume("finance")
+		require.NotNil(t, msg)
+		assert.Equal(t, "P-1 ACTIVE", msg.MessageBody)
+		assert.Equal(t, "UPDATE_STATUS", msg.Attributes["event"])
+		assert.Nil(t, consume("finance"))
+	})
+
+	t.Run("test ok list and unsubscribe", func(t *testing.T) {
+		subscriptions, err := topic.ListSubscriptions("policy.status")
+		require.NoError(t, err)
+		require.Len(t, subscriptions, 2)
+		assert.Equal(t, "finance", subscriptions[0].QueueName)
+
+		require.NoError(t, topic.Unsubscribe(&subscriptions[0]))
+		subscriptions, err = topic.ListSubscriptions("policy.status")
+		require.NoError(t, err)
+		assert.Len(t, subscriptions, 1)
+	})
+}
+
+type fakeSNS struct {
+	snsiface.SNSAPI
+	published  []*sns.PublishInput
+	subscribed []*sns.SubscribeInput
+}
+
+func (f *fakeSNS) ListTopicsPages(input *sns.ListTopicsInput, fn func(*sns.ListTopicsOutput, bool) bool) error {
+	fn(&sns.ListTopicsOutput{Topics: []*sns.Topic{{TopicArn: aws.String("arn:aws:sns:ap-southeast-1:1:claim-status")}}}, false)
+	fn(&sns.ListTopicsOutput{Topics: []*sns.Topic{{TopicArn: aws.String("arn:aws:sns:ap-southeast-1:1:policy-status")}}}, true)
+	return nil
+}
+
+func (f *fakeSNS) Publish(input *sns.PublishInput) (*sns.PublishOutput, error) {
+	f.published = append(f.published, input)
+	return &sns.PublishOutput{MessageId: aws.String("1")}, nil
+}
+
+func (f *fakeSNS) Subscribe(input *sns.SubscribeInput) (*sns.SubscribeOutput, error) {
+	f.subscribed = append(f.subscribed, input)
+	return &sns.SubscribeOutput{SubscriptionArn: aws.String(aws.StringValue(input.TopicArn) + ":sub-1")}, nil
+}
+
+func (f *fakeSNS) ListSubscriptionsByTopicPages(input *sns.ListSubscriptionsByTopicInput, fn func(*sns.ListSubscriptionsByTopicOutput, bool) bool) error {
+	fn(&sns.ListSubscriptionsByTopicOutput{Subscriptions: []*sns.Subscription{
+		{SubscriptionArn: aws.String("sub-1"), Protocol: aws.String("sqs"), Endpoint: aws.String("arn:aws:sqs:ap-southeast-1:1:finance")},
+		{SubscriptionArn: aws.String("sub-2"), Protocol: aws.String("email"), Endpoint: aws.String("ops@example.com")},
+		{SubscriptionArn: aws.String("PendingConfirmation"), Protocol: aws.String("sqs"), Endpoint: aws.String("arn:aws:sqs:ap-southeast-1:1:quotation")},
+	}}, true)
+	return nil
+}
+
+func (f *fakeSNS) GetSubscriptionAttributes(input *sns.GetSubscriptionAttributesInput) (*sns.GetSubscriptionAttributesOutput, error) {
+	return &sns.GetSubscriptionAttributesOutput{Attributes: map[string]*string{
+		"FilterPolicy": aws.String(`{"event":["UPDATE_STATUS"]}`),
+	}}, nil
+}
+
+func TestSNSTopic(t *testing.T) {
+	fake := &fakeSNS{}
+	topic := NewSNS(fake, &fakeSQS{})
+
+	t.Run("test ok publish with attributes", func(t *testing.T) {
+		require.NoError(t, topic.PublishToTopic(&MessagePublishOptions{
+			TopicName:   "policy-status",
+			MessageBody: "P-1 ACTIVE",
+			Attributes:  map[string]string{"event": "UPDATE_STATUS"},
+		}))
+		require.Len(t, fake.published, 1)
+		assert.Equal(t, "arn:aws:sns:ap-southeast-1:1:policy-status", aws.StringValue(fake.published[0].TopicArn))
+		assert.Equal(t, "UPDATE_STATUS", aws.StringValue(fake.published[0].MessageAttributes["event"].StringValue))
+	})
+
+	t.Run("test wrong unknown topic", func(t *testing.T) {
+		assert.Error(t, topic.PublishToTopic(&MessagePublishOptions{TopicName: "unknown", MessageBody: "P-1"}))
+	})
+
+	t.Run("test ok subscribe queue with raw delivery and filter policy", func(t *testing.T) {
+		subscription, err := topic.Subscribe("policy-status", "finance", FilterPolicy{"event": {"UPDATE_STATUS"}})
+		require.NoError(t, err)
+		assert.Equal(t, "arn:aws:sns:ap-southeast-1:1:policy-status:sub-1", subscription.ID)
+
+		input := fake.subscribed[0]
+		assert.Equal(t, "sqs", aws.StringValue(input.Protocol))
+		assert.Equal(t, "arn:aws:sqs:ap-southeast-1:1:finance", aws.StringValue(input.Endpoint))
+		assert.Equal(t, "true", aws.StringValue(input.Attributes["RawMessageDelivery"]))
+		assert.JSONEq(t, `{"event":["UPDATE_STATUS"]}`, aws.StringValue(input.Attributes["FilterPolicy"]))
+	})
+
+	t.Run("test ok list SQS subscriptions", func(t *testing.T) {
+		subscriptions, err := topic.ListSubscriptions("policy-status")
+		require.NoError(t, err)
+		assert.Equal(t, []TopicSubscription{
+			{ID: "sub-1", TopicName: "policy-status", QueueName: "finance", FilterPolicy: FilterPolicy{"event": {"UPDATE_STATUS"}}},
+			{ID: "PendingConfirmation", TopicName: "policy-status", QueueName: "quotation"},
+		}, subscriptions)
+	})
+}
+
+type fakeMNSTopic struct {
+	ali_mns.AliMNSTopic
+	name       string
+	published  []ali_mns.MessagePublishRequest
+	subscribed map[string]ali_mns.MessageSubsribeRequest
+}
+
+func (f *fakeMNSTopic) GenerateQueueEndpoint(queueName string) string {
+	return "acs:mns:ap-southeast-5:1:queues/" + queueName
+}
+
+func (f *fakeMNSTopic) PublishMessage(message ali_mns.MessagePublishRequest) (ali_mns.MessageSendResponse, error) {
+	f.published = append(f.published, message)
+	return ali_mns.MessageSendResponse{MessageId: "1"}, nil
+}
+
+func (f *fakeMNSTopic) Subscribe(subscriptionName string, message ali_mns.MessageSubsribeRequest) error {
+	f.subscribed[subscriptionName] = message
+	return nil
+}
+
+func (f *fakeMNSTopic) ListSubscriptionDetailByTopic(nextMarker string, retNumber int32, prefix string) (ali_mns.SubscriptionDetails, error) {
+	details := ali_mns.SubscriptionDetails{}
+	for name, subscription := range f.subscribed {
+		details.Attrs = append(details.Attrs, ali_mns.SubscriptionAttribute{
+			SubscriptionName: name,
+			TopicName:        f.name,
+			Endpoint:         subscription.Endpoint,
+			FilterTag:        subscription.FilterTag,
+		})
+	}
+	details.Attrs = append(details.Attrs, ali_mns.SubscriptionAttribute{SubscriptionName: "ops", Endpoint: "mail:directmail:ops@example.com"})
+	return details, nil
+}
+
+func TestMNSTopic(t *testing.T) {
+	fake := &fakeMNSTopic{name: "policy-status", subscribed: map[string]ali_mns.MessageSubsribeRequest{}}
+	topic := NewMNSTopic(nil)
+	topic.PropagateAttributes = true
+	topic.NewTopic = func(topicName string) ali_mns.AliMNSTopic { return fake }
+
+	t.Run("test ok publish with the event type as tag", func(t *testing.T) {
+		require.NoError(t, topic.PublishToTopic(&MessagePublishOptions{
+			TopicName:   "policy-status",
+			MessageBody: "P-1 ACTIVE",
+			Attributes:  map[string]string{AttributeEventType: "UPDATE_STATUS"},
+		}))
+		require.Len(t, fake.published, 1)
+		assert.Equal(t, "UPDATE_STATUS", fake.published[0].MessageTag)
+
+		body, attributes := unwrapMNSAttributes(fake.published[0].MessageBody)
+		assert.Equal(t, "P-1 ACTIVE", body)
+		assert.Equal(t, "UPDATE_STATUS", attributes[AttributeEventType])
+	})
+
+	t.Run("test wrong filter policy MNS cannot apply", func(t *testing.T) {
+		_, err := topic.Subscribe("policy-status", "finance", FilterPolicy{"product": {"car"}})
+		assert.Error(t, err)
+		_, err = topic.Subscribe("policy-status", "finance", FilterPolicy{AttributeEventType: {"UPDATE_STATUS", "CANCEL"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("test ok subscribe and list queue subscriptions", func(t *testing.T) {
+		_, err := topic.Subscribe("policy-status", "finance", FilterPolicy{AttributeEventType: {"UPDATE_STATUS"}})
+		require.NoError(t, err)
+		assert.Equal(t, ali_mns.MessageSubsribeRequest{
+			Endpoint:            "acs:mns:ap-southeast-5:1:queues/finance",
+			FilterTag:           "UPDATE_STATUS",
+			NotifyStrategy:      ali_mns.BACKOFF_RETRY,
+			NotifyContentFormat: ali_mns.SIMPLIFIED,
+		}, fake.subscribed["finance"])
+
+		subscriptions, err := topic.ListSubscriptions("policy-status")
+		require.NoError(t, err)
+		assert.Equal(t, []TopicSubscription{{
+			ID:           "finance",
+			TopicName:    "policy-status",
+			QueueName:    "finance",
+			FilterPolicy: FilterPolicy{AttributeEventType: {"UPDATE_STATUS"}},
+		}}, subscriptions)
+	})
+}
@@ -1,12 +1,39 @@
 package transporter
 
-import "github.com/pkg/errors"
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultShutdownTimeout is how long Run waits for in-flight messages after
+	// the context is cancelled when ConsumerOption.ShutdownTimeout is not set
+	DefaultShutdownTimeout = 30 * time.Second
+	// DefaultPollBackoff and DefaultMaxPollBackoff bound the wait between
+	// two polls after BatchConsume returned an error
+	DefaultPollBackoff    = 500 * time.Millisecond
+	DefaultMaxPollBackoff = 30 * time.Second
+)
+
+// Handler processes a consumed message. Returning nil acknowledges the message,
+// returning an error leaves it in the queue to be redelivered once its
+// visibility timeout expires
+type Handler func(ctx context.Context, message *MessageReceiveResponse) error
 
 type (
 	ServiceConsumer interface {
 		Consume(channelMessages chan *MessageReceiveResponse)
 		GetWorkerPool() int
 		Acknowledge(message *MessageReceiveResponse) error
+		Handle(handler Handler)
+		Run(ctx context.Context) error
 	}
 
 	consumer struct {
@@ -16,6 +43,15 @@ type (
 		numberOfMessage   int
 		waitTimeSecond    int
 		visibilityTimeout int
+		heartbeatInterval time.Duration
+		shutdownTimeout   time.Duration
+		pollBackoff       time.Duration
+		maxPollBackoff    time.Duration
+		retryPolicy       *RetryPolicy
+		deduplication     *DeduplicationOption
+		claimCheck        *ClaimCheckOption
+		archiver          *Archiver
+		handler           Handler
 	}
 
 	ConsumerOption struct {
@@ -25,6 +61,27 @@ type (
 		NumberOfMessage   int
 		WaitTimeSecond    int
 		VisibilityTimeout int
+		// HeartbeatInterval extends the visibility of a message to VisibilityTimeout every
+		// interval while its handler runs, so a handler slower than the visibility timeout
+		// does not get the message redelivered. It has to be shorter than VisibilityTimeout
+		HeartbeatInterval time.Duration
+		// ShutdownTimeout is how long Run waits for in-flight messages once it is stopped
+		ShutdownTimeout time.Duration
+		// PollBackoff is the first wait after a failed poll, it doubles up to MaxPollBackoff
+		PollBackoff    time.Duration
+		MaxPollBackoff time.Duration
+		// RetryPolicy delays the messages the handler failed on and moves them to a
+		// dead-letter queue after the last attempt, without it they are redelivered
+		// by the provider once their visibility timeout expires
+		RetryPolicy *RetryPolicy
+		// Deduplication skips the messages that were already processed, it is
+		// consulted before the handler is called
+		Deduplication *DeduplicationOption
+		// ClaimCheck restores the bodies a producer offloaded to object storage
+		ClaimCheck *ClaimCheckOption
+		// Archiver records the handled messages with their outcome so they can be
+		// replayed, it is shared by the consumers and run by the caller
+		Archiver *Archiver
 	}
 )
 
@@ -32,19 +89,110 @@ func NewServiceConsumer(option *ConsumerOption) (ServiceConsumer, error) {
 	if option == nil {
 		return nil, errors.New("option is empty")
 	}
+	if option.RetryPolicy != nil {
+		if err := option.RetryPolicy.validate(option.TransporterClient); err != nil {
+			return nil, err
+		}
+	}
+	if option.HeartbeatInterval > 0 && option.HeartbeatInterval >= time.Duration(option.VisibilityTimeout)*time.Second {
+		return nil, errors.New("heartbeat interval should be shorter than the visibility timeout")
+	}
 
-	return &consumer{
+	c := &consumer{
 		transporterClient: option.TransporterClient,
 		queueName:         option.QueueName,
 		workerPool:        option.WorkerPool,
 		numberOfMessage:   option.NumberOfMessage,
 		waitTimeSecond:    option.WaitTimeSecond,
 		visibilityTimeout: option.VisibilityTimeout,
-	}, nil
+		heartbeatInterval: option.HeartbeatInterval,
+		shutdownTimeout:   option.ShutdownTimeout,
+		pollBackoff:       option.PollBackoff,
+		maxPollBackoff:    option.MaxPollBackoff,
+		retryPolicy:       option.RetryPolicy,
+		deduplication:     option.Deduplication,
+		claimCheck:        option.ClaimCheck,
+		archiver:          option.Archiver,
+	}
+	if c.shutdownTimeout <= 0 {
+		c.shutdownTimeout = DefaultShutdownTimeout
+	}
+	if c.pollBackoff <= 0 {
+		c.pollBackoff = DefaultPollBackoff
+	}
+	if c.maxPollBackoff < c.pollBackoff {
+		c.maxPollBackoff = DefaultMaxPollBackoff
+	}
+	return c, nil
 }
 
 func (c *consumer) Consume(channelMessages chan *MessageReceiveResponse) {
-	for {
+	c.poll(context.Background(), channelMessages)
+}
+
+// Handle registers the handler called by Run for every consumed message
+func (c *consumer) Handle(handler Handler) {
+	c.handler = handler
+}
+
+// Run polls the queue and dispatches the messages to WorkerPool workers running the
+// registered handler, until ctx is cancelled or the process receives SIGTERM or SIGINT.
+// It then stops polling and waits up to ShutdownTimeout for the in-flight messages,
+// after which the context given to the handlers is cancelled and an error is returned.
+// A poll that is already waiting for messages finishes before Run returns.
+func (c *consumer) Run(ctx context.Context) error {
+	if c.handler == nil {
+		return fmt.Errorf("no handler registered for queue: %s", c.queueName)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// handlers keep the values of ctx but are only cancelled once the shutdown timeout expires
+	handlerCtx, cancelHandlers := context.WithCancel(detachedContext{parent: ctx})
+	defer cancelHandlers()
+
+	workerPool := c.workerPool
+	if workerPool <= 0 {
+		workerPool = 1
+	}
+
+	messages := make(chan *MessageReceiveResponse)
+	var workers sync.WaitGroup
+	for i := 0; i < workerPool; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for message := range messages {
+				c.process(handlerCtx, message)
+			}
+		}()
+	}
+
+	c.poll(ctx, messages)
+	close(messages)
+	logger.Infof("stopped polling queue: %s, waiting for in-flight messages", c.queueName)
+
+	drained := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(c.shutdownTimeout):
+		cancelHandlers()
+		return fmt.Errorf("in-flight messages of queue: %s were not processed within %s", c.queueName, c.shutdownTimeout)
+	}
+}
+
+// poll receives messages until ctx is done, waiting with an exponential backoff
+// between polls that failed
+func (c *consumer) poll(ctx context.Context, channelMessages chan *MessageReceiveResponse) {
+	backoff := c.pollBackoff
+	for ctx.Err() == nil {
 		result, err := c.transporterClient.BatchConsume(&MessageConsumeOptions{
 			QueueName:         c.queueName,
 			VisibilityTimeout: int64(c.visibilityTimeout),
@@ -54,13 +202,98 @@ func (c *consumer) Consume(channelMessages chan *MessageReceiveResponse) {
 
 		if err != nil {
 			logger.Error(errors.Wrap(err, "failed to retrieve message"))
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > c.maxPollBackoff {
+				backoff = c.maxPollBackoff
+			}
 			continue
 		}
+		backoff = c.pollBackoff
 
-		for _, receivedMessage := range result {
-			channelMessages <- &receivedMessage
+		if len(result) == 0 && c.waitTimeSecond <= 0 {
+			// without long polling an empty queue would be polled in a tight loop
+			select {
+			case <-ctx.Done():
+			case <-time.After(c.pollBackoff):
+			}
+			continue
 		}
+
+		for i := range result {
+			select {
+			case channelMessages <- &result[i]:
+			case <-ctx.Done():
+				// messages that were not dispatched are redelivered after their visibility timeout
+				return
+			}
+		}
+	}
+}
+
+// process runs the handler and acknowledges the message when it succeeds.
+// A panicking handler is treated as a failed one. Duplicates are skipped when
+// Deduplication is set, a message whose claim check cannot be restored fails,
+// the visibility of the message is extended while the handler runs
+// when HeartbeatInterval is set, the handled messages are archived when Archiver is set
+func (c *consumer) process(ctx context.Context, message *MessageReceiveResponse) {
+	receivedAt := time.Now()
+	unwrapRetryEnvelope(message)
+	ctx = ContextWithAttributes(ctx, message.Attributes)
+	if err := c.restoreClaimCheck(ctx, message); err != nil {
+		c.fail(ctx, message, receivedAt, err)
+		return
 	}
+
+	dedupLease, skip := c.beginDedup(ctx, message)
+	if skip {
+		return
+	}
+
+	stopHeartbeat := c.startHeartbeat(message)
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("handler panic: %v", r)
+			}
+		}()
+		return c.handler(ctx, message)
+	}()
+	stopHeartbeat()
+	c.endDedup(ctx, dedupLease, err)
+
+	if err != nil {
+		c.fail(ctx, message, receivedAt, err)
+		return
+	}
+
+	if c.Acknowledge(message) != nil {
+		c.archive(ctx, message, receivedAt, OutcomeFailed, nil)
+		return
+	}
+	c.deleteClaimCheck(ctx, message)
+	c.archive(ctx, message, receivedAt, OutcomeAcked, nil)
+}
+
+// fail hands a message that could not be handled to the RetryPolicy, without one it is
+// left for the provider to redeliver
+func (c *consumer) fail(ctx context.Context, message *MessageReceiveResponse, receivedAt time.Time, err error) {
+	if c.retryPolicy == nil {
+		logger.Errorf("failed to handle message with ID: %s on queue: %s, it will be redelivered: %s", message.MessageID, c.queueName, err)
+		c.archive(ctx, message, receivedAt, OutcomeFailed, err)
+		return
+	}
+
+	logger.Errorf("failed to handle message with ID: %s on queue: %s: %s", message.MessageID, c.queueName, err)
+	outcome, retryErr := c.retry(message, err)
+	if retryErr != nil {
+		logger.Errorf("failed to retry message with ID: %s on queue: %s, it will be redelivered: %s", message.MessageID, c.queueName, retryErr)
+		outcome = OutcomeFailed
+	}
+	c.archive(ctx, message, receivedAt, outcome, err)
 }
 
 func (c *consumer) Acknowledge(message *MessageReceiveResponse) error {
@@ -77,19 +310,44 @@ func (c *consumer) GetWorkerPool() int {
 	return c.workerPool
 }
 
+// detachedContext keeps the values of its parent without its cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (d detachedContext) Value(key interface{}) interface{} { return d.parent.Value(key) }
+
 type (
 	producer struct {
 		transporterClient Client
 		queueName         string
+		codec             Codec
+		claimCheck        *ClaimCheckOption
+		publisher         *ResilientPublisher
 	}
 
 	ServiceProducer interface {
 		Produce(data interface{}) error
+		ProduceWithContext(ctx context.Context, data interface{}) error
+		// ProduceEncoded is ProduceWithContext with data always encoded, with the codec of
+		// the producer or JSON when it has none. It backs Produce
+		ProduceEncoded(ctx context.Context, data interface{}) error
 	}
 
 	ProducerOption struct {
 		TransporterClient Client
 		QueueName         string
+		// Codec encodes the produced data and names itself in the Content-Type attribute,
+		// without it the data is given to the provider as is
+		Codec Codec
+		// ClaimCheck offloads the bodies larger than its threshold to object storage
+		ClaimCheck *ClaimCheckOption
+		// Publisher retries the transient errors and spools the messages while the
+		// provider is down, TransporterClient is not used when it is set
+		Publisher *ResilientPublisher
 	}
 )
 
@@ -100,14 +358,71 @@ func NewServiceProducer(option *ProducerOption) (ServiceProducer, error) {
 	return &producer{
 		transporterClient: option.TransporterClient,
 		queueName:         option.QueueName,
+		codec:             option.Codec,
+		claimCheck:        option.ClaimCheck,
+		publisher:         option.Publisher,
 	}, nil
 }
 
+// client returns the client the messages are published with
+func (p *producer) client() Client {
+	if p.publisher != nil {
+		return p.publisher.client
+	}
+	return p.transporterClient
+}
+
 func (p *producer) Produce(data interface{}) error {
-	err := p.transporterClient.Publish(&MessagePublishOptions{
+	return p.ProduceWithContext(context.Background(), data)
+}
+
+// ProduceWithContext publishes data with the Datadog span context and the request ID
+// of ctx as message attributes, so the consumer continues the same trace
+func (p *producer) ProduceWithContext(ctx context.Context, data interface{}) error {
+	return p.publish(ctx, data, p.codec)
+}
+
+func (p *producer) ProduceEncoded(ctx context.Context, data interface{}) error {
+	codec := p.codec
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	return p.publish(ctx, data, codec)
+}
+
+func (p *producer) publish(ctx context.Context, data interface{}, codec Codec) error {
+	var attributes map[string]string
+	if codec != nil {
+		if codecNeedsAttributes(codec) && dropsAttributes(p.client()) {
+			return fmt.Errorf("content type: %s cannot be decoded without the message attributes dropped by the MNS client, enable PropagateAttributes", codec.ContentType())
+		}
+		body, codecAttributes, err := EncodeBody(codec, data)
+		if err != nil {
+			logger.Error(errors.Wrap(err, "failed to encode data for the queue"))
+			return err
+		}
+		data, attributes = body, codecAttributes
+	}
+	if p.claimCheck != nil {
+		offloaded, err := p.claimCheck.offload(ctx, p.queueName, data)
+		if err != nil {
+			logger.Error(errors.Wrap(err, "failed to offload data for the queue"))
+			return err
+		}
+		data = offloaded
+	}
+
+	options := &MessagePublishOptions{
 		MessageBody: data,
 		QueueName:   p.queueName,
-	})
+		Attributes:  InjectContext(ctx, attributes),
+	}
+	var err error
+	if p.publisher != nil {
+		err = p.publisher.Publish(ctx, options)
+	} else {
+		err = p.transporterClient.Publish(options)
+	}
 
 	if err != nil {
 		logger.Error(errors.Wrap(err, "failed to publish data to the queue"))
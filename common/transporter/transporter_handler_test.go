@@ -0,0 +1,211 @@
+package transporter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingClient struct {
+	*Memory
+	polls int32
+}
+
+func (f *failingClient) BatchConsume(options *MessageConsumeOptions) ([]MessageReceiveResponse, error) {
+	atomic.AddInt32(&f.polls, 1)
+	return nil, errors.New("connection refused")
+}
+
+func TestServiceConsumerRunAcknowledgesHandledMessages(t *testing.T) {
+	client := NewMemory()
+	for _, body := range []string{"ok-1", "fail", "ok-2"} {
+		require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "add-product", MessageBody: body}))
+	}
+
+	consumer, err := NewServiceConsumer(&ConsumerOption{
+		TransporterClient: client,
+		QueueName:         "add-product",
+		WorkerPool:        2,
+		NumberOfMessage:   5,
+		VisibilityTimeout: 30,
+	})
+	require.NoError(t, err)
+
+	var handled int32
+	consumer.Handle(func(ctx context.Context, message *MessageReceiveResponse) error {
+		atomic.AddInt32(&handled, 1)
+		if message.MessageBody == "fail" {
+			return errors.New("failed to update stoploss")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- consumer.Run(ctx) }()
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&handled) == 3 }, 2*time.Second, 10*time.Millisecond)
+	cancel()
+
+	t.Run("test ok run stops when context is cancelled", func(t *testing.T) {
+		assert.Nil(t, <-done)
+	})
+
+	t.Run("test ok failed message is left for redelivery", func(t *testing.T) {
+		assert.Equal(t, 1, client.InFlight("add-product"))
+		assert.Equal(t, []interface{}{"fail"}, client.Drain("add-product"))
+	})
+}
+
+func TestServiceConsumerRunDrainsInFlightMessages(t *testing.T) {
+	client := NewMemory()
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "policy", MessageBody: "slow"}))
+
+	consumer, _ := NewServiceConsumer(&ConsumerOption{
+		TransporterClient: client,
+		QueueName:         "policy",
+		WorkerPool:        1,
+		ShutdownTimeout:   time.Second,
+	})
+
+	started := make(chan struct{})
+	consumer.Handle(func(ctx context.Context, message *MessageReceiveResponse) error {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- consumer.Run(ctx) }()
+
+	<-started
+	cancel()
+
+	t.Run("test ok in-flight message is acked after cancellation", func(t *testing.T) {
+		assert.Nil(t, <-done)
+		assert.Equal(t, 0, client.Depth("policy"))
+		assert.Equal(t, 0, client.InFlight("policy"))
+	})
+}
+
+func TestServiceConsumerRunShutdownTimeout(t *testing.T) {
+	client := NewMemory()
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "policy", MessageBody: "stuck"}))
+
+	consumer, _ := NewServiceConsumer(&ConsumerOption{
+		TransporterClient: client,
+		QueueName:         "policy",
+		ShutdownTimeout:   50 * time.Millisecond,
+	})
+
+	started := make(chan struct{})
+	consumer.Handle(func(ctx context.Context, message *MessageReceiveResponse) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- consumer.Run(ctx) }()
+
+	<-started
+	cancel()
+
+	t.Run("test run returns an error when in-flight messages do not finish", func(t *testing.T) {
+		assert.Error(t, <-done)
+		assert.Equal(t, 1, client.InFlight("policy"))
+	})
+}
+
+func TestServiceConsumerRunBacksOffOnPollErrors(t *testing.T) {
+	client := &failingClient{Memory: NewMemory()}
+	consumer, _ := NewServiceConsumer(&ConsumerOption{
+		TransporterClient: client,
+		QueueName:         "policy",
+		PollBackoff:       20 * time.Millisecond,
+		MaxPollBackoff:    40 * time.Millisecond,
+	})
+	consumer.Handle(func(ctx context.Context, message *MessageReceiveResponse) error { return nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+
+	t.Run("test poll errors are retried with backoff", func(t *testing.T) {
+		assert.Nil(t, consumer.Run(ctx))
+		polls := atomic.LoadInt32(&client.polls)
+		assert.GreaterOrEqual(t, polls, int32(3))
+		assert.LessOrEqual(t, polls, int32(6))
+	})
+
+	t.Run("test run without handler", func(t *testing.T) {
+		consumer, _ := NewServiceConsumer(&ConsumerOption{TransporterClient: client, QueueName: "policy"})
+		assert.Error(t, consumer.Run(context.Background()))
+	})
+}
+
+type visibilityCountingClient struct {
+	*Memory
+	extended int32
+}
+
+func (v *visibilityCountingClient) ChangeVisibility(queueName string, message *MessageReceiveResponse, visibilityTimeout int64) error {
+	atomic.AddInt32(&v.extended, 1)
+	return v.Memory.ChangeVisibility(queueName, message, visibilityTimeout)
+}
+
+func TestServiceConsumerHeartbeatExtendsVisibility(t *testing.T) {
+	client := &visibilityCountingClient{Memory: NewMemory()}
+	require.NoError(t, client.Publish(&MessagePublishOptions{QueueName: "certificate", MessageBody: "GENERATE_CERTIFICATE"}))
+
+	serviceConsumer, err := NewServiceConsumer(&ConsumerOption{
+		TransporterClient: client,
+		QueueName:         "certificate",
+		VisibilityTimeout: 1,
+		HeartbeatInterval: 200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	serviceConsumer.Handle(func(ctx context.Context, message *MessageReceiveResponse) error {
+		<-release
+		return nil
+	})
+
+	message, err := client.Consume(&MessageConsumeOptions{QueueName: "certificate", VisibilityTimeout: 1})
+	require.NoError(t, err)
+	require.NotNil(t, message)
+	processed := make(chan struct{})
+	go func() {
+		serviceConsumer.(*consumer).process(context.Background(), message)
+		close(processed)
+	}()
+
+	t.Run("test ok message stays hidden while the handler runs", func(t *testing.T) {
+		time.Sleep(1500 * time.Millisecond)
+		redelivered, err := client.Consume(&MessageConsumeOptions{QueueName: "certificate", VisibilityTimeout: 1})
+		assert.Nil(t, err)
+		assert.Nil(t, redelivered)
+		assert.GreaterOrEqual(t, atomic.LoadInt32(&client.extended), int32(5))
+	})
+
+	t.Run("test ok heartbeat stops once the handler returns", func(t *testing.T) {
+		close(release)
+		<-processed
+		extended := atomic.LoadInt32(&client.extended)
+		time.Sleep(500 * time.Millisecond)
+		assert.Equal(t, extended, atomic.LoadInt32(&client.extended))
+		assert.Equal(t, 0, client.Depth("certificate")+client.InFlight("certificate"))
+	})
+
+	t.Run("test wrong heartbeat interval is not shorter than the visibility timeout", func(t *testing.T) {
+		_, err := NewServiceConsumer(&ConsumerOption{QueueName: "certificate", VisibilityTimeout: 1, HeartbeatInterval: time.Second})
+		assert.EqualError(t, err, "heartbeat interval should be shorter than the visibility timeout")
+	})
+}
@@ -1,6 +1,9 @@
 package transporter
 
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -22,3 +25,31 @@ func GetAWSSession(accessKey string, secretKey string, region string) (*session.
 	}
 	return sess, err
 }
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// messageBodyBytes sends strings and bytes as is and JSON encodes any other body
+// the same way the NATS EncodedConn does
+func messageBodyBytes(body interface{}) ([]byte, error) {
+	switch v := body.(type) {
+	case nil:
+		return nil, fmt.Errorf("message body is empty")
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case *string:
+		if v == nil {
+			return nil, fmt.Errorf("message body is empty")
+		}
+		return []byte(*v), nil
+	}
+	return json.Marshal(body)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/labstack/echo"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
@@ -14,6 +15,9 @@ type SpanOption struct {
 	Entity      string
 	Name        string
 	HttpRequest *http.Request
+	// Attributes are the attributes of a consumed message, the consumer span
+	// continues the trace of the producer when they carry its span context
+	Attributes map[string]string
 }
 
 func StartSpanFromContextRest(option SpanOption) (ddtrace.Span, context.Context) {
@@ -30,6 +34,14 @@ func StartSpanFromContextUsecase(ctx context.Context, entity, name string) (ddtr
 }
 
 func StartSpanFromContextConsumer(option SpanOption) (ddtrace.Span, context.Context) {
-	return tracer.StartSpanFromContext(option.Context, "consumer."+option.Entity+"."+option.Name,
-		tracer.SpanType(ext.SpanTypeMessageConsumer))
+	opts := []ddtrace.StartSpanOption{tracer.SpanType(ext.SpanTypeMessageConsumer)}
+	if len(option.Attributes) > 0 {
+		if spanContext, err := tracer.Extract(tracer.TextMapCarrier(option.Attributes)); err == nil {
+			opts = append(opts, tracer.ChildOf(spanContext))
+		}
+		if requestID := option.Attributes[echo.HeaderXRequestID]; requestID != "" {
+			opts = append(opts, tracer.Tag("request_id", requestID))
+		}
+	}
+	return tracer.StartSpanFromContext(option.Context, "consumer."+option.Entity+"."+option.Name, opts...)
 }
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"sync"
 
+	transporterLib "github.com/rohanchauhan02/clean/common/transporter"
 	"github.com/spf13/viper"
 )
 
@@ -19,6 +20,7 @@ type (
 		GetApiDoc() ApiDoc
 		GetSlack() Slack
 		GetSQS() SQS
+		GetArchive() Archive
 	}
 	Config struct {
 		Port    int     `mapstructure:"PORT"`
@@ -30,6 +32,7 @@ type (
 		ApiDoc  ApiDoc  `mapstructure:"ApiDoc"`
 		Slack   Slack   `mapstructure:"Slack"`
 		SQS     SQS     `mapstructure:"SQS"`
+		Archive Archive `mapstructure:"ARCHIVE"`
 	}
 	DB struct {
 		Host             string `mapstructure:"HOST"`
@@ -77,13 +80,20 @@ type (
 
 	SQS struct {
 		AddProduct QueueConfig `mapstructure:"ADD_PRODUCT"`
+		// Env is the environment the queues are registered for in schemas.GetSQSActions
+		Env string `mapstructure:"ENV"`
+		// Queues are consumed by the consumers built in the app bootstrap
+		Queues map[string]QueueConfig `mapstructure:"QUEUES"`
 	}
-	QueueConfig struct {
-		Name                  string `mapstructure:"NAME"`
-		NumberRetrieveMessage int    `mapstructure:"NUMBER_RETRIEVE_MESSAGE"`
-		WaitTimeSecond        int    `mapstructure:"WAIT_TIME_SECOND"`
-		VisibilityTimeout     int    `mapstructure:"VISIBILITY_TIMEOUT"`
-		WorkerPool            int    `mapstructure:"WORKER_POOL"`
+	QueueConfig = transporterLib.QueueConfig
+
+	// Archive is where the messages of the queues with ARCHIVE set are recorded,
+	// the archiver is disabled when Bucket is empty
+	Archive struct {
+		Bucket              string `mapstructure:"BUCKET"`
+		Prefix              string `mapstructure:"PREFIX"`
+		MaxRecords          int    `mapstructure:"MAX_RECORDS"`
+		FlushIntervalSecond int    `mapstructure:"FLUSH_INTERVAL_SECOND"`
 	}
 )
 
@@ -123,6 +133,10 @@ func (c Config) GetSQS() SQS {
 	return c.SQS
 }
 
+func (c Config) GetArchive() Archive {
+	return c.Archive
+}
+
 var (
 	confOnce sync.Once
 	conf     *Config